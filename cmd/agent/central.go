@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/allocation"
+	"clustercost-agent-k8s/internal/anomaly"
+	"clustercost-agent-k8s/internal/api"
+	"clustercost-agent-k8s/internal/budget"
+	"clustercost-agent-k8s/internal/central"
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/export"
+	"clustercost-agent-k8s/internal/exporter"
+	"clustercost-agent-k8s/internal/health"
+	"clustercost-agent-k8s/internal/kube"
+	"clustercost-agent-k8s/internal/snapshot"
+	"clustercost-agent-k8s/internal/telemetry"
+	"clustercost-agent-k8s/internal/version"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+)
+
+// runCentral serves the /agent/v1 API over the reports forwarded by node
+// agents instead of collecting from the cluster itself.
+func runCentral(ctx context.Context, cfg config.Config, logger *slog.Logger) error {
+	const clusterType = "k8s"
+	agentVersion := version.Value()
+	clusterName := configuredClusterName(cfg, logger)
+
+	// The central agent may run outside the cluster; it only needs the API
+	// for kubernetes auth, and otherwise uses it to name the cluster like
+	// the agents do.
+	var client kubernetes.Interface
+	kubeClient, err := kube.NewClient(clusterName, cfg.KubeconfigPath)
+	switch {
+	case err == nil:
+		client = kubeClient.Kubernetes
+	case cfg.Server.AuthMode == config.AuthModeKubernetes:
+		return fmt.Errorf("kube client: %w", err)
+	default:
+		logger.Info("no cluster access; using the configured cluster name", slog.String("reason", err.Error()))
+	}
+	clusterID, clusterName := resolveCluster(ctx, cfg, clusterName, client, logger)
+
+	logger.Info("starting clustercost central agent",
+		slog.String("version", agentVersion),
+		slog.String("clusterId", clusterID),
+		slog.String("clusterName", clusterName),
+		slog.String("ingestPath", cfg.Central.IngestPath),
+	)
+
+	agentMetrics := telemetry.New()
+	tracker := health.NewTracker(cfg.Server.ReadinessComponents)
+	store := snapshot.NewStore()
+	aggregator := central.New(central.Config{ClusterID: clusterID, NodeTTL: cfg.Central.NodeTTL, DedupeWindow: cfg.Central.DedupeWindow})
+
+	costs, budgets, err := newCostTracking(cfg, clusterName, "", logger)
+	if err != nil {
+		return err
+	}
+	if budgets != nil {
+		go budgets.Run(ctx)
+	}
+
+	var detector *anomaly.Detector
+	if cfg.Anomaly.Enabled {
+		var notifiers []anomaly.Notifier
+		for _, hook := range cfg.Anomaly.Webhooks {
+			notifiers = append(notifiers, anomaly.NewWebhookNotifier(hook, clusterName, cfg.Anomaly.Timeout))
+		}
+		if cfg.Anomaly.Events {
+			logger.Warn("anomaly events are not recorded in central mode")
+		}
+		detector = anomaly.NewDetector(cfg.Anomaly, store, notifiers, logger)
+		go detector.Run(ctx)
+	}
+
+	var allocator *allocation.Allocator
+	if cfg.Allocation.Enabled {
+		allocator = allocation.New(allocation.Config{
+			Retention:            cfg.Allocation.Retention,
+			MaxGap:               cfg.Accumulation.MaxGap,
+			CPUCoreHourPrice:     cfg.Pricing.CPUCoreHourPriceUSD,
+			RAMGiBHourPrice:      cfg.Pricing.MemoryGiBHourPriceUSD,
+			GPUHourPrice:         cfg.Allocation.GPUHourPriceUSD,
+			StorageGiBMonthPrice: cfg.Allocation.StorageGiBMonthPriceUSD,
+		}, store, logger)
+		go allocator.Run(ctx)
+	}
+
+	go runMergeLoop(ctx, aggregator, costs, budgets, store, agentMetrics, cfg.ScrapeInterval(), logger)
+
+	mux := http.NewServeMux()
+	api.NewHandler(clusterType, clusterName, cfg.Pricing.Region, agentVersion, store, tracker).Register(mux)
+	exportMeta := export.Meta{
+		ClusterID:   clusterID,
+		ClusterName: clusterName,
+		Provider:    cfg.Pricing.Provider,
+		Region:      cfg.Pricing.Region,
+	}
+	api.NewExportHandler(exportMeta, store, costs, cfg.ScrapeInterval()).Register(mux)
+	if detector != nil {
+		api.NewAnomaliesHandler(detector).Register(mux)
+	}
+	if allocator != nil {
+		api.NewAllocationHandler(allocator).Register(mux)
+		api.NewOpenCostHandler(allocator).Register(mux)
+	}
+	stream := api.NewStreamHandler(store, cfg.Server.MaxStreamClients)
+	stream.Register(mux)
+
+	seriesConfig := exporter.SnapshotCollectorConfig{
+		LabelKeys:           cfg.Prometheus.LabelKeys,
+		MaxPodSeries:        cfg.Prometheus.MaxPodSeries,
+		MaxConnectionSeries: cfg.Prometheus.MaxConnectionSeries,
+		ConnectionLevels:    cfg.Prometheus.ConnectionLevels,
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(agentMetrics)
+	registry.MustRegister(exporter.NewSnapshotCollector(clusterName, store, seriesConfig))
+	if costs != nil {
+		api.NewCostsHandler(costs).Register(mux)
+		api.NewChargebackHandler(exportMeta, costs).Register(mux)
+		api.NewBudgetsHandler(budgets).Register(mux)
+		registry.MustRegister(exporter.NewAccumulatedCostCollector(clusterName, costs))
+	}
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// With its own token file the ingest path skips the API auth, so agents
+	// only need the ingest token.
+	public := publicPaths
+	var ingest http.Handler = api.NewIngestHandler(aggregator, cfg.Central.MaxBodyBytes)
+	if cfg.Central.AuthTokenFile != "" {
+		auth, err := exporter.NewTokenFileAuthenticator(cfg.Central.AuthTokenFile)
+		if err != nil {
+			return fmt.Errorf("ingest auth: %w", err)
+		}
+		ingest = exporter.RequireAuth(ingest, auth, nil, logger)
+		public = append(append([]string{}, publicPaths...), cfg.Central.IngestPath)
+	}
+	mux.Handle(cfg.Central.IngestPath, ingest)
+
+	handler, err := secureHandler(mux, cfg.Server, public, client, logger)
+	if err != nil {
+		return fmt.Errorf("http auth: %w", err)
+	}
+	return serve(ctx, cfg, handler, stream.Close, logger)
+}
+
+// runMergeLoop publishes the merged view every interval in which reports
+// arrived and feeds it to the same accumulation and budgets as a collected
+// snapshot.
+func runMergeLoop(ctx context.Context, aggregator *central.Aggregator, costs *accumulator.Accumulator, budgets *budget.Evaluator, store *snapshot.Store, metrics *telemetry.Metrics, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		if snap, ok := aggregator.Snapshot(start.UTC()); ok {
+			metrics.ObserveBuild(time.Since(start), nil)
+			store.Update(snap)
+			if costs != nil {
+				costs.Observe(snap)
+				if err := costs.Checkpoint(); err != nil {
+					logger.Warn("cost checkpoint failed", slog.String("error", err.Error()))
+				}
+				budgets.Evaluate(snap)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
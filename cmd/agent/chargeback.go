@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/chargeback"
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/export"
+)
+
+// runChargeback renders chargeback statements from the checkpoint file and
+// exits.
+func runChargeback(args []string) int {
+	fs := flag.NewFlagSet("clustercost-agent-k8s chargeback", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to YAML config file")
+	windowName := fs.String("window", string(accumulator.WindowMonth), "Calendar window: day, week, or month")
+	period := fs.String("period", chargeback.PeriodPrevious, "Period: current, previous, or a date (YYYY-MM-DD) or month (YYYY-MM) in it")
+	groupBy := fs.String("group-by", chargeback.GroupNamespace, "Grouping: namespace, environment, or an allocation dimension such as team")
+	top := fs.Int("top", chargeback.DefaultTop, "Workloads listed per statement")
+	shareIdle := fs.Bool("share-idle", false, "Spread idle node cost over the groups as shared cost")
+	formatName := fs.String("format", string(chargeback.FormatHTML), "Output format: json, csv, or html")
+	output := fs.String("output", "-", "Output file, or - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = []string{"--config", *configFile}
+	}
+	cfg, err := config.LoadArgs(cfgArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		return 1
+	}
+	format, err := chargeback.ParseFormat(*formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	window, err := accumulator.ParseWindow(*windowName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *top <= 0 {
+		fmt.Fprintln(os.Stderr, "top must be positive")
+		return 2
+	}
+
+	costs, err := loadCheckpoint(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chargeback: %v\n", err)
+		return 1
+	}
+	p, err := chargeback.SelectPeriod(costs, window, *period)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chargeback: %v\n", err)
+		return 1
+	}
+
+	clusterName := cfg.ClusterName
+	if override := os.Getenv("CLUSTER_NAME"); override != "" {
+		clusterName = override
+	}
+	report := chargeback.Build(p, export.Meta{ClusterID: cfg.ClusterID, ClusterName: clusterName}, chargeback.Options{
+		GroupBy:   *groupBy,
+		Top:       *top,
+		ShareIdle: *shareIdle,
+	}, time.Now())
+
+	var out io.Writer = os.Stdout
+	if *output != "-" && *output != "" {
+		f, err := os.Create(*output) // #nosec G304 -- path provided by the operator running the command
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create output: %v\n", err)
+			return 1
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		out = f
+	}
+	if err := chargeback.Write(out, format, report); err != nil {
+		fmt.Fprintf(os.Stderr, "write chargeback: %v\n", err)
+		return 1
+	}
+	return 0
+}
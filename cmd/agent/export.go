@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/collector"
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/export"
+	"clustercost-agent-k8s/internal/kube"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// runExport renders cost data once and exits. Accumulated sources are read
+// from the checkpoint file; the snapshot source builds a snapshot from the
+// Kubernetes API, with usage falling back to requests since no eBPF
+// collectors run in this mode.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("clustercost-agent-k8s export", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to YAML config file")
+	source := fs.String("source", "current", "Data source: snapshot, current, or history")
+	formatName := fs.String("format", string(export.FormatCSV), "Output format: csv or focus")
+	levelName := fs.String("level", string(export.LevelNamespace), "Row granularity: namespace or node")
+	windowName := fs.String("window", string(accumulator.WindowMonth), "Calendar window for current/history: day, week, or month")
+	output := fs.String("output", "-", "Output file, or - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = []string{"--config", *configFile}
+	}
+	cfg, err := config.LoadArgs(cfgArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		return 1
+	}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	level, err := export.ParseLevel(*levelName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancelTimeout()
+
+	// Log to stderr so the export stays clean when written to stdout. The
+	// cluster ID and name are resolved as the agent resolves them, so the
+	// rows join with what it forwards and exports; accumulated sources can
+	// still be exported without cluster access.
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	clusterName := configuredClusterName(cfg, logger)
+	var client kubernetes.Interface
+	kubeClient, err := kube.NewClient(clusterName, cfg.KubeconfigPath)
+	if err == nil {
+		client = kubeClient.Kubernetes
+	} else if *source == "snapshot" {
+		fmt.Fprintf(os.Stderr, "export: create kube client: %v\n", err)
+		return 1
+	}
+	clusterID, clusterName := resolveCluster(ctx, cfg, clusterName, client, logger)
+	meta := export.Meta{
+		ClusterID:   clusterID,
+		ClusterName: clusterName,
+		Provider:    cfg.Pricing.Provider,
+		Region:      cfg.Pricing.Region,
+	}
+
+	var lines []export.Line
+	switch *source {
+	case "snapshot":
+		lines, err = exportSnapshotLines(ctx, cfg, client, clusterID, level, logger)
+	case "current", "history":
+		lines, err = exportPeriodLines(cfg, *source, *windowName, level)
+	default:
+		err = fmt.Errorf("unknown source %q", *source)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" && *output != "" {
+		f, err := os.Create(*output) // #nosec G304 -- path provided by the operator running the command
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create output: %v\n", err)
+			return 1
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		out = f
+	}
+	if err := export.Write(out, format, meta, lines); err != nil {
+		fmt.Fprintf(os.Stderr, "write export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func exportPeriodLines(cfg config.Config, source, windowName string, level export.Level) ([]export.Line, error) {
+	window, err := accumulator.ParseWindow(windowName)
+	if err != nil {
+		return nil, err
+	}
+	costs, err := loadCheckpoint(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var periods []accumulator.Period
+	if source == "history" {
+		periods = costs.History(window)
+	} else if p, ok := costs.CurrentWindow(window); ok {
+		periods = []accumulator.Period{p}
+	}
+	return export.PeriodLines(periods, level, nil), nil
+}
+
+// loadCheckpoint restores the accumulated totals the agent last wrote.
+func loadCheckpoint(cfg config.Config) (*accumulator.Accumulator, error) {
+	location, err := time.LoadLocation(cfg.Accumulation.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	costs := accumulator.New(accumulator.Config{
+		CheckpointPath: cfg.Accumulation.CheckpointPath,
+		Location:       location,
+		Retention:      cfg.Accumulation.Retention,
+	}, nil)
+	if err := costs.Load(); err != nil {
+		return nil, err
+	}
+	return costs, nil
+}
+
+func exportSnapshotLines(ctx context.Context, cfg config.Config, client kubernetes.Interface, clusterID string, level export.Level, logger *slog.Logger) ([]export.Line, error) {
+	cache := kube.NewClusterCache(client, 0)
+	if err := cache.Start(ctx); err != nil {
+		return nil, fmt.Errorf("start informers: %w", err)
+	}
+
+	metricsCollector := collector.NewPodMetricsCollector(config.MetricsConfig{}, nil, logger)
+	networkCollector := collector.NewNetworkCollector(collector.NetworkCollectorConfig{}, nil, logger)
+	builder, err := newBuilder(cfg, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := collectSnapshot(ctx, builder, cache, metricsCollector, networkCollector, cfg.NodeName, nil, nil, logger)
+	if err != nil {
+		return nil, err
+	}
+	return export.SnapshotLines(snap, level, cfg.ScrapeInterval()), nil
+}
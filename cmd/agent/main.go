@@ -3,29 +3,57 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // calendar windows may use any IANA zone regardless of the base image
 
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/allocation"
+	"clustercost-agent-k8s/internal/anomaly"
 	"clustercost-agent-k8s/internal/api"
+	"clustercost-agent-k8s/internal/budget"
 	"clustercost-agent-k8s/internal/collector"
 	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/crd"
 	"clustercost-agent-k8s/internal/ebpf"
+	"clustercost-agent-k8s/internal/enricher"
+	"clustercost-agent-k8s/internal/export"
 	"clustercost-agent-k8s/internal/exporter"
 	"clustercost-agent-k8s/internal/forwarder"
+	"clustercost-agent-k8s/internal/health"
 	"clustercost-agent-k8s/internal/kube"
+	"clustercost-agent-k8s/internal/leader"
 	"clustercost-agent-k8s/internal/logging"
+	"clustercost-agent-k8s/internal/otlp"
+	"clustercost-agent-k8s/internal/remotewrite"
 	"clustercost-agent-k8s/internal/snapshot"
+	"clustercost-agent-k8s/internal/telemetry"
 	"clustercost-agent-k8s/internal/version"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "chargeback":
+			os.Exit(runChargeback(os.Args[2:]))
+		}
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		panic(err)
@@ -37,89 +65,120 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 
-	clusterID := cfg.ClusterID
-	const clusterType = "k8s"
-	clusterName := cfg.ClusterName
-	if override := os.Getenv("CLUSTER_NAME"); override != "" {
-		clusterName = override
-		logger.Info("cluster name override from env", slog.String("clusterName", clusterName))
+	if cfg.Mode == config.ModeCentral {
+		if err := runCentral(ctx, cfg, logger); err != nil {
+			logger.Error("central agent failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		return
 	}
+
+	const clusterType = "k8s"
+	clusterName := configuredClusterName(cfg, logger)
 	kubeClient, err := kube.NewClient(clusterName, cfg.KubeconfigPath)
 	if err != nil {
 		logger.Error("failed to create kube client", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	clusterID, clusterName := resolveCluster(ctx, cfg, clusterName, kubeClient.Kubernetes, logger)
+	kubeClient.ClusterName = clusterName
 
-	const placeholderName = "kubernetes"
-	const unknownClusterName = "unknown"
-
-	if clusterName == "" || clusterName == placeholderName {
-		detectCtx, cancelDetect := context.WithTimeout(ctx, 10*time.Second)
-		if detectedName, err := kube.DetectClusterName(detectCtx, kubeClient.Kubernetes); err == nil && detectedName != "" {
-			clusterName = detectedName
-			kubeClient.ClusterName = detectedName
-			if clusterID == "" || clusterID == placeholderName {
-				clusterID = detectedName
-			}
-			logger.Info("detected cluster name", slog.String("clusterName", detectedName))
+	clusterRegion := cfg.Pricing.Region
+	if cfg.Mode != config.ModeNode {
+		regionCtx, cancelRegion := context.WithTimeout(ctx, 10*time.Second)
+		if detectedRegion, err := kube.DetectClusterRegion(regionCtx, kubeClient.Kubernetes); err == nil && detectedRegion != "" {
+			clusterRegion = detectedRegion
+			logger.Info("detected cluster region", slog.String("clusterRegion", detectedRegion))
 		} else if err != nil {
-			logger.Warn("failed to detect cluster name", slog.String("error", err.Error()))
-		}
-		cancelDetect()
-
-		if clusterName == "" || clusterName == placeholderName {
-			clusterName = unknownClusterName
-			logger.Info("defaulting cluster name to unknown")
-		}
-		if clusterID == "" || clusterID == placeholderName {
-			clusterID = clusterName
+			logger.Warn("failed to detect cluster region", slog.String("error", err.Error()))
 		}
+		cancelRegion()
 	}
 
-	clusterRegion := cfg.Pricing.Region
-	regionCtx, cancelRegion := context.WithTimeout(ctx, 10*time.Second)
-	if detectedRegion, err := kube.DetectClusterRegion(regionCtx, kubeClient.Kubernetes); err == nil && detectedRegion != "" {
-		clusterRegion = detectedRegion
-		logger.Info("detected cluster region", slog.String("clusterRegion", detectedRegion))
-	} else if err != nil {
-		logger.Warn("failed to detect cluster region", slog.String("error", err.Error()))
-	}
-	cancelRegion()
-
 	logger.Info("starting clustercost agent",
 		slog.String("version", agentVersion),
 		slog.String("clusterType", clusterType),
 		slog.String("clusterId", clusterID),
 		slog.String("clusterName", clusterName),
 		slog.String("clusterRegion", clusterRegion),
+		slog.String("mode", cfg.Mode),
 	)
 
 	nodeName := cfg.NodeName
-	if nodeName == "" {
-		if envNode := os.Getenv("NODE_NAME"); envNode != "" {
-			nodeName = envNode
+	if cfg.Mode == config.ModeCluster {
+		// The cluster-scope deployment sees every node; node-local usage
+		// arrives from the DaemonSet agents instead.
+		nodeName = ""
+		if cfg.Metrics.Enabled || cfg.Network.Enabled {
+			logger.Info("eBPF collectors are disabled in cluster mode")
+			cfg.Metrics.Enabled = false
+			cfg.Network.Enabled = false
 		}
-	}
-	if nodeName == "" {
-		if host, err := os.Hostname(); err == nil && host != "" {
-			nodeName = host
+	} else {
+		if nodeName == "" {
+			if envNode := os.Getenv("NODE_NAME"); envNode != "" {
+				nodeName = envNode
+			}
+		}
+		if nodeName == "" {
+			if host, err := os.Hostname(); err == nil && host != "" {
+				nodeName = host
+			}
 		}
 	}
 	if nodeName != "" {
 		logger.Info("running in node scope", slog.String("nodeName", nodeName))
 	} else {
-		if cfg.Metrics.Enabled || cfg.Network.Enabled {
-			logger.Error("node name is required for eBPF collectors; set NODE_NAME or --node-name")
+		if cfg.Mode == config.ModeNode || cfg.Metrics.Enabled || cfg.Network.Enabled {
+			logger.Error("node name is required for node mode and eBPF collectors; set NODE_NAME or --node-name")
 			os.Exit(1)
 		}
 		logger.Warn("node name not set; using cluster-wide view")
 	}
 
+	agentMetrics := telemetry.New()
+	tracker := health.NewTracker(cfg.Server.ReadinessComponents)
+	tracker.Track(health.Informers)
+	if cfg.Metrics.Enabled {
+		tracker.Track(health.MetricsCollector)
+	}
+	if cfg.Network.Enabled {
+		tracker.Track(health.NetworkCollector)
+	}
+	if cfg.Metrics.Enabled || cfg.Network.Enabled {
+		tracker.Track(health.EBPF)
+	}
+
+	var elector *leader.Elector
+	if cfg.Mode == config.ModeCluster {
+		identity := os.Getenv("POD_NAME")
+		if identity == "" {
+			identity, _ = os.Hostname()
+		}
+		elector, err = leader.New(kubeClient.Kubernetes, cfg.LeaderElection, identity, logger)
+		if err != nil {
+			logger.Error("failed to configure leader election", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		elector.OnChange(agentMetrics.SetLeader)
+	}
+	// leaderOnly runs fn while this replica leads, which without leader
+	// election is for the lifetime of the agent.
+	leaderOnly := func(fn func(context.Context)) {
+		if elector == nil {
+			go fn(ctx)
+			return
+		}
+		elector.OnStartedLeading(fn)
+	}
+
 	cache := kube.NewClusterCache(kubeClient.Kubernetes, 0)
+	agentMetrics.WatchCaches(cache.Sizes)
 	if err := cache.Start(ctx); err != nil {
 		logger.Error("failed to start informers", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	tracker.Observe(health.Informers, nil)
 
 	if cfg.Metrics.Enabled || cfg.Network.Enabled {
 		report := ebpf.Preflight(cfg, logger)
@@ -128,6 +187,14 @@ func main() {
 				logger.Error("eBPF preflight failed", slog.String("component", issue.Component), slog.String("error", issue.Message))
 			}
 			logger.Warn("disabling eBPF collectors due to preflight errors")
+			preflightErr := fmt.Errorf("disabled by eBPF preflight: %s", report.Issues[0].Message)
+			tracker.Fail(health.EBPF, preflightErr)
+			if cfg.Metrics.Enabled {
+				tracker.Fail(health.MetricsCollector, preflightErr)
+			}
+			if cfg.Network.Enabled {
+				tracker.Fail(health.NetworkCollector, preflightErr)
+			}
 			cfg.Metrics.Enabled = false
 			cfg.Network.Enabled = false
 		}
@@ -141,54 +208,273 @@ func main() {
 			os.Exit(1)
 		}
 		defer ebpfMgr.Close()
+		tracker.Observe(health.EBPF, nil)
 	}
 
-	metricsCollector := collector.NewPodMetricsCollector(cfg.Metrics, logger)
+	metricsCollector := collector.NewPodMetricsCollector(cfg.Metrics, agentMetrics, logger)
 	networkCollector := collector.NewNetworkCollector(collector.NetworkCollectorConfig{
 		Enabled:    cfg.Network.Enabled,
 		BPFMapPath: cfg.Network.BPFMapPath,
-	}, logger)
+	}, agentMetrics, logger)
 	var sender *forwarder.Sender
 	var queue *forwarder.Queue
 	if cfg.Remote.Enabled && cfg.Remote.EndpointURL != "" {
 		sender = forwarder.NewSender(cfg.Remote.EndpointURL, cfg.Remote.AuthToken, cfg.Remote.Timeout, cfg.Remote.GzipEnabled)
+		sender.SetWireFormat(cfg.Remote.WireFormat == config.WireFormatProtobuf, cfg.Remote.ZstdEnabled)
 		queue = forwarder.NewQueue(cfg.Remote.QueueDir, cfg.Remote.MaxBatch, cfg.Remote.MaxRetries, cfg.Remote.Backoff, cfg.Remote.FlushEvery, cfg.Remote.MaxBatchBytes, cfg.Remote.MemoryBuffer, sender, logger)
-		logger.Info("remote forwarding enabled", slog.String("endpoint", cfg.Remote.EndpointURL))
-		go queue.Run(ctx)
+		queue.SetTelemetry(agentMetrics)
+		queue.SetHealth(tracker)
+		queue.SetRetention(forwarder.Retention{
+			MaxBytes:        cfg.Remote.MaxQueueBytes,
+			MaxAge:          cfg.Remote.MaxQueueAge,
+			Policy:          cfg.Remote.OverflowPolicy,
+			DownsampleEvery: cfg.Remote.DownsampleEvery,
+		})
+		if cfg.Remote.DeltaEnabled {
+			queue.EnableDelta(cfg.Remote.KeyframeEvery)
+		}
+		logger.Info("remote forwarding enabled", slog.String("endpoint", cfg.Remote.EndpointURL), slog.String("wireFormat", cfg.Remote.WireFormat))
+		leaderOnly(queue.Run)
+	}
+	builder, err := newBuilder(cfg, clusterID)
+	if err != nil {
+		logger.Error("invalid builder configuration", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
-	classifier := snapshot.NewEnvironmentClassifier(snapshot.ClassifierConfig{
-		LabelKeys:              cfg.Environment.LabelKeys,
-		ProductionLabelValues:  cfg.Environment.ProductionLabelValues,
-		NonProdLabelValues:     cfg.Environment.NonProdLabelValues,
-		SystemLabelValues:      cfg.Environment.SystemLabelValues,
-		ProductionNameContains: cfg.Environment.ProductionNameContains,
-		SystemNamespaces:       cfg.Environment.SystemNamespaces,
-	})
-	priceLookup := snapshot.NewNodePriceLookup(cfg.Pricing.InstancePrices, cfg.Pricing.DefaultNodeHourlyUSD)
-	networkPriceLookup := snapshot.NewNetworkPriceLookup(cfg.Pricing.Network.DefaultEgressGiBPriceUSD, cfg.Pricing.Network.EgressGiBPricesUSD)
-	builder := snapshot.NewBuilder(clusterID, classifier, priceLookup, networkPriceLookup)
 	store := snapshot.NewStore()
 
-	go runSnapshotLoop(ctx, builder, cache, metricsCollector, networkCollector, queue, clusterID, clusterName, nodeName, agentVersion, store, cfg.ScrapeInterval(), logger)
+	costs, budgets, err := newCostTracking(cfg, clusterName, nodeName, logger)
+	if err != nil {
+		logger.Error("invalid cost tracking configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if budgets != nil {
+		leaderOnly(budgets.Run)
+	}
+
+	if cfg.CRDs.Enabled {
+		dynamicClient, err := dynamic.NewForConfig(kubeClient.RestConfig)
+		if err != nil {
+			logger.Error("failed to create dynamic client", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		prices, netPrices := builder.Prices()
+		watcher := crd.NewWatcher(dynamicClient, cfg, prices, netPrices, store, logger)
+		watcher.Start(ctx)
+		builder.SetOverrides(watcher.Overrides)
+		budgets.SetSource(watcher.Budgets)
+		watcher.SetNodeName(nodeName)
+		logger.Info("clustercost.io custom resources enabled")
+		leaderOnly(watcher.Run)
+	}
 
-	apiHandler := api.NewHandler(clusterType, clusterName, clusterRegion, agentVersion, store)
+	if cfg.OTLP.Enabled {
+		otlpExporter, err := otlp.NewExporter(cfg.OTLP, clusterName, agentVersion, store, logger)
+		if err != nil {
+			logger.Error("failed to configure otlp exporter", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		otlpExporter.SetInstance(nodeName)
+		logger.Info("otlp export enabled", slog.String("endpoint", cfg.OTLP.Endpoint), slog.String("protocol", cfg.OTLP.Protocol))
+		leaderOnly(otlpExporter.Run)
+	}
+
+	var detector *anomaly.Detector
+	if cfg.Anomaly.Enabled {
+		var notifiers []anomaly.Notifier
+		for _, hook := range cfg.Anomaly.Webhooks {
+			notifiers = append(notifiers, anomaly.NewWebhookNotifier(hook, clusterName, cfg.Anomaly.Timeout))
+		}
+		if cfg.Anomaly.Events {
+			instance, _ := os.Hostname()
+			notifiers = append(notifiers, anomaly.NewEventNotifier(kubeClient.Kubernetes, instance, os.Getenv("POD_NAMESPACE")))
+		}
+		detector = anomaly.NewDetector(cfg.Anomaly, store, notifiers, logger)
+		logger.Info("cost anomaly detection enabled", slog.Float64("sensitivity", cfg.Anomaly.Sensitivity), slog.Int("notifiers", len(notifiers)))
+		leaderOnly(detector.Run)
+	}
+
+	var allocator *allocation.Allocator
+	if cfg.Allocation.Enabled {
+		allocator = allocation.New(allocation.Config{
+			Retention:            cfg.Allocation.Retention,
+			MaxGap:               cfg.Accumulation.MaxGap,
+			CPUCoreHourPrice:     cfg.Pricing.CPUCoreHourPriceUSD,
+			RAMGiBHourPrice:      cfg.Pricing.MemoryGiBHourPriceUSD,
+			GPUHourPrice:         cfg.Allocation.GPUHourPriceUSD,
+			StorageGiBMonthPrice: cfg.Allocation.StorageGiBMonthPriceUSD,
+		}, store, logger)
+		go allocator.Run(ctx)
+	}
+
+	seriesConfig := exporter.SnapshotCollectorConfig{
+		LabelKeys:           cfg.Prometheus.LabelKeys,
+		MaxPodSeries:        cfg.Prometheus.MaxPodSeries,
+		MaxConnectionSeries: cfg.Prometheus.MaxConnectionSeries,
+		ConnectionLevels:    cfg.Prometheus.ConnectionLevels,
+	}
+	if cfg.RemoteWrite.Enabled {
+		cfg.RemoteWrite.ExternalLabels = remoteWriteLabels(cfg.RemoteWrite.ExternalLabels, nodeName)
+		writer := remotewrite.NewWriter(cfg.RemoteWrite, exporter.NewSnapshotCollector(clusterName, store, seriesConfig), store, logger)
+		logger.Info("remote_write enabled", slog.String("url", cfg.RemoteWrite.URL), slog.String("queueDir", cfg.RemoteWrite.QueueDir))
+		leaderOnly(writer.Run)
+	}
+
+	go runSnapshotLoop(ctx, builder, cache, metricsCollector, networkCollector, queue, reportScope(cfg.Mode), elector, costs, budgets, clusterID, clusterName, nodeName, agentVersion, store, agentMetrics, tracker, cfg.ScrapeInterval(), logger)
+	go elector.Run(ctx)
+
+	apiHandler := api.NewHandler(clusterType, clusterName, clusterRegion, agentVersion, store, tracker)
 	mux := http.NewServeMux()
 	apiHandler.Register(mux)
 
-	server := exporter.NewServer(cfg.ListenAddr, mux, logger)
+	exportMeta := export.Meta{
+		ClusterID:   clusterID,
+		ClusterName: clusterName,
+		Provider:    cfg.Pricing.Provider,
+		Region:      clusterRegion,
+	}
+	api.NewExportHandler(exportMeta, store, costs, cfg.ScrapeInterval()).Register(mux)
 
-	if err := server.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+	if detector != nil {
+		api.NewAnomaliesHandler(detector).Register(mux)
+	}
+	if allocator != nil {
+		api.NewAllocationHandler(allocator).Register(mux)
+		api.NewOpenCostHandler(allocator).Register(mux)
+	}
+
+	// newBuilder already validated the rules.
+	classifierCfg, _ := classifierConfig(cfg)
+	api.NewEnvironmentsHandler(classifierCfg, func() ([]*corev1.Namespace, error) {
+		return cache.NamespaceLister().List(labels.Everything())
+	}).Register(mux)
+
+	stream := api.NewStreamHandler(store, cfg.Server.MaxStreamClients)
+	stream.Register(mux)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(agentMetrics)
+	registry.MustRegister(exporter.NewSnapshotCollector(clusterName, store, seriesConfig))
+	if costs != nil {
+		api.NewCostsHandler(costs).Register(mux)
+		api.NewChargebackHandler(exportMeta, costs).Register(mux)
+		registry.MustRegister(exporter.NewAccumulatedCostCollector(clusterName, costs))
+	}
+	if budgets != nil {
+		api.NewBudgetsHandler(budgets).Register(mux)
+	}
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	handler, err := secureHandler(mux, cfg.Server, publicPaths, kubeClient.Kubernetes, logger)
+	if err != nil {
+		logger.Error("failed to configure http auth", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if err := serve(ctx, cfg, handler, stream.Close, logger); err != nil {
 		logger.Error("server error", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 }
 
-func runSnapshotLoop(ctx context.Context, builder *snapshot.Builder, cache *kube.ClusterCache, metricsCollector collector.PodMetricsCollector, networkCollector collector.NetworkCollector, queue *forwarder.Queue, clusterID, clusterName, nodeName, version string, store *snapshot.Store, interval time.Duration, logger *slog.Logger) {
+// remoteWriteLabels defaults the "instance" label of a node-scoped agent to
+// its node name, because every DaemonSet agent pushes the same series.
+func remoteWriteLabels(labels map[string]string, nodeName string) map[string]string {
+	if nodeName == "" {
+		return labels
+	}
+	if _, ok := labels["instance"]; ok {
+		return labels
+	}
+	out := map[string]string{"instance": nodeName}
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+// publicPaths stay reachable without credentials so kubelet probes keep working.
+var publicPaths = []string{"/agent/v1/readyz", "/agent/v1/health"}
+
+func secureHandler(mux http.Handler, cfg config.ServerConfig, public []string, client kubernetes.Interface, logger *slog.Logger) (http.Handler, error) {
+	switch cfg.AuthMode {
+	case config.AuthModeToken:
+		auth, err := exporter.NewTokenFileAuthenticator(cfg.AuthTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("http auth enabled", slog.String("mode", cfg.AuthMode))
+		return exporter.RequireAuth(mux, auth, public, logger), nil
+	case config.AuthModeKubernetes:
+		logger.Info("http auth enabled", slog.String("mode", cfg.AuthMode))
+		return exporter.RequireAuth(mux, exporter.NewKubeAuthenticator(client, cfg.AuthCacheTTL), public, logger), nil
+	default:
+		return mux, nil
+	}
+}
+
+// newCostTracking restores accumulated totals and the budget alert state.
+// Both are nil when accumulation is disabled; the caller runs the budget
+// notifier. A node-scoped agent only accumulates its own node's costs, so
+// it gets no budgets and rejects configured ones.
+func newCostTracking(cfg config.Config, clusterName, nodeName string, logger *slog.Logger) (*accumulator.Accumulator, *budget.Evaluator, error) {
+	if !cfg.Accumulation.Enabled {
+		return nil, nil, nil
+	}
+	location, err := time.LoadLocation(cfg.Accumulation.Timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("accumulation timezone: %w", err)
+	}
+	costs := accumulator.New(accumulator.Config{
+		CheckpointPath:       cfg.Accumulation.CheckpointPath,
+		MaxGap:               cfg.Accumulation.MaxGap,
+		Location:             location,
+		Retention:            cfg.Accumulation.Retention,
+		StorageGiBMonthPrice: cfg.Allocation.StorageGiBMonthPriceUSD,
+	}, logger)
+	if err := costs.Load(); err != nil {
+		logger.Warn("failed to restore cost checkpoint; starting from zero", slog.String("error", err.Error()))
+	}
+
+	if nodeName != "" {
+		if len(cfg.Budgets.Definitions) > 0 {
+			return nil, nil, fmt.Errorf("budgets need the cluster-wide totals of --mode cluster or central, not node %q", nodeName)
+		}
+		return costs, nil, nil
+	}
+	budgets, err := budget.New(cfg.Budgets, clusterName, costs, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("budgets: %w", err)
+	}
+	if err := budgets.Load(); err != nil {
+		logger.Warn("failed to restore budget alert state", slog.String("error", err.Error()))
+	}
+	return costs, budgets, nil
+}
+
+// serve runs the HTTP server, with TLS when a certificate is configured,
+// until ctx is done.
+func serve(ctx context.Context, cfg config.Config, handler http.Handler, onShutdown func(), logger *slog.Logger) error {
+	server := exporter.NewServer(cfg.ListenAddr, handler, logger)
+	server.RegisterOnShutdown(onShutdown)
+	if cfg.Server.TLSCertFile != "" {
+		certs, err := exporter.NewCertReloader(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile, cfg.Server.TLSReloadInterval, logger)
+		if err != nil {
+			return fmt.Errorf("load tls certificate: %w", err)
+		}
+		server.EnableTLS(certs)
+	}
+	if err := server.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func runSnapshotLoop(ctx context.Context, builder *snapshot.Builder, cache *kube.ClusterCache, metricsCollector collector.PodMetricsCollector, networkCollector collector.NetworkCollector, queue *forwarder.Queue, scope string, elector *leader.Elector, costs *accumulator.Accumulator, budgets *budget.Evaluator, clusterID, clusterName, nodeName, version string, store *snapshot.Store, metrics *telemetry.Metrics, tracker *health.Tracker, interval time.Duration, logger *slog.Logger) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
-		if err := buildOnce(ctx, builder, cache, metricsCollector, networkCollector, queue, clusterID, clusterName, nodeName, version, store, logger); err != nil {
+		if err := buildOnce(ctx, builder, cache, metricsCollector, networkCollector, queue, scope, elector, costs, budgets, clusterID, clusterName, nodeName, version, store, metrics, tracker, logger); err != nil {
 			logger.Warn("snapshot refresh failed", slog.String("error", err.Error()))
 		}
 
@@ -200,26 +486,72 @@ func runSnapshotLoop(ctx context.Context, builder *snapshot.Builder, cache *kube
 	}
 }
 
-func buildOnce(ctx context.Context, builder *snapshot.Builder, cache *kube.ClusterCache, metricsCollector collector.PodMetricsCollector, networkCollector collector.NetworkCollector, queue *forwarder.Queue, clusterID, clusterName, nodeName, version string, store *snapshot.Store, logger *slog.Logger) error {
-	nodes, err := cache.NodeLister().List(labels.Everything())
+func buildOnce(ctx context.Context, builder *snapshot.Builder, cache *kube.ClusterCache, metricsCollector collector.PodMetricsCollector, networkCollector collector.NetworkCollector, queue *forwarder.Queue, scope string, elector *leader.Elector, costs *accumulator.Accumulator, budgets *budget.Evaluator, clusterID, clusterName, nodeName, version string, store *snapshot.Store, metrics *telemetry.Metrics, tracker *health.Tracker, logger *slog.Logger) error {
+	start := time.Now()
+	snap, err := collectSnapshot(ctx, builder, cache, metricsCollector, networkCollector, nodeName, metrics, tracker, logger)
+	metrics.ObserveBuild(time.Since(start), err)
 	if err != nil {
 		return err
 	}
-	namespaces, err := cache.NamespaceLister().List(labels.Everything())
-	if err != nil {
-		return err
+	store.Update(snap)
+
+	if costs != nil {
+		costs.Observe(snap)
+		if err := costs.Checkpoint(); err != nil {
+			logger.Warn("cost checkpoint failed", slog.String("error", err.Error()))
+		}
+		budgets.Evaluate(snap)
 	}
-	pods, err := cache.PodLister().List(labels.Everything())
-	if err != nil {
-		return err
+
+	// Standby replicas keep a warm snapshot for the API but leave
+	// forwarding to the leader.
+	if queue != nil && elector.IsLeader() {
+		report := forwarder.AgentReport{
+			SchemaVersion: forwarder.SchemaVersion,
+			ClusterID:     clusterID,
+			ClusterName:   clusterName,
+			NodeName:      nodeName,
+			Scope:         scope,
+			Version:       version,
+			Timestamp:     time.Now().UTC(),
+			Snapshot:      store.LatestSnapshot(),
+		}
+		if scope == forwarder.ScopeNode {
+			report.Snapshot = forwarder.NodeUsage(report.Snapshot)
+		}
+		if err := queue.Enqueue(report); err != nil {
+			logger.Warn("queue enqueue failed", slog.String("error", err.Error()))
+		}
 	}
-	services, err := cache.ServiceLister().List(labels.Everything())
-	if err != nil {
-		return err
+	return nil
+}
+
+// reportScope is the forwarded report scope for an agent mode; plain agents
+// leave it unset.
+func reportScope(mode string) string {
+	switch mode {
+	case config.ModeCluster:
+		return forwarder.ScopeCluster
+	case config.ModeNode:
+		return forwarder.ScopeNode
 	}
-	endpoints, err := cache.EndpointsLister().List(labels.Everything())
+	return ""
+}
+
+// collectSnapshot lists cached objects, gathers usage, and builds a snapshot.
+func collectSnapshot(ctx context.Context, builder *snapshot.Builder, cache *kube.ClusterCache, metricsCollector collector.PodMetricsCollector, networkCollector collector.NetworkCollector, nodeName string, metrics *telemetry.Metrics, tracker *health.Tracker, logger *slog.Logger) (snapshot.Snapshot, error) {
+	nodes, namespaces, pods, services, endpoints, err := listCached(cache)
+	var workloads []kube.Workload
+	var claims []*corev1.PersistentVolumeClaim
+	if err == nil {
+		workloads, err = cache.Workloads()
+	}
+	if err == nil {
+		claims, err = cache.PersistentVolumeClaimLister().List(labels.Everything())
+	}
+	tracker.Observe(health.Informers, err)
 	if err != nil {
-		return err
+		return snapshot.Snapshot{}, err
 	}
 
 	if nodeName != "" {
@@ -228,7 +560,10 @@ func buildOnce(ctx context.Context, builder *snapshot.Builder, cache *kube.Clust
 	}
 
 	metricsCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	start := time.Now()
 	usage, metricsErr := metricsCollector.CollectPodMetrics(metricsCtx, pods)
+	metrics.ObserveCollector("metrics", time.Since(start), metricsErr)
+	tracker.Observe(health.MetricsCollector, metricsErr)
 	cancel()
 	if metricsErr != nil {
 		logger.Warn("pod metrics collection failed", slog.String("error", metricsErr.Error()))
@@ -238,28 +573,141 @@ func buildOnce(ctx context.Context, builder *snapshot.Builder, cache *kube.Clust
 	}
 
 	networkCtx, cancelNetwork := context.WithTimeout(ctx, 15*time.Second)
+	start = time.Now()
 	networkCollection, networkErr := networkCollector.CollectPodNetwork(networkCtx, pods, nodes)
+	metrics.ObserveCollector("network", time.Since(start), networkErr)
+	tracker.Observe(health.NetworkCollector, networkErr)
 	cancelNetwork()
 	if networkErr != nil {
 		logger.Warn("network usage collection failed", slog.String("error", networkErr.Error()))
 	}
 
-	store.Update(builder.Build(nodes, namespaces, pods, services, endpoints, usage, networkCollection, time.Now().UTC()))
+	return builder.Build(nodes, namespaces, pods, services, endpoints, workloads, claims, usage, networkCollection, time.Now().UTC()), nil
+}
 
-	if queue != nil {
-		report := forwarder.AgentReport{
-			ClusterID:   clusterID,
-			ClusterName: clusterName,
-			NodeName:    nodeName,
-			Version:     version,
-			Timestamp:   time.Now().UTC(),
-			Snapshot:    store.LatestSnapshot(),
+func listCached(cache *kube.ClusterCache) ([]*corev1.Node, []*corev1.Namespace, []*corev1.Pod, []*corev1.Service, []*discoveryv1.EndpointSlice, error) {
+	nodes, err := cache.NodeLister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	namespaces, err := cache.NamespaceLister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	pods, err := cache.PodLister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	services, err := cache.ServiceLister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	endpoints, err := cache.EndpointsLister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	return nodes, namespaces, pods, services, endpoints, nil
+}
+
+func classifierConfig(cfg config.Config) (snapshot.ClassifierConfig, error) {
+	out := snapshot.ClassifierConfig{
+		LabelKeys:              cfg.Environment.LabelKeys,
+		ProductionLabelValues:  cfg.Environment.ProductionLabelValues,
+		NonProdLabelValues:     cfg.Environment.NonProdLabelValues,
+		SystemLabelValues:      cfg.Environment.SystemLabelValues,
+		ProductionNameContains: cfg.Environment.ProductionNameContains,
+		SystemNamespaces:       cfg.Environment.SystemNamespaces,
+	}
+	for i, r := range cfg.Environment.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
 		}
-		if err := queue.Enqueue(report); err != nil {
-			logger.Warn("queue enqueue failed", slog.String("error", err.Error()))
+		rule, err := snapshot.CompileEnvironmentRule(name, r.Environment, r.NamespaceRegex, r.Selector, r.Annotations)
+		if err != nil {
+			return snapshot.ClassifierConfig{}, err
 		}
+		out.Rules = append(out.Rules, rule)
 	}
-	return nil
+	return out, nil
+}
+
+// configuredClusterName returns the configured cluster name, or the
+// CLUSTER_NAME override.
+func configuredClusterName(cfg config.Config, logger *slog.Logger) string {
+	if override := os.Getenv("CLUSTER_NAME"); override != "" {
+		logger.Info("cluster name override from env", slog.String("clusterName", override))
+		return override
+	}
+	return cfg.ClusterName
+}
+
+// resolveCluster derives the cluster ID and name the agent reports under
+// from the configured ones, detecting a missing or placeholder name from
+// the cluster when client is set. Every output of the agent uses them so
+// its data joins.
+func resolveCluster(ctx context.Context, cfg config.Config, clusterName string, client kubernetes.Interface, logger *slog.Logger) (string, string) {
+	const placeholderName = "kubernetes"
+	const unknownClusterName = "unknown"
+
+	clusterID := cfg.ClusterID
+	if cfg.Mode == config.ModeNode {
+		// Node agents only forward usage; naming and pricing belong to the
+		// cluster-scope leader, so skip the cluster-wide lookups.
+		if clusterName == "" {
+			clusterName = clusterID
+		}
+		return clusterID, clusterName
+	}
+	if clusterName != "" && clusterName != placeholderName {
+		return clusterID, clusterName
+	}
+
+	// Without cluster access only the fallbacks below apply.
+	if client != nil {
+		detectCtx, cancelDetect := context.WithTimeout(ctx, 10*time.Second)
+		defer cancelDetect()
+		if detectedName, err := kube.DetectClusterName(detectCtx, client); err == nil && detectedName != "" {
+			clusterName = detectedName
+			if clusterID == "" || clusterID == placeholderName {
+				clusterID = detectedName
+			}
+			logger.Info("detected cluster name", slog.String("clusterName", detectedName))
+		} else if err != nil {
+			logger.Warn("failed to detect cluster name", slog.String("error", err.Error()))
+		}
+	}
+
+	if clusterName == "" || clusterName == placeholderName {
+		clusterName = unknownClusterName
+		logger.Info("defaulting cluster name to unknown")
+	}
+	if clusterID == "" || clusterID == placeholderName {
+		clusterID = clusterName
+	}
+	return clusterID, clusterName
+}
+
+func newBuilder(cfg config.Config, clusterID string) (*snapshot.Builder, error) {
+	classifierCfg, err := classifierConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	classifier := snapshot.NewEnvironmentClassifier(classifierCfg)
+	priceLookup := snapshot.NewNodePriceLookup(cfg.Pricing.InstancePrices, cfg.Pricing.DefaultNodeHourlyUSD)
+	networkPriceLookup := snapshot.NewNetworkPriceLookup(cfg.Pricing.Network.DefaultEgressGiBPriceUSD, cfg.Pricing.Network.EgressGiBPricesUSD)
+	builder := snapshot.NewBuilder(clusterID, classifier, priceLookup, networkPriceLookup)
+	builder.SetAttributionDefaults(enricher.Defaults{
+		Team:         cfg.Attribution.DefaultTeam,
+		CostCenter:   cfg.Attribution.DefaultCostCenter,
+		SharedWeight: cfg.Attribution.DefaultSharedWeight,
+	})
+	dimensions, err := enricher.DimensionsFromConfig(cfg.Enricher.Dimensions)
+	if err != nil {
+		return nil, fmt.Errorf("enricher dimensions: %w", err)
+	}
+	builder.SetEnricher(enricher.NewLabelEnricher(dimensions...))
+	return builder, nil
 }
 
 func filterNodes(nodes []*corev1.Node, nodeName string) []*corev1.Node {
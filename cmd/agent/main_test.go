@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"io"
+	"log/slog"
 	"testing"
 
+	"clustercost-agent-k8s/internal/config"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -28,3 +33,37 @@ func TestFilterPods(t *testing.T) {
 		t.Fatalf("unexpected pod filter result: %+v", filtered)
 	}
 }
+
+func TestNodeScopedAgentHasNoBudgets(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Accumulation.Enabled = true
+	cfg.Accumulation.CheckpointPath = ""
+	cfg.Budgets.StatePath = ""
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	costs, budgets, err := newCostTracking(cfg, "prod", "node-a", logger)
+	if err != nil || costs == nil || budgets != nil {
+		t.Fatalf("expected accumulation without budgets, got %v %v %v", costs, budgets, err)
+	}
+	cfg.Budgets.Definitions = []config.BudgetConfig{{Name: "cluster", Amount: 100, Period: "month"}}
+	if _, _, err := newCostTracking(cfg, "prod", "node-a", logger); err == nil {
+		t.Fatal("expected configured budgets to be rejected in node scope")
+	}
+	if _, budgets, err := newCostTracking(cfg, "prod", "", logger); err != nil || budgets == nil {
+		t.Fatalf("expected budgets for a cluster-wide agent, got %v %v", budgets, err)
+	}
+}
+
+func TestResolveClusterFallsBackWithoutClient(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Mode = config.ModeCentral
+	cfg.ClusterID = "prod"
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if id, name := resolveCluster(context.Background(), cfg, "kubernetes", nil, logger); id != "prod" || name != "unknown" {
+		t.Fatalf("expected the agents' fallback name, got %q %q", id, name)
+	}
+	if id, name := resolveCluster(context.Background(), cfg, "shop", nil, logger); id != "prod" || name != "shop" {
+		t.Fatalf("expected the configured name, got %q %q", id, name)
+	}
+}
@@ -0,0 +1,504 @@
+package accumulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+const (
+	checkpointVersion = 1
+
+	hoursPerMonth = 730
+	bytesPerGiB   = 1 << 30
+)
+
+// Config controls how hourly rates are integrated into calendar totals.
+type Config struct {
+	// CheckpointPath is the JSON file used to persist totals across restarts.
+	// An empty path keeps totals in memory only.
+	CheckpointPath string
+	// MaxGap bounds the interval integrated between two snapshots. Longer gaps
+	// (agent down, cluster unreachable) are not charged because the rates in
+	// effect during the gap are unknown.
+	MaxGap time.Duration
+	// Location defines calendar boundaries; defaults to UTC.
+	Location *time.Location
+	// Retention is the number of closed periods kept per window.
+	Retention int
+	// StorageGiBMonthPrice prices the storage requested by pods, with a
+	// month of 730 hours.
+	StorageGiBMonthPrice float64
+}
+
+// Totals holds accumulated spend in USD. SharedCost is the part of
+// ComputeCost received from shared namespaces.
+type Totals struct {
+	ComputeCost float64 `json:"computeCost"`
+	NetworkCost float64 `json:"networkCost"`
+	StorageCost float64 `json:"storageCost"`
+	SharedCost  float64 `json:"sharedCost"`
+	TotalCost   float64 `json:"totalCost"`
+}
+
+// NamespaceInfo is the namespace metadata last seen in a period, so closed
+// periods can be grouped by the ownership in effect at the time.
+type NamespaceInfo struct {
+	Environment string            `json:"environment,omitempty"`
+	Dimensions  map[string]string `json:"dimensions,omitempty"`
+}
+
+// Period is the accumulated spend for one calendar window.
+type Period struct {
+	Window         Window            `json:"window"`
+	Start          time.Time         `json:"start"`
+	End            time.Time         `json:"end"`
+	CoveredSeconds float64           `json:"coveredSeconds"`
+	Cluster        Totals            `json:"cluster"`
+	Namespaces     map[string]Totals `json:"namespaces"`
+	Nodes          map[string]Totals `json:"nodes"`
+	// Workloads are keyed by namespace, controller kind and name, as in
+	// "payments/Deployment/api". Pods without a controller use kind Pod.
+	Workloads       map[string]Totals        `json:"workloads,omitempty"`
+	NamespaceInfo   map[string]NamespaceInfo `json:"namespaceInfo,omitempty"`
+	LastObservation time.Time                `json:"lastObservation,omitempty"`
+}
+
+// Contains reports whether t falls inside the period.
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.End)
+}
+
+// rates captures the hourly compute and storage rates from a snapshot.
+type rates struct {
+	Cluster          float64            `json:"cluster"`
+	Namespaces       map[string]float64 `json:"namespaces"`
+	Nodes            map[string]float64 `json:"nodes"`
+	NamespaceShared  map[string]float64 `json:"namespaceShared,omitempty"`
+	Workloads        map[string]float64 `json:"workloads,omitempty"`
+	ClusterStorage   float64            `json:"clusterStorage,omitempty"`
+	NamespaceStorage map[string]float64 `json:"namespaceStorage,omitempty"`
+	WorkloadStorage  map[string]float64 `json:"workloadStorage,omitempty"`
+}
+
+// amounts captures spend already expressed in dollars for one collection interval.
+type amounts struct {
+	Cluster    float64
+	Namespaces map[string]float64
+	Nodes      map[string]float64
+	Workloads  map[string]float64
+}
+
+type checkpoint struct {
+	Version       int                 `json:"version"`
+	LastTimestamp time.Time           `json:"lastTimestamp"`
+	LastRates     rates               `json:"lastRates"`
+	Current       map[Window]*Period  `json:"current"`
+	History       map[Window][]Period `json:"history"`
+}
+
+// Accumulator integrates snapshot hourly rates into running calendar totals.
+type Accumulator struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu            sync.RWMutex
+	lastTimestamp time.Time
+	lastRates     rates
+	current       map[Window]*Period
+	history       map[Window][]Period
+}
+
+// New returns an empty Accumulator.
+func New(cfg Config, logger *slog.Logger) *Accumulator {
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+	if cfg.Retention < 0 {
+		cfg.Retention = 0
+	}
+	return &Accumulator{
+		cfg:     cfg,
+		logger:  logger,
+		current: map[Window]*Period{},
+		history: map[Window][]Period{},
+	}
+}
+
+// Load restores totals from the checkpoint file if it exists.
+func (a *Accumulator) Load() error {
+	if a == nil || a.cfg.CheckpointPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.cfg.CheckpointPath) // #nosec G304 -- path provided by cluster operator
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("decode checkpoint: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastTimestamp = cp.LastTimestamp
+	a.lastRates = cp.LastRates
+	a.current = map[Window]*Period{}
+	for w, p := range cp.Current {
+		if p == nil {
+			continue
+		}
+		p.Start = p.Start.In(a.cfg.Location)
+		p.End = p.End.In(a.cfg.Location)
+		ensureMaps(p)
+		a.current[w] = p
+	}
+	a.history = map[Window][]Period{}
+	for w, periods := range cp.History {
+		a.history[w] = periods
+	}
+	return nil
+}
+
+// Checkpoint atomically writes the current totals to disk.
+func (a *Accumulator) Checkpoint() error {
+	if a == nil || a.cfg.CheckpointPath == "" {
+		return nil
+	}
+	a.mu.RLock()
+	data, err := json.Marshal(checkpoint{
+		Version:       checkpointVersion,
+		LastTimestamp: a.lastTimestamp,
+		LastRates:     a.lastRates,
+		Current:       a.current,
+		History:       a.history,
+	})
+	a.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.cfg.CheckpointPath), 0o750); err != nil {
+		return fmt.Errorf("create checkpoint dir: %w", err)
+	}
+	tmpPath := a.cfg.CheckpointPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmpPath, a.cfg.CheckpointPath); err != nil {
+		return fmt.Errorf("commit checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Observe integrates the rates of the previous snapshot over the elapsed time
+// up to snap.Timestamp and adds the network spend carried by snap.
+func (a *Accumulator) Observe(snap snapshot.Snapshot) {
+	if a == nil || snap.Timestamp.IsZero() {
+		return
+	}
+	now := snap.Timestamp
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Before(a.lastTimestamp) {
+		// Out-of-order snapshots would reopen a closed period; drop them.
+		return
+	}
+	if !a.lastTimestamp.IsZero() && now.After(a.lastTimestamp) {
+		elapsed := now.Sub(a.lastTimestamp)
+		if a.cfg.MaxGap > 0 && elapsed > a.cfg.MaxGap {
+			if a.logger != nil {
+				a.logger.Warn("cost accumulation gap exceeds max gap; skipping interval",
+					slog.Duration("gap", elapsed),
+					slog.Duration("maxGap", a.cfg.MaxGap),
+				)
+			}
+		} else {
+			for _, w := range Windows {
+				a.integrate(w, a.lastTimestamp, now, a.lastRates)
+			}
+		}
+	}
+
+	spend := networkAmounts(snap)
+	for _, w := range Windows {
+		p := a.periodFor(w, now)
+		addAmounts(p, spend)
+		for _, ns := range snap.Namespaces {
+			p.NamespaceInfo[ns.Namespace] = NamespaceInfo{Environment: ns.Environment, Dimensions: ns.Dimensions}
+		}
+		p.LastObservation = now
+	}
+
+	a.lastTimestamp = now
+	a.lastRates = computeRates(snap, a.cfg.StorageGiBMonthPrice)
+}
+
+// Current returns copies of the open periods in display order.
+func (a *Accumulator) Current() []Period {
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]Period, 0, len(Windows))
+	for _, w := range Windows {
+		if p := a.current[w]; p != nil {
+			out = append(out, clonePeriod(*p))
+		}
+	}
+	return out
+}
+
+// CurrentWindow returns a copy of the open period for w.
+func (a *Accumulator) CurrentWindow(w Window) (Period, bool) {
+	if a == nil {
+		return Period{}, false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	p := a.current[w]
+	if p == nil {
+		return Period{}, false
+	}
+	return clonePeriod(*p), true
+}
+
+// History returns copies of the closed periods for w, oldest first.
+func (a *Accumulator) History(w Window) []Period {
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	periods := a.history[w]
+	out := make([]Period, 0, len(periods))
+	for _, p := range periods {
+		out = append(out, clonePeriod(p))
+	}
+	return out
+}
+
+// Location returns the time zone used for calendar boundaries.
+func (a *Accumulator) Location() *time.Location {
+	if a == nil {
+		return time.UTC
+	}
+	return a.cfg.Location
+}
+
+// integrate charges r over [from, to), splitting at window boundaries.
+func (a *Accumulator) integrate(w Window, from, to time.Time, r rates) {
+	for from.Before(to) {
+		p := a.periodFor(w, from)
+		segEnd := to
+		if p.End.Before(segEnd) {
+			segEnd = p.End
+		}
+		hours := segEnd.Sub(from).Hours()
+		p.CoveredSeconds += segEnd.Sub(from).Seconds()
+		p.Cluster.ComputeCost += r.Cluster * hours
+		p.Cluster.StorageCost += r.ClusterStorage * hours
+		p.Cluster.TotalCost += (r.Cluster + r.ClusterStorage) * hours
+		chargeCompute(p.Namespaces, r.Namespaces, hours)
+		chargeCompute(p.Nodes, r.Nodes, hours)
+		chargeCompute(p.Workloads, r.Workloads, hours)
+		chargeStorage(p.Namespaces, r.NamespaceStorage, hours)
+		chargeStorage(p.Workloads, r.WorkloadStorage, hours)
+		for name, rate := range r.NamespaceShared {
+			t := p.Namespaces[name]
+			t.SharedCost += rate * hours
+			p.Namespaces[name] = t
+		}
+		from = segEnd
+	}
+}
+
+func chargeCompute(totals map[string]Totals, rates map[string]float64, hours float64) {
+	for name, rate := range rates {
+		t := totals[name]
+		t.ComputeCost += rate * hours
+		t.TotalCost += rate * hours
+		totals[name] = t
+	}
+}
+
+func chargeStorage(totals map[string]Totals, rates map[string]float64, hours float64) {
+	for name, rate := range rates {
+		t := totals[name]
+		t.StorageCost += rate * hours
+		t.TotalCost += rate * hours
+		totals[name] = t
+	}
+}
+
+// periodFor returns the open period containing t, closing stale periods.
+func (a *Accumulator) periodFor(w Window, t time.Time) *Period {
+	p := a.current[w]
+	if p != nil && p.Contains(t) {
+		return p
+	}
+	if p != nil && !t.Before(p.End) {
+		a.close(w, *p)
+	}
+	start, end := w.Bounds(t, a.cfg.Location)
+	p = &Period{Window: w, Start: start, End: end}
+	ensureMaps(p)
+	a.current[w] = p
+	return p
+}
+
+func (a *Accumulator) close(w Window, p Period) {
+	if a.cfg.Retention == 0 {
+		return
+	}
+	hist := append(a.history[w], p)
+	if len(hist) > a.cfg.Retention {
+		hist = hist[len(hist)-a.cfg.Retention:]
+	}
+	a.history[w] = hist
+}
+
+func computeRates(snap snapshot.Snapshot, storageGiBMonthPrice float64) rates {
+	r := rates{
+		Cluster:          snap.Resources.TotalNodeHourlyCost,
+		Namespaces:       make(map[string]float64, len(snap.Namespaces)),
+		Nodes:            make(map[string]float64, len(snap.Nodes)),
+		NamespaceShared:  map[string]float64{},
+		Workloads:        map[string]float64{},
+		NamespaceStorage: map[string]float64{},
+		WorkloadStorage:  map[string]float64{},
+	}
+	for _, ns := range snap.Namespaces {
+		r.Namespaces[ns.Namespace] = ns.HourlyCost
+		if ns.SharedCostHourly > 0 {
+			r.NamespaceShared[ns.Namespace] = ns.SharedCostHourly
+		}
+	}
+	for _, node := range snap.Nodes {
+		r.Nodes[node.NodeName] = node.HourlyCost
+	}
+	storagePerByte := storageGiBMonthPrice / hoursPerMonth / bytesPerGiB
+	for _, pod := range snap.Pods {
+		key := WorkloadKey(pod)
+		r.Workloads[key] += pod.HourlyCost
+		if storage := float64(pod.StorageRequestBytes) * storagePerByte; storage > 0 {
+			r.WorkloadStorage[key] += storage
+			r.NamespaceStorage[pod.Namespace] += storage
+			r.ClusterStorage += storage
+		}
+	}
+	return r
+}
+
+// WorkloadKey names the workload a pod belongs to in Period.Workloads.
+func WorkloadKey(pod snapshot.PodCostRecord) string {
+	if pod.ControllerKind == "" || pod.ControllerName == "" {
+		return pod.Namespace + "/Pod/" + pod.Pod
+	}
+	return pod.Namespace + "/" + pod.ControllerKind + "/" + pod.ControllerName
+}
+
+// networkAmounts extracts egress spend. The builder prices the bytes observed
+// since the previous collection, so these values are dollar amounts for the
+// interval rather than hourly rates and are added without integration.
+func networkAmounts(snap snapshot.Snapshot) amounts {
+	out := amounts{
+		Cluster:    snap.Resources.NetworkEgressCostTotal,
+		Namespaces: map[string]float64{},
+		Nodes:      map[string]float64{},
+		Workloads:  map[string]float64{},
+	}
+	workloads := make(map[string]string, len(snap.Pods))
+	for _, pod := range snap.Pods {
+		workloads[pod.Namespace+"/"+pod.Pod] = WorkloadKey(pod)
+	}
+	for _, ns := range snap.Namespaces {
+		if ns.NetworkEgressCost > 0 {
+			out.Namespaces[ns.Namespace] += ns.NetworkEgressCost
+		}
+	}
+	for _, pod := range snap.Network.Pods {
+		if pod.EgressCostHourly > 0 && pod.Node != "" {
+			out.Nodes[pod.Node] += pod.EgressCostHourly
+		}
+		if key, ok := workloads[pod.Namespace+"/"+pod.Pod]; ok && pod.EgressCostHourly > 0 {
+			out.Workloads[key] += pod.EgressCostHourly
+		}
+	}
+	return out
+}
+
+func addAmounts(p *Period, spend amounts) {
+	p.Cluster.NetworkCost += spend.Cluster
+	p.Cluster.TotalCost += spend.Cluster
+	for name, cost := range spend.Namespaces {
+		t := p.Namespaces[name]
+		t.NetworkCost += cost
+		t.TotalCost += cost
+		p.Namespaces[name] = t
+	}
+	for name, cost := range spend.Nodes {
+		t := p.Nodes[name]
+		t.NetworkCost += cost
+		t.TotalCost += cost
+		p.Nodes[name] = t
+	}
+	for name, cost := range spend.Workloads {
+		t := p.Workloads[name]
+		t.NetworkCost += cost
+		t.TotalCost += cost
+		p.Workloads[name] = t
+	}
+}
+
+func ensureMaps(p *Period) {
+	if p.Namespaces == nil {
+		p.Namespaces = map[string]Totals{}
+	}
+	if p.Nodes == nil {
+		p.Nodes = map[string]Totals{}
+	}
+	if p.Workloads == nil {
+		p.Workloads = map[string]Totals{}
+	}
+	if p.NamespaceInfo == nil {
+		p.NamespaceInfo = map[string]NamespaceInfo{}
+	}
+}
+
+func clonePeriod(p Period) Period {
+	out := p
+	out.Namespaces = make(map[string]Totals, len(p.Namespaces))
+	for k, v := range p.Namespaces {
+		out.Namespaces[k] = v
+	}
+	out.Nodes = make(map[string]Totals, len(p.Nodes))
+	for k, v := range p.Nodes {
+		out.Nodes[k] = v
+	}
+	out.Workloads = make(map[string]Totals, len(p.Workloads))
+	for k, v := range p.Workloads {
+		out.Workloads[k] = v
+	}
+	out.NamespaceInfo = make(map[string]NamespaceInfo, len(p.NamespaceInfo))
+	for k, v := range p.NamespaceInfo {
+		out.NamespaceInfo[k] = v
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package accumulator
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func TestObserveIntegratesElapsedTime(t *testing.T) {
+	acc := New(Config{}, nil)
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+
+	acc.Observe(testSnapshot(start, 1.0, 0))
+	// A missed scrape doubles the interval; the rate still applies to all of it.
+	acc.Observe(testSnapshot(start.Add(30*time.Minute), 2.0, 0))
+	acc.Observe(testSnapshot(start.Add(90*time.Minute), 2.0, 0))
+
+	day, ok := acc.CurrentWindow(WindowDay)
+	if !ok {
+		t.Fatalf("expected open day window")
+	}
+	// 0.5h at $1/h plus 1h at $2/h.
+	if !almostEqual(day.Cluster.ComputeCost, 2.5) {
+		t.Fatalf("cluster compute = %.4f, want 2.5", day.Cluster.ComputeCost)
+	}
+	if !almostEqual(day.Namespaces["payments"].ComputeCost, 1.25) {
+		t.Fatalf("namespace compute = %.4f, want 1.25", day.Namespaces["payments"].ComputeCost)
+	}
+	if !almostEqual(day.Nodes["node-a"].ComputeCost, 2.5) {
+		t.Fatalf("node compute = %.4f, want 2.5", day.Nodes["node-a"].ComputeCost)
+	}
+	if !almostEqual(day.CoveredSeconds, 5400) {
+		t.Fatalf("covered seconds = %.0f", day.CoveredSeconds)
+	}
+}
+
+func TestObserveAddsNetworkAmounts(t *testing.T) {
+	acc := New(Config{}, nil)
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	acc.Observe(testSnapshot(start, 0, 0.2))
+	acc.Observe(testSnapshot(start.Add(time.Minute), 0, 0.3))
+
+	month, _ := acc.CurrentWindow(WindowMonth)
+	if !almostEqual(month.Cluster.NetworkCost, 0.5) {
+		t.Fatalf("cluster network = %.4f, want 0.5", month.Cluster.NetworkCost)
+	}
+	if !almostEqual(month.Nodes["node-a"].NetworkCost, 0.5) {
+		t.Fatalf("node network = %.4f, want 0.5", month.Nodes["node-a"].NetworkCost)
+	}
+	if !almostEqual(month.Cluster.TotalCost, 0.5) {
+		t.Fatalf("cluster total = %.4f, want 0.5", month.Cluster.TotalCost)
+	}
+}
+
+func TestObserveSplitsAtWindowBoundary(t *testing.T) {
+	acc := New(Config{Retention: 5}, nil)
+	beforeMidnight := time.Date(2025, 3, 12, 23, 30, 0, 0, time.UTC)
+	acc.Observe(testSnapshot(beforeMidnight, 1.0, 0))
+	acc.Observe(testSnapshot(beforeMidnight.Add(time.Hour), 1.0, 0))
+
+	closed := acc.History(WindowDay)
+	if len(closed) != 1 {
+		t.Fatalf("expected one closed day, got %d", len(closed))
+	}
+	if !almostEqual(closed[0].Cluster.ComputeCost, 0.5) {
+		t.Fatalf("closed day compute = %.4f, want 0.5", closed[0].Cluster.ComputeCost)
+	}
+	day, _ := acc.CurrentWindow(WindowDay)
+	if !almostEqual(day.Cluster.ComputeCost, 0.5) {
+		t.Fatalf("open day compute = %.4f, want 0.5", day.Cluster.ComputeCost)
+	}
+	week, _ := acc.CurrentWindow(WindowWeek)
+	if !almostEqual(week.Cluster.ComputeCost, 1.0) {
+		t.Fatalf("week compute = %.4f, want 1.0", week.Cluster.ComputeCost)
+	}
+}
+
+func TestObserveSkipsGapsBeyondMaxGap(t *testing.T) {
+	acc := New(Config{MaxGap: 10 * time.Minute}, nil)
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	acc.Observe(testSnapshot(start, 1.0, 0))
+	acc.Observe(testSnapshot(start.Add(2*time.Hour), 1.0, 0))
+	acc.Observe(testSnapshot(start.Add(2*time.Hour+6*time.Minute), 1.0, 0))
+
+	day, _ := acc.CurrentWindow(WindowDay)
+	if !almostEqual(day.Cluster.ComputeCost, 0.1) {
+		t.Fatalf("cluster compute = %.4f, want 0.1", day.Cluster.ComputeCost)
+	}
+}
+
+func TestObserveTracksStorageAndWorkloads(t *testing.T) {
+	acc := New(Config{StorageGiBMonthPrice: 7.3}, nil)
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	snap := testSnapshot(start, 1.0, 0.2)
+	snap.Namespaces[0].SharedCostHourly = 0.1
+	snap.Namespaces[0].Dimensions = map[string]string{"team": "money"}
+	snap.Pods = []snapshot.PodCostRecord{
+		{Namespace: "payments", Pod: "api-0", ControllerKind: "Deployment", ControllerName: "api", HourlyCost: 0.4, StorageRequestBytes: 10 << 30},
+		{Namespace: "payments", Pod: "debug", HourlyCost: 0.1},
+	}
+	acc.Observe(snap)
+	snap.Timestamp = start.Add(time.Hour)
+	acc.Observe(snap)
+
+	day, _ := acc.CurrentWindow(WindowDay)
+	// 10 GiB at 7.3 per GiB-month is 0.1 per hour.
+	payments := day.Namespaces["payments"]
+	if !almostEqual(payments.StorageCost, 0.1) || !almostEqual(payments.SharedCost, 0.1) {
+		t.Fatalf("namespace totals = %+v", payments)
+	}
+	if !almostEqual(payments.TotalCost, 0.5+0.1+0.4) {
+		t.Fatalf("namespace total = %.4f, want 1.0", payments.TotalCost)
+	}
+	api := day.Workloads["payments/Deployment/api"]
+	if !almostEqual(api.ComputeCost, 0.4) || !almostEqual(api.StorageCost, 0.1) || !almostEqual(api.NetworkCost, 0.4) {
+		t.Fatalf("workload totals = %+v", api)
+	}
+	if !almostEqual(day.Workloads["payments/Pod/debug"].ComputeCost, 0.1) {
+		t.Fatalf("bare pod totals = %+v", day.Workloads["payments/Pod/debug"])
+	}
+	if !almostEqual(day.Cluster.StorageCost, 0.1) {
+		t.Fatalf("cluster storage = %.4f, want 0.1", day.Cluster.StorageCost)
+	}
+	if day.NamespaceInfo["payments"].Dimensions["team"] != "money" {
+		t.Fatalf("namespace info = %+v", day.NamespaceInfo)
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "costs.json")
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+
+	acc := New(Config{CheckpointPath: path}, nil)
+	acc.Observe(testSnapshot(start, 1.0, 0))
+	acc.Observe(testSnapshot(start.Add(time.Hour), 1.0, 0))
+	if err := acc.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+
+	restored := New(Config{CheckpointPath: path}, nil)
+	if err := restored.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	restored.Observe(testSnapshot(start.Add(90*time.Minute), 1.0, 0))
+
+	day, _ := restored.CurrentWindow(WindowDay)
+	if !almostEqual(day.Cluster.ComputeCost, 1.5) {
+		t.Fatalf("restored compute = %.4f, want 1.5", day.Cluster.ComputeCost)
+	}
+}
+
+func TestWindowBounds(t *testing.T) {
+	ts := time.Date(2025, 3, 12, 15, 4, 5, 0, time.UTC) // Wednesday
+	start, end := WindowWeek.Bounds(ts, time.UTC)
+	if !start.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected week bounds %s - %s", start, end)
+	}
+	start, end = WindowMonth.Bounds(ts, time.UTC)
+	if !start.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected month bounds %s - %s", start, end)
+	}
+}
+
+func testSnapshot(ts time.Time, nodeHourly, egress float64) snapshot.Snapshot {
+	return snapshot.Snapshot{
+		Timestamp: ts,
+		Namespaces: []snapshot.NamespaceCostRecord{
+			{Namespace: "payments", HourlyCost: nodeHourly / 2, NetworkEgressCost: egress},
+		},
+		Nodes: []snapshot.NodeCostRecord{
+			{NodeName: "node-a", HourlyCost: nodeHourly},
+		},
+		Resources: snapshot.ResourceSnapshot{
+			TotalNodeHourlyCost:    nodeHourly,
+			NetworkEgressCostTotal: egress,
+		},
+		Network: snapshot.NetworkSnapshot{
+			Pods: []snapshot.PodNetworkRecord{
+				{Namespace: "payments", Pod: "api-0", Node: "node-a", EgressCostHourly: egress},
+			},
+		},
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.0001
+}
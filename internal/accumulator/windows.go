@@ -0,0 +1,51 @@
+package accumulator
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window identifies a calendar accumulation window.
+type Window string
+
+const (
+	// WindowDay accumulates from local midnight to midnight.
+	WindowDay Window = "day"
+	// WindowWeek accumulates from Monday 00:00 to the following Monday.
+	WindowWeek Window = "week"
+	// WindowMonth accumulates from the first of the month to the first of the next.
+	WindowMonth Window = "month"
+)
+
+// Windows lists the supported windows in display order.
+var Windows = []Window{WindowDay, WindowWeek, WindowMonth}
+
+// ParseWindow validates a window name.
+func ParseWindow(raw string) (Window, error) {
+	switch Window(raw) {
+	case WindowDay, WindowWeek, WindowMonth:
+		return Window(raw), nil
+	default:
+		return "", fmt.Errorf("unknown window %q", raw)
+	}
+}
+
+// Bounds returns the start and end of the window containing t in loc.
+func (w Window) Bounds(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	switch w {
+	case WindowWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	case WindowMonth:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
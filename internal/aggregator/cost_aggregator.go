@@ -147,7 +147,9 @@ func (a *CostAggregator) Aggregate(snapshot kube.ClusterSnapshot, usage map[stri
 	missingInstanceLogged := map[string]struct{}{}
 
 	nsLabels := map[string]map[string]string{}
+	nsAnnotations := map[string]map[string]string{}
 	for _, ns := range snapshot.Namespaces {
+		nsAnnotations[ns.Name] = ns.Annotations
 		nsCopy := make(map[string]string, len(ns.Labels))
 		for k, v := range ns.Labels {
 			nsCopy[k] = v
@@ -185,7 +187,12 @@ func (a *CostAggregator) Aggregate(snapshot kube.ClusterSnapshot, usage map[stri
 		}
 		cost := a.calc.CostFor(cpuUsage, memUsage)
 
-		labels := a.enricher.Merge(nsLabels[pod.Namespace], pod.Labels)
+		// Ownership annotations win over the tracked labels.
+		attribution := enricher.Resolve(enricher.Defaults{},
+			enricher.Source{Kind: enricher.SourceNamespace, Annotations: nsAnnotations[pod.Namespace]},
+			enricher.Source{Kind: enricher.SourcePod, Annotations: pod.Annotations},
+		)
+		labels := attribution.Labels(a.enricher.Merge(nsLabels[pod.Namespace], pod.Labels))
 		podCost := PodCost{
 			Namespace:          pod.Namespace,
 			Pod:                pod.Name,
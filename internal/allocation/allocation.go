@@ -0,0 +1,677 @@
+// Package allocation keeps hourly cost per pod and answers queries that
+// group pods by any combination of their properties over a time window.
+package allocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+const (
+	// Unallocated is the value of a property a pod does not have, such as
+	// the controller of a bare pod or a missing label.
+	Unallocated = "__unallocated__"
+
+	bucketSize    = time.Hour
+	hoursPerMonth = 730
+	bytesPerGiB   = 1 << 30
+)
+
+// ErrNoData is returned by Query before the first snapshot was observed.
+var ErrNoData = errors.New("no allocation data yet")
+
+// Config controls how snapshots are turned into allocations.
+type Config struct {
+	// Retention is how far back queries can reach.
+	Retention time.Duration
+	// MaxGap bounds the interval integrated between two snapshots.
+	MaxGap time.Duration
+	// CPUCoreHourPrice, RAMGiBHourPrice and GPUHourPrice weigh the
+	// resources of a node when its price is split between them.
+	CPUCoreHourPrice float64
+	RAMGiBHourPrice  float64
+	GPUHourPrice     float64
+	// StorageGiBMonthPrice prices the storage requested by pods.
+	StorageGiBMonthPrice float64
+}
+
+// Properties describe the pod an allocation was charged to.
+type Properties struct {
+	Cluster        string
+	Namespace      string
+	Node           string
+	Pod            string
+	ControllerKind string
+	Controller     string
+	Environment    string
+	Labels         map[string]string
+	Annotations    map[string]string
+}
+
+// NodeProperties describe the node an asset cost was charged to.
+type NodeProperties struct {
+	Cluster      string
+	Node         string
+	InstanceType string
+	Labels       map[string]string
+}
+
+// usage is resource time and cost, either per hour or accumulated.
+type usage struct {
+	CPUCoreHours        float64
+	CPUCoreRequestHours float64
+	CPUCoreUsageHours   float64
+	RAMByteHours        float64
+	RAMByteRequestHours float64
+	RAMByteUsageHours   float64
+	GPUHours            float64
+	PVByteHours         float64
+	CPUCost             float64
+	RAMCost             float64
+	GPUCost             float64
+	NetworkCost         float64
+	NetworkTxBytes      float64
+	NetworkRxBytes      float64
+	PVCost              float64
+}
+
+func (u *usage) add(o usage, scale float64) {
+	u.CPUCoreHours += o.CPUCoreHours * scale
+	u.CPUCoreRequestHours += o.CPUCoreRequestHours * scale
+	u.CPUCoreUsageHours += o.CPUCoreUsageHours * scale
+	u.RAMByteHours += o.RAMByteHours * scale
+	u.RAMByteRequestHours += o.RAMByteRequestHours * scale
+	u.RAMByteUsageHours += o.RAMByteUsageHours * scale
+	u.GPUHours += o.GPUHours * scale
+	u.PVByteHours += o.PVByteHours * scale
+	u.CPUCost += o.CPUCost * scale
+	u.RAMCost += o.RAMCost * scale
+	u.GPUCost += o.GPUCost * scale
+	u.NetworkCost += o.NetworkCost * scale
+	u.NetworkTxBytes += o.NetworkTxBytes * scale
+	u.NetworkRxBytes += o.NetworkRxBytes * scale
+	u.PVCost += o.PVCost * scale
+}
+
+type podRate struct {
+	props   *Properties
+	perHour usage
+}
+
+type entry struct {
+	props      *Properties
+	start, end time.Time
+	usage
+}
+
+func (e *entry) extend(from, to time.Time) {
+	if e.start.IsZero() || from.Before(e.start) {
+		e.start = from
+	}
+	if to.After(e.end) {
+		e.end = to
+	}
+}
+
+type nodeRate struct {
+	props   *NodeProperties
+	perHour usage
+}
+
+type nodeEntry struct {
+	props      *NodeProperties
+	start, end time.Time
+	usage
+}
+
+type bucket struct {
+	start   time.Time
+	entries map[string]*entry
+	nodes   map[string]*nodeEntry
+}
+
+// Allocator integrates the per-pod hourly cost of every snapshot into
+// hourly buckets that are kept for the retention period. Data is held in
+// memory only, so a restart starts the history over.
+type Allocator struct {
+	cfg    Config
+	store  *snapshot.Store
+	logger *slog.Logger
+
+	mu        sync.RWMutex
+	last      time.Time
+	rates     map[string]podRate
+	nodeRates map[string]nodeRate
+	buckets   []*bucket
+}
+
+// New returns an empty Allocator fed from store.
+func New(cfg Config, store *snapshot.Store, logger *slog.Logger) *Allocator {
+	if cfg.Retention < bucketSize {
+		cfg.Retention = bucketSize
+	}
+	return &Allocator{cfg: cfg, store: store, logger: logger, rates: map[string]podRate{}}
+}
+
+// Retention returns how far back queries can reach.
+func (a *Allocator) Retention() time.Duration {
+	return a.cfg.Retention
+}
+
+// Run observes every published snapshot until ctx is done.
+func (a *Allocator) Run(ctx context.Context) {
+	sub := a.store.Subscribe()
+	defer sub.Close()
+	if snap, ok := a.store.Latest(); ok {
+		a.Observe(snap)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			a.Observe(snap)
+		}
+	}
+}
+
+// Observe charges the rates of the previous snapshot over the time elapsed
+// up to snap.Timestamp and adds the egress cost carried by snap. Snapshots
+// that are older than the last one are ignored.
+func (a *Allocator) Observe(snap snapshot.Snapshot) {
+	now := snap.Timestamp
+	if now.IsZero() {
+		return
+	}
+	rates, nodeRates := a.computeRates(snap)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Before(a.last) {
+		return
+	}
+	if !a.last.IsZero() && now.After(a.last) {
+		if elapsed := now.Sub(a.last); a.cfg.MaxGap > 0 && elapsed > a.cfg.MaxGap {
+			if a.logger != nil {
+				a.logger.Warn("allocation gap exceeds max gap; skipping interval",
+					slog.Duration("gap", elapsed),
+					slog.Duration("maxGap", a.cfg.MaxGap),
+				)
+			}
+		} else {
+			a.integrate(a.last, now)
+		}
+	}
+
+	// Egress is priced for the bytes seen since the previous collection, so
+	// it is an amount for the interval rather than an hourly rate.
+	b := a.bucketFor(now.Truncate(bucketSize))
+	for _, p := range snap.Network.Pods {
+		rate, ok := rates[p.Namespace+"/"+p.Pod]
+		if !ok || (p.EgressCostHourly <= 0 && p.TxBytes == 0 && p.RxBytes == 0) {
+			continue
+		}
+		e := b.entry(p.Namespace+"/"+p.Pod, rate.props)
+		e.NetworkCost += p.EgressCostHourly
+		e.NetworkTxBytes += float64(p.TxBytes)
+		e.NetworkRxBytes += float64(p.RxBytes)
+		e.extend(now, now)
+	}
+
+	a.last = now
+	a.rates = rates
+	a.nodeRates = nodeRates
+	a.prune(now)
+}
+
+// integrate charges the current rates over [from, to), split by hour.
+func (a *Allocator) integrate(from, to time.Time) {
+	for from.Before(to) {
+		start := from.Truncate(bucketSize)
+		segEnd := start.Add(bucketSize)
+		if to.Before(segEnd) {
+			segEnd = to
+		}
+		hours := segEnd.Sub(from).Hours()
+		b := a.bucketFor(start)
+		for key, rate := range a.rates {
+			e := b.entry(key, rate.props)
+			e.add(rate.perHour, hours)
+			e.extend(from, segEnd)
+		}
+		for name, rate := range a.nodeRates {
+			n := b.node(name, rate.props)
+			n.add(rate.perHour, hours)
+			if n.start.IsZero() || from.Before(n.start) {
+				n.start = from
+			}
+			if segEnd.After(n.end) {
+				n.end = segEnd
+			}
+		}
+		from = segEnd
+	}
+}
+
+func (a *Allocator) bucketFor(start time.Time) *bucket {
+	for i := len(a.buckets) - 1; i >= 0; i-- {
+		switch {
+		case a.buckets[i].start.Equal(start):
+			return a.buckets[i]
+		case a.buckets[i].start.Before(start):
+			b := newBucket(start)
+			a.buckets = append(a.buckets[:i+1], append([]*bucket{b}, a.buckets[i+1:]...)...)
+			return b
+		}
+	}
+	b := newBucket(start)
+	a.buckets = append([]*bucket{b}, a.buckets...)
+	return b
+}
+
+func newBucket(start time.Time) *bucket {
+	return &bucket{start: start, entries: map[string]*entry{}, nodes: map[string]*nodeEntry{}}
+}
+
+func (b *bucket) entry(key string, props *Properties) *entry {
+	e := b.entries[key]
+	if e == nil {
+		e = &entry{props: props}
+		b.entries[key] = e
+	}
+	// The latest properties win, so a relabelled pod groups under its
+	// current labels for the rest of the hour.
+	e.props = props
+	return e
+}
+
+func (b *bucket) node(name string, props *NodeProperties) *nodeEntry {
+	n := b.nodes[name]
+	if n == nil {
+		n = &nodeEntry{}
+		b.nodes[name] = n
+	}
+	n.props = props
+	return n
+}
+
+func (a *Allocator) prune(now time.Time) {
+	cutoff := now.Add(-a.cfg.Retention).Truncate(bucketSize)
+	drop := 0
+	for drop < len(a.buckets) && a.buckets[drop].start.Before(cutoff) {
+		drop++
+	}
+	a.buckets = a.buckets[drop:]
+}
+
+// computeRates prices every pod and node of snap per hour. A node's price
+// is split between its CPU, memory and GPUs by the configured weights, and
+// a pod is charged for the larger of its request and its usage.
+func (a *Allocator) computeRates(snap snapshot.Snapshot) (map[string]podRate, map[string]nodeRate) {
+	type unitPrice struct{ perCore, perByte, perGPU float64 }
+	nodes := make(map[string]unitPrice, len(snap.Nodes))
+	nodeRates := make(map[string]nodeRate, len(snap.Nodes))
+	for _, n := range snap.Nodes {
+		cores := float64(n.CPUAllocatableMilli) / 1000
+		gib := float64(n.MemoryAllocatableBytes) / bytesPerGiB
+		gpus := float64(n.GPUAllocatable)
+		cpuWeight := cores * a.cfg.CPUCoreHourPrice
+		ramWeight := gib * a.cfg.RAMGiBHourPrice
+		gpuWeight := gpus * a.cfg.GPUHourPrice
+		total := cpuWeight + ramWeight + gpuWeight
+		if total <= 0 {
+			cpuWeight, total = 1, 1
+		}
+		var r unitPrice
+		if cores > 0 {
+			r.perCore = n.HourlyCost * cpuWeight / total / cores
+		}
+		if gib > 0 {
+			r.perByte = n.HourlyCost * ramWeight / total / float64(n.MemoryAllocatableBytes)
+		}
+		if gpus > 0 {
+			r.perGPU = n.HourlyCost * gpuWeight / total / gpus
+		}
+		nodes[n.NodeName] = r
+		cluster := n.ClusterID
+		if cluster == "" {
+			cluster = snap.Resources.ClusterID
+		}
+		nodeRates[n.NodeName] = nodeRate{
+			props: &NodeProperties{
+				Cluster:      cluster,
+				Node:         n.NodeName,
+				InstanceType: n.InstanceType,
+				Labels:       n.Labels,
+			},
+			perHour: usage{
+				CPUCoreHours: cores,
+				RAMByteHours: float64(n.MemoryAllocatableBytes),
+				GPUHours:     gpus,
+				CPUCost:      n.HourlyCost * cpuWeight / total,
+				RAMCost:      n.HourlyCost * ramWeight / total,
+				GPUCost:      n.HourlyCost * gpuWeight / total,
+			},
+		}
+	}
+
+	storagePerByte := a.cfg.StorageGiBMonthPrice / hoursPerMonth / bytesPerGiB
+	out := make(map[string]podRate, len(snap.Pods))
+	for _, p := range snap.Pods {
+		props := &Properties{
+			Cluster:        snap.Resources.ClusterID,
+			Namespace:      p.Namespace,
+			Node:           p.Node,
+			Pod:            p.Pod,
+			ControllerKind: p.ControllerKind,
+			Environment:    p.Attribution.Environment.Value,
+			Labels:         p.Labels,
+			Annotations:    p.Annotations,
+		}
+		if p.ControllerKind != "" && p.ControllerName != "" {
+			props.Controller = p.ControllerKind + "/" + p.ControllerName
+		}
+		cpuReq := float64(p.CPURequestMilli) / 1000
+		cpuUse := float64(p.CPUUsageMilli) / 1000
+		ramReq := float64(p.MemoryRequestBytes)
+		ramUse := float64(p.MemoryUsageBytes)
+		n := nodes[p.Node]
+		u := usage{
+			CPUCoreHours:        math.Max(cpuReq, cpuUse),
+			CPUCoreRequestHours: cpuReq,
+			CPUCoreUsageHours:   cpuUse,
+			RAMByteHours:        math.Max(ramReq, ramUse),
+			RAMByteRequestHours: ramReq,
+			RAMByteUsageHours:   ramUse,
+			GPUHours:            float64(p.GPURequest),
+			PVByteHours:         float64(p.StorageRequestBytes),
+		}
+		u.CPUCost = u.CPUCoreHours * n.perCore
+		u.RAMCost = u.RAMByteHours * n.perByte
+		u.GPUCost = u.GPUHours * n.perGPU
+		u.PVCost = u.PVByteHours * storagePerByte
+		out[p.Namespace+"/"+p.Pod] = podRate{props: props, perHour: u}
+	}
+	return out, nodeRates
+}
+
+// Allocation is the cost of one group over a window. Hours are resource
+// hours, such as core-hours; efficiency is usage over request.
+type Allocation struct {
+	Name                string            `json:"name"`
+	Properties          map[string]string `json:"properties"`
+	Start               time.Time         `json:"start"`
+	End                 time.Time         `json:"end"`
+	Minutes             float64           `json:"minutes"`
+	CPUCoreHours        float64           `json:"cpuCoreHours"`
+	CPUCoreRequestHours float64           `json:"cpuCoreRequestHours"`
+	CPUCoreUsageHours   float64           `json:"cpuCoreUsageHours"`
+	CPUCost             float64           `json:"cpuCost"`
+	CPUEfficiency       float64           `json:"cpuEfficiency"`
+	RAMByteHours        float64           `json:"ramByteHours"`
+	RAMByteRequestHours float64           `json:"ramByteRequestHours"`
+	RAMByteUsageHours   float64           `json:"ramByteUsageHours"`
+	RAMCost             float64           `json:"ramCost"`
+	RAMEfficiency       float64           `json:"ramEfficiency"`
+	GPUHours            float64           `json:"gpuHours"`
+	GPUCost             float64           `json:"gpuCost"`
+	NetworkCost         float64           `json:"networkCost"`
+	NetworkTxBytes      float64           `json:"networkTxBytes"`
+	NetworkRxBytes      float64           `json:"networkRxBytes"`
+	PVByteHours         float64           `json:"pvByteHours"`
+	PVCost              float64           `json:"pvCost"`
+	TotalCost           float64           `json:"totalCost"`
+	// TotalEfficiency is the CPU and memory efficiency weighted by cost.
+	TotalEfficiency float64 `json:"totalEfficiency"`
+}
+
+// Result is the answer to a query.
+type Result struct {
+	Start       time.Time
+	End         time.Time
+	Aggregate   []string
+	Allocations []Allocation
+	Total       Allocation
+}
+
+// Query groups the pods seen in the window that ends at the latest
+// snapshot by the aggregate properties, most expensive first. Data is kept
+// per hour, so the window starts at the beginning of the hour it falls in.
+func (a *Allocator) Query(window time.Duration, aggregate []Property) (Result, error) {
+	if window <= 0 {
+		return Result{}, errors.New("window must be positive")
+	}
+	if window > a.cfg.Retention {
+		return Result{}, fmt.Errorf("window %s exceeds the %s retention", window, a.cfg.Retention)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.last.IsZero() {
+		return Result{}, ErrNoData
+	}
+	start := a.last.Add(-window).Truncate(bucketSize)
+	res := a.group(start, a.last.Truncate(bucketSize).Add(bucketSize), aggregate)
+	res.Start, res.End = start, a.last
+	return res, nil
+}
+
+// Range groups the pods seen between start and end like Query. Hours that
+// begin before start or at or after end are left out, so consecutive
+// ranges never count an hour twice. Hours outside the retention period
+// have no data.
+func (a *Allocator) Range(start, end time.Time, aggregate []Property) (Result, error) {
+	if !end.After(start) {
+		return Result{}, errors.New("range end must be after its start")
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.last.IsZero() {
+		return Result{}, ErrNoData
+	}
+	res := a.group(start, end, aggregate)
+	res.Start, res.End = start, end
+	return res, nil
+}
+
+// group aggregates the hours that begin in [from, to). The caller holds mu.
+func (a *Allocator) group(from, to time.Time, aggregate []Property) Result {
+	if len(aggregate) == 0 {
+		aggregate = []Property{{Name: PropertyNamespace}}
+	}
+	var res Result
+	for _, p := range aggregate {
+		res.Aggregate = append(res.Aggregate, p.String())
+	}
+
+	type group struct {
+		values     []string
+		start, end time.Time
+		usage
+	}
+	groups := map[string]*group{}
+	var total group
+	for _, b := range a.buckets {
+		if b.start.Before(from) || !b.start.Before(to) {
+			continue
+		}
+		for _, e := range b.entries {
+			values := make([]string, len(aggregate))
+			for i, p := range aggregate {
+				values[i] = p.Value(e.props)
+			}
+			name := strings.Join(values, "/")
+			g := groups[name]
+			if g == nil {
+				g = &group{values: values}
+				groups[name] = g
+			}
+			for _, dst := range []*group{g, &total} {
+				dst.add(e.usage, 1)
+				if dst.start.IsZero() || e.start.Before(dst.start) {
+					dst.start = e.start
+				}
+				if e.end.After(dst.end) {
+					dst.end = e.end
+				}
+			}
+		}
+	}
+
+	res.Allocations = make([]Allocation, 0, len(groups))
+	for name, g := range groups {
+		alloc := newAllocation(name, g.usage, g.start, g.end)
+		alloc.Properties = make(map[string]string, len(aggregate))
+		for i, p := range aggregate {
+			alloc.Properties[p.String()] = g.values[i]
+		}
+		res.Allocations = append(res.Allocations, alloc)
+	}
+	sort.Slice(res.Allocations, func(i, j int) bool {
+		if res.Allocations[i].TotalCost != res.Allocations[j].TotalCost {
+			return res.Allocations[i].TotalCost > res.Allocations[j].TotalCost
+		}
+		return res.Allocations[i].Name < res.Allocations[j].Name
+	})
+	res.Total = newAllocation("__total__", total.usage, total.start, total.end)
+	return res
+}
+
+// Asset is the cost of one node over a range. Its price is split between
+// CPU, memory and GPUs with the same weights used for pods.
+type Asset struct {
+	Cluster      string
+	Node         string
+	InstanceType string
+	Labels       map[string]string
+	Start        time.Time
+	End          time.Time
+	CPUCoreHours float64
+	RAMByteHours float64
+	GPUHours     float64
+	CPUCost      float64
+	RAMCost      float64
+	GPUCost      float64
+	TotalCost    float64
+}
+
+// Assets returns the cost of every node seen in the hours that begin
+// between start and end, most expensive first.
+func (a *Allocator) Assets(start, end time.Time) ([]Asset, error) {
+	if !end.After(start) {
+		return nil, errors.New("range end must be after its start")
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.last.IsZero() {
+		return nil, ErrNoData
+	}
+	from := start.Truncate(bucketSize)
+	nodes := map[string]*nodeEntry{}
+	for _, b := range a.buckets {
+		if b.start.Before(from) || !b.start.Before(end) {
+			continue
+		}
+		for name, n := range b.nodes {
+			dst := nodes[name]
+			if dst == nil {
+				dst = &nodeEntry{start: n.start}
+				nodes[name] = dst
+			}
+			// Buckets are in time order, so the last one carries the
+			// current properties.
+			dst.props = n.props
+			dst.add(n.usage, 1)
+			if n.start.Before(dst.start) {
+				dst.start = n.start
+			}
+			if n.end.After(dst.end) {
+				dst.end = n.end
+			}
+		}
+	}
+
+	out := make([]Asset, 0, len(nodes))
+	for _, n := range nodes {
+		out = append(out, Asset{
+			Cluster:      n.props.Cluster,
+			Node:         n.props.Node,
+			InstanceType: n.props.InstanceType,
+			Labels:       n.props.Labels,
+			Start:        n.start,
+			End:          n.end,
+			CPUCoreHours: n.CPUCoreHours,
+			RAMByteHours: n.RAMByteHours,
+			GPUHours:     n.GPUHours,
+			CPUCost:      n.CPUCost,
+			RAMCost:      n.RAMCost,
+			GPUCost:      n.GPUCost,
+			TotalCost:    n.CPUCost + n.RAMCost + n.GPUCost,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TotalCost != out[j].TotalCost {
+			return out[i].TotalCost > out[j].TotalCost
+		}
+		return out[i].Node < out[j].Node
+	})
+	return out, nil
+}
+
+func newAllocation(name string, u usage, start, end time.Time) Allocation {
+	alloc := Allocation{
+		Name:                name,
+		Start:               start,
+		End:                 end,
+		Minutes:             end.Sub(start).Minutes(),
+		CPUCoreHours:        u.CPUCoreHours,
+		CPUCoreRequestHours: u.CPUCoreRequestHours,
+		CPUCoreUsageHours:   u.CPUCoreUsageHours,
+		CPUCost:             u.CPUCost,
+		CPUEfficiency:       efficiency(u.CPUCoreUsageHours, u.CPUCoreRequestHours),
+		RAMByteHours:        u.RAMByteHours,
+		RAMByteRequestHours: u.RAMByteRequestHours,
+		RAMByteUsageHours:   u.RAMByteUsageHours,
+		RAMCost:             u.RAMCost,
+		RAMEfficiency:       efficiency(u.RAMByteUsageHours, u.RAMByteRequestHours),
+		GPUHours:            u.GPUHours,
+		GPUCost:             u.GPUCost,
+		NetworkCost:         u.NetworkCost,
+		NetworkTxBytes:      u.NetworkTxBytes,
+		NetworkRxBytes:      u.NetworkRxBytes,
+		PVByteHours:         u.PVByteHours,
+		PVCost:              u.PVCost,
+	}
+	alloc.TotalCost = u.CPUCost + u.RAMCost + u.GPUCost + u.NetworkCost + u.PVCost
+	if resourceCost := u.CPUCost + u.RAMCost; resourceCost > 0 {
+		alloc.TotalEfficiency = (alloc.CPUEfficiency*u.CPUCost + alloc.RAMEfficiency*u.RAMCost) / resourceCost
+	}
+	return alloc
+}
+
+// efficiency is usage over request. Usage without a request counts as fully
+// efficient, since nothing was reserved and left idle.
+func efficiency(used, requested float64) float64 {
+	switch {
+	case requested > 0:
+		return used / requested
+	case used > 0:
+		return 1
+	default:
+		return 0
+	}
+}
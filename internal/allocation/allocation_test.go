@@ -0,0 +1,210 @@
+package allocation
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/enricher"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func fixture(at time.Time) snapshot.Snapshot {
+	return snapshot.Snapshot{
+		Timestamp: at,
+		Resources: snapshot.ResourceSnapshot{ClusterID: "c1"},
+		Nodes: []snapshot.NodeCostRecord{{
+			NodeName:               "node-a",
+			HourlyCost:             1,
+			CPUAllocatableMilli:    4000,
+			MemoryAllocatableBytes: 16 << 30,
+		}},
+		Pods: []snapshot.PodCostRecord{
+			{
+				Namespace: "payments", Pod: "api-1", Node: "node-a",
+				ControllerKind: "Deployment", ControllerName: "api",
+				CPURequestMilli: 1000, CPUUsageMilli: 500,
+				MemoryRequestBytes: 4 << 30, MemoryUsageBytes: 2 << 30,
+				Labels:      map[string]string{"team": "checkout"},
+				Attribution: enricher.Attribution{Environment: enricher.Value{Value: "production"}},
+			},
+			{
+				Namespace: "batch", Pod: "etl-1", Node: "node-a",
+				CPURequestMilli: 2000, CPUUsageMilli: 2000,
+				StorageRequestBytes: 73 << 30,
+				Annotations:         map[string]string{"example.com/owner": "data"},
+			},
+		},
+		Network: snapshot.NetworkSnapshot{
+			Pods: []snapshot.PodNetworkRecord{{Namespace: "payments", Pod: "api-1", EgressCostHourly: 0.01}},
+		},
+	}
+}
+
+func newTestAllocator() *Allocator {
+	return New(Config{
+		Retention:            48 * time.Hour,
+		MaxGap:               2 * time.Hour,
+		CPUCoreHourPrice:     1,
+		RAMGiBHourPrice:      0.25,
+		StorageGiBMonthPrice: 0.1,
+	}, nil, nil)
+}
+
+func approx(t *testing.T, what string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestAllocatorAggregatesByLabel(t *testing.T) {
+	a := newTestAllocator()
+	start := time.Date(2025, 3, 12, 10, 30, 0, 0, time.UTC)
+	a.Observe(fixture(start))
+	a.Observe(fixture(start.Add(time.Hour)))
+
+	aggregate, err := ParseAggregate("label:team,environment")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	res, err := a.Query(24*time.Hour, aggregate)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(res.Allocations) != 2 {
+		t.Fatalf("expected 2 groups, got %+v", res.Allocations)
+	}
+
+	// The node's price is split evenly between 4 cores and 16 GiB, so a core
+	// costs 0.125 per hour and a GiB 0.03125.
+	api := res.Allocations[0]
+	if api.Name != "checkout/production" || api.Properties["label:team"] != "checkout" {
+		t.Fatalf("unexpected first group %+v", api)
+	}
+	approx(t, "api cpu cost", api.CPUCost, 0.125)
+	approx(t, "api ram cost", api.RAMCost, 0.125)
+	approx(t, "api cpu efficiency", api.CPUEfficiency, 0.5)
+	approx(t, "api ram efficiency", api.RAMEfficiency, 0.5)
+	approx(t, "api network cost", api.NetworkCost, 0.02)
+	approx(t, "api total cost", api.TotalCost, 0.27)
+	approx(t, "api minutes", api.Minutes, 60)
+
+	etl := res.Allocations[1]
+	if etl.Name != Unallocated+"/"+Unallocated {
+		t.Fatalf("unexpected second group %+v", etl)
+	}
+	approx(t, "etl cpu cost", etl.CPUCost, 0.25)
+	approx(t, "etl pv cost", etl.PVCost, 0.01)
+	approx(t, "etl cpu efficiency", etl.CPUEfficiency, 1)
+	approx(t, "total cost", res.Total.TotalCost, 0.27+0.26)
+
+	// A 30 minute window only reaches back to 11:00.
+	res, err = a.Query(30*time.Minute, []Property{{Name: PropertyAnnotation, Key: "example.com/owner"}})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if !res.Start.Equal(start.Add(30 * time.Minute)) {
+		t.Fatalf("window start = %v", res.Start)
+	}
+	for _, alloc := range res.Allocations {
+		if alloc.Name == "data" {
+			approx(t, "etl cpu cost in the last half hour", alloc.CPUCost, 0.125)
+		}
+	}
+}
+
+func TestAllocatorQueryErrors(t *testing.T) {
+	a := newTestAllocator()
+	if _, err := a.Query(time.Hour, nil); !errors.Is(err, ErrNoData) {
+		t.Fatalf("expected ErrNoData, got %v", err)
+	}
+	a.Observe(fixture(time.Now()))
+	if _, err := a.Query(72*time.Hour, nil); err == nil {
+		t.Fatal("expected a window beyond retention to fail")
+	}
+	for _, raw := range []string{"label", "namespace:x", "team"} {
+		if _, err := ParseAggregate(raw); err == nil {
+			t.Errorf("ParseAggregate(%q) should fail", raw)
+		}
+	}
+	if d, err := ParseWindow("7d"); err != nil || d != 7*24*time.Hour {
+		t.Errorf("ParseWindow(7d) = %v, %v", d, err)
+	}
+}
+
+func TestAllocatorPrunesOldBuckets(t *testing.T) {
+	a := newTestAllocator()
+	start := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= 72; i++ {
+		a.Observe(fixture(start.Add(time.Duration(i) * time.Hour)))
+	}
+	if len(a.buckets) > 49 {
+		t.Fatalf("kept %d buckets for a 48h retention", len(a.buckets))
+	}
+}
+
+func TestAllocatorRangeAndAssets(t *testing.T) {
+	a := newTestAllocator()
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	for i := 0; i <= 2; i++ {
+		a.Observe(fixture(start.Add(time.Duration(i) * time.Hour)))
+	}
+
+	// Two consecutive one-hour ranges split the two hours of data: 0.25 for
+	// each pod, the storage of etl and the egress observed at the hour.
+	first, err := a.Range(start, start.Add(time.Hour), []Property{{Name: PropertyController, Key: "Deployment"}})
+	if err != nil {
+		t.Fatalf("range: %v", err)
+	}
+	second, err := a.Range(start.Add(time.Hour), start.Add(2*time.Hour), nil)
+	if err != nil {
+		t.Fatalf("range: %v", err)
+	}
+	approx(t, "first hour", first.Total.TotalCost, 0.52)
+	approx(t, "second hour", second.Total.TotalCost, 0.52)
+	if first.Allocations[0].Name != "api" && first.Allocations[1].Name != "api" {
+		t.Fatalf("expected the deployment name as a group, got %+v", first.Allocations)
+	}
+
+	assets, err := a.Assets(start, start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("assets: %v", err)
+	}
+	if len(assets) != 1 || assets[0].Node != "node-a" || assets[0].Cluster != "c1" {
+		t.Fatalf("unexpected assets %+v", assets)
+	}
+	approx(t, "node cost", assets[0].TotalCost, 2)
+	approx(t, "node cpu cost", assets[0].CPUCost, 1)
+	approx(t, "node core hours", assets[0].CPUCoreHours, 8)
+
+	if _, err := a.Range(start, start, nil); err == nil {
+		t.Fatal("expected an empty range to fail")
+	}
+}
+
+func TestAllocatorRangeUnalignedStepsSumToWindow(t *testing.T) {
+	a := newTestAllocator()
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	for i := 0; i <= 7; i++ {
+		a.Observe(fixture(start.Add(time.Duration(i) * time.Hour)))
+	}
+
+	// A 6h window ending at :17, as ParseWindow returns for window=6h.
+	from, to := start.Add(17*time.Minute), start.Add(6*time.Hour+17*time.Minute)
+	window, err := a.Range(from, to, nil)
+	if err != nil {
+		t.Fatalf("range: %v", err)
+	}
+	var steps float64
+	for s := from; s.Before(to); s = s.Add(time.Hour) {
+		step, err := a.Range(s, s.Add(time.Hour), nil)
+		if err != nil {
+			t.Fatalf("range: %v", err)
+		}
+		steps += step.Total.TotalCost
+	}
+	approx(t, "window", window.Total.TotalCost, 6*0.52)
+	approx(t, "summed steps", steps, window.Total.TotalCost)
+}
@@ -0,0 +1,129 @@
+package allocation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Properties pods can be grouped by. Labels and annotations are grouped
+// with "label:<key>" and "annotation:<key>".
+const (
+	PropertyCluster        = "cluster"
+	PropertyNamespace      = "namespace"
+	PropertyNode           = "node"
+	PropertyPod            = "pod"
+	PropertyControllerKind = "controllerKind"
+	PropertyController     = "controller"
+	PropertyEnvironment    = "environment"
+	PropertyLabel          = "label"
+	PropertyAnnotation     = "annotation"
+)
+
+// Property is one dimension of an aggregation.
+type Property struct {
+	Name string
+	// Key is the label or annotation key. For controller it limits the
+	// property to controllers of that kind, such as Deployment, whose value
+	// is then the bare controller name.
+	Key string
+}
+
+// ParseAggregate parses a comma-separated list of properties, such as
+// "namespace,label:team". "workload" is accepted for controller.
+func ParseAggregate(raw string) ([]Property, error) {
+	var out []Property
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, key, hasKey := strings.Cut(part, ":")
+		switch name {
+		case PropertyLabel, PropertyAnnotation:
+			if key == "" {
+				return nil, fmt.Errorf("%s needs a key, as in %s:team", name, name)
+			}
+		case PropertyCluster, PropertyNamespace, PropertyNode, PropertyPod, PropertyControllerKind, PropertyController, PropertyEnvironment, "workload":
+			if hasKey {
+				return nil, fmt.Errorf("property %q takes no key", name)
+			}
+			if name == "workload" {
+				name = PropertyController
+			}
+		default:
+			return nil, fmt.Errorf("unknown property %q", name)
+		}
+		out = append(out, Property{Name: name, Key: key})
+	}
+	return out, nil
+}
+
+func (p Property) String() string {
+	if p.Key != "" {
+		return p.Name + ":" + p.Key
+	}
+	return p.Name
+}
+
+// Value returns the value of the property for a pod, or Unallocated.
+func (p Property) Value(props *Properties) string {
+	var v string
+	switch p.Name {
+	case PropertyCluster:
+		v = props.Cluster
+	case PropertyNamespace:
+		v = props.Namespace
+	case PropertyNode:
+		v = props.Node
+	case PropertyPod:
+		v = props.Pod
+	case PropertyControllerKind:
+		v = props.ControllerKind
+	case PropertyController:
+		v = props.Controller
+		if p.Key != "" {
+			v, _ = strings.CutPrefix(props.Controller, p.Key+"/")
+			if v == props.Controller {
+				v = ""
+			}
+		}
+	case PropertyEnvironment:
+		v = props.Environment
+	case PropertyLabel:
+		v = props.Labels[p.Key]
+	case PropertyAnnotation:
+		v = props.Annotations[p.Key]
+	}
+	if v == "" {
+		return Unallocated
+	}
+	return v
+}
+
+// ParseWindow parses a query window such as "30m", "24h" or "7d". An empty
+// window is 24 hours.
+func ParseWindow(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 24 * time.Hour, nil
+	}
+	var d time.Duration
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", raw)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", raw)
+		}
+		d = parsed
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("window %q must be positive", raw)
+	}
+	return d, nil
+}
@@ -0,0 +1,344 @@
+// Package anomaly flags hourly cost spikes per namespace and per network
+// class against a rolling baseline.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// Kind is the type of spend an anomaly was found in.
+type Kind string
+
+const (
+	// KindNamespace is a namespace's compute plus egress cost.
+	KindNamespace Kind = "namespace"
+	// KindNetworkClass is the cluster egress cost of one traffic class.
+	KindNetworkClass Kind = "network_class"
+)
+
+const (
+	// madScale makes the MAD a consistent estimator of the standard deviation.
+	madScale = 1.4826
+	// minRelativeDeviation floors the deviation at a fraction of the median,
+	// otherwise a perfectly flat series would flag any change at all.
+	minRelativeDeviation = 0.05
+	maxResolved          = 100
+)
+
+// Anomaly is a spike in hourly cost. It stays active until the cost falls
+// back to within half the sensitivity of the baseline.
+type Anomaly struct {
+	ID                 string     `json:"id"`
+	Kind               Kind       `json:"kind"`
+	Name               string     `json:"name"`
+	Active             bool       `json:"active"`
+	StartedAt          time.Time  `json:"startedAt"`
+	LastSeen           time.Time  `json:"lastSeen"`
+	EndedAt            *time.Time `json:"endedAt,omitempty"`
+	HourlyCost         float64    `json:"hourlyCost"`
+	PeakHourlyCost     float64    `json:"peakHourlyCost"`
+	BaselineHourlyCost float64    `json:"baselineHourlyCost"`
+	// Score is how many scaled MADs the peak is above the baseline.
+	Score          float64                      `json:"score"`
+	TopPods        []snapshot.PodCostRecord     `json:"topPods,omitempty"`
+	TopConnections []snapshot.NetworkConnection `json:"topConnections,omitempty"`
+}
+
+type seriesKey struct {
+	kind Kind
+	name string
+}
+
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// Detector keeps a rolling window of hourly cost per series. Each value is
+// compared with the median and MAD of the window before it is added, so a
+// spike does not hide itself and a handful of outliers barely move the
+// baseline.
+type Detector struct {
+	cfg       config.AnomalyConfig
+	store     *snapshot.Store
+	notifiers []Notifier
+	logger    *slog.Logger
+
+	mu       sync.RWMutex
+	last     time.Time
+	series   map[seriesKey][]sample
+	active   map[seriesKey]*Anomaly
+	resolved []Anomaly
+}
+
+// NewDetector returns a Detector that reports new anomalies to notifiers.
+func NewDetector(cfg config.AnomalyConfig, store *snapshot.Store, notifiers []Notifier, logger *slog.Logger) *Detector {
+	if cfg.Window <= 0 {
+		cfg.Window = 24 * time.Hour
+	}
+	if cfg.TopContributors <= 0 {
+		cfg.TopContributors = 5
+	}
+	return &Detector{
+		cfg:       cfg,
+		store:     store,
+		notifiers: notifiers,
+		logger:    logger,
+		series:    map[seriesKey][]sample{},
+		active:    map[seriesKey]*Anomaly{},
+	}
+}
+
+// Run observes every published snapshot until ctx is done.
+func (d *Detector) Run(ctx context.Context) {
+	sub := d.store.Subscribe()
+	defer sub.Close()
+	if snap, ok := d.store.Latest(); ok {
+		d.notify(ctx, d.Observe(snap))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			d.notify(ctx, d.Observe(snap))
+		}
+	}
+}
+
+func (d *Detector) notify(ctx context.Context, opened []Anomaly) {
+	for _, a := range opened {
+		d.logger.Warn("cost anomaly detected",
+			slog.String("kind", string(a.Kind)),
+			slog.String("name", a.Name),
+			slog.Float64("hourlyCost", a.HourlyCost),
+			slog.Float64("baselineHourlyCost", a.BaselineHourlyCost),
+		)
+		for _, n := range d.notifiers {
+			if err := n.Notify(ctx, a); err != nil {
+				d.logger.Warn("anomaly notification failed", slog.String("id", a.ID), slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// Observe adds snap to the baselines and returns anomalies it opened.
+// Snapshots that are not newer than the last one are ignored.
+func (d *Detector) Observe(snap snapshot.Snapshot) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !snap.Timestamp.After(d.last) {
+		return nil
+	}
+	// Egress costs are amounts for the bytes since the previous snapshot;
+	// they become rates once the interval is known.
+	var intervalHours float64
+	if !d.last.IsZero() {
+		intervalHours = snap.Timestamp.Sub(d.last).Hours()
+	}
+	d.last = snap.Timestamp
+
+	values := map[seriesKey]float64{}
+	for _, ns := range snap.Namespaces {
+		value := ns.HourlyCost
+		if intervalHours > 0 {
+			value += ns.NetworkEgressCost / intervalHours
+		}
+		values[seriesKey{kind: KindNamespace, name: ns.Namespace}] = value
+	}
+	if intervalHours > 0 {
+		for _, class := range snap.Network.ByClass {
+			values[seriesKey{kind: KindNetworkClass, name: class.Class}] = class.EgressCostHourly / intervalHours
+		}
+	}
+
+	var opened []Anomaly
+	for key, value := range values {
+		if a := d.observe(key, value, snap); a != nil {
+			opened = append(opened, *a)
+		}
+	}
+	for key, a := range d.active {
+		if _, ok := values[key]; !ok {
+			d.resolve(key, a, snap.Timestamp)
+		}
+	}
+	d.trim(snap.Timestamp)
+	sort.Slice(opened, func(i, j int) bool { return opened[i].ID < opened[j].ID })
+	return opened
+}
+
+func (d *Detector) observe(key seriesKey, value float64, snap snapshot.Snapshot) *Anomaly {
+	samples := d.series[key]
+	d.series[key] = append(samples, sample{at: snap.Timestamp, value: value})
+	if len(samples) < d.cfg.MinSamples {
+		return nil
+	}
+	median, deviation := baseline(samples)
+	score := (value - median) / deviation
+
+	if a := d.active[key]; a != nil {
+		a.LastSeen = snap.Timestamp
+		a.HourlyCost = value
+		if score < d.cfg.Sensitivity/2 {
+			d.resolve(key, a, snap.Timestamp)
+			return nil
+		}
+		if value > a.PeakHourlyCost {
+			a.PeakHourlyCost = value
+			a.Score = round(score)
+			d.attachContributors(a, snap)
+		}
+		return nil
+	}
+	if score < d.cfg.Sensitivity || value-median < d.cfg.MinIncrease {
+		return nil
+	}
+	a := &Anomaly{
+		ID:                 fmt.Sprintf("%s/%s/%d", key.kind, key.name, snap.Timestamp.Unix()),
+		Kind:               key.kind,
+		Name:               key.name,
+		Active:             true,
+		StartedAt:          snap.Timestamp,
+		LastSeen:           snap.Timestamp,
+		HourlyCost:         value,
+		PeakHourlyCost:     value,
+		BaselineHourlyCost: median,
+		Score:              round(score),
+	}
+	d.attachContributors(a, snap)
+	d.active[key] = a
+	out := *a
+	return &out
+}
+
+func (d *Detector) resolve(key seriesKey, a *Anomaly, at time.Time) {
+	ended := at
+	a.Active = false
+	a.EndedAt = &ended
+	d.resolved = append(d.resolved, *a)
+	if len(d.resolved) > maxResolved {
+		d.resolved = d.resolved[len(d.resolved)-maxResolved:]
+	}
+	delete(d.active, key)
+}
+
+// trim drops samples older than the window and series with none left.
+func (d *Detector) trim(now time.Time) {
+	cutoff := now.Add(-d.cfg.Window)
+	for key, samples := range d.series {
+		i := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(cutoff) })
+		if i == len(samples) {
+			delete(d.series, key)
+			continue
+		}
+		d.series[key] = samples[i:]
+	}
+}
+
+// attachContributors records the most expensive pods of a namespace, or the
+// most expensive pod connections of a traffic class.
+func (d *Detector) attachContributors(a *Anomaly, snap snapshot.Snapshot) {
+	limit := d.cfg.TopContributors
+	switch a.Kind {
+	case KindNamespace:
+		var pods []snapshot.PodCostRecord
+		for _, pod := range snap.Pods {
+			if pod.Namespace == a.Name {
+				pods = append(pods, pod)
+			}
+		}
+		sort.Slice(pods, func(i, j int) bool { return pods[i].HourlyCost > pods[j].HourlyCost })
+		if len(pods) > limit {
+			pods = pods[:limit]
+		}
+		a.TopPods = pods
+	case KindNetworkClass:
+		var conns []snapshot.NetworkConnection
+		for _, conn := range snap.Network.PodConnections {
+			if conn.Class == a.Name {
+				conns = append(conns, conn)
+			}
+		}
+		sort.Slice(conns, func(i, j int) bool {
+			if conns[i].EgressCostHourly != conns[j].EgressCostHourly {
+				return conns[i].EgressCostHourly > conns[j].EgressCostHourly
+			}
+			return conns[i].TxBytes > conns[j].TxBytes
+		})
+		if len(conns) > limit {
+			conns = conns[:limit]
+		}
+		a.TopConnections = conns
+	}
+}
+
+// Anomalies returns active anomalies, then resolved ones, newest first.
+func (d *Detector) Anomalies() []Anomaly {
+	if d == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	active := make([]Anomaly, 0, len(d.active))
+	for _, a := range d.active {
+		active = append(active, *a)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if !active[i].StartedAt.Equal(active[j].StartedAt) {
+			return active[i].StartedAt.After(active[j].StartedAt)
+		}
+		return active[i].ID < active[j].ID
+	})
+	out := active
+	for i := len(d.resolved) - 1; i >= 0; i-- {
+		out = append(out, d.resolved[i])
+	}
+	return out
+}
+
+// baseline returns the median of samples and the MAD scaled to a standard
+// deviation, floored so that it is never zero.
+func baseline(samples []sample) (float64, float64) {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.value
+	}
+	median := medianOf(values)
+	for i, v := range values {
+		values[i] = math.Abs(v - median)
+	}
+	deviation := madScale * medianOf(values)
+	if floor := minRelativeDeviation * math.Abs(median); deviation < floor {
+		deviation = floor
+	}
+	if deviation == 0 {
+		deviation = 1e-9
+	}
+	return median, deviation
+}
+
+func medianOf(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
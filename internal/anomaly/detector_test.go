@@ -0,0 +1,168 @@
+package anomaly
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDetectorFlagsNamespaceSpike(t *testing.T) {
+	detector := newTestDetector(nil)
+	start := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 40; i++ {
+		// Normal jitter around $1/h must not be flagged.
+		cost := 1 + 0.02*float64(i%5)
+		if opened := detector.Observe(testSnapshot(start.Add(time.Duration(i)*time.Minute), cost, 0)); len(opened) != 0 {
+			t.Fatalf("flagged normal variation at sample %d: %+v", i, opened)
+		}
+	}
+
+	// A deploy that quintuples replicas.
+	opened := detector.Observe(testSnapshot(start.Add(40*time.Minute), 5, 0))
+	if len(opened) != 1 {
+		t.Fatalf("expected one anomaly, got %+v", opened)
+	}
+	a := opened[0]
+	if a.Kind != KindNamespace || a.Name != "payments" || !a.Active {
+		t.Fatalf("unexpected anomaly %+v", a)
+	}
+	if a.BaselineHourlyCost < 1 || a.BaselineHourlyCost > 1.1 || a.Score < 5 {
+		t.Fatalf("baseline %v score %v", a.BaselineHourlyCost, a.Score)
+	}
+	if len(a.TopPods) != 2 || a.TopPods[0].Pod != "api-2" {
+		t.Fatalf("top pods = %+v", a.TopPods)
+	}
+
+	// Still elevated: the anomaly stays open without notifying again.
+	if opened := detector.Observe(testSnapshot(start.Add(41*time.Minute), 6, 0)); len(opened) != 0 {
+		t.Fatalf("re-opened an active anomaly: %+v", opened)
+	}
+	if got := detector.Anomalies(); len(got) != 1 || got[0].PeakHourlyCost != 6 {
+		t.Fatalf("anomalies = %+v", got)
+	}
+
+	detector.Observe(testSnapshot(start.Add(42*time.Minute), 1, 0))
+	got := detector.Anomalies()
+	if len(got) != 1 || got[0].Active || got[0].EndedAt == nil {
+		t.Fatalf("anomaly not resolved: %+v", got)
+	}
+}
+
+func TestDetectorFlagsEgressSpikeAsRate(t *testing.T) {
+	detector := newTestDetector(nil)
+	start := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= 40; i++ {
+		// $0.01 per minute is $0.60/h.
+		detector.Observe(testSnapshot(start.Add(time.Duration(i)*time.Minute), 1, 0.01))
+	}
+	opened := detector.Observe(testSnapshot(start.Add(41*time.Minute), 1, 0.5))
+	var class *Anomaly
+	for i := range opened {
+		if opened[i].Kind == KindNetworkClass {
+			class = &opened[i]
+		}
+	}
+	if class == nil {
+		t.Fatalf("expected a network class anomaly, got %+v", opened)
+	}
+	if class.Name != "internet_egress" || class.HourlyCost != 30 || class.BaselineHourlyCost != 0.6 {
+		t.Fatalf("unexpected class anomaly %+v", class)
+	}
+	if len(class.TopConnections) != 1 || class.TopConnections[0].Destination.Name != "internet" {
+		t.Fatalf("top connections = %+v", class.TopConnections)
+	}
+}
+
+func TestDetectorIgnoresSmallIncreases(t *testing.T) {
+	detector := newTestDetector(nil)
+	start := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 40; i++ {
+		detector.Observe(testSnapshot(start.Add(time.Duration(i)*time.Minute), 0.01, 0))
+	}
+	// Ten times the baseline, but only $0.09/h more than it.
+	if opened := detector.Observe(testSnapshot(start.Add(40*time.Minute), 0.1, 0)); len(opened) != 0 {
+		t.Fatalf("flagged an increase below the minimum: %+v", opened)
+	}
+}
+
+func TestEventNotifierRecordsNamespaceEvent(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	notifier := NewEventNotifier(client, "agent-0", "clustercost")
+	a := Anomaly{
+		ID: "namespace/payments/1", Kind: KindNamespace, Name: "payments",
+		StartedAt: time.Unix(1700000000, 0), HourlyCost: 5, BaselineHourlyCost: 1, Score: 12,
+		TopPods: []snapshot.PodCostRecord{{Namespace: "payments", Pod: "api-2"}},
+	}
+	if err := notifier.Notify(context.Background(), a); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	events, err := client.CoreV1().Events("payments").List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(events.Items) != 1 {
+		t.Fatalf("expected one event, got %v (%v)", events, err)
+	}
+	event := events.Items[0]
+	if event.Reason != "CostAnomaly" || event.InvolvedObject.Kind != "Namespace" || !strings.Contains(event.Message, "api-2") {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestEventNotifierUsesSourceNamespaceOfConnection(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	notifier := NewEventNotifier(client, "agent-0", "clustercost")
+	for _, source := range []snapshot.NetworkEndpoint{
+		{Kind: "pod", Namespace: "payments", Name: "api-2"},
+		{Kind: "external", Name: "internet"},
+	} {
+		a := Anomaly{
+			ID: "network/internet_egress/1", Kind: KindNetworkClass, Name: "internet_egress",
+			StartedAt:      time.Unix(1700000000, 0),
+			TopConnections: []snapshot.NetworkConnection{{Source: source, Class: "internet_egress"}},
+		}
+		if err := notifier.Notify(context.Background(), a); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	for _, namespace := range []string{"payments", "clustercost"} {
+		events, err := client.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+		if err != nil || len(events.Items) != 1 {
+			t.Fatalf("expected one event in %s, got %v (%v)", namespace, events, err)
+		}
+	}
+}
+
+func newTestDetector(notifiers []Notifier) *Detector {
+	cfg := config.AnomalyConfig{Window: 24 * time.Hour, MinSamples: 30, Sensitivity: 5, MinIncrease: 0.1, TopContributors: 2}
+	return NewDetector(cfg, snapshot.NewStore(), notifiers, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// testSnapshot has one namespace costing nsCost per hour and egress worth
+// egress dollars since the previous snapshot.
+func testSnapshot(ts time.Time, nsCost, egress float64) snapshot.Snapshot {
+	return snapshot.Snapshot{
+		Timestamp:  ts,
+		Namespaces: []snapshot.NamespaceCostRecord{{Namespace: "payments", HourlyCost: nsCost}},
+		Pods: []snapshot.PodCostRecord{
+			{Namespace: "payments", Pod: "api-1", HourlyCost: nsCost * 0.2},
+			{Namespace: "payments", Pod: "api-2", HourlyCost: nsCost * 0.5},
+			{Namespace: "payments", Pod: "api-3", HourlyCost: nsCost * 0.3},
+		},
+		Network: snapshot.NetworkSnapshot{
+			ByClass: []snapshot.NetworkClassTotals{{Class: "internet_egress", EgressCostHourly: egress}},
+			PodConnections: []snapshot.NetworkConnection{{
+				Source:           snapshot.NetworkEndpoint{Kind: "pod", Namespace: "payments", Name: "api-2"},
+				Destination:      snapshot.NetworkEndpoint{Kind: "external", Name: "internet"},
+				Class:            "internet_egress",
+				EgressCostHourly: egress,
+			}},
+		},
+	}
+}
@@ -0,0 +1,150 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AlertKind identifies anomaly alerts to webhook receivers.
+const AlertKind = "cost.anomaly"
+
+// Notifier is told about every newly detected anomaly.
+type Notifier interface {
+	Notify(ctx context.Context, a Anomaly) error
+}
+
+// Alert is the JSON body posted to anomaly webhooks.
+type Alert struct {
+	Kind        string  `json:"kind"`
+	ClusterName string  `json:"clusterName"`
+	Anomaly     Anomaly `json:"anomaly"`
+}
+
+type webhookNotifier struct {
+	hook        config.WebhookConfig
+	clusterName string
+	client      *http.Client
+}
+
+// NewWebhookNotifier posts an Alert to hook for each anomaly.
+func NewWebhookNotifier(hook config.WebhookConfig, clusterName string, timeout time.Duration) Notifier {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &webhookNotifier{hook: hook, clusterName: clusterName, client: &http.Client{Timeout: timeout}}
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, a Anomaly) error {
+	body, err := json.Marshal(Alert{Kind: AlertKind, ClusterName: n.clusterName, Anomaly: a})
+	if err != nil {
+		return fmt.Errorf("encode alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	for k, v := range n.hook.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send alert: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type eventNotifier struct {
+	client   kubernetes.Interface
+	instance string
+	fallback string
+}
+
+// NewEventNotifier records each anomaly as a Warning Event on the affected
+// Namespace. Network class anomalies go to the namespace of the source of
+// their top connection, or to fallback, the agent's own namespace, when it
+// has none. They are skipped when both are empty.
+func NewEventNotifier(client kubernetes.Interface, instance, fallback string) Notifier {
+	return &eventNotifier{client: client, instance: instance, fallback: fallback}
+}
+
+func (n *eventNotifier) Notify(ctx context.Context, a Anomaly) error {
+	namespace := a.Name
+	if a.Kind == KindNetworkClass {
+		namespace = n.fallback
+		if len(a.TopConnections) > 0 && a.TopConnections[0].Source.Namespace != "" {
+			namespace = a.TopConnections[0].Source.Namespace
+		}
+		if namespace == "" {
+			return nil
+		}
+	}
+	now := metav1.NewTime(a.StartedAt)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "clustercost-anomaly-",
+			Namespace:    namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Name:       namespace,
+		},
+		Reason:              "CostAnomaly",
+		Message:             message(a),
+		Type:                corev1.EventTypeWarning,
+		Source:              corev1.EventSource{Component: "clustercost-agent"},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: "clustercost.io/agent",
+		ReportingInstance:   n.instance,
+	}
+	if _, err := n.client.CoreV1().Events(namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create event: %w", err)
+	}
+	return nil
+}
+
+func message(a Anomaly) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s costs $%.4f/h against a baseline of $%.4f/h (score %.1f)", strings.ReplaceAll(string(a.Kind), "_", " "), a.Name, a.HourlyCost, a.BaselineHourlyCost, a.Score)
+	if len(a.TopPods) > 0 {
+		names := make([]string, 0, len(a.TopPods))
+		for _, pod := range a.TopPods {
+			names = append(names, pod.Pod)
+		}
+		fmt.Fprintf(&b, "; top pods: %s", strings.Join(names, ", "))
+	}
+	if len(a.TopConnections) > 0 {
+		names := make([]string, 0, len(a.TopConnections))
+		for _, conn := range a.TopConnections {
+			names = append(names, endpointName(conn.Source)+" -> "+endpointName(conn.Destination))
+		}
+		fmt.Fprintf(&b, "; top connections: %s", strings.Join(names, ", "))
+	}
+	return b.String()
+}
+
+func endpointName(e snapshot.NetworkEndpoint) string {
+	if e.Namespace == "" {
+		return e.Name
+	}
+	return e.Namespace + "/" + e.Name
+}
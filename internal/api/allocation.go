@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"clustercost-agent-k8s/internal/allocation"
+)
+
+// AllocationHandler serves allocation queries.
+type AllocationHandler struct {
+	allocator *allocation.Allocator
+}
+
+// NewAllocationHandler builds an AllocationHandler bound to the allocator.
+func NewAllocationHandler(allocator *allocation.Allocator) *AllocationHandler {
+	return &AllocationHandler{allocator: allocator}
+}
+
+// Register wires the allocation endpoint on the mux.
+func (h *AllocationHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/allocation", h.query)
+}
+
+func (h *AllocationHandler) query(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	window, err := allocation.ParseWindow(q.Get("window"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	aggregate, err := allocation.ParseAggregate(q.Get("aggregate"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := h.allocator.Query(window, aggregate)
+	if errors.Is(err, allocation.ErrNoData) {
+		respondError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, AllocationResponse{
+		Start:     result.Start,
+		End:       result.End,
+		Aggregate: result.Aggregate,
+		Items:     result.Allocations,
+		Total:     result.Total,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
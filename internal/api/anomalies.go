@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"clustercost-agent-k8s/internal/anomaly"
+)
+
+// AnomaliesHandler serves detected cost anomalies.
+type AnomaliesHandler struct {
+	detector *anomaly.Detector
+}
+
+// NewAnomaliesHandler builds an AnomaliesHandler bound to the detector.
+func NewAnomaliesHandler(detector *anomaly.Detector) *AnomaliesHandler {
+	return &AnomaliesHandler{detector: detector}
+}
+
+// Register wires the anomaly endpoint on the mux.
+func (h *AnomaliesHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/anomalies", h.list)
+}
+
+func (h *AnomaliesHandler) list(w http.ResponseWriter, r *http.Request) {
+	activeOnly := false
+	if raw := r.URL.Query().Get("active"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "active must be true or false")
+			return
+		}
+		activeOnly = parsed
+	}
+	items := make([]anomaly.Anomaly, 0)
+	for _, a := range h.detector.Anomalies() {
+		if activeOnly && !a.Active {
+			continue
+		}
+		items = append(items, a)
+	}
+	respondJSON(w, http.StatusOK, AnomaliesResponse{
+		Items:     items,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"clustercost-agent-k8s/internal/health"
 	"clustercost-agent-k8s/internal/snapshot"
 )
 
@@ -15,16 +16,19 @@ type Handler struct {
 	clusterRegion string
 	version       string
 	store         *snapshot.Store
+	tracker       *health.Tracker
 }
 
-// NewHandler builds a Handler bound to the snapshot store.
-func NewHandler(clusterType, clusterName, clusterRegion, version string, store *snapshot.Store) *Handler {
+// NewHandler builds a Handler bound to the snapshot store. tracker may be nil,
+// in which case health only reflects snapshot availability.
+func NewHandler(clusterType, clusterName, clusterRegion, version string, store *snapshot.Store, tracker *health.Tracker) *Handler {
 	return &Handler{
 		clusterType:   clusterType,
 		clusterName:   clusterName,
 		clusterRegion: clusterRegion,
 		version:       version,
 		store:         store,
+		tracker:       tracker,
 	}
 }
 
@@ -37,64 +41,66 @@ func (h *Handler) Register(mux *http.ServeMux) {
 	mux.HandleFunc("/agent/v1/nodes", h.nodes)
 	mux.HandleFunc("/agent/v1/resources", h.resources)
 	mux.HandleFunc("/agent/v1/network", h.network)
+	mux.HandleFunc("/agent/v1/openapi.json", h.openapi)
 }
 
 func (h *Handler) overview(w http.ResponseWriter, r *http.Request) {
 	status := "initializing"
 	timestamp := time.Now().UTC()
 	if snap, ok := h.store.Latest(); ok {
-		status = "ok"
+		status = h.tracker.Overall()
 		timestamp = snap.Timestamp.UTC()
 	}
 
-	payload := map[string]any{
-		"status":        status,
-		"clusterType":   h.clusterType,
-		"clusterName":   h.clusterName,
-		"clusterRegion": h.clusterRegion,
-		"version":       h.version,
-		"timestamp":     timestamp.Format(time.RFC3339Nano),
+	payload := OverviewResponse{
+		Status:        status,
+		SchemaVersion: snapshot.SchemaVersion,
+		ClusterType:   h.clusterType,
+		ClusterName:   h.clusterName,
+		ClusterRegion: h.clusterRegion,
+		Version:       h.version,
+		Timestamp:     timestamp.Format(time.RFC3339Nano),
 	}
 	respondJSON(w, http.StatusOK, payload)
 }
 
 func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
-	if snap, ok := h.store.Latest(); ok {
-		payload := map[string]any{
-			"status":        "ok",
-			"clusterType":   h.clusterType,
-			"clusterName":   h.clusterName,
-			"clusterRegion": h.clusterRegion,
-			"version":       h.version,
-			"timestamp":     snap.Timestamp.UTC().Format(time.RFC3339Nano),
-		}
-		respondJSON(w, http.StatusOK, payload)
-		return
+	payload := HealthResponse{
+		Status:        "initializing",
+		ClusterType:   h.clusterType,
+		ClusterName:   h.clusterName,
+		ClusterRegion: h.clusterRegion,
+		Version:       h.version,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		Components:    h.tracker.Components(),
 	}
-	payload := map[string]any{
-		"status":        "initializing",
-		"clusterType":   h.clusterType,
-		"clusterName":   h.clusterName,
-		"clusterRegion": h.clusterRegion,
-		"version":       h.version,
-		"timestamp":     time.Now().UTC().Format(time.RFC3339Nano),
+	if payload.Components == nil {
+		payload.Components = []health.ComponentStatus{}
+	}
+	if snap, ok := h.store.Latest(); ok {
+		payload.Status = h.tracker.Overall()
+		payload.Timestamp = snap.Timestamp.UTC().Format(time.RFC3339Nano)
 	}
 	respondJSON(w, http.StatusOK, payload)
 }
 
 func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
-	if _, ok := h.store.Latest(); ok {
-		respondJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	if _, ok := h.store.Latest(); !ok {
+		respondError(w, http.StatusServiceUnavailable, "snapshot not ready")
 		return
 	}
-	respondError(w, http.StatusServiceUnavailable, "snapshot not ready")
+	if ready, reason := h.tracker.Ready(); !ready {
+		respondError(w, http.StatusServiceUnavailable, reason)
+		return
+	}
+	respondJSON(w, http.StatusOK, ReadyResponse{Status: "ready"})
 }
 
 func (h *Handler) namespaces(w http.ResponseWriter, r *http.Request) {
 	if snap, ok := h.store.Latest(); ok {
-		payload := map[string]any{
-			"items":     snap.Namespaces,
-			"timestamp": snap.Timestamp.UTC().Format(time.RFC3339Nano),
+		payload := NamespacesResponse{
+			Items:     snap.Namespaces,
+			Timestamp: snap.Timestamp.UTC().Format(time.RFC3339Nano),
 		}
 		respondJSON(w, http.StatusOK, payload)
 		return
@@ -104,9 +110,9 @@ func (h *Handler) namespaces(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) nodes(w http.ResponseWriter, r *http.Request) {
 	if snap, ok := h.store.Latest(); ok {
-		payload := map[string]any{
-			"items":     snap.Nodes,
-			"timestamp": snap.Timestamp.UTC().Format(time.RFC3339Nano),
+		payload := NodesResponse{
+			Items:     snap.Nodes,
+			Timestamp: snap.Timestamp.UTC().Format(time.RFC3339Nano),
 		}
 		respondJSON(w, http.StatusOK, payload)
 		return
@@ -116,9 +122,9 @@ func (h *Handler) nodes(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) resources(w http.ResponseWriter, r *http.Request) {
 	if snap, ok := h.store.Latest(); ok {
-		payload := map[string]any{
-			"snapshot":  snap.Resources,
-			"timestamp": snap.Timestamp.UTC().Format(time.RFC3339Nano),
+		payload := ResourcesResponse{
+			Snapshot:  snap.Resources,
+			Timestamp: snap.Timestamp.UTC().Format(time.RFC3339Nano),
 		}
 		respondJSON(w, http.StatusOK, payload)
 		return
@@ -128,9 +134,9 @@ func (h *Handler) resources(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) network(w http.ResponseWriter, r *http.Request) {
 	if snap, ok := h.store.Latest(); ok {
-		payload := map[string]any{
-			"network":   snap.Network,
-			"timestamp": snap.Timestamp.UTC().Format(time.RFC3339Nano),
+		payload := NetworkResponse{
+			Network:   snap.Network,
+			Timestamp: snap.Timestamp.UTC().Format(time.RFC3339Nano),
 		}
 		respondJSON(w, http.StatusOK, payload)
 		return
@@ -145,5 +151,5 @@ func respondJSON(w http.ResponseWriter, status int, payload any) {
 }
 
 func respondError(w http.ResponseWriter, status int, msg string) {
-	respondJSON(w, status, map[string]string{"error": msg})
+	respondJSON(w, status, ErrorResponse{Error: msg})
 }
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"clustercost-agent-k8s/internal/health"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func TestHealthReportsComponentsAndGatesReadiness(t *testing.T) {
+	store := snapshot.NewStore()
+	store.Update(fixtureSnapshot())
+	tracker := health.NewTracker([]string{health.NetworkCollector})
+	tracker.Track(health.Informers, health.NetworkCollector)
+	tracker.Observe(health.Informers, nil)
+	tracker.Fail(health.NetworkCollector, errors.New("load pinned eBPF map: no such file"))
+
+	mux := http.NewServeMux()
+	NewHandler("eks", "prod", "us-east-1", "v1.2.3", store, tracker).Register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent/v1/health", nil))
+	var payload HealthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.Status != health.StatusFailed || len(payload.Components) != 2 {
+		t.Fatalf("unexpected health %+v", payload)
+	}
+	if payload.Components[1].Name != health.NetworkCollector || payload.Components[1].LastError == "" {
+		t.Fatalf("unexpected network collector status %+v", payload.Components[1])
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent/v1/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"clustercost-agent-k8s/internal/budget"
+)
+
+// BudgetsHandler serves budget status.
+type BudgetsHandler struct {
+	budgets *budget.Evaluator
+}
+
+// NewBudgetsHandler builds a BudgetsHandler bound to the evaluator.
+func NewBudgetsHandler(budgets *budget.Evaluator) *BudgetsHandler {
+	return &BudgetsHandler{budgets: budgets}
+}
+
+// Register wires the budget endpoint on the mux.
+func (h *BudgetsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/budgets", h.list)
+}
+
+func (h *BudgetsHandler) list(w http.ResponseWriter, r *http.Request) {
+	statuses := h.budgets.Statuses()
+	if statuses == nil {
+		respondError(w, http.StatusServiceUnavailable, "budgets not evaluated yet")
+		return
+	}
+	respondJSON(w, http.StatusOK, BudgetsResponse{
+		Items:     statuses,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
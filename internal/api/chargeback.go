@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/chargeback"
+	"clustercost-agent-k8s/internal/export"
+)
+
+// ChargebackHandler renders chargeback statements from accumulated costs.
+type ChargebackHandler struct {
+	meta  export.Meta
+	costs *accumulator.Accumulator
+}
+
+// NewChargebackHandler builds a ChargebackHandler bound to the accumulator.
+func NewChargebackHandler(meta export.Meta, costs *accumulator.Accumulator) *ChargebackHandler {
+	return &ChargebackHandler{meta: meta, costs: costs}
+}
+
+// Register wires the chargeback endpoint on the mux.
+func (h *ChargebackHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/chargeback", h.report)
+}
+
+func (h *ChargebackHandler) report(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format, err := chargeback.ParseFormat(q.Get("format"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	window := accumulator.WindowMonth
+	if raw := q.Get("window"); raw != "" {
+		if window, err = accumulator.ParseWindow(raw); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	opts := chargeback.Options{GroupBy: q.Get("groupBy")}
+	if raw := q.Get("top"); raw != "" {
+		if opts.Top, err = strconv.Atoi(raw); err != nil || opts.Top <= 0 {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid top %q", raw))
+			return
+		}
+	}
+	if raw := q.Get("shareIdle"); raw != "" {
+		if opts.ShareIdle, err = strconv.ParseBool(raw); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("invalid shareIdle %q", raw))
+			return
+		}
+	}
+
+	period, err := chargeback.SelectPeriod(h.costs, window, q.Get("period"))
+	if errors.Is(err, chargeback.ErrNoPeriod) {
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report := chargeback.Build(period, h.meta, opts, time.Now())
+	w.Header().Set("Content-Type", format.ContentType())
+	if format != chargeback.FormatJSON {
+		filename := fmt.Sprintf("chargeback-%s-%s-%s.%s", report.Window, report.Start.Format("20060102"), report.GroupBy, format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = chargeback.Write(w, format, report)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+)
+
+// CostsHandler serves accumulated calendar cost totals.
+type CostsHandler struct {
+	costs *accumulator.Accumulator
+}
+
+// NewCostsHandler builds a CostsHandler bound to the accumulator.
+func NewCostsHandler(costs *accumulator.Accumulator) *CostsHandler {
+	return &CostsHandler{costs: costs}
+}
+
+// Register wires the cost endpoints on the mux.
+func (h *CostsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/costs", h.current)
+	mux.HandleFunc("/agent/v1/costs/history", h.history)
+}
+
+func (h *CostsHandler) current(w http.ResponseWriter, r *http.Request) {
+	periods := h.costs.Current()
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		window, err := accumulator.ParseWindow(raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		periods = periods[:0]
+		if p, ok := h.costs.CurrentWindow(window); ok {
+			periods = append(periods, p)
+		}
+	}
+	if len(periods) == 0 {
+		respondError(w, http.StatusServiceUnavailable, "cost totals not ready")
+		return
+	}
+	payload := CostsResponse{
+		Items:     periods,
+		Timezone:  h.costs.Location().String(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	respondJSON(w, http.StatusOK, payload)
+}
+
+func (h *CostsHandler) history(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("window")
+	if raw == "" {
+		raw = string(accumulator.WindowDay)
+	}
+	window, err := accumulator.ParseWindow(raw)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload := CostsResponse{
+		Items:     h.costs.History(window),
+		Timezone:  h.costs.Location().String(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	respondJSON(w, http.StatusOK, payload)
+}
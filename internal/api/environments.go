@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const maxDryRunBody = 1 << 20
+
+// EnvironmentsHandler shows how namespaces are classified into environments.
+type EnvironmentsHandler struct {
+	config     snapshot.ClassifierConfig
+	classifier *snapshot.EnvironmentClassifier
+	namespaces func() ([]*corev1.Namespace, error)
+}
+
+// NewEnvironmentsHandler builds an EnvironmentsHandler for the configured
+// classifier. namespaces lists the namespaces to classify.
+func NewEnvironmentsHandler(cfg snapshot.ClassifierConfig, namespaces func() ([]*corev1.Namespace, error)) *EnvironmentsHandler {
+	return &EnvironmentsHandler{
+		config:     cfg,
+		classifier: snapshot.NewEnvironmentClassifier(cfg),
+		namespaces: namespaces,
+	}
+}
+
+// Register wires the dry-run endpoint on the mux.
+func (h *EnvironmentsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/environments/dry-run", h.dryRun)
+}
+
+// dryRun classifies every namespace. GET uses the configured rules; POST
+// replaces them with the posted rules and reports the configured result
+// alongside, without changing what snapshots use.
+func (h *EnvironmentsHandler) dryRun(w http.ResponseWriter, r *http.Request) {
+	var candidate *snapshot.EnvironmentClassifier
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req EnvironmentDryRunRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDryRunBody)).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		cfg := h.config
+		cfg.Rules = make([]snapshot.EnvironmentRule, 0, len(req.Rules))
+		for _, rr := range req.Rules {
+			rule, err := snapshot.CompileEnvironmentRule(rr.Name, rr.Environment, rr.NamespaceRegex, rr.Selector, rr.Annotations)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			cfg.Rules = append(cfg.Rules, rule)
+		}
+		candidate = snapshot.NewEnvironmentClassifier(cfg)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	namespaces, err := h.namespaces()
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "list namespaces: "+err.Error())
+		return
+	}
+	items := make([]EnvironmentMatch, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if ns == nil {
+			continue
+		}
+		current := h.classifier.MatchNamespace(ns)
+		item := EnvironmentMatch{Namespace: ns.Name, Match: current}
+		if candidate != nil {
+			item.Match = candidate.MatchNamespace(ns)
+			item.Current = &current
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Namespace < items[j].Namespace })
+	respondJSON(w, http.StatusOK, EnvironmentDryRunResponse{
+		Items:     items,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
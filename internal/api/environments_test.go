@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"clustercost-agent-k8s/internal/snapshot"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnvironmentDryRun(t *testing.T) {
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "perf-load"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "checkout-prod"}},
+	}
+	mux := http.NewServeMux()
+	NewEnvironmentsHandler(snapshot.ClassifierConfig{ProductionNameContains: []string{"prod"}}, func() ([]*corev1.Namespace, error) {
+		return namespaces, nil
+	}).Register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent/v1/environments/dry-run", nil))
+	var payload EnvironmentDryRunResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(payload.Items) != 2 || payload.Items[0].Namespace != "checkout-prod" ||
+		payload.Items[0].Match.Rule != snapshot.RuleNameContains || payload.Items[1].Match.Rule != snapshot.RuleDefault ||
+		payload.Items[0].Current != nil {
+		t.Fatalf("unexpected configured classification %+v", payload.Items)
+	}
+
+	body := `{"rules":[{"name":"perf","environment":"perf","namespaceRegex":"perf-.*"}]}`
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/agent/v1/environments/dry-run", strings.NewReader(body)))
+	payload = EnvironmentDryRunResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	perf := payload.Items[1]
+	if perf.Match != (snapshot.Match{Environment: "perf", Rule: "perf"}) || perf.Current == nil || perf.Current.Environment != "nonprod" {
+		t.Fatalf("unexpected candidate classification %+v", perf)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/agent/v1/environments/dry-run", strings.NewReader(`{"rules":[{"name":"x","environment":"qa"}]}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("rule without conditions = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/export"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// ExportHandler renders snapshot and accumulated cost data as CSV or FOCUS.
+type ExportHandler struct {
+	meta     export.Meta
+	store    *snapshot.Store
+	costs    *accumulator.Accumulator
+	interval time.Duration
+}
+
+// NewExportHandler builds an ExportHandler. costs may be nil when accumulation
+// is disabled, in which case only the snapshot source is available. interval
+// is the collection interval the snapshot's egress cost covers.
+func NewExportHandler(meta export.Meta, store *snapshot.Store, costs *accumulator.Accumulator, interval time.Duration) *ExportHandler {
+	return &ExportHandler{meta: meta, store: store, costs: costs, interval: interval}
+}
+
+// Register wires the export endpoint on the mux.
+func (h *ExportHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/export", h.export)
+}
+
+func (h *ExportHandler) export(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := export.ParseFormat(query.Get("format"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	level, err := export.ParseLevel(query.Get("level"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	source := query.Get("source")
+	if source == "" {
+		source = "snapshot"
+	}
+
+	snap, ready := h.store.Latest()
+	var lines []export.Line
+	switch source {
+	case "snapshot":
+		if !ready {
+			respondError(w, http.StatusServiceUnavailable, "snapshot not ready")
+			return
+		}
+		lines = export.SnapshotLines(snap, level, h.interval)
+	case "current", "history":
+		if h.costs == nil {
+			respondError(w, http.StatusNotFound, "cost accumulation disabled")
+			return
+		}
+		window := accumulator.WindowDay
+		if raw := query.Get("window"); raw != "" {
+			if window, err = accumulator.ParseWindow(raw); err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		var periods []accumulator.Period
+		if source == "history" {
+			periods = h.costs.History(window)
+		} else if p, ok := h.costs.CurrentWindow(window); ok {
+			periods = []accumulator.Period{p}
+		}
+		var enrich *snapshot.Snapshot
+		if ready {
+			enrich = &snap
+		}
+		lines = export.PeriodLines(periods, level, enrich)
+	default:
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("unknown source %q", source))
+		return
+	}
+
+	filename := fmt.Sprintf("clustercost-%s-%s-%s.csv", source, format, time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	_ = export.Write(w, format, h.meta, lines)
+}
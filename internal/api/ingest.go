@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"clustercost-agent-k8s/internal/central"
+)
+
+// IngestHandler receives the report batches agents forward to a central
+// agent. It is mounted at the configured ingest path rather than through
+// Register, so it can carry its own authentication.
+type IngestHandler struct {
+	aggregator   *central.Aggregator
+	maxBodyBytes int64
+}
+
+// NewIngestHandler builds an IngestHandler that records reports in
+// aggregator.
+func NewIngestHandler(aggregator *central.Aggregator, maxBodyBytes int64) *IngestHandler {
+	return &IngestHandler{aggregator: aggregator, maxBodyBytes: maxBodyBytes}
+}
+
+// ServeHTTP accepts a POSTed batch as JSON or protobuf, compressed with gzip
+// or zstd or not at all.
+func (h *IngestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	reports, err := central.DecodeBatch(r.Body, r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), h.maxBodyBytes)
+	if errors.Is(err, central.ErrTooLarge) {
+		respondError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if errors.Is(err, central.ErrUnsupportedMedia) {
+		respondError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result := h.aggregator.Accept(r.Header.Get("Idempotency-Key"), reports)
+	if err := result.Err(); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, IngestResponse{
+		Accepted:   result.Accepted,
+		Duplicates: result.Duplicates,
+		Nodes:      h.aggregator.Nodes(),
+		Resync:     result.Resync,
+		Rejected:   result.Rejected,
+	})
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/central"
+	"clustercost-agent-k8s/internal/chargeback"
+	"clustercost-agent-k8s/internal/forwarder"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// route documents one endpoint in the OpenAPI document. Every endpoint
+// under /agent/v1 must be listed here; the golden test in openapi_test.go
+// fails when a documented shape changes incompatibly.
+type route struct {
+	path string
+	// method defaults to GET.
+	method      string
+	summary     string
+	request     any
+	params      []queryParam
+	response    any
+	contentType string
+	errors      []int
+}
+
+type queryParam struct {
+	name        string
+	description string
+	enum        []string
+}
+
+var routes = []route{
+	{path: "/agent/v1/readyz", summary: "Readiness probe; 200 once a snapshot exists", response: ReadyResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{path: "/agent/v1/health", summary: "Agent health and identity", response: HealthResponse{}},
+	{path: "/agent/v1/overview", summary: "Cluster identity and snapshot status", response: OverviewResponse{}},
+	{path: "/agent/v1/namespaces", summary: "Namespace cost records from the latest snapshot", response: NamespacesResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{path: "/agent/v1/nodes", summary: "Node cost records from the latest snapshot", response: NodesResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{path: "/agent/v1/resources", summary: "Cluster resource totals from the latest snapshot", response: ResourcesResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{path: "/agent/v1/network", summary: "Network usage, cost, and connection graphs", response: NetworkResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{
+		path:     "/agent/v1/costs",
+		summary:  "Accumulated spend for the open calendar windows",
+		params:   []queryParam{{name: "window", description: "Restrict to one window", enum: []string{"day", "week", "month"}}},
+		response: CostsResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusServiceUnavailable},
+	},
+	{
+		path:     "/agent/v1/costs/history",
+		summary:  "Closed calendar periods, oldest first",
+		params:   []queryParam{{name: "window", description: "Window to list (default day)", enum: []string{"day", "week", "month"}}},
+		response: CostsResponse{},
+		errors:   []int{http.StatusBadRequest},
+	},
+	{path: "/agent/v1/budgets", summary: "Budget spend and threshold status for the open periods", response: BudgetsResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{
+		path:     "/agent/v1/anomalies",
+		summary:  "Hourly cost spikes per namespace and network class with their top contributors",
+		params:   []queryParam{{name: "active", description: "Only list anomalies that are still active", enum: []string{"true", "false"}}},
+		response: AnomaliesResponse{},
+		errors:   []int{http.StatusBadRequest},
+	},
+	{
+		path:    "/agent/v1/allocation",
+		summary: "Pod cost over a window grouped by any combination of properties, with efficiency",
+		params: []queryParam{
+			{name: "aggregate", description: "Comma-separated properties: cluster, namespace, node, pod, controllerKind, controller (or workload), environment, label:<key>, annotation:<key> (default namespace)"},
+			{name: "window", description: "Window ending at the latest snapshot, such as 30m, 24h or 7d (default 24h)"},
+		},
+		response: AllocationResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusServiceUnavailable},
+	},
+	{
+		path:    "/agent/v1/export",
+		summary: "Cost data as CSV or FOCUS rows",
+		params: []queryParam{
+			{name: "source", description: "Data source (default snapshot)", enum: []string{"snapshot", "current", "history"}},
+			{name: "format", description: "Output format (default csv)", enum: []string{"csv", "focus"}},
+			{name: "level", description: "Row granularity (default namespace)", enum: []string{"namespace", "node"}},
+			{name: "window", description: "Calendar window for current and history (default day)", enum: []string{"day", "week", "month"}},
+		},
+		contentType: "text/csv",
+		errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable},
+	},
+	{
+		path:    "/agent/v1/chargeback",
+		summary: "Chargeback statements per group for an accumulated period, with compute, network, storage and shared cost and the top workloads",
+		params: []queryParam{
+			{name: "window", description: "Calendar window (default month)", enum: []string{"day", "week", "month"}},
+			{name: "period", description: "current (default), previous, or a date (YYYY-MM-DD) or month (YYYY-MM) in the period"},
+			{name: "groupBy", description: "namespace (default), environment, or an allocation dimension such as team"},
+			{name: "top", description: "Workloads listed per statement (default 5)"},
+			{name: "shareIdle", description: "Spread idle node cost over the groups as shared cost", enum: []string{"true", "false"}},
+			{name: "format", description: "Output format (default json)", enum: []string{"json", "csv", "html"}},
+		},
+		response: chargeback.Report{},
+		errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	{
+		path:        "/agent/v1/stream",
+		summary:     "Server-Sent Events on every snapshot; data is a StreamDiff (event diff) or StreamSummary (event summary)",
+		params:      []queryParam{{name: "mode", description: "Event payload (default diff)", enum: []string{"diff", "summary"}}},
+		response:    StreamDiff{},
+		contentType: "text/event-stream",
+		errors:      []int{http.StatusBadRequest, http.StatusServiceUnavailable},
+	},
+	{path: "/agent/v1/environments/dry-run", summary: "Environment of every namespace and the rule that decided it", response: EnvironmentDryRunResponse{}, errors: []int{http.StatusServiceUnavailable}},
+	{
+		path:     "/agent/v1/environments/dry-run",
+		method:   http.MethodPost,
+		summary:  "Classify every namespace with candidate rules instead of the configured ones, without applying them",
+		request:  EnvironmentDryRunRequest{},
+		response: EnvironmentDryRunResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusServiceUnavailable},
+	},
+	{
+		path:     "/agent/v1/ingest",
+		method:   http.MethodPost,
+		summary:  "Central mode only: accept a batch of forwarded agent reports as JSON or application/x-protobuf, optionally gzip- or zstd-compressed; the path is configurable",
+		request:  central.Batch{},
+		response: IngestResponse{},
+		errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType},
+	},
+	{path: "/agent/v1/openapi.json", summary: "This document", contentType: "application/json"},
+}
+
+// wireTypes are documented as components even though no /agent/v1 route
+// returns them directly: Snapshot is the unit the builder produces and
+// AgentReport is what the forwarder posts to the remote endpoint.
+var wireTypes = []any{snapshot.Snapshot{}, forwarder.AgentReport{}}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]any
+)
+
+// OpenAPIDocument returns the OpenAPI 3 description of the /agent/v1 API,
+// generated from the route table and the Go response types.
+func OpenAPIDocument() map[string]any {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPIDocument()
+	})
+	return openAPIDoc
+}
+
+func (h *Handler) openapi(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, OpenAPIDocument())
+}
+
+func buildOpenAPIDocument() map[string]any {
+	gen := &schemaGenerator{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	errorRef := gen.schemaFor(reflect.TypeOf(ErrorResponse{}))
+	gen.schemaFor(reflect.TypeOf(StreamSummary{}))
+	for _, wire := range wireTypes {
+		gen.schemaFor(reflect.TypeOf(wire))
+	}
+
+	paths := map[string]any{}
+	for _, rt := range routes {
+		contentType := rt.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		var body map[string]any
+		switch {
+		case rt.response != nil:
+			body = map[string]any{"schema": gen.schemaFor(reflect.TypeOf(rt.response))}
+		case contentType == "text/csv":
+			body = map[string]any{"schema": map[string]any{"type": "string"}}
+		default:
+			body = map[string]any{"schema": map[string]any{"type": "object"}}
+		}
+		responses := map[string]any{
+			"200": map[string]any{
+				"description": "OK",
+				"content":     map[string]any{contentType: body},
+			},
+		}
+		for _, code := range rt.errors {
+			responses[strconv.Itoa(code)] = map[string]any{
+				"description": http.StatusText(code),
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": errorRef},
+				},
+			}
+		}
+		op := map[string]any{
+			"summary":   rt.summary,
+			"responses": responses,
+		}
+		if len(rt.params) > 0 {
+			params := make([]any, 0, len(rt.params))
+			for _, p := range rt.params {
+				schema := map[string]any{"type": "string"}
+				if len(p.enum) > 0 {
+					schema["enum"] = p.enum
+				}
+				params = append(params, map[string]any{
+					"name":        p.name,
+					"in":          "query",
+					"required":    false,
+					"description": p.description,
+					"schema":      schema,
+				})
+			}
+			op["parameters"] = params
+		}
+		if rt.request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": gen.schemaFor(reflect.TypeOf(rt.request))},
+				},
+			}
+		}
+		method := rt.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		item, _ := paths[rt.path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ClusterCost Agent API",
+			"version": snapshot.SchemaVersion,
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": gen.schemas},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGenerator maps Go types to OpenAPI schemas, registering named structs
+// as components so shared records are described once.
+type schemaGenerator struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + g.register(t)}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (g *schemaGenerator) register(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.names[t] = name
+	g.schemas[name] = map[string]any{} // placeholder for recursive types
+	g.schemas[name] = g.structSchema(t)
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
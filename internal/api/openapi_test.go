@@ -0,0 +1,336 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/forwarder"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// Run `go test ./internal/api -update` after an intentional schema change.
+// Golden files are only rewritten when the change is compatible with the
+// recorded document or the major schema version has been bumped.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestOpenAPIDocumentMatchesGolden(t *testing.T) {
+	current := encodeIndent(t, OpenAPIDocument())
+	path := filepath.Join("testdata", "openapi.json")
+	golden, err := os.ReadFile(path)
+	if err != nil && !*update {
+		t.Fatalf("read golden: %v", err)
+	}
+	if err == nil {
+		var old map[string]any
+		if err := json.Unmarshal(golden, &old); err != nil {
+			t.Fatalf("decode golden: %v", err)
+		}
+		if majorVersion(old) == snapshot.SchemaVersion[:strings.Index(snapshot.SchemaVersion, ".")] {
+			if problems := incompatibilities(old, roundTrip(t, OpenAPIDocument())); len(problems) > 0 {
+				t.Fatalf("incompatible API change without a major schema version bump:\n  %s", strings.Join(problems, "\n  "))
+			}
+		}
+	}
+	if *update {
+		writeGolden(t, path, current)
+		return
+	}
+	if !bytes.Equal(golden, current) {
+		t.Fatalf("openapi document changed; run go test ./internal/api -update and commit testdata/openapi.json")
+	}
+}
+
+func TestIncompatibilitiesDetectsBreakingChanges(t *testing.T) {
+	old := roundTrip(t, OpenAPIDocument())
+	changed := roundTrip(t, OpenAPIDocument())
+
+	schemas := changed["components"].(map[string]any)["schemas"].(map[string]any)
+	record := schemas["NamespaceCostRecord"].(map[string]any)["properties"].(map[string]any)
+	delete(record, "hourlyCost")
+	record["podCount"] = map[string]any{"type": "string"}
+	delete(changed["paths"].(map[string]any), "/agent/v1/nodes")
+
+	problems := incompatibilities(old, changed)
+	want := []string{
+		"components.NamespaceCostRecord.hourlyCost: removed",
+		"components.NamespaceCostRecord.podCount: type integer/int64 changed to string",
+		"paths./agent/v1/nodes: removed",
+	}
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected problems:\n%s", strings.Join(problems, "\n"))
+	}
+
+	added := roundTrip(t, OpenAPIDocument())
+	addedRecord := added["components"].(map[string]any)["schemas"].(map[string]any)["NamespaceCostRecord"].(map[string]any)["properties"].(map[string]any)
+	addedRecord["team"] = map[string]any{"type": "string"}
+	if problems := incompatibilities(old, added); len(problems) != 0 {
+		t.Fatalf("additive change reported as incompatible: %v", problems)
+	}
+}
+
+func TestSnapshotPayloadsMatchGolden(t *testing.T) {
+	store := snapshot.NewStore()
+	snap := fixtureSnapshot()
+	store.Update(snap)
+	mux := http.NewServeMux()
+	NewHandler("eks", "prod", "us-east-1", "v1.2.3", store, nil).Register(mux)
+
+	for _, name := range []string{"overview", "namespaces", "nodes", "resources", "network"} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/agent/v1/"+name, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			var payload any
+			if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			compareGolden(t, name+".json", encodeIndent(t, payload))
+		})
+	}
+
+	report := forwarder.AgentReport{
+		SchemaVersion: forwarder.SchemaVersion,
+		ClusterID:     "c1",
+		ClusterName:   "prod",
+		NodeName:      "node-a",
+		Version:       "v1.2.3",
+		Timestamp:     snap.Timestamp,
+		Snapshot:      snap,
+	}
+	compareGolden(t, "agent_report.json", encodeIndent(t, report))
+}
+
+func fixtureSnapshot() snapshot.Snapshot {
+	conn := snapshot.NetworkConnection{
+		Source:           snapshot.NetworkEndpoint{Kind: "pod", Namespace: "payments", Name: "api-0"},
+		Destination:      snapshot.NetworkEndpoint{Kind: "external", Name: "internet"},
+		Class:            "internet_egress",
+		TxBytes:          2048,
+		RxBytes:          512,
+		EgressCostHourly: 0.002,
+	}
+	byClass := []snapshot.NetworkClassTotals{{Class: "internet_egress", TxBytes: 2048, RxBytes: 512, EgressCostHourly: 0.002}}
+	return snapshot.Snapshot{
+		SchemaVersion: snapshot.SchemaVersion,
+		Timestamp:     time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC),
+		Namespaces: []snapshot.NamespaceCostRecord{{
+			ClusterID:          "c1",
+			Namespace:          "payments",
+			HourlyCost:         0.25,
+			PodCount:           2,
+			CPURequestMilli:    500,
+			MemoryRequestBytes: 1 << 30,
+			CPUUsageMilli:      250,
+			MemoryUsageBytes:   512 << 20,
+			NetworkTxBytes:     2048,
+			NetworkRxBytes:     512,
+			NetworkEgressCost:  0.002,
+			Labels:             map[string]string{"team": "checkout"},
+			Environment:        "production",
+		}},
+		Pods: []snapshot.PodCostRecord{{
+			Namespace:          "payments",
+			Pod:                "api-0",
+			Node:               "node-a",
+			ControllerKind:     "Deployment",
+			ControllerName:     "api",
+			HourlyCost:         0.25,
+			CPURequestMilli:    500,
+			MemoryRequestBytes: 1 << 30,
+			CPUUsageMilli:      250,
+			MemoryUsageBytes:   512 << 20,
+			Labels:             map[string]string{"app": "api"},
+		}},
+		Nodes: []snapshot.NodeCostRecord{{
+			ClusterID:              "c1",
+			NodeName:               "node-a",
+			HourlyCost:             0.5,
+			CPUUsagePercent:        12.5,
+			MemoryUsagePercent:     25,
+			CPUAllocatableMilli:    4000,
+			MemoryAllocatableBytes: 16 << 30,
+			PodCount:               2,
+			Status:                 "Ready",
+			InstanceType:           "m5.xlarge",
+			Labels:                 map[string]string{"topology.kubernetes.io/zone": "us-east-1a"},
+			Taints:                 []string{},
+		}},
+		Resources: snapshot.ResourceSnapshot{
+			ClusterID:               "c1",
+			CPUUsageMilliTotal:      250,
+			CPURequestMilliTotal:    500,
+			MemoryUsageBytesTotal:   512 << 20,
+			MemoryRequestBytesTotal: 1 << 30,
+			TotalNodeHourlyCost:     0.5,
+			NetworkTxBytesTotal:     2048,
+			NetworkRxBytesTotal:     512,
+			NetworkEgressCostTotal:  0.002,
+		},
+		Network: snapshot.NetworkSnapshot{
+			ClusterID:  "c1",
+			TxBytes:    2048,
+			RxBytes:    512,
+			EgressCost: 0.002,
+			ByClass:    byClass,
+			Pods: []snapshot.PodNetworkRecord{{
+				Namespace: "payments", Pod: "api-0", Node: "node-a",
+				TxBytes: 2048, RxBytes: 512, EgressCostHourly: 0.002, ByClass: byClass,
+			}},
+			Namespaces: []snapshot.NamespaceNetworkRecord{{
+				Namespace: "payments", TxBytes: 2048, RxBytes: 512, EgressCostHourly: 0.002, ByClass: byClass,
+			}},
+			PodConnections:       []snapshot.NetworkConnection{conn},
+			WorkloadConnections:  []snapshot.NetworkConnection{},
+			NamespaceConnections: []snapshot.NetworkConnection{},
+			ServiceConnections:   []snapshot.NetworkConnection{},
+		},
+	}
+}
+
+// incompatibilities lists changes from old to current that would break an
+// existing client: removed paths, schemas, or properties, and properties
+// whose type, format, or reference changed. Added fields are compatible.
+func incompatibilities(old, current map[string]any) []string {
+	var problems []string
+	oldPaths, _ := old["paths"].(map[string]any)
+	curPaths, _ := current["paths"].(map[string]any)
+	for path, item := range oldPaths {
+		curItem, ok := curPaths[path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("paths.%s: removed", path))
+			continue
+		}
+		oldSchema := okSchema(item)
+		curSchema := okSchema(curItem)
+		if desc := describeType(oldSchema); desc != describeType(curSchema) {
+			problems = append(problems, fmt.Sprintf("paths.%s: response %s changed to %s", path, desc, describeType(curSchema)))
+		}
+	}
+
+	oldSchemas := schemasOf(old)
+	curSchemas := schemasOf(current)
+	for name, raw := range oldSchemas {
+		curRaw, ok := curSchemas[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("components.%s: removed", name))
+			continue
+		}
+		oldProps, _ := raw.(map[string]any)["properties"].(map[string]any)
+		curProps, _ := curRaw.(map[string]any)["properties"].(map[string]any)
+		for prop, schema := range oldProps {
+			curSchema, ok := curProps[prop]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("components.%s.%s: removed", name, prop))
+				continue
+			}
+			if desc := describeType(schema); desc != describeType(curSchema) {
+				problems = append(problems, fmt.Sprintf("components.%s.%s: type %s changed to %s", name, prop, desc, describeType(curSchema)))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func okSchema(item any) any {
+	get, _ := item.(map[string]any)["get"].(map[string]any)
+	responses, _ := get["responses"].(map[string]any)
+	ok, _ := responses["200"].(map[string]any)
+	content, _ := ok["content"].(map[string]any)
+	for _, media := range content {
+		return media.(map[string]any)["schema"]
+	}
+	return nil
+}
+
+func schemasOf(doc map[string]any) map[string]any {
+	components, _ := doc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	return schemas
+}
+
+// describeType renders the shape of a schema without descriptions so that
+// type changes in nested arrays and maps are caught too.
+func describeType(raw any) string {
+	schema, _ := raw.(map[string]any)
+	if ref, ok := schema["$ref"].(string); ok {
+		return ref[strings.LastIndex(ref, "/")+1:]
+	}
+	kind, _ := schema["type"].(string)
+	switch kind {
+	case "array":
+		return "array<" + describeType(schema["items"]) + ">"
+	case "object":
+		if extra, ok := schema["additionalProperties"]; ok {
+			return "map<" + describeType(extra) + ">"
+		}
+		return "object"
+	}
+	if format, ok := schema["format"].(string); ok {
+		return kind + "/" + format
+	}
+	return kind
+}
+
+func majorVersion(doc map[string]any) string {
+	info, _ := doc["info"].(map[string]any)
+	version, _ := info["version"].(string)
+	major, _, _ := strings.Cut(version, ".")
+	return major
+}
+
+func roundTrip(t *testing.T, doc map[string]any) map[string]any {
+	t.Helper()
+	var out map[string]any
+	if err := json.Unmarshal(encodeIndent(t, doc), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return out
+}
+
+func encodeIndent(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return append(data, '\n')
+}
+
+func compareGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		writeGolden(t, path, got)
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden: %v", err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Fatalf("%s changed; run go test ./internal/api -update if intended\n%s", name, got)
+	}
+}
+
+func writeGolden(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write golden: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"clustercost-agent-k8s/internal/allocation"
+	"clustercost-agent-k8s/internal/opencost"
+)
+
+// OpenCostHandler serves the OpenCost allocation and assets APIs from the
+// allocator, so OpenCost clients can query the agent unchanged.
+type OpenCostHandler struct {
+	allocator *allocation.Allocator
+	now       func() time.Time
+}
+
+// NewOpenCostHandler builds an OpenCostHandler bound to the allocator.
+func NewOpenCostHandler(allocator *allocation.Allocator) *OpenCostHandler {
+	return &OpenCostHandler{allocator: allocator, now: time.Now}
+}
+
+// Register wires the OpenCost endpoints on the mux, both at the root as the
+// OpenCost API serves them and under /model as its UI proxies them.
+func (h *OpenCostHandler) Register(mux *http.ServeMux) {
+	for _, prefix := range []string{"", "/model"} {
+		mux.HandleFunc(prefix+"/allocation", h.allocation)
+		mux.HandleFunc(prefix+"/allocation/compute", h.allocation)
+		mux.HandleFunc(prefix+"/assets", h.assets)
+	}
+}
+
+func (h *OpenCostHandler) allocation(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	steps, ok := h.steps(w, r)
+	if !ok {
+		return
+	}
+	aggregate, err := opencost.ParseAggregate(q.Get("aggregate"))
+	if err != nil {
+		respondOpenCostError(w, http.StatusBadRequest, err)
+		return
+	}
+	sets := make([]map[string]opencost.Allocation, 0, len(steps))
+	for _, step := range steps {
+		res, err := h.allocator.Range(step.Start, step.End, aggregate)
+		if err != nil {
+			respondOpenCostError(w, openCostStatus(err), err)
+			return
+		}
+		sets = append(sets, opencost.AllocationSet(res, aggregate, step))
+	}
+	respondJSON(w, http.StatusOK, opencost.Response{Code: http.StatusOK, Data: sets})
+}
+
+func (h *OpenCostHandler) assets(w http.ResponseWriter, r *http.Request) {
+	steps, ok := h.steps(w, r)
+	if !ok {
+		return
+	}
+	aggregate, err := opencost.ParseAssetAggregate(r.URL.Query().Get("aggregate"))
+	if err != nil {
+		respondOpenCostError(w, http.StatusBadRequest, err)
+		return
+	}
+	sets := make([]map[string]opencost.Asset, 0, len(steps))
+	for _, step := range steps {
+		assets, err := h.allocator.Assets(step.Start, step.End)
+		if err != nil {
+			respondOpenCostError(w, openCostStatus(err), err)
+			return
+		}
+		sets = append(sets, opencost.AssetSet(assets, aggregate, step))
+	}
+	respondJSON(w, http.StatusOK, opencost.Response{Code: http.StatusOK, Data: sets})
+}
+
+// steps parses window, accumulate and step, writing the error response when
+// they are invalid.
+func (h *OpenCostHandler) steps(w http.ResponseWriter, r *http.Request) ([]opencost.Range, bool) {
+	q := r.URL.Query()
+	start, end, err := opencost.ParseWindow(q.Get("window"), h.now())
+	if err != nil {
+		respondOpenCostError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	steps, err := opencost.Steps(start, end, q.Get("accumulate"), q.Get("step"))
+	if err != nil {
+		respondOpenCostError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return steps, true
+}
+
+func openCostStatus(err error) int {
+	if errors.Is(err, allocation.ErrNoData) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+func respondOpenCostError(w http.ResponseWriter, status int, err error) {
+	respondJSON(w, status, opencost.Response{Code: status, Message: err.Error()})
+}
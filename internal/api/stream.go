@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+const (
+	streamModeDiff    = "diff"
+	streamModeSummary = "summary"
+)
+
+// StreamHandler pushes snapshot updates to clients over Server-Sent Events.
+// Slow clients never hold up the snapshot loop: the store coalesces updates
+// they have not consumed, and a write that stalls past writeTimeout ends the
+// stream.
+type StreamHandler struct {
+	store        *snapshot.Store
+	slots        chan struct{}
+	heartbeat    time.Duration
+	writeTimeout time.Duration
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStreamHandler builds a StreamHandler that serves at most maxClients
+// concurrent streams.
+func NewStreamHandler(store *snapshot.Store, maxClients int) *StreamHandler {
+	if maxClients <= 0 {
+		maxClients = 64
+	}
+	return &StreamHandler{
+		store:        store,
+		slots:        make(chan struct{}, maxClients),
+		heartbeat:    15 * time.Second,
+		writeTimeout: 10 * time.Second,
+		done:         make(chan struct{}),
+	}
+}
+
+// Register wires the stream endpoint on the mux.
+func (h *StreamHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/agent/v1/stream", h.stream)
+}
+
+// Close ends every open stream. http.Server.Shutdown waits for handlers to
+// return, so this must be registered with exporter.Server.RegisterOnShutdown.
+func (h *StreamHandler) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+func (h *StreamHandler) stream(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = streamModeDiff
+	}
+	if mode != streamModeDiff && mode != streamModeSummary {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("unknown stream mode %q", mode))
+		return
+	}
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		respondError(w, http.StatusServiceUnavailable, "too many stream clients")
+		return
+	}
+
+	sub := h.store.Subscribe()
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	var last snapshot.Snapshot
+	if snap, ok := h.store.Latest(); ok {
+		if err := h.send(w, rc, mode, nil, snap, 0); err != nil {
+			return
+		}
+		last = snap
+	} else if err := h.comment(w, rc, "waiting for first snapshot"); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-ticker.C:
+			if err := h.comment(w, rc, "keepalive"); err != nil {
+				return
+			}
+		case snap, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			if snap.Timestamp.Equal(last.Timestamp) && !last.Timestamp.IsZero() {
+				continue
+			}
+			var prev *snapshot.Snapshot
+			if !last.Timestamp.IsZero() {
+				prev = &last
+			}
+			if err := h.send(w, rc, mode, prev, snap, sub.Coalesced()); err != nil {
+				return
+			}
+			last = snap
+		}
+	}
+}
+
+func (h *StreamHandler) send(w http.ResponseWriter, rc *http.ResponseController, mode string, prev *snapshot.Snapshot, snap snapshot.Snapshot, coalesced uint64) error {
+	var payload any
+	event := streamModeSummary
+	if mode == streamModeSummary {
+		payload = summarize(snap)
+	} else {
+		event = streamModeDiff
+		payload = diffSnapshots(prev, snap, coalesced)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return h.write(w, rc, fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", snap.Timestamp.UnixNano(), event, data))
+}
+
+func (h *StreamHandler) comment(w http.ResponseWriter, rc *http.ResponseController, text string) error {
+	return h.write(w, rc, ": "+text+"\n\n")
+}
+
+func (h *StreamHandler) write(w http.ResponseWriter, rc *http.ResponseController, frame string) error {
+	if err := rc.SetWriteDeadline(time.Now().Add(h.writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := w.Write([]byte(frame)); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+func summarize(snap snapshot.Snapshot) StreamSummary {
+	return StreamSummary{
+		SchemaVersion:     snap.SchemaVersion,
+		Timestamp:         snap.Timestamp.UTC().Format(time.RFC3339Nano),
+		Namespaces:        len(snap.Namespaces),
+		Nodes:             len(snap.Nodes),
+		HourlyCost:        snap.Resources.TotalNodeHourlyCost,
+		NetworkEgressCost: snap.Resources.NetworkEgressCostTotal,
+	}
+}
+
+// diffSnapshots reports the namespace and node records that changed since
+// prev. A nil prev produces a full event carrying every record.
+func diffSnapshots(prev *snapshot.Snapshot, snap snapshot.Snapshot, coalesced uint64) StreamDiff {
+	diff := StreamDiff{
+		Summary:   summarize(snap),
+		Full:      prev == nil,
+		Coalesced: coalesced,
+	}
+	var prevNamespaces []snapshot.NamespaceCostRecord
+	var prevNodes []snapshot.NodeCostRecord
+	if prev != nil {
+		diff.PreviousTimestamp = prev.Timestamp.UTC().Format(time.RFC3339Nano)
+		prevNamespaces = prev.Namespaces
+		prevNodes = prev.Nodes
+	}
+	diff.Namespaces.Upserted, diff.Namespaces.Removed = diffRecords(prevNamespaces, snap.Namespaces, func(r snapshot.NamespaceCostRecord) string { return r.Namespace })
+	diff.Nodes.Upserted, diff.Nodes.Removed = diffRecords(prevNodes, snap.Nodes, func(r snapshot.NodeCostRecord) string { return r.NodeName })
+	return diff
+}
+
+func diffRecords[T any](prev, cur []T, key func(T) string) ([]T, []string) {
+	before := make(map[string]T, len(prev))
+	for _, rec := range prev {
+		before[key(rec)] = rec
+	}
+	upserted := []T{}
+	seen := make(map[string]struct{}, len(cur))
+	for _, rec := range cur {
+		k := key(rec)
+		seen[k] = struct{}{}
+		if old, ok := before[k]; ok && reflect.DeepEqual(old, rec) {
+			continue
+		}
+		upserted = append(upserted, rec)
+	}
+	removed := []string{}
+	for k := range before {
+		if _, ok := seen[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	return upserted, removed
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func TestStreamSendsFullThenDiff(t *testing.T) {
+	store := snapshot.NewStore()
+	first := fixtureSnapshot()
+	store.Update(first)
+
+	stream := NewStreamHandler(store, 1)
+	mux := http.NewServeMux()
+	stream.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer stream.Close()
+
+	resp, err := http.Get(srv.URL + "/agent/v1/stream")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %s", ct)
+	}
+	events := readEvents(resp)
+
+	initial := nextDiff(t, events)
+	if !initial.Full || len(initial.Namespaces.Upserted) != 1 || len(initial.Nodes.Upserted) != 1 {
+		t.Fatalf("unexpected initial event: %+v", initial)
+	}
+
+	// A second client is refused while the only slot is taken.
+	busy, err := http.Get(srv.URL + "/agent/v1/stream")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	busy.Body.Close()
+	if busy.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for extra client, got %d", busy.StatusCode)
+	}
+
+	second := fixtureSnapshot()
+	second.Timestamp = first.Timestamp.Add(time.Minute)
+	second.Namespaces[0].HourlyCost = 0.5
+	second.Nodes = nil
+	store.Update(second)
+
+	diff := nextDiff(t, events)
+	if diff.Full || diff.PreviousTimestamp != "2025-03-12T10:00:00Z" {
+		t.Fatalf("unexpected diff header: %+v", diff)
+	}
+	if len(diff.Namespaces.Upserted) != 1 || diff.Namespaces.Upserted[0].HourlyCost != 0.5 {
+		t.Fatalf("expected changed namespace, got %+v", diff.Namespaces)
+	}
+	if len(diff.Nodes.Upserted) != 0 || len(diff.Nodes.Removed) != 1 || diff.Nodes.Removed[0] != "node-a" {
+		t.Fatalf("expected removed node, got %+v", diff.Nodes)
+	}
+
+	stream.Close()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatalf("expected stream to end after Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("stream did not end after Close")
+	}
+}
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readEvents(resp *http.Response) <-chan sseEvent {
+	out := make(chan sseEvent)
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+		var ev sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && ev.name != "":
+				out <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+func nextDiff(t *testing.T, events <-chan sseEvent) StreamDiff {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatalf("stream ended early")
+		}
+		if ev.name != "diff" {
+			t.Fatalf("unexpected event %q", ev.name)
+		}
+		var diff StreamDiff
+		if err := json.Unmarshal([]byte(ev.data), &diff); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return diff
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return StreamDiff{}
+}
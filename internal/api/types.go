@@ -0,0 +1,184 @@
+package api
+
+import (
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/allocation"
+	"clustercost-agent-k8s/internal/anomaly"
+	"clustercost-agent-k8s/internal/budget"
+	"clustercost-agent-k8s/internal/forwarder"
+	"clustercost-agent-k8s/internal/health"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// OverviewResponse is returned by /agent/v1/overview.
+type OverviewResponse struct {
+	Status        string `json:"status"`
+	SchemaVersion string `json:"schemaVersion"`
+	ClusterType   string `json:"clusterType"`
+	ClusterName   string `json:"clusterName"`
+	ClusterRegion string `json:"clusterRegion"`
+	Version       string `json:"version"`
+	Timestamp     string `json:"timestamp"`
+}
+
+// HealthResponse is returned by /agent/v1/health.
+type HealthResponse struct {
+	Status        string `json:"status"`
+	ClusterType   string `json:"clusterType"`
+	ClusterName   string `json:"clusterName"`
+	ClusterRegion string `json:"clusterRegion"`
+	Version       string `json:"version"`
+	Timestamp     string `json:"timestamp"`
+	// Components is empty until the agent records its first component outcome.
+	Components []health.ComponentStatus `json:"components"`
+}
+
+// ReadyResponse is returned by /agent/v1/readyz once a snapshot exists.
+type ReadyResponse struct {
+	Status string `json:"status"`
+}
+
+// ErrorResponse is returned with every non-2xx status.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NamespacesResponse is returned by /agent/v1/namespaces.
+type NamespacesResponse struct {
+	Items     []snapshot.NamespaceCostRecord `json:"items"`
+	Timestamp string                         `json:"timestamp"`
+}
+
+// NodesResponse is returned by /agent/v1/nodes.
+type NodesResponse struct {
+	Items     []snapshot.NodeCostRecord `json:"items"`
+	Timestamp string                    `json:"timestamp"`
+}
+
+// ResourcesResponse is returned by /agent/v1/resources.
+type ResourcesResponse struct {
+	Snapshot  snapshot.ResourceSnapshot `json:"snapshot"`
+	Timestamp string                    `json:"timestamp"`
+}
+
+// NetworkResponse is returned by /agent/v1/network.
+type NetworkResponse struct {
+	Network   snapshot.NetworkSnapshot `json:"network"`
+	Timestamp string                   `json:"timestamp"`
+}
+
+// StreamSummary is the compact view of one snapshot sent on /agent/v1/stream.
+type StreamSummary struct {
+	SchemaVersion     string  `json:"schemaVersion"`
+	Timestamp         string  `json:"timestamp"`
+	Namespaces        int     `json:"namespaces"`
+	Nodes             int     `json:"nodes"`
+	HourlyCost        float64 `json:"hourlyCost"`
+	NetworkEgressCost float64 `json:"networkEgressCostHourly"`
+}
+
+// StreamDiff is the data of a diff event on /agent/v1/stream. Full is set on
+// the first event of a stream, which carries every record. Coalesced counts
+// snapshots the client missed because it was reading too slowly; the diff is
+// always against the last snapshot the client received.
+type StreamDiff struct {
+	Summary           StreamSummary `json:"summary"`
+	PreviousTimestamp string        `json:"previousTimestamp,omitempty"`
+	Full              bool          `json:"full"`
+	Coalesced         uint64        `json:"coalesced"`
+	Namespaces        NamespaceDiff `json:"namespaces"`
+	Nodes             NodeDiff      `json:"nodes"`
+}
+
+// NamespaceDiff lists changed and removed namespace records.
+type NamespaceDiff struct {
+	Upserted []snapshot.NamespaceCostRecord `json:"upserted"`
+	Removed  []string                       `json:"removed"`
+}
+
+// NodeDiff lists changed and removed node records.
+type NodeDiff struct {
+	Upserted []snapshot.NodeCostRecord `json:"upserted"`
+	Removed  []string                  `json:"removed"`
+}
+
+// AnomaliesResponse is returned by /agent/v1/anomalies, active anomalies
+// first and then resolved ones, newest first.
+type AnomaliesResponse struct {
+	Items     []anomaly.Anomaly `json:"items"`
+	Timestamp string            `json:"timestamp"`
+}
+
+// BudgetsResponse is returned by /agent/v1/budgets.
+type BudgetsResponse struct {
+	Items     []budget.Status `json:"items"`
+	Timestamp string          `json:"timestamp"`
+}
+
+// CostsResponse is returned by /agent/v1/costs and /agent/v1/costs/history.
+type CostsResponse struct {
+	Items     []accumulator.Period `json:"items"`
+	Timezone  string               `json:"timezone"`
+	Timestamp string               `json:"timestamp"`
+}
+
+// EnvironmentRule is a candidate classification rule posted to
+// /agent/v1/environments/dry-run. NamespaceRegex and annotation patterns
+// must match the whole value; Selector uses the label selector syntax.
+type EnvironmentRule struct {
+	Name           string            `json:"name"`
+	Environment    string            `json:"environment"`
+	NamespaceRegex string            `json:"namespaceRegex,omitempty"`
+	Selector       string            `json:"selector,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+}
+
+// EnvironmentDryRunRequest replaces the configured rules for one dry run.
+type EnvironmentDryRunRequest struct {
+	Rules []EnvironmentRule `json:"rules"`
+}
+
+// EnvironmentMatch is the classification of one namespace.
+type EnvironmentMatch struct {
+	Namespace string         `json:"namespace"`
+	Match     snapshot.Match `json:"match"`
+	// Current is the classification under the configured rules. It is only
+	// set when candidate rules were posted.
+	Current *snapshot.Match `json:"current,omitempty"`
+}
+
+// EnvironmentDryRunResponse is returned by /agent/v1/environments/dry-run,
+// sorted by namespace.
+type EnvironmentDryRunResponse struct {
+	Items     []EnvironmentMatch `json:"items"`
+	Timestamp string             `json:"timestamp"`
+}
+
+// AllocationResponse is returned by /agent/v1/allocation, most expensive
+// group first.
+type AllocationResponse struct {
+	Start     time.Time               `json:"start"`
+	End       time.Time               `json:"end"`
+	Aggregate []string                `json:"aggregate"`
+	Items     []allocation.Allocation `json:"items"`
+	Total     allocation.Allocation   `json:"total"`
+	Timestamp string                  `json:"timestamp"`
+}
+
+// IngestResponse is returned when a central agent accepts a report batch.
+type IngestResponse struct {
+	Accepted int `json:"accepted"`
+	// Duplicates counts reports whose ID was already recorded; they count
+	// as delivered.
+	Duplicates int `json:"duplicates,omitempty"`
+	// Nodes is the number of nodes in the merged view.
+	Nodes int `json:"nodes"`
+	// Resync lists nodes whose delta did not apply to the report held for
+	// them; they must send a keyframe next.
+	Resync []string `json:"resync,omitempty"`
+	// Rejected lists the reports that were not recorded and should be
+	// retried; every other report in the batch is delivered.
+	Rejected []forwarder.Rejection `json:"rejected,omitempty"`
+}
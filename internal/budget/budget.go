@@ -0,0 +1,298 @@
+// Package budget checks spend budgets against the accumulated calendar totals
+// and alerts webhooks when thresholds are crossed.
+package budget
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// State summarises how far a budget has been consumed.
+type State string
+
+const (
+	// StateOK means no threshold has been crossed.
+	StateOK State = "ok"
+	// StateWarning means a threshold below 100% has been crossed.
+	StateWarning State = "warning"
+	// StateExceeded means spend has reached the budget amount.
+	StateExceeded State = "exceeded"
+)
+
+// Scope selects the namespaces a budget covers. Set fields combine; an
+// empty scope covers the whole cluster, including unallocated node cost.
+type Scope struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Selector    string `json:"selector,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+// Status is a budget evaluated against the open period of its window.
+type Status struct {
+	Name        string    `json:"name"`
+	Scope       Scope     `json:"scope"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Amount      float64   `json:"amount"`
+	Spend       float64   `json:"spend"`
+	PercentUsed float64   `json:"percentUsed"`
+	Thresholds  []float64 `json:"thresholds"`
+	// CrossedThreshold is the highest threshold reached, or 0.
+	CrossedThreshold float64  `json:"crossedThreshold"`
+	State            State    `json:"state"`
+	Namespaces       []string `json:"namespaces"`
+	// LastNotified is when an alert for this period was last delivered.
+	LastNotified *time.Time `json:"lastNotified,omitempty"`
+}
+
+type definition struct {
+	cfg      config.BudgetConfig
+	window   accumulator.Window
+	selector labels.Selector
+}
+
+// Evaluator recomputes budget status after every snapshot and delivers
+// alerts from Run, so slow webhooks never hold up the snapshot loop.
+type Evaluator struct {
+	clusterName string
+	budgets     []definition
+	source      func() []config.BudgetConfig
+	costs       *accumulator.Accumulator
+	notifier    *notifier
+	logger      *slog.Logger
+
+	mu          sync.RWMutex
+	statuses    []Status
+	evaluatedAt time.Time
+	trigger     chan struct{}
+}
+
+// New validates the budget definitions in cfg and restores sent alerts from
+// cfg.StatePath.
+func New(cfg config.BudgetsConfig, clusterName string, costs *accumulator.Accumulator, logger *slog.Logger) (*Evaluator, error) {
+	budgets := make([]definition, 0, len(cfg.Definitions))
+	for _, b := range cfg.Definitions {
+		def, err := parseDefinition(b)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, def)
+	}
+	return &Evaluator{
+		clusterName: clusterName,
+		budgets:     budgets,
+		costs:       costs,
+		notifier:    newNotifier(cfg, logger),
+		logger:      logger,
+		trigger:     make(chan struct{}, 1),
+	}, nil
+}
+
+func parseDefinition(b config.BudgetConfig) (definition, error) {
+	window, err := accumulator.ParseWindow(b.Period)
+	if err != nil {
+		return definition{}, fmt.Errorf("budget %q: %w", b.Name, err)
+	}
+	def := definition{cfg: b, window: window}
+	if b.Selector != "" {
+		def.selector, err = labels.Parse(b.Selector)
+		if err != nil {
+			return definition{}, fmt.Errorf("budget %q selector: %w", b.Name, err)
+		}
+	}
+	def.cfg.Thresholds = append([]float64{}, b.Thresholds...)
+	sort.Float64s(def.cfg.Thresholds)
+	return def, nil
+}
+
+// SetSource adds budgets that can change at runtime, such as CostBudget
+// objects. source is called on every evaluation and its budgets follow the
+// configured ones; definitions that fail to parse are skipped.
+func (e *Evaluator) SetSource(source func() []config.BudgetConfig) {
+	if e == nil {
+		return
+	}
+	e.source = source
+}
+
+// Load restores the record of delivered alerts.
+func (e *Evaluator) Load() error {
+	if e == nil {
+		return nil
+	}
+	return e.notifier.load()
+}
+
+// Evaluate recomputes every budget from the accumulated totals. snap
+// supplies the labels and environment used to match namespaces; call it
+// after the accumulator has observed snap.
+func (e *Evaluator) Evaluate(snap snapshot.Snapshot) {
+	if e == nil {
+		return
+	}
+	byName := make(map[string]snapshot.NamespaceCostRecord, len(snap.Namespaces))
+	for _, ns := range snap.Namespaces {
+		byName[ns.Namespace] = ns
+	}
+	budgets := e.budgets
+	if e.source != nil {
+		budgets = append([]definition{}, budgets...)
+		for _, b := range e.source() {
+			def, err := parseDefinition(b)
+			if err != nil {
+				e.logger.Warn("skipping budget", slog.String("error", err.Error()))
+				continue
+			}
+			budgets = append(budgets, def)
+		}
+	}
+	statuses := make([]Status, 0, len(budgets))
+	for _, def := range budgets {
+		statuses = append(statuses, e.evaluate(def, byName))
+	}
+
+	e.mu.Lock()
+	e.statuses = statuses
+	e.evaluatedAt = snap.Timestamp
+	e.mu.Unlock()
+
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Evaluator) evaluate(def definition, namespaces map[string]snapshot.NamespaceCostRecord) Status {
+	b := def.cfg
+	status := Status{
+		Name:       b.Name,
+		Scope:      Scope{Namespace: b.Namespace, Selector: b.Selector, Environment: b.Environment},
+		Period:     b.Period,
+		Amount:     b.Amount,
+		Thresholds: b.Thresholds,
+		State:      StateOK,
+		Namespaces: []string{},
+	}
+	period, ok := e.costs.CurrentWindow(def.window)
+	if !ok {
+		return status
+	}
+	status.PeriodStart = period.Start
+	status.PeriodEnd = period.End
+
+	if status.Scope == (Scope{}) {
+		status.Spend = period.Cluster.TotalCost
+	} else {
+		for name, totals := range period.Namespaces {
+			if def.matches(name, namespaces) {
+				status.Spend += totals.TotalCost
+				status.Namespaces = append(status.Namespaces, name)
+			}
+		}
+		sort.Strings(status.Namespaces)
+	}
+
+	status.PercentUsed = math.Round(status.Spend/b.Amount*10000) / 100
+	for _, threshold := range b.Thresholds {
+		if status.PercentUsed >= threshold {
+			status.CrossedThreshold = threshold
+		}
+	}
+	switch {
+	case status.PercentUsed >= 100:
+		status.State = StateExceeded
+	case status.CrossedThreshold > 0:
+		status.State = StateWarning
+	}
+	return status
+}
+
+// matches reports whether the namespace falls in the budget scope. A
+// namespace that has since been deleted keeps counting towards a budget
+// scoped by name only, since its labels are no longer known.
+func (d definition) matches(name string, namespaces map[string]snapshot.NamespaceCostRecord) bool {
+	b := d.cfg
+	if b.Namespace != "" && b.Namespace != name {
+		return false
+	}
+	if d.selector == nil && b.Environment == "" {
+		return true
+	}
+	ns, ok := namespaces[name]
+	if !ok {
+		return false
+	}
+	if b.Environment != "" && ns.Environment != b.Environment {
+		return false
+	}
+	return d.selector == nil || d.selector.Matches(labels.Set(ns.Labels))
+}
+
+// Statuses returns the result of the last evaluation.
+func (e *Evaluator) Statuses() []Status {
+	if e == nil {
+		return nil
+	}
+	e.mu.RLock()
+	statuses := make([]Status, len(e.statuses))
+	copy(statuses, e.statuses)
+	e.mu.RUnlock()
+	for i := range statuses {
+		if sent, ok := e.notifier.lastSent(statuses[i].Name, statuses[i].PeriodStart); ok {
+			statuses[i].LastNotified = &sent
+		}
+	}
+	return statuses
+}
+
+// Run delivers alerts after each evaluation until ctx is done.
+func (e *Evaluator) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.trigger:
+			e.notify(ctx)
+		}
+	}
+}
+
+func (e *Evaluator) notify(ctx context.Context) {
+	e.mu.RLock()
+	statuses := e.statuses
+	now := e.evaluatedAt
+	e.mu.RUnlock()
+	for _, status := range statuses {
+		if status.CrossedThreshold == 0 {
+			continue
+		}
+		e.notifier.deliver(ctx, Alert{
+			Kind:        AlertKind,
+			ClusterName: e.clusterName,
+			Budget:      status.Name,
+			Scope:       status.Scope,
+			Period:      status.Period,
+			PeriodStart: status.PeriodStart,
+			PeriodEnd:   status.PeriodEnd,
+			Amount:      status.Amount,
+			Spend:       status.Spend,
+			PercentUsed: status.PercentUsed,
+			Threshold:   status.CrossedThreshold,
+			State:       status.State,
+			Namespaces:  status.Namespaces,
+			Timestamp:   now,
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func TestEvaluateScopes(t *testing.T) {
+	costs := accumulator.New(accumulator.Config{}, nil)
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	costs.Observe(testSnapshot(start))
+	snap := testSnapshot(start.Add(10 * time.Hour))
+	costs.Observe(snap)
+
+	evaluator := newTestEvaluator(t, config.BudgetsConfig{Definitions: []config.BudgetConfig{
+		{Name: "cluster", Period: "month", Amount: 60, Thresholds: []float64{50, 80, 100}},
+		{Name: "payments", Namespace: "payments", Period: "month", Amount: 100, Thresholds: []float64{50, 80, 100}},
+		{Name: "team-a", Selector: "team=a", Period: "day", Amount: 40, Thresholds: []float64{50, 80, 100}},
+		{Name: "staging", Environment: "nonprod", Period: "week", Amount: 1000, Thresholds: []float64{50, 80, 100}},
+	}}, costs)
+	evaluator.Evaluate(snap)
+
+	got := map[string]Status{}
+	for _, status := range evaluator.Statuses() {
+		got[status.Name] = status
+	}
+	// Ten hours at $5/h cluster, $3/h payments, $1/h checkout.
+	checks := []struct {
+		name      string
+		spend     float64
+		crossed   float64
+		state     State
+		namespace []string
+	}{
+		{name: "cluster", spend: 50, crossed: 80, state: StateWarning, namespace: []string{}},
+		{name: "payments", spend: 30, crossed: 0, state: StateOK, namespace: []string{"payments"}},
+		{name: "team-a", spend: 40, crossed: 100, state: StateExceeded, namespace: []string{"checkout", "payments"}},
+		{name: "staging", spend: 10, crossed: 0, state: StateOK, namespace: []string{"checkout"}},
+	}
+	for _, check := range checks {
+		status := got[check.name]
+		if math.Abs(status.Spend-check.spend) > 1e-9 {
+			t.Errorf("%s spend = %v, want %v", check.name, status.Spend, check.spend)
+		}
+		if status.CrossedThreshold != check.crossed || status.State != check.state {
+			t.Errorf("%s crossed %v state %s, want %v %s", check.name, status.CrossedThreshold, status.State, check.crossed, check.state)
+		}
+		if len(status.Namespaces) != len(check.namespace) {
+			t.Errorf("%s namespaces = %v, want %v", check.name, status.Namespaces, check.namespace)
+			continue
+		}
+		for i := range check.namespace {
+			if status.Namespaces[i] != check.namespace[i] {
+				t.Errorf("%s namespaces = %v, want %v", check.name, status.Namespaces, check.namespace)
+			}
+		}
+	}
+}
+
+func TestNotifyDeduplicatesAndRenotifies(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	statePath := filepath.Join(t.TempDir(), "budgets.json")
+	cfg := config.BudgetsConfig{
+		Definitions:      []config.BudgetConfig{{Name: "cluster", Period: "month", Amount: 200, Thresholds: []float64{50, 80, 100}}},
+		Webhooks:         []config.WebhookConfig{{URL: server.URL, Headers: map[string]string{"X-Token": "abc"}}},
+		RenotifyInterval: 6 * time.Hour,
+		StatePath:        statePath,
+	}
+	costs := accumulator.New(accumulator.Config{}, nil)
+	evaluator := newTestEvaluator(t, cfg, costs)
+
+	// $5/h crosses 50% after 20h and 80% after 32h.
+	start := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	step := func(hours int) {
+		snap := testSnapshot(start.Add(time.Duration(hours) * time.Hour))
+		costs.Observe(snap)
+		evaluator.Evaluate(snap)
+		evaluator.notify(context.Background())
+	}
+	step(0)
+	step(20)
+	step(21)
+	step(32)
+	step(33)
+	step(38)
+
+	want := []struct {
+		threshold float64
+		renotify  bool
+	}{{50, false}, {80, false}, {80, true}}
+	alerts := hook.received()
+	if len(alerts) != len(want) {
+		t.Fatalf("got %d alerts, want %d: %+v", len(alerts), len(want), alerts)
+	}
+	for i, w := range want {
+		if alerts[i].Threshold != w.threshold || alerts[i].Renotify != w.renotify {
+			t.Errorf("alert %d = threshold %v renotify %v, want %v %v", i, alerts[i].Threshold, alerts[i].Renotify, w.threshold, w.renotify)
+		}
+		if alerts[i].Kind != AlertKind || alerts[i].ClusterName != "prod" {
+			t.Errorf("alert %d has kind %q cluster %q", i, alerts[i].Kind, alerts[i].ClusterName)
+		}
+	}
+	if hook.header != "abc" {
+		t.Errorf("webhook header not sent")
+	}
+	if status := evaluator.Statuses()[0]; status.LastNotified == nil || !status.LastNotified.Equal(start.Add(38*time.Hour)) {
+		t.Errorf("last notified = %v", status.LastNotified)
+	}
+
+	// A restarted agent remembers what was sent.
+	restored := newTestEvaluator(t, cfg, costs)
+	if err := restored.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	snap := testSnapshot(start.Add(39 * time.Hour))
+	costs.Observe(snap)
+	restored.Evaluate(snap)
+	restored.notify(context.Background())
+	if got := len(hook.received()); got != len(want) {
+		t.Fatalf("restored evaluator re-sent alerts: %d", got)
+	}
+}
+
+func TestNotifyRetriesFailedDelivery(t *testing.T) {
+	hook := &webhook{status: http.StatusBadGateway}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	costs := accumulator.New(accumulator.Config{}, nil)
+	evaluator := newTestEvaluator(t, config.BudgetsConfig{
+		Definitions: []config.BudgetConfig{{Name: "cluster", Period: "day", Amount: 1, Thresholds: []float64{100}}},
+		Webhooks:    []config.WebhookConfig{{URL: server.URL}},
+	}, costs)
+	start := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	costs.Observe(testSnapshot(start))
+	snap := testSnapshot(start.Add(time.Hour))
+	costs.Observe(snap)
+	evaluator.Evaluate(snap)
+
+	evaluator.notify(context.Background())
+	hook.mu.Lock()
+	hook.status = http.StatusOK
+	hook.mu.Unlock()
+	evaluator.notify(context.Background())
+	evaluator.notify(context.Background())
+	if got := len(hook.received()); got != 1 {
+		t.Fatalf("expected one delivered alert after retry, got %d", got)
+	}
+	if hook.attempts != 2 {
+		t.Fatalf("expected a failed attempt and a retry, got %d attempts", hook.attempts)
+	}
+}
+
+func newTestEvaluator(t *testing.T, cfg config.BudgetsConfig, costs *accumulator.Accumulator) *Evaluator {
+	t.Helper()
+	evaluator, err := New(cfg, "prod", costs, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return evaluator
+}
+
+func testSnapshot(ts time.Time) snapshot.Snapshot {
+	return snapshot.Snapshot{
+		Timestamp: ts,
+		Namespaces: []snapshot.NamespaceCostRecord{
+			{Namespace: "payments", HourlyCost: 3, Environment: "production", Labels: map[string]string{"team": "a"}},
+			{Namespace: "checkout", HourlyCost: 1, Environment: "nonprod", Labels: map[string]string{"team": "a"}},
+		},
+		Resources: snapshot.ResourceSnapshot{TotalNodeHourlyCost: 5},
+	}
+}
+
+// webhook records alerts and answers with status (200 when unset).
+type webhook struct {
+	mu       sync.Mutex
+	status   int
+	attempts int
+	header   string
+	alerts   []Alert
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts++
+	if h.status != 0 && h.status != http.StatusOK {
+		w.WriteHeader(h.status)
+		return
+	}
+	var alert Alert
+	if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.header = r.Header.Get("X-Token")
+	h.alerts = append(h.alerts, alert)
+}
+
+func (h *webhook) received() []Alert {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Alert{}, h.alerts...)
+}
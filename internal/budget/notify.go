@@ -0,0 +1,209 @@
+package budget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+)
+
+// AlertKind identifies budget alerts to webhook receivers.
+const AlertKind = "budget.threshold"
+
+const stateVersion = 1
+
+// Alert is the JSON body posted to budget webhooks.
+type Alert struct {
+	Kind        string    `json:"kind"`
+	ClusterName string    `json:"clusterName"`
+	Budget      string    `json:"budget"`
+	Scope       Scope     `json:"scope"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Amount      float64   `json:"amount"`
+	Spend       float64   `json:"spend"`
+	PercentUsed float64   `json:"percentUsed"`
+	Threshold   float64   `json:"threshold"`
+	State       State     `json:"state"`
+	Namespaces  []string  `json:"namespaces"`
+	// Renotify is set when the threshold was already reported this period.
+	Renotify  bool      `json:"renotify"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notice records the last alert delivered to one webhook for one budget.
+type notice struct {
+	PeriodStart time.Time `json:"periodStart"`
+	Threshold   float64   `json:"threshold"`
+	SentAt      time.Time `json:"sentAt"`
+}
+
+type state struct {
+	Version int                          `json:"version"`
+	Notices map[string]map[string]notice `json:"notices"`
+}
+
+// notifier delivers alerts and deduplicates them per budget and webhook: an
+// alert goes out when a higher threshold is crossed, or again after the
+// renotify interval, and the record resets when a new period opens.
+type notifier struct {
+	webhooks  []config.WebhookConfig
+	client    *http.Client
+	renotify  time.Duration
+	statePath string
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	notices map[string]map[string]notice
+}
+
+func newNotifier(cfg config.BudgetsConfig, logger *slog.Logger) *notifier {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &notifier{
+		webhooks:  cfg.Webhooks,
+		client:    &http.Client{Timeout: timeout},
+		renotify:  cfg.RenotifyInterval,
+		statePath: cfg.StatePath,
+		logger:    logger,
+		notices:   map[string]map[string]notice{},
+	}
+}
+
+func (n *notifier) deliver(ctx context.Context, alert Alert) {
+	changed := false
+	for _, hook := range n.webhooks {
+		n.mu.Lock()
+		last, ok := n.notices[alert.Budget][hook.URL]
+		n.mu.Unlock()
+		if ok && !last.PeriodStart.Equal(alert.PeriodStart) {
+			ok = false
+		}
+		out := alert
+		switch {
+		case !ok || alert.Threshold > last.Threshold:
+		case alert.Threshold == last.Threshold && n.renotify > 0 && !alert.Timestamp.Before(last.SentAt.Add(n.renotify)):
+			out.Renotify = true
+		default:
+			continue
+		}
+		if err := n.post(ctx, hook, out); err != nil {
+			n.logger.Warn("budget alert delivery failed",
+				slog.String("budget", alert.Budget),
+				slog.String("webhook", hook.URL),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		n.mu.Lock()
+		if n.notices[alert.Budget] == nil {
+			n.notices[alert.Budget] = map[string]notice{}
+		}
+		n.notices[alert.Budget][hook.URL] = notice{PeriodStart: alert.PeriodStart, Threshold: alert.Threshold, SentAt: alert.Timestamp}
+		n.mu.Unlock()
+		changed = true
+	}
+	if changed {
+		if err := n.save(); err != nil {
+			n.logger.Warn("budget state checkpoint failed", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (n *notifier) post(ctx context.Context, hook config.WebhookConfig, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("encode alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send alert: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// lastSent returns the latest delivery for budget within the period.
+func (n *notifier) lastSent(budget string, periodStart time.Time) (time.Time, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var latest time.Time
+	for _, last := range n.notices[budget] {
+		if last.PeriodStart.Equal(periodStart) && last.SentAt.After(latest) {
+			latest = last.SentAt
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+func (n *notifier) load() error {
+	if n.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(n.statePath) // #nosec G304 -- path provided by cluster operator
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read budget state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("decode budget state: %w", err)
+	}
+	if st.Version != stateVersion {
+		return fmt.Errorf("unsupported budget state version %d", st.Version)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if st.Notices != nil {
+		n.notices = st.Notices
+	}
+	return nil
+}
+
+func (n *notifier) save() error {
+	if n.statePath == "" {
+		return nil
+	}
+	n.mu.Lock()
+	data, err := json.Marshal(state{Version: stateVersion, Notices: n.notices})
+	n.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshal budget state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(n.statePath), 0o750); err != nil {
+		return fmt.Errorf("create budget state dir: %w", err)
+	}
+	tmpPath := n.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write budget state: %w", err)
+	}
+	if err := os.Rename(tmpPath, n.statePath); err != nil {
+		return fmt.Errorf("commit budget state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,584 @@
+// Package central keeps the latest report forwarded by each node agent and
+// merges them into one cluster-wide snapshot.
+package central
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/forwarder"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// ErrRejected wraps the reasons a report is not accepted.
+var ErrRejected = errors.New("report rejected")
+
+// Config configures an Aggregator.
+type Config struct {
+	// ClusterID is the only cluster whose reports are accepted.
+	ClusterID string
+	// NodeTTL drops a node from the merged view when its latest report is
+	// older than this.
+	NodeTTL time.Duration
+	// DedupeWindow is how long recorded report IDs and batch idempotency
+	// keys are remembered. Zero means an hour.
+	DedupeWindow time.Duration
+}
+
+// Aggregator holds the latest report per node, and the network amounts of
+// every report accepted since the last merge.
+type Aggregator struct {
+	mu      sync.Mutex
+	cfg     Config
+	reports map[string]forwarder.AgentReport
+	traffic map[string]*traffic
+	changed bool
+	seen    map[string]time.Time
+	batches map[string]batchResult
+}
+
+type batchResult struct {
+	result Result
+	at     time.Time
+}
+
+// Result is what Accept did with each report of a batch.
+type Result struct {
+	// Accepted counts the reports that were recorded. Deltas of nodes
+	// listed in Resync are not.
+	Accepted int
+	// Duplicates counts reports whose ID was already recorded.
+	Duplicates int
+	// Resync lists the nodes whose delta did not apply to the report held
+	// for them, so the agent must send a keyframe.
+	Resync []string
+	// Rejected lists the invalid reports, which were not recorded.
+	Rejected []forwarder.Rejection
+}
+
+// Err reports ErrRejected when reports were sent and all were rejected.
+func (r Result) Err() error {
+	if len(r.Rejected) == 0 || r.Accepted > 0 || r.Duplicates > 0 {
+		return nil
+	}
+	first := r.Rejected[0]
+	return fmt.Errorf("%w: reports[%d]: %s", ErrRejected, first.Index, first.Error)
+}
+
+// New returns an empty Aggregator.
+func New(cfg Config) *Aggregator {
+	if cfg.DedupeWindow <= 0 {
+		cfg.DedupeWindow = time.Hour
+	}
+	return &Aggregator{
+		cfg:     cfg,
+		reports: map[string]forwarder.AgentReport{},
+		traffic: map[string]*traffic{},
+		seen:    map[string]time.Time{},
+		batches: map[string]batchResult{},
+	}
+}
+
+// Accept records every valid report and lists the others in the result,
+// so the agent retries only those. A report older than the one already
+// held for its node is valid but does not replace it; its network amounts
+// are still booked, like those of every accepted report. A report whose ID
+// was recorded within DedupeWindow is counted as a duplicate and skipped.
+//
+// key is the batch's Idempotency-Key. A batch whose key was seen within
+// DedupeWindow gets the first answer again without being applied, unless
+// that answer asked for a resync.
+//
+// Deltas are applied, in batch order, to the report held for their node.
+// When that is not the report a delta was encoded against, the delta is
+// skipped and its node returned in Resync, so the agent sends a keyframe.
+func (a *Aggregator) Accept(key string, reports []forwarder.AgentReport) Result {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cached, ok := a.batches[key]; ok && key != "" && now.Sub(cached.at) < a.cfg.DedupeWindow {
+		return cached.result
+	}
+
+	var result Result
+	gaps := map[string]bool{}
+	for i, report := range reports {
+		if err := a.validate(report); err != nil {
+			result.Rejected = append(result.Rejected, forwarder.Rejection{Index: i, ID: report.ID, Error: err.Error()})
+			continue
+		}
+		if at, ok := a.seen[report.ID]; ok && report.ID != "" && now.Sub(at) < a.cfg.DedupeWindow {
+			result.Duplicates++
+			continue
+		}
+		held, ok := a.reports[report.NodeName]
+		if report.Delta != nil {
+			full, err := forwarder.Apply(held, report)
+			if err != nil {
+				gaps[report.NodeName] = true
+				continue
+			}
+			report = full
+		}
+		result.Accepted++
+		if report.ID != "" {
+			a.seen[report.ID] = now
+		}
+		booked := a.traffic[report.NodeName]
+		if booked == nil {
+			booked = newTraffic(a.cfg.ClusterID)
+			a.traffic[report.NodeName] = booked
+		}
+		booked.add(report.Snapshot)
+		a.changed = true
+		if ok && held.Timestamp.After(report.Timestamp) {
+			continue
+		}
+		a.reports[report.NodeName] = report
+		delete(gaps, report.NodeName)
+	}
+	for node := range gaps {
+		result.Resync = append(result.Resync, node)
+	}
+	sort.Strings(result.Resync)
+	if key != "" && len(result.Resync) == 0 {
+		a.batches[key] = batchResult{result: result, at: now}
+	}
+	return result
+}
+
+func (a *Aggregator) validate(report forwarder.AgentReport) error {
+	if !sameMajor(report.SchemaVersion, forwarder.SchemaVersion) {
+		return fmt.Errorf("unsupported schema version %q", report.SchemaVersion)
+	}
+	if report.ClusterID != a.cfg.ClusterID {
+		return fmt.Errorf("cluster %q is not %q", report.ClusterID, a.cfg.ClusterID)
+	}
+	if report.Timestamp.IsZero() {
+		return errors.New("timestamp is required")
+	}
+	if report.Delta != nil && report.Sequence <= report.Delta.BaseSequence {
+		return errors.New("delta sequence must follow its base")
+	}
+	return nil
+}
+
+func sameMajor(version, want string) bool {
+	major, _, _ := strings.Cut(version, ".")
+	wantMajor, _, _ := strings.Cut(want, ".")
+	return major != "" && major == wantMajor
+}
+
+// Nodes returns the number of nodes with a report held.
+func (a *Aggregator) Nodes() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.reports)
+}
+
+// Snapshot forgets nodes that have not reported within NodeTTL, and IDs
+// and keys older than DedupeWindow, and merges the remaining nodes. The
+// merged network amounts are those of the reports accepted since the
+// previous call, so each is published once. It reports false while no node
+// is left, or when no report arrived and no node expired since the
+// previous call.
+func (a *Aggregator) Snapshot(now time.Time) (snapshot.Snapshot, bool) {
+	a.mu.Lock()
+	for id, at := range a.seen {
+		if now.Sub(at) >= a.cfg.DedupeWindow {
+			delete(a.seen, id)
+		}
+	}
+	for key, batch := range a.batches {
+		if now.Sub(batch.at) >= a.cfg.DedupeWindow {
+			delete(a.batches, key)
+		}
+	}
+	changed := a.changed
+	reports := make([]forwarder.AgentReport, 0, len(a.reports))
+	for node, report := range a.reports {
+		if a.cfg.NodeTTL > 0 && now.Sub(report.Timestamp) > a.cfg.NodeTTL {
+			delete(a.reports, node)
+			changed = true
+			continue
+		}
+		report.Snapshot = a.traffic[node].apply(a.cfg.ClusterID, report.Snapshot)
+		reports = append(reports, report)
+	}
+	a.traffic = map[string]*traffic{}
+	a.changed = false
+	a.mu.Unlock()
+	if len(reports) == 0 || !changed {
+		return snapshot.Snapshot{}, false
+	}
+	return Merge(a.cfg.ClusterID, reports, now), true
+}
+
+// Merge combines node reports into one snapshot. Nodes and pods are keyed
+// by name, and the newest report wins when several carry the same object.
+// Network amounts are per-interval, so they are summed.
+// Namespace records are per-node shares of the namespace, so their costs,
+// counts and usage are summed while labels and dimensions come from the
+// newest report. Cluster totals are recomputed from the merged records.
+//
+// When a cluster scope report is present, the newest one supplies every
+// cost record and the other reports only contribute pod usage and network
+// traffic, so cluster-level cost is never counted twice.
+func Merge(clusterID string, reports []forwarder.AgentReport, at time.Time) snapshot.Snapshot {
+	ordered := append([]forwarder.AgentReport{}, reports...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Timestamp.Before(ordered[j].Timestamp)
+	})
+	leader := -1
+	for i, report := range ordered {
+		if report.Scope == forwarder.ScopeCluster {
+			leader = i
+		}
+	}
+
+	m := newMerger(clusterID)
+	if leader < 0 {
+		for _, report := range ordered {
+			m.addCost(report.Snapshot)
+			m.addNetwork(report.Snapshot)
+		}
+		return m.snapshot(at)
+	}
+	m.addCost(ordered[leader].Snapshot)
+	for i, report := range ordered {
+		if i == leader || report.Scope == forwarder.ScopeCluster {
+			continue
+		}
+		m.addUsage(report.Snapshot)
+		m.addNetwork(report.Snapshot)
+	}
+	m.recomputeUsage()
+	return m.snapshot(at)
+}
+
+type merger struct {
+	clusterID   string
+	nodes       map[string]snapshot.NodeCostRecord
+	pods        map[string]snapshot.PodCostRecord
+	namespaces  map[string]*snapshot.NamespaceCostRecord
+	podNetwork  map[string]snapshot.PodNetworkRecord
+	nsNetwork   map[string]*snapshot.NamespaceNetworkRecord
+	byClass     map[string]*snapshot.NetworkClassTotals
+	connections [4]map[connectionKey]*snapshot.NetworkConnection
+}
+
+func newMerger(clusterID string) *merger {
+	return &merger{
+		clusterID:   clusterID,
+		nodes:       map[string]snapshot.NodeCostRecord{},
+		pods:        map[string]snapshot.PodCostRecord{},
+		namespaces:  map[string]*snapshot.NamespaceCostRecord{},
+		podNetwork:  map[string]snapshot.PodNetworkRecord{},
+		nsNetwork:   map[string]*snapshot.NamespaceNetworkRecord{},
+		byClass:     map[string]*snapshot.NetworkClassTotals{},
+		connections: [4]map[connectionKey]*snapshot.NetworkConnection{{}, {}, {}, {}},
+	}
+}
+
+func (m *merger) addCost(snap snapshot.Snapshot) {
+	for _, node := range snap.Nodes {
+		node.ClusterID = m.clusterID
+		m.nodes[node.NodeName] = node
+	}
+	for _, pod := range snap.Pods {
+		m.pods[pod.Namespace+"/"+pod.Pod] = pod
+	}
+	for _, ns := range snap.Namespaces {
+		mergeNamespace(m.namespaces, m.clusterID, ns)
+	}
+}
+
+// addUsage copies measured usage onto pods already known from a cost report.
+func (m *merger) addUsage(snap snapshot.Snapshot) {
+	for _, usage := range snap.Pods {
+		key := usage.Namespace + "/" + usage.Pod
+		pod, ok := m.pods[key]
+		if !ok {
+			continue
+		}
+		pod.CPUUsageMilli = usage.CPUUsageMilli
+		pod.MemoryUsageBytes = usage.MemoryUsageBytes
+		m.pods[key] = pod
+	}
+}
+
+func (m *merger) addNetwork(snap snapshot.Snapshot) {
+	for _, pod := range snap.Network.Pods {
+		key := pod.Namespace + "/" + pod.Pod
+		if held, ok := m.podNetwork[key]; ok {
+			pod.TxBytes += held.TxBytes
+			pod.RxBytes += held.RxBytes
+			pod.EgressCostHourly += held.EgressCostHourly
+			pod.ByClass = addClasses(held.ByClass, pod.ByClass)
+		}
+		m.podNetwork[key] = pod
+	}
+	for _, ns := range snap.Network.Namespaces {
+		rec := m.nsNetwork[ns.Namespace]
+		if rec == nil {
+			rec = &snapshot.NamespaceNetworkRecord{Namespace: ns.Namespace}
+			m.nsNetwork[ns.Namespace] = rec
+		}
+		rec.TxBytes += ns.TxBytes
+		rec.RxBytes += ns.RxBytes
+		rec.EgressCostHourly += ns.EgressCostHourly
+		rec.ByClass = addClasses(rec.ByClass, ns.ByClass)
+	}
+	for _, class := range snap.Network.ByClass {
+		addClass(m.byClass, class)
+	}
+	for i, list := range [4][]snapshot.NetworkConnection{
+		snap.Network.PodConnections,
+		snap.Network.WorkloadConnections,
+		snap.Network.NamespaceConnections,
+		snap.Network.ServiceConnections,
+	} {
+		for _, conn := range list {
+			addConnection(m.connections[i], conn)
+		}
+	}
+}
+
+// recomputeUsage rebuilds the namespace and node usage that a cost report
+// without eBPF could only estimate, from the pod usage and traffic merged
+// in from node reports.
+func (m *merger) recomputeUsage() {
+	type nodeUsage struct{ cpu, memory int64 }
+	nodes := map[string]*nodeUsage{}
+	for _, ns := range m.namespaces {
+		ns.CPUUsageMilli, ns.MemoryUsageBytes = 0, 0
+		ns.NetworkTxBytes, ns.NetworkRxBytes, ns.NetworkEgressCost = 0, 0, 0
+		if traffic := m.nsNetwork[ns.Namespace]; traffic != nil {
+			ns.NetworkTxBytes = traffic.TxBytes
+			ns.NetworkRxBytes = traffic.RxBytes
+			ns.NetworkEgressCost = traffic.EgressCostHourly
+		}
+	}
+	for _, pod := range m.pods {
+		if ns := m.namespaces[pod.Namespace]; ns != nil {
+			ns.CPUUsageMilli += pod.CPUUsageMilli
+			ns.MemoryUsageBytes += pod.MemoryUsageBytes
+		}
+		u := nodes[pod.Node]
+		if u == nil {
+			u = &nodeUsage{}
+			nodes[pod.Node] = u
+		}
+		u.cpu += pod.CPUUsageMilli
+		u.memory += pod.MemoryUsageBytes
+	}
+	for name, node := range m.nodes {
+		u := nodes[name]
+		if u == nil {
+			u = &nodeUsage{}
+		}
+		if node.CPUAllocatableMilli > 0 {
+			node.CPUUsagePercent = math.Min(100, float64(u.cpu)/float64(node.CPUAllocatableMilli)*100)
+		}
+		if node.MemoryAllocatableBytes > 0 {
+			node.MemoryUsagePercent = math.Min(100, float64(u.memory)/float64(node.MemoryAllocatableBytes)*100)
+		}
+		m.nodes[name] = node
+	}
+}
+
+func (m *merger) snapshot(at time.Time) snapshot.Snapshot {
+	out := snapshot.Snapshot{
+		SchemaVersion: snapshot.SchemaVersion,
+		Timestamp:     at.UTC(),
+		Namespaces:    make([]snapshot.NamespaceCostRecord, 0, len(m.namespaces)),
+		Pods:          make([]snapshot.PodCostRecord, 0, len(m.pods)),
+		Nodes:         make([]snapshot.NodeCostRecord, 0, len(m.nodes)),
+		Resources:     snapshot.ResourceSnapshot{ClusterID: m.clusterID},
+	}
+	for _, node := range m.nodes {
+		out.Nodes = append(out.Nodes, node)
+		out.Resources.TotalNodeHourlyCost += node.HourlyCost
+	}
+	sort.Slice(out.Nodes, func(i, j int) bool { return out.Nodes[i].NodeName < out.Nodes[j].NodeName })
+
+	for _, pod := range m.pods {
+		out.Pods = append(out.Pods, pod)
+		out.Resources.CPUUsageMilliTotal += pod.CPUUsageMilli
+		out.Resources.CPURequestMilliTotal += pod.CPURequestMilli
+		out.Resources.MemoryUsageBytesTotal += pod.MemoryUsageBytes
+		out.Resources.MemoryRequestBytesTotal += pod.MemoryRequestBytes
+	}
+	sort.Slice(out.Pods, func(i, j int) bool {
+		if out.Pods[i].Namespace != out.Pods[j].Namespace {
+			return out.Pods[i].Namespace < out.Pods[j].Namespace
+		}
+		return out.Pods[i].Pod < out.Pods[j].Pod
+	})
+
+	for _, ns := range m.namespaces {
+		out.Namespaces = append(out.Namespaces, *ns)
+	}
+	sort.Slice(out.Namespaces, func(i, j int) bool { return out.Namespaces[i].Namespace < out.Namespaces[j].Namespace })
+
+	out.Network = m.network()
+	out.Resources.NetworkTxBytesTotal = out.Network.TxBytes
+	out.Resources.NetworkRxBytesTotal = out.Network.RxBytes
+	out.Resources.NetworkEgressCostTotal = out.Network.EgressCost
+	return out
+}
+
+func (m *merger) network() snapshot.NetworkSnapshot {
+	out := snapshot.NetworkSnapshot{ClusterID: m.clusterID}
+	for _, pod := range m.podNetwork {
+		out.Pods = append(out.Pods, pod)
+		out.TxBytes += pod.TxBytes
+		out.RxBytes += pod.RxBytes
+		out.EgressCost += pod.EgressCostHourly
+	}
+	sort.Slice(out.Pods, func(i, j int) bool {
+		if out.Pods[i].Namespace != out.Pods[j].Namespace {
+			return out.Pods[i].Namespace < out.Pods[j].Namespace
+		}
+		return out.Pods[i].Pod < out.Pods[j].Pod
+	})
+
+	for _, ns := range m.nsNetwork {
+		out.Namespaces = append(out.Namespaces, *ns)
+	}
+	sort.Slice(out.Namespaces, func(i, j int) bool {
+		return out.Namespaces[i].Namespace < out.Namespaces[j].Namespace
+	})
+	out.ByClass = flattenClasses(m.byClass)
+	out.PodConnections = flattenConnections(m.connections[0])
+	out.WorkloadConnections = flattenConnections(m.connections[1])
+	out.NamespaceConnections = flattenConnections(m.connections[2])
+	out.ServiceConnections = flattenConnections(m.connections[3])
+	return out
+}
+
+func mergeNamespace(target map[string]*snapshot.NamespaceCostRecord, clusterID string, ns snapshot.NamespaceCostRecord) {
+	rec := target[ns.Namespace]
+	if rec == nil {
+		ns.ClusterID = clusterID
+		ns.SharedCosts = append([]snapshot.SharedCost(nil), ns.SharedCosts...)
+		target[ns.Namespace] = &ns
+		return
+	}
+	rec.Labels = ns.Labels
+	rec.Environment = ns.Environment
+	rec.Attribution = ns.Attribution
+	rec.Dimensions = ns.Dimensions
+	rec.HourlyCost += ns.HourlyCost
+	rec.PodCount += ns.PodCount
+	rec.CPURequestMilli += ns.CPURequestMilli
+	rec.MemoryRequestBytes += ns.MemoryRequestBytes
+	rec.CPUUsageMilli += ns.CPUUsageMilli
+	rec.MemoryUsageBytes += ns.MemoryUsageBytes
+	rec.NetworkTxBytes += ns.NetworkTxBytes
+	rec.NetworkRxBytes += ns.NetworkRxBytes
+	rec.NetworkEgressCost += ns.NetworkEgressCost
+	rec.SharedCostHourly += ns.SharedCostHourly
+	rec.DistributedCostHourly += ns.DistributedCostHourly
+	for _, shared := range ns.SharedCosts {
+		found := false
+		for i := range rec.SharedCosts {
+			if rec.SharedCosts[i].Namespace == shared.Namespace && rec.SharedCosts[i].Policy == shared.Policy {
+				rec.SharedCosts[i].HourlyCost += shared.HourlyCost
+				found = true
+				break
+			}
+		}
+		if !found {
+			rec.SharedCosts = append(rec.SharedCosts, shared)
+		}
+	}
+}
+
+func addClass(target map[string]*snapshot.NetworkClassTotals, class snapshot.NetworkClassTotals) {
+	t := target[class.Class]
+	if t == nil {
+		t = &snapshot.NetworkClassTotals{Class: class.Class}
+		target[class.Class] = t
+	}
+	t.TxBytes += class.TxBytes
+	t.RxBytes += class.RxBytes
+	t.EgressCostHourly += class.EgressCostHourly
+}
+
+func addClasses(into, from []snapshot.NetworkClassTotals) []snapshot.NetworkClassTotals {
+	totals := map[string]*snapshot.NetworkClassTotals{}
+	for _, class := range into {
+		addClass(totals, class)
+	}
+	for _, class := range from {
+		addClass(totals, class)
+	}
+	return flattenClasses(totals)
+}
+
+func flattenClasses(totals map[string]*snapshot.NetworkClassTotals) []snapshot.NetworkClassTotals {
+	if len(totals) == 0 {
+		return nil
+	}
+	out := make([]snapshot.NetworkClassTotals, 0, len(totals))
+	for _, t := range totals {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Class < out[j].Class })
+	return out
+}
+
+type connectionKey struct {
+	source      snapshot.NetworkEndpoint
+	destination snapshot.NetworkEndpoint
+	class       string
+}
+
+func addConnection(target map[connectionKey]*snapshot.NetworkConnection, conn snapshot.NetworkConnection) {
+	key := connectionKey{source: conn.Source, destination: conn.Destination, class: conn.Class}
+	existing := target[key]
+	if existing == nil {
+		target[key] = &conn
+		return
+	}
+	existing.TxBytes += conn.TxBytes
+	existing.RxBytes += conn.RxBytes
+	existing.EgressCostHourly += conn.EgressCostHourly
+}
+
+func flattenConnections(target map[connectionKey]*snapshot.NetworkConnection) []snapshot.NetworkConnection {
+	if len(target) == 0 {
+		return nil
+	}
+	out := make([]snapshot.NetworkConnection, 0, len(target))
+	for _, conn := range target {
+		out = append(out, *conn)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Source != b.Source {
+			return endpointLess(a.Source, b.Source)
+		}
+		if a.Destination != b.Destination {
+			return endpointLess(a.Destination, b.Destination)
+		}
+		return a.Class < b.Class
+	})
+	return out
+}
+
+func endpointLess(a, b snapshot.NetworkEndpoint) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
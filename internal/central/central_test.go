@@ -0,0 +1,312 @@
+package central
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/forwarder"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func nodeReport(node string, at time.Time, nsCost float64) forwarder.AgentReport {
+	return forwarder.AgentReport{
+		SchemaVersion: forwarder.SchemaVersion,
+		ClusterID:     "prod",
+		NodeName:      node,
+		Timestamp:     at,
+		Snapshot: snapshot.Snapshot{
+			Nodes: []snapshot.NodeCostRecord{{NodeName: node, HourlyCost: 1}},
+			Pods: []snapshot.PodCostRecord{
+				{Namespace: "shop", Pod: "web-" + node, Node: node, HourlyCost: nsCost, CPURequestMilli: 100},
+			},
+			Namespaces: []snapshot.NamespaceCostRecord{
+				{Namespace: "shop", HourlyCost: nsCost, PodCount: 1, CPURequestMilli: 100, Labels: map[string]string{"node": node}},
+				{Namespace: "idle", Labels: map[string]string{"node": node}},
+			},
+			Network: snapshot.NetworkSnapshot{
+				Pods: []snapshot.PodNetworkRecord{{Namespace: "shop", Pod: "web-" + node, TxBytes: 10, EgressCostHourly: 0.01}},
+				NamespaceConnections: []snapshot.NetworkConnection{{
+					Source:      snapshot.NetworkEndpoint{Kind: "namespace", Name: "shop"},
+					Destination: snapshot.NetworkEndpoint{Kind: "external", Name: "internet"},
+					Class:       "internet",
+					TxBytes:     10,
+				}},
+			},
+		},
+	}
+}
+
+func TestMergeDeduplicatesAndSums(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := nodeReport("a", at, 0.4)
+	b := nodeReport("b", at.Add(time.Second), 0.6)
+	// A cluster-wide record of node a also arrives with b's report.
+	b.Snapshot.Nodes = append(b.Snapshot.Nodes, snapshot.NodeCostRecord{NodeName: "a", HourlyCost: 1})
+
+	snap := Merge("prod", []forwarder.AgentReport{b, a}, at)
+
+	if len(snap.Nodes) != 2 || snap.Resources.TotalNodeHourlyCost != 2 {
+		t.Fatalf("expected two nodes costing 2, got %+v", snap.Nodes)
+	}
+	if len(snap.Pods) != 2 || snap.Resources.CPURequestMilliTotal != 200 {
+		t.Fatalf("unexpected pods %+v", snap.Pods)
+	}
+	if len(snap.Namespaces) != 2 {
+		t.Fatalf("expected namespaces to be deduplicated, got %+v", snap.Namespaces)
+	}
+	shop := snap.Namespaces[1]
+	if shop.Namespace != "shop" || math.Abs(shop.HourlyCost-1) > 1e-9 || shop.PodCount != 2 || shop.ClusterID != "prod" {
+		t.Fatalf("unexpected merged namespace %+v", shop)
+	}
+	if shop.Labels["node"] != "b" {
+		t.Fatalf("expected labels from the newest report, got %v", shop.Labels)
+	}
+	if snap.Network.TxBytes != 20 || len(snap.Network.NamespaceConnections) != 1 || snap.Network.NamespaceConnections[0].TxBytes != 20 {
+		t.Fatalf("unexpected network %+v", snap.Network)
+	}
+}
+
+func TestAggregatorKeepsNewestAndExpiresNodes(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	agg := New(Config{ClusterID: "prod", NodeTTL: time.Minute})
+
+	if err := agg.Accept("", []forwarder.AgentReport{nodeReport("a", at, 0.5), nodeReport("b", at, 0.5)}).Err(); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if err := agg.Accept("", []forwarder.AgentReport{nodeReport("a", at.Add(-time.Minute), 9)}).Err(); err != nil {
+		t.Fatalf("accept late report: %v", err)
+	}
+	if err := agg.Accept("", []forwarder.AgentReport{nodeReport("a", at.Add(45*time.Second), 0.7)}).Err(); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	snap, ok := agg.Snapshot(at.Add(90 * time.Second))
+	if !ok || len(snap.Nodes) != 1 || snap.Nodes[0].NodeName != "a" {
+		t.Fatalf("expected only node a to remain, got %+v", snap.Nodes)
+	}
+	if snap.Pods[0].HourlyCost != 0.7 {
+		t.Fatalf("expected the newest report of node a, got %+v", snap.Pods[0])
+	}
+	if agg.Nodes() != 1 {
+		t.Fatalf("expected node b to be forgotten, got %d nodes", agg.Nodes())
+	}
+	if _, ok := agg.Snapshot(at.Add(time.Hour)); ok {
+		t.Fatal("expected no snapshot once every node expired")
+	}
+}
+
+func TestSnapshotBooksTrafficOnce(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	agg := New(Config{ClusterID: "prod", NodeTTL: 5 * time.Minute})
+	costs := accumulator.New(accumulator.Config{}, nil)
+
+	// A backlogged agent flushes two intervals at once.
+	batch := []forwarder.AgentReport{nodeReport("a", at, 1), nodeReport("a", at.Add(30*time.Second), 1)}
+	if err := agg.Accept("", batch).Err(); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	snap, ok := agg.Snapshot(at.Add(time.Minute))
+	if !ok || math.Abs(snap.Network.EgressCost-0.02) > 1e-9 || snap.Network.Pods[0].TxBytes != 20 {
+		t.Fatalf("expected the egress of both reports, got %+v", snap.Network)
+	}
+	costs.Observe(snap)
+
+	// Two ticks without new reports publish nothing.
+	for _, tick := range []time.Duration{2 * time.Minute, 3 * time.Minute} {
+		if snap, ok := agg.Snapshot(at.Add(tick)); ok {
+			costs.Observe(snap)
+		}
+	}
+	day, _ := costs.CurrentWindow(accumulator.WindowDay)
+	if math.Abs(day.Cluster.NetworkCost-0.02) > 1e-9 {
+		t.Fatalf("expected accumulated egress to stay at 0.02, got %v", day.Cluster.NetworkCost)
+	}
+
+	// The next report books its own interval only.
+	if err := agg.Accept("", []forwarder.AgentReport{nodeReport("a", at.Add(4*time.Minute), 1)}).Err(); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	snap, ok = agg.Snapshot(at.Add(4 * time.Minute))
+	if !ok || math.Abs(snap.Network.EgressCost-0.01) > 1e-9 {
+		t.Fatalf("expected the egress of the new report only, got %+v", snap.Network)
+	}
+}
+
+func TestAcceptRejectsInvalidReports(t *testing.T) {
+	at := time.Now()
+	agg := New(Config{ClusterID: "prod"})
+
+	other := nodeReport("b", at, 1)
+	other.ClusterID = "staging"
+	other.ID = "agent-b-1"
+	result := agg.Accept("", []forwarder.AgentReport{nodeReport("a", at, 1), other})
+	if result.Err() != nil || result.Accepted != 1 || len(result.Rejected) != 1 {
+		t.Fatalf("expected only the other cluster's report to be rejected, got %+v", result)
+	}
+	if rejected := result.Rejected[0]; rejected.Index != 1 || rejected.ID != "agent-b-1" || rejected.Error == "" {
+		t.Fatalf("unexpected rejection %+v", rejected)
+	}
+	future := nodeReport("c", at, 1)
+	future.SchemaVersion = "2.0"
+	if err := agg.Accept("", []forwarder.AgentReport{future}).Err(); !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected a new major version to be rejected, got %v", err)
+	}
+	if agg.Nodes() != 1 {
+		t.Fatalf("expected only node a recorded, got %d nodes", agg.Nodes())
+	}
+}
+
+func TestAcceptDeduplicatesReportsAndBatches(t *testing.T) {
+	at := time.Now()
+	agg := New(Config{ClusterID: "prod", DedupeWindow: time.Minute})
+
+	first := nodeReport("a", at, 1)
+	first.ID = "agent-a-1"
+	second := nodeReport("a", at.Add(time.Second), 2)
+	second.ID = "agent-a-2"
+	batch := []forwarder.AgentReport{first, second}
+	key := forwarder.BatchKey(batch)
+
+	if result := agg.Accept(key, batch); result.Accepted != 2 {
+		t.Fatalf("expected both reports accepted, got %+v", result)
+	}
+	// The answer was lost and the batch is resent with the same key.
+	if result := agg.Accept(key, batch); result.Accepted != 2 || result.Duplicates != 0 {
+		t.Fatalf("expected the first answer again, got %+v", result)
+	}
+	// The same reports under another key are recognised by ID.
+	third := nodeReport("a", at.Add(2*time.Second), 3)
+	third.ID = "agent-a-3"
+	if result := agg.Accept("other", []forwarder.AgentReport{second, third}); result.Accepted != 1 || result.Duplicates != 1 {
+		t.Fatalf("expected one duplicate, got %+v", result)
+	}
+
+	agg.Snapshot(at.Add(2 * time.Minute))
+	if result := agg.Accept(key, batch); result.Accepted != 2 || result.Duplicates != 0 {
+		t.Fatalf("expected IDs to be forgotten after the window, got %+v", result)
+	}
+}
+
+func TestDecodeBatch(t *testing.T) {
+	report := nodeReport("a", time.Now().UTC(), 1)
+	body, err := json.Marshal(Batch{Reports: []forwarder.AgentReport{report, report}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(body)
+	_ = zw.Close()
+
+	reports, err := DecodeBatch(&gz, "", "gzip", 1<<20)
+	if err != nil || len(reports) != 2 || reports[0].NodeName != "a" {
+		t.Fatalf("unexpected batch %v %+v", err, reports)
+	}
+
+	single, _ := json.Marshal(report)
+	reports, err = DecodeBatch(bytes.NewReader(single), "application/json", "", 1<<20)
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("unexpected single report %v %+v", err, reports)
+	}
+
+	if _, err := DecodeBatch(bytes.NewReader(body), "", "", 16); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	zenc, _ := zstd.NewWriter(nil)
+	compact := zenc.EncodeAll(forwarder.MarshalBatch([]forwarder.AgentReport{report}), nil)
+	_ = zenc.Close()
+	reports, err = DecodeBatch(bytes.NewReader(compact), forwarder.ContentTypeProtobuf, "zstd", 1<<20)
+	if err != nil || len(reports) != 1 || reports[0].NodeName != "a" || len(reports[0].Snapshot.Nodes) != 1 {
+		t.Fatalf("unexpected protobuf batch %v %+v", err, reports)
+	}
+
+	for _, header := range [][2]string{{"text/plain", ""}, {"", "br"}} {
+		if _, err := DecodeBatch(bytes.NewReader(body), header[0], header[1], 1<<20); !errors.Is(err, ErrUnsupportedMedia) {
+			t.Fatalf("expected ErrUnsupportedMedia for %v, got %v", header, err)
+		}
+	}
+}
+
+func TestMergeOverlaysNodeUsageOnClusterReport(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cluster := nodeReport("a", at, 0.4)
+	cluster.NodeName = ""
+	cluster.Scope = forwarder.ScopeCluster
+	cluster.Snapshot.Nodes = []snapshot.NodeCostRecord{
+		{NodeName: "a", HourlyCost: 1, CPUAllocatableMilli: 1000},
+		{NodeName: "b", HourlyCost: 1, CPUAllocatableMilli: 1000},
+	}
+	cluster.Snapshot.Pods = append(cluster.Snapshot.Pods, snapshot.PodCostRecord{Namespace: "shop", Pod: "web-b", Node: "b", HourlyCost: 0.6})
+	cluster.Snapshot.Network = snapshot.NetworkSnapshot{}
+
+	usage := nodeReport("b", at.Add(time.Second), 0)
+	usage.Scope = forwarder.ScopeNode
+	usage.Snapshot.Pods[0].CPUUsageMilli = 250
+	usage.Snapshot.Network.Namespaces = []snapshot.NamespaceNetworkRecord{{Namespace: "shop", TxBytes: 10}}
+	usage.Snapshot = forwarder.NodeUsage(usage.Snapshot)
+
+	snap := Merge("prod", []forwarder.AgentReport{usage, cluster}, at)
+
+	if len(snap.Nodes) != 2 || snap.Resources.TotalNodeHourlyCost != 2 {
+		t.Fatalf("expected node costs from the cluster report only, got %+v", snap.Nodes)
+	}
+	if snap.Nodes[1].NodeName != "b" || snap.Nodes[1].CPUUsagePercent != 25 {
+		t.Fatalf("expected node b usage from its node report, got %+v", snap.Nodes[1])
+	}
+	shop := snap.Namespaces[1]
+	if math.Abs(shop.HourlyCost-0.4) > 1e-9 || shop.CPUUsageMilli != 250 || shop.NetworkTxBytes != 10 {
+		t.Fatalf("unexpected namespace %+v", shop)
+	}
+	if snap.Network.TxBytes != 10 {
+		t.Fatalf("expected traffic from the node report, got %+v", snap.Network)
+	}
+}
+
+func TestAcceptAppliesDeltasAndRequestsResync(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	agg := New(Config{ClusterID: "prod"})
+
+	keyframe := nodeReport("a", at, 0.5)
+	keyframe.Sequence = 10
+	next := nodeReport("a", at.Add(time.Minute), 0.8)
+	next.Sequence = 11
+	delta := forwarder.Diff(keyframe, next)
+
+	result := agg.Accept("", []forwarder.AgentReport{keyframe, delta})
+	if result.Err() != nil || len(result.Resync) != 0 {
+		t.Fatalf("accept: %+v", result)
+	}
+	snap, _ := agg.Snapshot(at.Add(time.Minute))
+	if snap.Pods[0].HourlyCost != 0.8 || len(snap.Nodes) != 1 {
+		t.Fatalf("expected the delta applied to the keyframe, got %+v", snap.Pods)
+	}
+
+	// Report 12 never arrived, so 13 cannot be rebuilt.
+	lost := nodeReport("a", at.Add(2*time.Minute), 0.9)
+	lost.Sequence = 12
+	late := nodeReport("a", at.Add(3*time.Minute), 1.1)
+	late.Sequence = 13
+	result = agg.Accept("", []forwarder.AgentReport{forwarder.Diff(lost, late), nodeReport("b", late.Timestamp, 1)})
+	if result.Err() != nil || result.Accepted != 1 || len(result.Resync) != 1 || result.Resync[0] != "a" {
+		t.Fatalf("expected a resync for node a and only b accepted, got %+v", result)
+	}
+	snap, _ = agg.Snapshot(at.Add(3 * time.Minute))
+	if len(snap.Pods) != 2 || snap.Pods[0].HourlyCost != 0.8 {
+		t.Fatalf("expected the held report to be kept, got %+v", snap.Pods)
+	}
+
+	bad := forwarder.Diff(keyframe, next)
+	bad.Sequence = 10
+	if err := agg.Accept("", []forwarder.AgentReport{bad}).Err(); !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected a delta that does not follow its base to be rejected, got %v", err)
+	}
+}
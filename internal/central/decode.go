@@ -0,0 +1,102 @@
+package central
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"clustercost-agent-k8s/internal/forwarder"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrTooLarge is returned when a decoded body exceeds the limit.
+var ErrTooLarge = errors.New("payload too large")
+
+// ErrUnsupportedMedia is returned for a content type or encoding the
+// central agent cannot decode. Senders fall back to JSON when they see it.
+var ErrUnsupportedMedia = errors.New("unsupported media type")
+
+// Batch is the body forwarder.Sender.SendBatch posts.
+type Batch struct {
+	Reports []forwarder.AgentReport `json:"reports"`
+}
+
+// DecodeBatch reads a batch, or a single report as forwarder.Sender.Send
+// posts it. contentType and encoding are the Content-Type and
+// Content-Encoding headers, where an empty type means JSON; limit bounds
+// the decompressed size.
+func DecodeBatch(r io.Reader, contentType, encoding string, limit int64) ([]forwarder.AgentReport, error) {
+	protobuf := false
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("%w: content type %q", ErrUnsupportedMedia, contentType)
+		}
+		switch mediaType {
+		case forwarder.ContentTypeJSON:
+		case forwarder.ContentTypeProtobuf:
+			protobuf = true
+		default:
+			return nil, fmt.Errorf("%w: content type %q", ErrUnsupportedMedia, contentType)
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer func() {
+			_ = zr.Close()
+		}()
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("%w: content encoding %q", ErrUnsupportedMedia, encoding)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrTooLarge
+	}
+	if protobuf {
+		reports, err := forwarder.UnmarshalBatch(body)
+		if err != nil {
+			return nil, fmt.Errorf("decode batch: %w", err)
+		}
+		return reports, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	if _, ok := fields["reports"]; ok {
+		var batch Batch
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("decode batch: %w", err)
+		}
+		return batch.Reports, nil
+	}
+	var report forwarder.AgentReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("decode report: %w", err)
+	}
+	return []forwarder.AgentReport{report}, nil
+}
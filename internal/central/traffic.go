@@ -0,0 +1,48 @@
+package central
+
+import "clustercost-agent-k8s/internal/snapshot"
+
+// traffic sums the network amounts of a node's reports that have not been
+// published yet. Agents price the bytes seen since their previous
+// collection, so every report's amounts must reach the merged view exactly
+// once, however many reports arrive between merges.
+type traffic struct {
+	network    *merger
+	namespaces map[string]snapshot.NamespaceCostRecord
+}
+
+func newTraffic(clusterID string) *traffic {
+	return &traffic{network: newMerger(clusterID), namespaces: map[string]snapshot.NamespaceCostRecord{}}
+}
+
+func (t *traffic) add(snap snapshot.Snapshot) {
+	t.network.addNetwork(snap)
+	for _, ns := range snap.Namespaces {
+		rec := t.namespaces[ns.Namespace]
+		rec.NetworkTxBytes += ns.NetworkTxBytes
+		rec.NetworkRxBytes += ns.NetworkRxBytes
+		rec.NetworkEgressCost += ns.NetworkEgressCost
+		t.namespaces[ns.Namespace] = rec
+	}
+}
+
+// apply returns a copy of snap whose network amounts are those summed in t,
+// or zero when t is nil.
+func (t *traffic) apply(clusterID string, snap snapshot.Snapshot) snapshot.Snapshot {
+	if t == nil {
+		t = newTraffic(clusterID)
+	}
+	snap.Namespaces = append([]snapshot.NamespaceCostRecord(nil), snap.Namespaces...)
+	for i := range snap.Namespaces {
+		ns := &snap.Namespaces[i]
+		rec := t.namespaces[ns.Namespace]
+		ns.NetworkTxBytes = rec.NetworkTxBytes
+		ns.NetworkRxBytes = rec.NetworkRxBytes
+		ns.NetworkEgressCost = rec.NetworkEgressCost
+	}
+	snap.Network = t.network.network()
+	snap.Resources.NetworkTxBytesTotal = snap.Network.TxBytes
+	snap.Resources.NetworkRxBytesTotal = snap.Network.RxBytes
+	snap.Resources.NetworkEgressCostTotal = snap.Network.EgressCost
+	return snap
+}
@@ -0,0 +1,275 @@
+// Package chargeback turns an accumulated cost period into per-group
+// statements with compute, network, storage and shared-cost breakdowns.
+package chargeback
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/export"
+)
+
+const (
+	// Unallocated groups the namespaces without a value for the grouping
+	// dimension.
+	Unallocated = "__unallocated__"
+
+	// GroupNamespace and GroupEnvironment group by the namespace itself and
+	// by its environment; any other name is an allocation dimension.
+	GroupNamespace   = "namespace"
+	GroupEnvironment = "environment"
+
+	// DefaultTop is the number of workloads listed per statement.
+	DefaultTop = 5
+)
+
+// Options control how a period is split into statements.
+type Options struct {
+	// GroupBy is namespace, environment or an allocation dimension such as
+	// team. Defaults to namespace.
+	GroupBy string
+	// Top is the number of workloads listed per statement.
+	Top int
+	// ShareIdle spreads node cost not charged to any namespace over the
+	// groups in proportion to their compute cost, as shared cost. When
+	// false it is reported separately as Idle.
+	ShareIdle bool
+}
+
+// Breakdown splits a cost in USD. Compute excludes Shared, which is the
+// cost received from shared namespaces and, with ShareIdle, idle nodes.
+type Breakdown struct {
+	Compute float64 `json:"compute"`
+	Network float64 `json:"network"`
+	Storage float64 `json:"storage"`
+	Shared  float64 `json:"shared"`
+	Total   float64 `json:"total"`
+}
+
+func (b *Breakdown) add(o Breakdown) {
+	b.Compute += o.Compute
+	b.Network += o.Network
+	b.Storage += o.Storage
+	b.Shared += o.Shared
+	b.Total += o.Total
+}
+
+func breakdown(t accumulator.Totals) Breakdown {
+	return Breakdown{
+		Compute: t.ComputeCost - t.SharedCost,
+		Network: t.NetworkCost,
+		Storage: t.StorageCost,
+		Shared:  t.SharedCost,
+		Total:   t.TotalCost,
+	}
+}
+
+// Workload is the cost of one controller, or of a pod without one.
+type Workload struct {
+	Namespace string    `json:"namespace"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Cost      Breakdown `json:"cost"`
+}
+
+// Statement is the charge for one group.
+type Statement struct {
+	Group      string    `json:"group"`
+	Namespaces []string  `json:"namespaces"`
+	Cost       Breakdown `json:"cost"`
+	// Percent is the share of the cluster total charged to the group.
+	Percent      float64    `json:"percent"`
+	TopWorkloads []Workload `json:"topWorkloads"`
+}
+
+// Report holds the statements for one period, most expensive first.
+type Report struct {
+	ClusterID   string    `json:"clusterId"`
+	ClusterName string    `json:"clusterName"`
+	Window      string    `json:"window"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	GroupBy     string    `json:"groupBy"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Coverage is the fraction of the period observed so far, which is
+	// below 1 for an open period or when the agent was down.
+	Coverage   float64     `json:"coverage"`
+	Statements []Statement `json:"statements"`
+	// Idle is node cost not charged to any namespace. It is zero when
+	// idle cost is shared.
+	Idle  float64   `json:"idle"`
+	Total Breakdown `json:"total"`
+}
+
+// Build splits p into statements grouped as opts request.
+func Build(p accumulator.Period, meta export.Meta, opts Options, now time.Time) Report {
+	if opts.GroupBy == "" {
+		opts.GroupBy = GroupNamespace
+	}
+	if opts.Top <= 0 {
+		opts.Top = DefaultTop
+	}
+	report := Report{
+		ClusterID:   meta.ClusterID,
+		ClusterName: meta.ClusterName,
+		Window:      string(p.Window),
+		Start:       p.Start,
+		End:         p.End,
+		GroupBy:     opts.GroupBy,
+		GeneratedAt: now.UTC(),
+	}
+	if length := p.End.Sub(p.Start).Seconds(); length > 0 {
+		report.Coverage = p.CoveredSeconds / length
+	}
+
+	statements := map[string]*Statement{}
+	groupOf := map[string]string{}
+	var charged float64
+	for ns, t := range p.Namespaces {
+		group := groupFor(p, ns, opts.GroupBy)
+		groupOf[ns] = group
+		s := statements[group]
+		if s == nil {
+			s = &Statement{Group: group}
+			statements[group] = s
+		}
+		s.Namespaces = append(s.Namespaces, ns)
+		s.Cost.add(breakdown(t))
+		charged += t.ComputeCost
+	}
+
+	idle := p.Cluster.ComputeCost - charged
+	if idle < 0 {
+		idle = 0
+	}
+	if opts.ShareIdle && charged > 0 {
+		for _, s := range statements {
+			share := idle * (s.Cost.Compute + s.Cost.Shared) / charged
+			s.Cost.Shared += share
+			s.Cost.Total += share
+		}
+	} else {
+		report.Idle = idle
+	}
+
+	workloads := map[string][]Workload{}
+	for key, t := range p.Workloads {
+		parts := strings.SplitN(key, "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		group, ok := groupOf[parts[0]]
+		if !ok {
+			group = groupFor(p, parts[0], opts.GroupBy)
+		}
+		workloads[group] = append(workloads[group], Workload{
+			Namespace: parts[0],
+			Kind:      parts[1],
+			Name:      parts[2],
+			Cost:      breakdown(t),
+		})
+	}
+
+	for _, s := range statements {
+		sort.Strings(s.Namespaces)
+		top := workloads[s.Group]
+		sort.Slice(top, func(i, j int) bool {
+			if top[i].Cost.Total != top[j].Cost.Total {
+				return top[i].Cost.Total > top[j].Cost.Total
+			}
+			return top[i].Namespace+"/"+top[i].Name < top[j].Namespace+"/"+top[j].Name
+		})
+		if len(top) > opts.Top {
+			top = top[:opts.Top]
+		}
+		s.TopWorkloads = append([]Workload{}, top...)
+		report.Total.add(s.Cost)
+		report.Statements = append(report.Statements, *s)
+	}
+	report.Total.Compute += report.Idle
+	report.Total.Total += report.Idle
+	for i := range report.Statements {
+		if report.Total.Total > 0 {
+			report.Statements[i].Percent = 100 * report.Statements[i].Cost.Total / report.Total.Total
+		}
+	}
+	sort.Slice(report.Statements, func(i, j int) bool {
+		a, b := report.Statements[i], report.Statements[j]
+		if a.Cost.Total != b.Cost.Total {
+			return a.Cost.Total > b.Cost.Total
+		}
+		return a.Group < b.Group
+	})
+	if report.Statements == nil {
+		report.Statements = []Statement{}
+	}
+	return report
+}
+
+func groupFor(p accumulator.Period, ns, groupBy string) string {
+	var v string
+	switch groupBy {
+	case GroupNamespace:
+		v = ns
+	case GroupEnvironment:
+		v = p.NamespaceInfo[ns].Environment
+	default:
+		v = p.NamespaceInfo[ns].Dimensions[groupBy]
+	}
+	if v == "" {
+		return Unallocated
+	}
+	return v
+}
+
+// Period selectors accepted by SelectPeriod besides dates.
+const (
+	PeriodCurrent  = "current"
+	PeriodPrevious = "previous"
+)
+
+// ErrNoPeriod is returned when no accumulated period matches the request.
+var ErrNoPeriod = errors.New("no accumulated period matches")
+
+// SelectPeriod returns the period of window named by selector: "current"
+// (the default) for the open period, "previous" for the last closed one,
+// or a date such as 2025-03-01, or a month such as 2025-03, that falls in
+// the period.
+func SelectPeriod(costs *accumulator.Accumulator, window accumulator.Window, selector string) (accumulator.Period, error) {
+	switch selector {
+	case "", PeriodCurrent:
+		if p, ok := costs.CurrentWindow(window); ok {
+			return p, nil
+		}
+		return accumulator.Period{}, ErrNoPeriod
+	case PeriodPrevious:
+		history := costs.History(window)
+		if len(history) == 0 {
+			return accumulator.Period{}, ErrNoPeriod
+		}
+		return history[len(history)-1], nil
+	}
+	var at time.Time
+	var err error
+	for _, layout := range []string{"2006-01-02", "2006-01"} {
+		if at, err = time.ParseInLocation(layout, selector, costs.Location()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return accumulator.Period{}, fmt.Errorf("invalid period %q: use current, previous, YYYY-MM-DD or YYYY-MM", selector)
+	}
+	if p, ok := costs.CurrentWindow(window); ok && p.Contains(at) {
+		return p, nil
+	}
+	for _, p := range costs.History(window) {
+		if p.Contains(at) {
+			return p, nil
+		}
+	}
+	return accumulator.Period{}, ErrNoPeriod
+}
@@ -0,0 +1,122 @@
+package chargeback
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/export"
+)
+
+func testPeriod() accumulator.Period {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	return accumulator.Period{
+		Window:         accumulator.WindowMonth,
+		Start:          start,
+		End:            start.AddDate(0, 1, 0),
+		CoveredSeconds: 31 * 24 * 3600,
+		Cluster:        accumulator.Totals{ComputeCost: 100, NetworkCost: 6, StorageCost: 4, TotalCost: 110},
+		Namespaces: map[string]accumulator.Totals{
+			"payments": {ComputeCost: 40, SharedCost: 5, NetworkCost: 5, StorageCost: 1, TotalCost: 46},
+			"checkout": {ComputeCost: 20, SharedCost: 2, TotalCost: 20},
+			"batch":    {ComputeCost: 20, StorageCost: 3, TotalCost: 23},
+		},
+		Workloads: map[string]accumulator.Totals{
+			"payments/Deployment/api":    {ComputeCost: 30, TotalCost: 30},
+			"payments/Pod/debug":         {ComputeCost: 5, TotalCost: 5},
+			"checkout/Deployment/web":    {ComputeCost: 18, TotalCost: 18},
+			"batch/CronJob/nightly-sync": {ComputeCost: 20, StorageCost: 3, TotalCost: 23},
+		},
+		NamespaceInfo: map[string]accumulator.NamespaceInfo{
+			"payments": {Environment: "production", Dimensions: map[string]string{"team": "money"}},
+			"checkout": {Environment: "production", Dimensions: map[string]string{"team": "money"}},
+			"batch":    {Environment: "production"},
+		},
+	}
+}
+
+func approx(t *testing.T, what string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestBuildGroupsByDimension(t *testing.T) {
+	now := time.Date(2025, 4, 1, 8, 0, 0, 0, time.UTC)
+	report := Build(testPeriod(), export.Meta{ClusterID: "c1", ClusterName: "prod"}, Options{GroupBy: "team", Top: 2}, now)
+
+	if len(report.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %+v", report.Statements)
+	}
+	money := report.Statements[0]
+	if money.Group != "money" || strings.Join(money.Namespaces, ",") != "checkout,payments" {
+		t.Fatalf("unexpected first statement %+v", money)
+	}
+	approx(t, "money compute", money.Cost.Compute, 53)
+	approx(t, "money shared", money.Cost.Shared, 7)
+	approx(t, "money network", money.Cost.Network, 5)
+	approx(t, "money total", money.Cost.Total, 66)
+	if len(money.TopWorkloads) != 2 || money.TopWorkloads[0].Name != "api" || money.TopWorkloads[1].Name != "web" {
+		t.Fatalf("unexpected top workloads %+v", money.TopWorkloads)
+	}
+	if report.Statements[1].Group != Unallocated {
+		t.Fatalf("expected batch to be unallocated, got %+v", report.Statements[1])
+	}
+
+	// 20 of the 100 cluster compute is on no namespace.
+	approx(t, "idle", report.Idle, 20)
+	approx(t, "total", report.Total.Total, 66+23+20)
+	approx(t, "money percent", money.Percent, 100*66.0/109)
+	approx(t, "coverage", report.Coverage, 1)
+}
+
+func TestBuildSharesIdle(t *testing.T) {
+	report := Build(testPeriod(), export.Meta{}, Options{GroupBy: GroupEnvironment, ShareIdle: true}, time.Now())
+	if len(report.Statements) != 1 || report.Statements[0].Group != "production" {
+		t.Fatalf("unexpected statements %+v", report.Statements)
+	}
+	approx(t, "idle", report.Idle, 0)
+	approx(t, "shared", report.Statements[0].Cost.Shared, 7+20)
+	approx(t, "total", report.Total.Total, 89+20)
+	approx(t, "percent", report.Statements[0].Percent, 100)
+}
+
+func TestRenderFormats(t *testing.T) {
+	report := Build(testPeriod(), export.Meta{ClusterID: "c1", ClusterName: "prod"}, Options{}, time.Now())
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatCSV, report); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	// Header, three statements with four workloads, and the idle row.
+	if len(rows) != 1+3+4+1 || rows[1][4] != "payments" || rows[1][5] != "statement" {
+		t.Fatalf("unexpected csv rows %v", rows)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FormatHTML, report); err != nil {
+		t.Fatalf("html: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{"<!DOCTYPE html>", "Chargeback: prod", "payments/Deployment/api", "$109.00"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("html page is missing %q", want)
+		}
+	}
+	if strings.Contains(page, "<script") || strings.Contains(page, "<link") {
+		t.Error("html page should not reference external assets")
+	}
+
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("expected pdf to be rejected")
+	}
+}
@@ -0,0 +1,186 @@
+package chargeback
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format selects the rendering of a report.
+type Format string
+
+const (
+	// FormatJSON renders the report as a JSON document.
+	FormatJSON Format = "json"
+	// FormatCSV renders one row per statement and per listed workload.
+	FormatCSV Format = "csv"
+	// FormatHTML renders a self-contained page for sharing.
+	FormatHTML Format = "html"
+)
+
+// ParseFormat validates a format name.
+func ParseFormat(raw string) (Format, error) {
+	switch Format(raw) {
+	case "", FormatJSON:
+		return FormatJSON, nil
+	case FormatCSV, FormatHTML:
+		return Format(raw), nil
+	default:
+		return "", fmt.Errorf("unknown format %q", raw)
+	}
+}
+
+// ContentType returns the media type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// Write renders report in the requested format.
+func Write(w io.Writer, format Format, report Report) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, report)
+	case FormatHTML:
+		return WriteHTML(w, report)
+	default:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+}
+
+var csvHeader = []string{
+	"cluster_id",
+	"window",
+	"start",
+	"end",
+	"group",
+	"record",
+	"namespace",
+	"workload",
+	"compute_cost_usd",
+	"network_cost_usd",
+	"storage_cost_usd",
+	"shared_cost_usd",
+	"total_cost_usd",
+}
+
+// WriteCSV renders a statement row per group followed by its workloads,
+// and an idle row when idle cost is not shared.
+func WriteCSV(w io.Writer, report Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	row := func(group, record, namespace, workload string, cost Breakdown) error {
+		return cw.Write([]string{
+			report.ClusterID,
+			report.Window,
+			report.Start.Format(time.RFC3339),
+			report.End.Format(time.RFC3339),
+			group,
+			record,
+			namespace,
+			workload,
+			formatCost(cost.Compute),
+			formatCost(cost.Network),
+			formatCost(cost.Storage),
+			formatCost(cost.Shared),
+			formatCost(cost.Total),
+		})
+	}
+	for _, s := range report.Statements {
+		if err := row(s.Group, "statement", strings.Join(s.Namespaces, " "), "", s.Cost); err != nil {
+			return err
+		}
+		for _, wl := range s.TopWorkloads {
+			if err := row(s.Group, "workload", wl.Namespace, wl.Kind+"/"+wl.Name, wl.Cost); err != nil {
+				return err
+			}
+		}
+	}
+	if report.Idle > 0 {
+		if err := row("", "idle", "", "", Breakdown{Compute: report.Idle, Total: report.Idle}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatCost(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// WriteHTML renders a page with no external assets, so it can be mailed or
+// archived as a single file.
+func WriteHTML(w io.Writer, report Report) error {
+	return htmlTemplate.Execute(w, report)
+}
+
+var htmlTemplate = template.Must(template.New("chargeback").Funcs(template.FuncMap{
+	"usd":  func(v float64) string { return fmt.Sprintf("$%.2f", v) },
+	"pct":  func(v float64) string { return fmt.Sprintf("%.1f%%", v*100) },
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Chargeback {{.ClusterName}} {{date .Start}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #1f2933; margin: 2rem auto; max-width: 960px; }
+h1 { font-size: 1.5rem; margin-bottom: 0.25rem; }
+h2 { font-size: 1.15rem; margin-top: 2rem; border-bottom: 1px solid #d9e2ec; padding-bottom: 0.25rem; }
+p.meta { color: #627d98; margin-top: 0; }
+table { border-collapse: collapse; width: 100%; margin-top: 0.5rem; }
+th, td { padding: 0.35rem 0.6rem; text-align: right; border-bottom: 1px solid #f0f4f8; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #f0f4f8; font-weight: 600; }
+tfoot td { font-weight: 600; border-top: 2px solid #bcccdc; }
+</style>
+</head>
+<body>
+<h1>Chargeback: {{.ClusterName}}</h1>
+<p class="meta">{{.Window}} from {{date .Start}} to {{date .End}}, grouped by {{.GroupBy}}. Covers {{pct .Coverage}} of the period. Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}.</p>
+<table>
+<thead><tr><th>Group</th><th>Compute</th><th>Network</th><th>Storage</th><th>Shared</th><th>Total</th><th>Share</th></tr></thead>
+<tbody>
+{{- range .Statements}}
+<tr><td><a href="#{{.Group}}">{{.Group}}</a></td><td>{{usd .Cost.Compute}}</td><td>{{usd .Cost.Network}}</td><td>{{usd .Cost.Storage}}</td><td>{{usd .Cost.Shared}}</td><td>{{usd .Cost.Total}}</td><td>{{printf "%.1f%%" .Percent}}</td></tr>
+{{- end}}
+{{- if .Idle}}
+<tr><td>Idle</td><td>{{usd .Idle}}</td><td></td><td></td><td></td><td>{{usd .Idle}}</td><td></td></tr>
+{{- end}}
+</tbody>
+<tfoot><tr><td>Total</td><td>{{usd .Total.Compute}}</td><td>{{usd .Total.Network}}</td><td>{{usd .Total.Storage}}</td><td>{{usd .Total.Shared}}</td><td>{{usd .Total.Total}}</td><td></td></tr></tfoot>
+</table>
+{{- range .Statements}}
+<h2 id="{{.Group}}">{{.Group}}: {{usd .Cost.Total}}</h2>
+<p class="meta">Namespaces: {{join .Namespaces ", "}}</p>
+{{- if .TopWorkloads}}
+<table>
+<thead><tr><th>Workload</th><th>Compute</th><th>Network</th><th>Storage</th><th>Total</th></tr></thead>
+<tbody>
+{{- range .TopWorkloads}}
+<tr><td>{{.Namespace}}/{{.Kind}}/{{.Name}}</td><td>{{usd .Cost.Compute}}</td><td>{{usd .Cost.Network}}</td><td>{{usd .Cost.Storage}}</td><td>{{usd .Cost.Total}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
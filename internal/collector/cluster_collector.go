@@ -52,23 +52,25 @@ func (c *ClusterCollector) Collect(ctx context.Context) (kube.ClusterSnapshot, e
 	nsMap := map[string]kube.Namespace{}
 	for _, ns := range namespaces.Items {
 		nsMap[ns.Name] = kube.Namespace{
-			Name:   ns.Name,
-			Labels: ns.Labels,
+			Name:        ns.Name,
+			Labels:      ns.Labels,
+			Annotations: ns.Annotations,
 		}
 		snapshot.Namespaces = append(snapshot.Namespaces, nsMap[ns.Name])
 	}
 
 	for _, pod := range pods.Items {
 		snapshot.Pods = append(snapshot.Pods, kube.Pod{
-			Namespace:  pod.Namespace,
-			Name:       pod.Name,
-			UID:        pod.UID,
-			NodeName:   pod.Spec.NodeName,
-			PodIP:      pod.Status.PodIP,
-			Labels:     pod.Labels,
-			OwnerKind:  controllerKind(&pod),
-			OwnerName:  controllerName(&pod),
-			Containers: extractContainers(pod.Spec.Containers),
+			Namespace:   pod.Namespace,
+			Name:        pod.Name,
+			UID:         pod.UID,
+			NodeName:    pod.Spec.NodeName,
+			PodIP:       pod.Status.PodIP,
+			Labels:      pod.Labels,
+			Annotations: pod.Annotations,
+			OwnerKind:   controllerKind(&pod),
+			OwnerName:   controllerName(&pod),
+			Containers:  extractContainers(pod.Spec.Containers),
 		})
 	}
 
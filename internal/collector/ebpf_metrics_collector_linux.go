@@ -16,6 +16,7 @@ import (
 
 	"clustercost-agent-k8s/internal/config"
 	"clustercost-agent-k8s/internal/kube"
+	"clustercost-agent-k8s/internal/telemetry"
 
 	"github.com/cilium/ebpf"
 	corev1 "k8s.io/api/core/v1"
@@ -24,6 +25,7 @@ import (
 type ebpfMetricsCollector struct {
 	mapPath    string
 	cgroupRoot string
+	metrics    *telemetry.Metrics
 	logger     *slog.Logger
 
 	mu       sync.Mutex
@@ -45,7 +47,7 @@ type metricStats struct {
 }
 
 // newEBPFMetricsCollector reads a pinned eBPF map of cgroup stats.
-func newEBPFMetricsCollector(cfg config.MetricsConfig, logger *slog.Logger) PodMetricsCollector {
+func newEBPFMetricsCollector(cfg config.MetricsConfig, metrics *telemetry.Metrics, logger *slog.Logger) PodMetricsCollector {
 	mapPath := cfg.BPFMapPath
 	if mapPath == "" {
 		mapPath = "/sys/fs/bpf/clustercost/metrics"
@@ -57,6 +59,7 @@ func newEBPFMetricsCollector(cfg config.MetricsConfig, logger *slog.Logger) PodM
 	return &ebpfMetricsCollector{
 		mapPath:    mapPath,
 		cgroupRoot: cgroupRoot,
+		metrics:    metrics,
 		logger:     logger,
 		last:       map[uint64]metricStats{},
 		cache:      map[string]uint64{},
@@ -76,6 +79,7 @@ func (c *ebpfMetricsCollector) CollectPodMetrics(ctx context.Context, pods []*co
 	if err != nil {
 		return nil, err
 	}
+	c.metrics.ObserveCgroupLookups(len(podCgroups), countPodsWithUID(pods)-len(podCgroups))
 
 	lookup := make(map[uint64]string, len(podCgroups))
 	for key, cgID := range podCgroups {
@@ -95,7 +99,9 @@ func (c *ebpfMetricsCollector) CollectPodMetrics(ctx context.Context, pods []*co
 	iter := c.usageMap.Iterate()
 	var key metricKey
 	var stats metricStats
+	entries := 0
 	for iter.Next(&key, &stats) {
+		entries++
 		podKey, ok := lookup[key.CgroupID]
 		if !ok {
 			continue
@@ -117,6 +123,8 @@ func (c *ebpfMetricsCollector) CollectPodMetrics(ctx context.Context, pods []*co
 		}
 	}
 
+	c.metrics.ObserveMap("metrics", entries, c.usageMap.MaxEntries())
+
 	if err := iter.Err(); err != nil {
 		return result, fmt.Errorf("iterate eBPF metrics map: %w", err)
 	}
@@ -203,6 +211,16 @@ func (c *ebpfMetricsCollector) mapPodCgroupIDsCached(pods []*corev1.Pod) (map[st
 	return result, nil
 }
 
+func countPodsWithUID(pods []*corev1.Pod) int {
+	n := 0
+	for _, pod := range pods {
+		if pod != nil && pod.UID != "" {
+			n++
+		}
+	}
+	return n
+}
+
 func cgroupInode(path string) (uint64, bool) {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -9,11 +9,12 @@ import (
 
 	"clustercost-agent-k8s/internal/config"
 	"clustercost-agent-k8s/internal/kube"
+	"clustercost-agent-k8s/internal/telemetry"
 
 	corev1 "k8s.io/api/core/v1"
 )
 
-func newEBPFMetricsCollector(cfg config.MetricsConfig, logger *slog.Logger) PodMetricsCollector {
+func newEBPFMetricsCollector(cfg config.MetricsConfig, metrics *telemetry.Metrics, logger *slog.Logger) PodMetricsCollector {
 	return &unsupportedMetricsCollector{}
 }
 
@@ -6,6 +6,7 @@ import (
 
 	"clustercost-agent-k8s/internal/kube"
 	"clustercost-agent-k8s/internal/network"
+	"clustercost-agent-k8s/internal/telemetry"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -27,12 +28,13 @@ type NetworkCollector interface {
 	CollectPodNetwork(ctx context.Context, pods []*corev1.Pod, nodes []*corev1.Node) (NetworkCollection, error)
 }
 
-// NewNetworkCollector returns a network collector implementation.
-func NewNetworkCollector(cfg NetworkCollectorConfig, logger *slog.Logger) NetworkCollector {
+// NewNetworkCollector returns a network collector implementation. metrics may
+// be nil.
+func NewNetworkCollector(cfg NetworkCollectorConfig, metrics *telemetry.Metrics, logger *slog.Logger) NetworkCollector {
 	if !cfg.Enabled {
 		return &noopNetworkCollector{}
 	}
-	return newEBPFNetworkCollector(cfg.BPFMapPath, logger)
+	return newEBPFNetworkCollector(cfg.BPFMapPath, metrics, logger)
 }
 
 type noopNetworkCollector struct{}
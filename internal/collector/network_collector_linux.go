@@ -11,6 +11,7 @@ import (
 
 	"clustercost-agent-k8s/internal/kube"
 	"clustercost-agent-k8s/internal/network"
+	"clustercost-agent-k8s/internal/telemetry"
 
 	"github.com/cilium/ebpf"
 	corev1 "k8s.io/api/core/v1"
@@ -23,6 +24,7 @@ const (
 
 type ebpfNetworkCollector struct {
 	mapPath string
+	metrics *telemetry.Metrics
 	logger  *slog.Logger
 
 	mu      sync.Mutex
@@ -44,12 +46,13 @@ type flowStats struct {
 	RxBytes uint64
 }
 
-func newEBPFNetworkCollector(mapPath string, logger *slog.Logger) NetworkCollector {
+func newEBPFNetworkCollector(mapPath string, metrics *telemetry.Metrics, logger *slog.Logger) NetworkCollector {
 	if mapPath == "" {
 		mapPath = "/sys/fs/bpf/clustercost/flows"
 	}
 	return &ebpfNetworkCollector{
 		mapPath: mapPath,
+		metrics: metrics,
 		logger:  logger,
 		last:    map[flowKey]flowStats{},
 	}
@@ -93,7 +96,9 @@ func (c *ebpfNetworkCollector) CollectPodNetwork(ctx context.Context, pods []*co
 	iter := c.flowMap.Iterate()
 	var key flowKey
 	var stats flowStats
+	entries := 0
 	for iter.Next(&key, &stats) {
+		entries++
 		srcIP, ok := ipFromFlowKey(key.SrcAddr, key.Family)
 		if !ok {
 			continue
@@ -126,6 +131,8 @@ func (c *ebpfNetworkCollector) CollectPodNetwork(ctx context.Context, pods []*co
 		result[keyStr] = usage
 	}
 
+	c.metrics.ObserveMap("flows", entries, c.flowMap.MaxEntries())
+
 	if err := iter.Err(); err != nil {
 		return NetworkCollection{PodUsage: result, Flows: flows}, fmt.Errorf("iterate eBPF flow map: %w", err)
 	}
@@ -7,12 +7,14 @@ import (
 	"fmt"
 	"log/slog"
 
+	"clustercost-agent-k8s/internal/telemetry"
+
 	corev1 "k8s.io/api/core/v1"
 )
 
 type ebpfNetworkCollector struct{}
 
-func newEBPFNetworkCollector(mapPath string, logger *slog.Logger) NetworkCollector {
+func newEBPFNetworkCollector(mapPath string, metrics *telemetry.Metrics, logger *slog.Logger) NetworkCollector {
 	return &ebpfNetworkCollector{}
 }
 
@@ -6,6 +6,7 @@ import (
 
 	"clustercost-agent-k8s/internal/config"
 	"clustercost-agent-k8s/internal/kube"
+	"clustercost-agent-k8s/internal/telemetry"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -15,12 +16,13 @@ type PodMetricsCollector interface {
 	CollectPodMetrics(ctx context.Context, pods []*corev1.Pod) (map[string]kube.PodUsage, error)
 }
 
-// NewPodMetricsCollector returns an eBPF-backed metrics collector.
-func NewPodMetricsCollector(cfg config.MetricsConfig, logger *slog.Logger) PodMetricsCollector {
+// NewPodMetricsCollector returns an eBPF-backed metrics collector. metrics may
+// be nil.
+func NewPodMetricsCollector(cfg config.MetricsConfig, metrics *telemetry.Metrics, logger *slog.Logger) PodMetricsCollector {
 	if !cfg.Enabled {
 		return &noopPodMetricsCollector{}
 	}
-	return newEBPFMetricsCollector(cfg, logger)
+	return newEBPFMetricsCollector(cfg, metrics, logger)
 }
 
 type noopPodMetricsCollector struct{}
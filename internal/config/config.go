@@ -6,7 +6,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,18 +16,36 @@ import (
 
 // Config captures the runtime settings for the agent.
 type Config struct {
-	ClusterID             string            `yaml:"clusterId"`
-	ClusterName           string            `yaml:"clusterName"`
-	NodeName              string            `yaml:"nodeName"`
-	ListenAddr            string            `yaml:"listenAddr"`
-	LogLevel              string            `yaml:"logLevel"`
-	ScrapeIntervalSeconds int               `yaml:"scrapeIntervalSeconds"`
-	KubeconfigPath        string            `yaml:"kubeconfig"`
-	Pricing               PricingConfig     `yaml:"pricing"`
-	Network               NetworkConfig     `yaml:"network"`
-	Metrics               MetricsConfig     `yaml:"metrics"`
-	Remote                RemoteConfig      `yaml:"remote"`
-	Environment           EnvironmentConfig `yaml:"environment"`
+	// Mode is agent (default) to collect from the cluster, central to serve
+	// the merged reports forwarded by agents, or the cluster and node pair:
+	// a leader-elected deployment for cluster-wide cost and a DaemonSet that
+	// reports node-local usage.
+	Mode                  string               `yaml:"mode"`
+	ClusterID             string               `yaml:"clusterId"`
+	ClusterName           string               `yaml:"clusterName"`
+	NodeName              string               `yaml:"nodeName"`
+	ListenAddr            string               `yaml:"listenAddr"`
+	LogLevel              string               `yaml:"logLevel"`
+	ScrapeIntervalSeconds int                  `yaml:"scrapeIntervalSeconds"`
+	KubeconfigPath        string               `yaml:"kubeconfig"`
+	Pricing               PricingConfig        `yaml:"pricing"`
+	Network               NetworkConfig        `yaml:"network"`
+	Metrics               MetricsConfig        `yaml:"metrics"`
+	Remote                RemoteConfig         `yaml:"remote"`
+	Central               CentralConfig        `yaml:"central"`
+	LeaderElection        LeaderElectionConfig `yaml:"leaderElection"`
+	Environment           EnvironmentConfig    `yaml:"environment"`
+	Accumulation          AccumulationConfig   `yaml:"accumulation"`
+	Server                ServerConfig         `yaml:"server"`
+	Prometheus            PrometheusConfig     `yaml:"prometheus"`
+	OTLP                  OTLPConfig           `yaml:"otlp"`
+	RemoteWrite           RemoteWriteConfig    `yaml:"remoteWrite"`
+	Budgets               BudgetsConfig        `yaml:"budgets"`
+	Anomaly               AnomalyConfig        `yaml:"anomaly"`
+	CRDs                  CRDsConfig           `yaml:"crds"`
+	Attribution           AttributionConfig    `yaml:"attribution"`
+	Enricher              EnricherConfig       `yaml:"enricher"`
+	Allocation            AllocationConfig     `yaml:"allocation"`
 }
 
 // PricingConfig represents the simplified pricing inputs for cost calculations.
@@ -83,16 +103,280 @@ type RemoteConfig struct {
 	MaxBatchBytes int64         `yaml:"maxBatchBytes"`
 	MemoryBuffer  int           `yaml:"memoryBuffer"`
 	GzipEnabled   bool          `yaml:"gzipEnabled"`
+	// DeltaEnabled sends only the records that changed since the last
+	// report the central agent acknowledged, with a full keyframe every
+	// KeyframeEvery reports.
+	DeltaEnabled  bool `yaml:"deltaEnabled"`
+	KeyframeEvery int  `yaml:"keyframeEvery"`
+	// WireFormat is json or protobuf, for batches and queue files alike.
+	WireFormat string `yaml:"wireFormat"`
+	// ZstdEnabled compresses batches with zstd instead of gzip.
+	ZstdEnabled bool `yaml:"zstdEnabled"`
+	// MaxQueueBytes caps the pending and failed queue files together, and
+	// MaxQueueAge drops files older than it; zero disables either.
+	MaxQueueBytes int64         `yaml:"maxQueueBytes"`
+	MaxQueueAge   time.Duration `yaml:"maxQueueAge"`
+	// OverflowPolicy picks the pending reports dropped over MaxQueueBytes:
+	// drop-oldest, drop-newest, or downsample to one per DownsampleEvery.
+	OverflowPolicy  string        `yaml:"overflowPolicy"`
+	DownsampleEvery time.Duration `yaml:"downsampleEvery"`
+}
+
+// Remote wire formats.
+const (
+	WireFormatJSON     = "json"
+	WireFormatProtobuf = "protobuf"
+)
+
+// Queue overflow policies.
+const (
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+	OverflowDownsample = "downsample"
+)
+
+// Run modes.
+const (
+	ModeAgent   = "agent"
+	ModeCentral = "central"
+	ModeCluster = "cluster"
+	ModeNode    = "node"
+)
+
+// LeaderElectionConfig configures the Lease that cluster mode replicas
+// compete for.
+type LeaderElectionConfig struct {
+	LeaseName      string        `yaml:"leaseName"`
+	LeaseNamespace string        `yaml:"leaseNamespace"`
+	LeaseDuration  time.Duration `yaml:"leaseDuration"`
+	RenewDeadline  time.Duration `yaml:"renewDeadline"`
+	RetryPeriod    time.Duration `yaml:"retryPeriod"`
+}
+
+// CentralConfig configures the receiver for reports forwarded by agents in
+// central mode.
+type CentralConfig struct {
+	// IngestPath is where agents POST report batches.
+	IngestPath string `yaml:"ingestPath"`
+	// AuthTokenFile lists the bearer tokens agents may send, one per line.
+	// When empty, the ingest path is protected like the rest of the API.
+	AuthTokenFile string `yaml:"authTokenFile"`
+	// NodeTTL drops a node from the merged view when it has not reported
+	// for this long.
+	NodeTTL time.Duration `yaml:"nodeTTL"`
+	// MaxBodyBytes bounds a decompressed batch.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+	// DedupeWindow is how long report IDs and batch idempotency keys are
+	// remembered, so a resent batch is not recorded twice.
+	DedupeWindow time.Duration `yaml:"dedupeWindow"`
+}
+
+// AccumulationConfig configures calendar cost totals integrated from hourly rates.
+type AccumulationConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	CheckpointPath string        `yaml:"checkpointPath"`
+	MaxGap         time.Duration `yaml:"maxGap"`
+	Timezone       string        `yaml:"timezone"`
+	Retention      int           `yaml:"retention"`
+}
+
+// OTLP transport protocols, named as in OTEL_EXPORTER_OTLP_PROTOCOL.
+const (
+	OTLPProtocolHTTP = "http/protobuf"
+	OTLPProtocolGRPC = "grpc"
+)
+
+// OTLPConfig pushes snapshot metrics to an OpenTelemetry collector.
+type OTLPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is a URL. For http/protobuf a bare host gets /v1/metrics
+	// appended; for grpc an http:// scheme selects plaintext HTTP/2.
+	Endpoint string            `yaml:"endpoint"`
+	Protocol string            `yaml:"protocol"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Headers  map[string]string `yaml:"headers"`
+}
+
+// RemoteWriteConfig pushes the /metrics series to a Prometheus remote_write
+// endpoint, spooling requests on disk while the endpoint is unavailable.
+type RemoteWriteConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	URL       string            `yaml:"url"`
+	AuthToken string            `yaml:"authToken"`
+	Headers   map[string]string `yaml:"headers"`
+	// ExternalLabels are added to every pushed series that does not carry
+	// the label already. Node-scoped agents default "instance" to their
+	// node name so the series of different nodes do not collide.
+	ExternalLabels map[string]string `yaml:"externalLabels"`
+	Timeout        time.Duration     `yaml:"timeout"`
+	QueueDir       string            `yaml:"queueDir"`
+	FlushEvery     time.Duration     `yaml:"flushEvery"`
+	MaxBatch       int               `yaml:"maxBatch"`
+	MaxRetries     int               `yaml:"maxRetries"`
+	Backoff        time.Duration     `yaml:"backoff"`
+}
+
+// BudgetsConfig defines spend budgets checked against the accumulated totals
+// and the webhooks that receive threshold alerts.
+type BudgetsConfig struct {
+	Definitions []BudgetConfig  `yaml:"definitions"`
+	Webhooks    []WebhookConfig `yaml:"webhooks"`
+	// RenotifyInterval repeats the alert for the highest crossed threshold
+	// while it stays crossed; zero notifies once per threshold and period.
+	RenotifyInterval time.Duration `yaml:"renotifyInterval"`
+	Timeout          time.Duration `yaml:"timeout"`
+	// StatePath persists sent notifications so restarts do not repeat them.
+	StatePath string `yaml:"statePath"`
+}
+
+// BudgetConfig is one budget. Scope fields combine; a budget without any
+// covers the whole cluster.
+type BudgetConfig struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	// Selector is a Kubernetes label selector matched against namespace labels.
+	Selector    string  `yaml:"selector"`
+	Environment string  `yaml:"environment"`
+	Period      string  `yaml:"period"`
+	Amount      float64 `yaml:"amount"`
+	// Thresholds are percentages of Amount; defaults to 50, 80, and 100.
+	Thresholds []float64 `yaml:"thresholds"`
+}
+
+// WebhookConfig is an HTTP endpoint that receives budget alerts as JSON.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// AnomalyConfig flags hourly cost spikes against a rolling median baseline
+// per namespace and per network class.
+type AnomalyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is how far back the baseline looks.
+	Window time.Duration `yaml:"window"`
+	// MinSamples is the baseline size needed before anything is flagged.
+	MinSamples int `yaml:"minSamples"`
+	// Sensitivity is the robust z-score (deviations from the median in
+	// scaled MADs) at which a value is flagged. Lower is more sensitive.
+	Sensitivity float64 `yaml:"sensitivity"`
+	// MinIncrease ignores spikes smaller than this many USD per hour.
+	MinIncrease     float64         `yaml:"minIncrease"`
+	TopContributors int             `yaml:"topContributors"`
+	Events          bool            `yaml:"events"`
+	Webhooks        []WebhookConfig `yaml:"webhooks"`
+	Timeout         time.Duration   `yaml:"timeout"`
+}
+
+// AttributionConfig supplies ownership for namespaces, workloads and pods
+// that carry no clustercost.io/team, cost-center or shared-weight
+// annotation. The environment defaults to the classifier result.
+type AttributionConfig struct {
+	DefaultTeam         string  `yaml:"defaultTeam"`
+	DefaultCostCenter   string  `yaml:"defaultCostCenter"`
+	DefaultSharedWeight float64 `yaml:"defaultSharedWeight"`
+}
+
+// AllocationConfig keeps hourly per-pod cost for allocation queries.
+// Node prices are split between CPU, memory and GPUs in proportion to
+// pricing.cpuHourPrice, pricing.memoryGibHourPrice and GPUHourPriceUSD.
+type AllocationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is how far back queries can reach.
+	Retention       time.Duration `yaml:"retention"`
+	GPUHourPriceUSD float64       `yaml:"gpuHourPrice"`
+	// StorageGiBMonthPriceUSD prices persistent volume claims, with a month
+	// of 730 hours.
+	StorageGiBMonthPriceUSD float64 `yaml:"storageGibMonthPrice"`
+}
+
+// EnricherConfig lists the allocation dimensions resolved for every
+// namespace and pod. Without dimensions the agent tracks the team, service,
+// env, client and cost_center labels.
+type EnricherConfig struct {
+	Dimensions []DimensionConfig `yaml:"dimensions"`
+}
+
+// DimensionConfig resolves one allocation dimension.
+type DimensionConfig struct {
+	Name string `yaml:"name"`
+	// Sources are label keys, or annotation keys prefixed with
+	// "annotation:", tried in order.
+	Sources   []string        `yaml:"sources"`
+	Lowercase bool            `yaml:"lowercase"`
+	Rewrites  []RewriteConfig `yaml:"rewrites"`
+	Default   string          `yaml:"default"`
+}
+
+// RewriteConfig replaces matches of the Match regular expression with
+// Replace, which may refer to capture groups as $1.
+type RewriteConfig struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// CRDsConfig reads pricing overrides, budgets and allocation policies from
+// clustercost.io custom resources in addition to this file.
+type CRDsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ResyncInterval is how often the informers re-list every object.
+	ResyncInterval time.Duration `yaml:"resyncInterval"`
+}
+
+// Auth modes for the local HTTP server.
+const (
+	AuthModeNone       = "none"
+	AuthModeToken      = "token"
+	AuthModeKubernetes = "kubernetes"
+)
+
+// ServerConfig secures the local HTTP server. TLS is enabled when both
+// certificate files are set; the files are re-read when they change.
+type ServerConfig struct {
+	TLSCertFile       string        `yaml:"tlsCertFile"`
+	TLSKeyFile        string        `yaml:"tlsKeyFile"`
+	TLSReloadInterval time.Duration `yaml:"tlsReloadInterval"`
+	AuthMode          string        `yaml:"authMode"`
+	AuthTokenFile     string        `yaml:"authTokenFile"`
+	AuthCacheTTL      time.Duration `yaml:"authCacheTTL"`
+	MaxStreamClients  int           `yaml:"maxStreamClients"`
+	// ReadinessComponents fail /agent/v1/readyz while any of them is failed.
+	ReadinessComponents []string `yaml:"readinessComponents"`
+}
+
+// PrometheusConfig bounds the series served on /metrics.
+type PrometheusConfig struct {
+	LabelKeys           []string `yaml:"labelKeys"`
+	MaxPodSeries        int      `yaml:"maxPodSeries"`
+	MaxConnectionSeries int      `yaml:"maxConnectionSeries"`
+	ConnectionLevels    []string `yaml:"connectionLevels"`
 }
 
 // EnvironmentConfig holds heuristics for namespace classification.
 type EnvironmentConfig struct {
-	LabelKeys              []string `yaml:"labelKeys"`
-	ProductionLabelValues  []string `yaml:"productionLabelValues"`
-	NonProdLabelValues     []string `yaml:"nonProdLabelValues"`
-	SystemLabelValues      []string `yaml:"systemLabelValues"`
-	ProductionNameContains []string `yaml:"productionNameContains"`
-	SystemNamespaces       []string `yaml:"systemNamespaces"`
+	// Rules are tried in order before the label and name heuristics.
+	Rules                  []EnvironmentRuleConfig `yaml:"rules"`
+	LabelKeys              []string                `yaml:"labelKeys"`
+	ProductionLabelValues  []string                `yaml:"productionLabelValues"`
+	NonProdLabelValues     []string                `yaml:"nonProdLabelValues"`
+	SystemLabelValues      []string                `yaml:"systemLabelValues"`
+	ProductionNameContains []string                `yaml:"productionNameContains"`
+	SystemNamespaces       []string                `yaml:"systemNamespaces"`
+}
+
+// EnvironmentRuleConfig assigns Environment to every namespace that matches
+// all of the conditions it sets.
+type EnvironmentRuleConfig struct {
+	Name        string `yaml:"name"`
+	Environment string `yaml:"environment"`
+	// NamespaceRegex must match the whole namespace name.
+	NamespaceRegex string `yaml:"namespaceRegex"`
+	// Selector is a Kubernetes label selector matched against namespace labels.
+	Selector string `yaml:"selector"`
+	// Annotations maps annotation keys to regular expressions that must
+	// match the whole value.
+	Annotations map[string]string `yaml:"annotations"`
 }
 
 // DefaultConfig returns sane defaults for the agent.
@@ -132,18 +416,37 @@ func DefaultConfig() Config {
 			CgroupPath: "/sys/fs/cgroup",
 		},
 		Remote: RemoteConfig{
-			Enabled:       false,
-			EndpointURL:   "",
-			AuthToken:     "",
-			Timeout:       5 * time.Second,
-			QueueDir:      "/var/lib/clustercost/queue",
-			FlushEvery:    5 * time.Second,
-			MaxBatch:      50,
-			MaxRetries:    5,
-			Backoff:       10 * time.Second,
-			MaxBatchBytes: 512 * 1024,
-			MemoryBuffer:  200,
-			GzipEnabled:   true,
+			Enabled:         false,
+			EndpointURL:     "",
+			AuthToken:       "",
+			Timeout:         5 * time.Second,
+			QueueDir:        "/var/lib/clustercost/queue",
+			FlushEvery:      5 * time.Second,
+			MaxBatch:        50,
+			MaxRetries:      5,
+			Backoff:         10 * time.Second,
+			MaxBatchBytes:   512 * 1024,
+			MemoryBuffer:    200,
+			GzipEnabled:     true,
+			KeyframeEvery:   30,
+			MaxQueueBytes:   256 << 20,
+			MaxQueueAge:     24 * time.Hour,
+			OverflowPolicy:  OverflowDropOldest,
+			DownsampleEvery: 10 * time.Minute,
+			WireFormat:      WireFormatJSON,
+		},
+		Central: CentralConfig{
+			IngestPath:   "/agent/v1/ingest",
+			NodeTTL:      5 * time.Minute,
+			MaxBodyBytes: 64 << 20,
+			DedupeWindow: time.Hour,
+		},
+		LeaderElection: LeaderElectionConfig{
+			LeaseName:      "clustercost-agent",
+			LeaseNamespace: "clustercost",
+			LeaseDuration:  15 * time.Second,
+			RenewDeadline:  10 * time.Second,
+			RetryPeriod:    2 * time.Second,
 		},
 		Environment: EnvironmentConfig{
 			LabelKeys: []string{"clustercost.io/environment"},
@@ -167,6 +470,63 @@ func DefaultConfig() Config {
 				"cert-manager",
 			},
 		},
+		Accumulation: AccumulationConfig{
+			Enabled:        true,
+			CheckpointPath: "/var/lib/clustercost/costs.json",
+			MaxGap:         10 * time.Minute,
+			Timezone:       "UTC",
+			Retention:      62,
+		},
+		Prometheus: PrometheusConfig{
+			LabelKeys:           []string{"team", "service", "env", "client"},
+			MaxPodSeries:        2000,
+			MaxConnectionSeries: 500,
+			ConnectionLevels:    []string{"namespace", "workload", "service"},
+		},
+		RemoteWrite: RemoteWriteConfig{
+			Timeout:    10 * time.Second,
+			QueueDir:   "/var/lib/clustercost/remote-write",
+			FlushEvery: 5 * time.Second,
+			MaxBatch:   10,
+			MaxRetries: 10,
+			Backoff:    5 * time.Second,
+		},
+		Budgets: BudgetsConfig{
+			RenotifyInterval: 24 * time.Hour,
+			Timeout:          10 * time.Second,
+			StatePath:        "/var/lib/clustercost/budgets.json",
+		},
+		Anomaly: AnomalyConfig{
+			Window:          24 * time.Hour,
+			MinSamples:      30,
+			Sensitivity:     5,
+			MinIncrease:     0.05,
+			TopContributors: 5,
+			Timeout:         10 * time.Second,
+		},
+		CRDs: CRDsConfig{
+			ResyncInterval: 10 * time.Minute,
+		},
+		Attribution: AttributionConfig{
+			DefaultSharedWeight: 1,
+		},
+		Allocation: AllocationConfig{
+			Enabled:                 true,
+			Retention:               7 * 24 * time.Hour,
+			GPUHourPriceUSD:         0.95,
+			StorageGiBMonthPriceUSD: 0.04,
+		},
+		OTLP: OTLPConfig{
+			Protocol: OTLPProtocolHTTP,
+			Interval: time.Minute,
+			Timeout:  10 * time.Second,
+		},
+		Server: ServerConfig{
+			TLSReloadInterval: time.Minute,
+			AuthMode:          AuthModeNone,
+			AuthCacheTTL:      time.Minute,
+			MaxStreamClients:  64,
+		},
 	}
 }
 
@@ -180,6 +540,12 @@ func (c Config) ScrapeInterval() time.Duration {
 
 // Load builds the configuration by merging defaults, file, environment, and flags.
 func Load() (Config, error) {
+	return LoadArgs(os.Args[1:])
+}
+
+// LoadArgs is Load with an explicit argument list, for subcommands that parse
+// their own flags first.
+func LoadArgs(args []string) (Config, error) {
 	cfg := DefaultConfig()
 
 	// Step 1: optional config file
@@ -187,6 +553,7 @@ func Load() (Config, error) {
 
 	fs := flag.NewFlagSet("clustercost-agent-k8s", flag.ContinueOnError)
 	fs.StringVar(&configFile, "config", configFile, "Path to YAML config file")
+	fs.StringVar(&cfg.Mode, "mode", cfg.Mode, "Run mode (agent, central, cluster, node)")
 	fs.StringVar(&cfg.ClusterID, "cluster-id", cfg.ClusterID, "Logical cluster identifier")
 	fs.StringVar(&cfg.ClusterName, "cluster-name", cfg.ClusterName, "Legacy cluster name (alias for cluster-id)")
 	fs.StringVar(&cfg.NodeName, "node-name", cfg.NodeName, "Node name (for daemonset mode)")
@@ -220,8 +587,110 @@ func Load() (Config, error) {
 	fs.Int64Var(&cfg.Remote.MaxBatchBytes, "remote-max-batch-bytes", cfg.Remote.MaxBatchBytes, "Max payload size per batch in bytes")
 	fs.IntVar(&cfg.Remote.MemoryBuffer, "remote-memory-buffer", cfg.Remote.MemoryBuffer, "In-memory buffer size before spooling to disk")
 	fs.BoolVar(&cfg.Remote.GzipEnabled, "remote-gzip", cfg.Remote.GzipEnabled, "Enable gzip compression for batches")
+	fs.BoolVar(&cfg.Remote.DeltaEnabled, "remote-delta", cfg.Remote.DeltaEnabled, "Send only records changed since the last acknowledged report")
+	fs.IntVar(&cfg.Remote.KeyframeEvery, "remote-keyframe-every", cfg.Remote.KeyframeEvery, "Reports between full keyframes in delta mode")
+	fs.StringVar(&cfg.Remote.WireFormat, "remote-wire-format", cfg.Remote.WireFormat, "Remote batch and queue file format (json or protobuf)")
+	fs.BoolVar(&cfg.Remote.ZstdEnabled, "remote-zstd", cfg.Remote.ZstdEnabled, "Compress batches with zstd instead of gzip")
+	fs.Int64Var(&cfg.Remote.MaxQueueBytes, "remote-max-queue-bytes", cfg.Remote.MaxQueueBytes, "Max bytes of pending and failed queue files (0 for no limit)")
+	fs.DurationVar(&cfg.Remote.MaxQueueAge, "remote-max-queue-age", cfg.Remote.MaxQueueAge, "Drop queued reports older than this (0 to keep them)")
+	fs.StringVar(&cfg.Remote.OverflowPolicy, "remote-overflow-policy", cfg.Remote.OverflowPolicy, "Reports dropped over the queue byte cap (drop-oldest, drop-newest, or downsample)")
+	fs.DurationVar(&cfg.Remote.DownsampleEvery, "remote-downsample-every", cfg.Remote.DownsampleEvery, "Keep one queued report per this interval under the downsample policy")
+	fs.StringVar(&cfg.Central.IngestPath, "central-ingest-path", cfg.Central.IngestPath, "Path agents POST reports to in central mode")
+	fs.StringVar(&cfg.Central.AuthTokenFile, "central-auth-token-file", cfg.Central.AuthTokenFile, "File with bearer tokens accepted from agents, one per line")
+	fs.DurationVar(&cfg.Central.NodeTTL, "central-node-ttl", cfg.Central.NodeTTL, "Drop nodes from the merged view after this long without a report")
+	fs.Int64Var(&cfg.Central.MaxBodyBytes, "central-max-body-bytes", cfg.Central.MaxBodyBytes, "Max decompressed size of a report batch")
+	fs.DurationVar(&cfg.Central.DedupeWindow, "central-dedupe-window", cfg.Central.DedupeWindow, "How long report IDs and idempotency keys are remembered")
+	fs.StringVar(&cfg.LeaderElection.LeaseName, "leader-election-lease", cfg.LeaderElection.LeaseName, "Lease cluster mode replicas compete for")
+	fs.StringVar(&cfg.LeaderElection.LeaseNamespace, "leader-election-namespace", cfg.LeaderElection.LeaseNamespace, "Namespace of the leader election Lease")
+	fs.DurationVar(&cfg.LeaderElection.LeaseDuration, "leader-election-lease-duration", cfg.LeaderElection.LeaseDuration, "How long standbys wait before taking over an unrenewed Lease")
+	fs.DurationVar(&cfg.LeaderElection.RenewDeadline, "leader-election-renew-deadline", cfg.LeaderElection.RenewDeadline, "How long the leader retries renewing before giving up leadership")
+	fs.DurationVar(&cfg.LeaderElection.RetryPeriod, "leader-election-retry-period", cfg.LeaderElection.RetryPeriod, "Interval between Lease acquire and renew attempts")
+	fs.BoolVar(&cfg.Accumulation.Enabled, "accumulation-enabled", cfg.Accumulation.Enabled, "Enable accumulated cost totals per calendar window")
+	fs.StringVar(&cfg.Accumulation.CheckpointPath, "accumulation-checkpoint", cfg.Accumulation.CheckpointPath, "Checkpoint file for accumulated cost totals")
+	fs.DurationVar(&cfg.Accumulation.MaxGap, "accumulation-max-gap", cfg.Accumulation.MaxGap, "Longest interval between snapshots that is still integrated")
+	fs.StringVar(&cfg.Accumulation.Timezone, "accumulation-timezone", cfg.Accumulation.Timezone, "IANA time zone for calendar window boundaries")
+	fs.IntVar(&cfg.Accumulation.Retention, "accumulation-retention", cfg.Accumulation.Retention, "Closed periods kept per calendar window")
+	fs.StringVar(&cfg.Server.TLSCertFile, "tls-cert-file", cfg.Server.TLSCertFile, "TLS certificate file for the HTTP server")
+	fs.StringVar(&cfg.Server.TLSKeyFile, "tls-key-file", cfg.Server.TLSKeyFile, "TLS private key file for the HTTP server")
+	fs.DurationVar(&cfg.Server.TLSReloadInterval, "tls-reload-interval", cfg.Server.TLSReloadInterval, "How often to check TLS files for changes")
+	fs.StringVar(&cfg.Server.AuthMode, "auth-mode", cfg.Server.AuthMode, "HTTP auth mode (none, token, kubernetes)")
+	fs.StringVar(&cfg.Server.AuthTokenFile, "auth-token-file", cfg.Server.AuthTokenFile, "File with accepted bearer tokens, one per line")
+	fs.DurationVar(&cfg.Server.AuthCacheTTL, "auth-cache-ttl", cfg.Server.AuthCacheTTL, "How long TokenReview and SubjectAccessReview results are cached")
+	fs.IntVar(&cfg.Prometheus.MaxPodSeries, "prometheus-max-pod-series", cfg.Prometheus.MaxPodSeries, "Max pod cost series on /metrics (0 disables)")
+	fs.IntVar(&cfg.Prometheus.MaxConnectionSeries, "prometheus-max-connection-series", cfg.Prometheus.MaxConnectionSeries, "Max connection series per level on /metrics (0 is unlimited)")
+	fs.IntVar(&cfg.Server.MaxStreamClients, "max-stream-clients", cfg.Server.MaxStreamClients, "Concurrent /agent/v1/stream clients")
+	fs.BoolVar(&cfg.RemoteWrite.Enabled, "remote-write-enabled", cfg.RemoteWrite.Enabled, "Push metrics to a Prometheus remote_write endpoint")
+	fs.StringVar(&cfg.RemoteWrite.URL, "remote-write-url", cfg.RemoteWrite.URL, "Prometheus remote_write URL")
+	fs.StringVar(&cfg.RemoteWrite.AuthToken, "remote-write-auth-token", cfg.RemoteWrite.AuthToken, "Bearer token for remote_write")
+	fs.Func("remote-write-headers", "Extra remote_write headers as key=value pairs separated by commas", func(v string) error {
+		headers, err := parseHeaders(v)
+		if err != nil {
+			return err
+		}
+		cfg.RemoteWrite.Headers = headers
+		return nil
+	})
+	fs.Func("remote-write-external-labels", "Labels added to every remote_write series as key=value pairs separated by commas", func(v string) error {
+		labels, err := parseHeaders(v)
+		if err != nil {
+			return err
+		}
+		cfg.RemoteWrite.ExternalLabels = labels
+		return nil
+	})
+	fs.DurationVar(&cfg.RemoteWrite.Timeout, "remote-write-timeout", cfg.RemoteWrite.Timeout, "Timeout for remote_write requests")
+	fs.StringVar(&cfg.RemoteWrite.QueueDir, "remote-write-queue-dir", cfg.RemoteWrite.QueueDir, "Disk queue directory for remote_write")
+	fs.DurationVar(&cfg.RemoteWrite.FlushEvery, "remote-write-flush-every", cfg.RemoteWrite.FlushEvery, "Flush interval for remote_write")
+	fs.IntVar(&cfg.RemoteWrite.MaxBatch, "remote-write-max-batch", cfg.RemoteWrite.MaxBatch, "Max snapshots per remote_write request")
+	fs.IntVar(&cfg.RemoteWrite.MaxRetries, "remote-write-max-retries", cfg.RemoteWrite.MaxRetries, "Max retries before a remote_write batch is moved to failed/")
+	fs.DurationVar(&cfg.RemoteWrite.Backoff, "remote-write-backoff", cfg.RemoteWrite.Backoff, "Base backoff before retrying remote_write")
+	fs.Func("budget-webhook-urls", "Comma-separated webhook URLs for budget alerts", func(v string) error {
+		cfg.Budgets.Webhooks = nil
+		for _, url := range splitList(v) {
+			cfg.Budgets.Webhooks = append(cfg.Budgets.Webhooks, WebhookConfig{URL: url})
+		}
+		return nil
+	})
+	fs.DurationVar(&cfg.Budgets.RenotifyInterval, "budget-renotify-interval", cfg.Budgets.RenotifyInterval, "Repeat budget alerts while a threshold stays crossed (0 disables)")
+	fs.StringVar(&cfg.Budgets.StatePath, "budget-state-path", cfg.Budgets.StatePath, "File recording sent budget alerts")
+	fs.BoolVar(&cfg.Anomaly.Enabled, "anomaly-enabled", cfg.Anomaly.Enabled, "Detect hourly cost spikes per namespace and network class")
+	fs.DurationVar(&cfg.Anomaly.Window, "anomaly-window", cfg.Anomaly.Window, "Baseline window for anomaly detection")
+	fs.IntVar(&cfg.Anomaly.MinSamples, "anomaly-min-samples", cfg.Anomaly.MinSamples, "Baseline samples needed before flagging anomalies")
+	fs.Float64Var(&cfg.Anomaly.Sensitivity, "anomaly-sensitivity", cfg.Anomaly.Sensitivity, "Robust z-score that flags an anomaly (lower is more sensitive)")
+	fs.Float64Var(&cfg.Anomaly.MinIncrease, "anomaly-min-increase", cfg.Anomaly.MinIncrease, "Ignore spikes below this hourly USD increase")
+	fs.BoolVar(&cfg.Anomaly.Events, "anomaly-events", cfg.Anomaly.Events, "Record anomalies as Kubernetes Events")
+	fs.Func("anomaly-webhook-urls", "Comma-separated webhook URLs for anomaly alerts", func(v string) error {
+		cfg.Anomaly.Webhooks = nil
+		for _, url := range splitList(v) {
+			cfg.Anomaly.Webhooks = append(cfg.Anomaly.Webhooks, WebhookConfig{URL: url})
+		}
+		return nil
+	})
+	fs.BoolVar(&cfg.CRDs.Enabled, "crds-enabled", cfg.CRDs.Enabled, "Read CostPricingOverride, CostBudget and CostAllocationPolicy objects")
+	fs.DurationVar(&cfg.CRDs.ResyncInterval, "crds-resync-interval", cfg.CRDs.ResyncInterval, "Informer resync interval for clustercost.io objects")
+	fs.BoolVar(&cfg.Allocation.Enabled, "allocation-enabled", cfg.Allocation.Enabled, "Keep hourly per-pod cost for allocation queries")
+	fs.DurationVar(&cfg.Allocation.Retention, "allocation-retention", cfg.Allocation.Retention, "How far back allocation queries can reach")
+	fs.StringVar(&cfg.Attribution.DefaultTeam, "attribution-default-team", cfg.Attribution.DefaultTeam, "Team for resources without a clustercost.io/team annotation")
+	fs.StringVar(&cfg.Attribution.DefaultCostCenter, "attribution-default-cost-center", cfg.Attribution.DefaultCostCenter, "Cost center for resources without a clustercost.io/cost-center annotation")
+	fs.BoolVar(&cfg.OTLP.Enabled, "otlp-enabled", cfg.OTLP.Enabled, "Push snapshot metrics to an OTLP endpoint")
+	fs.StringVar(&cfg.OTLP.Endpoint, "otlp-endpoint", cfg.OTLP.Endpoint, "OTLP endpoint URL")
+	fs.StringVar(&cfg.OTLP.Protocol, "otlp-protocol", cfg.OTLP.Protocol, "OTLP protocol (http/protobuf or grpc)")
+	fs.DurationVar(&cfg.OTLP.Interval, "otlp-interval", cfg.OTLP.Interval, "OTLP export interval")
+	fs.DurationVar(&cfg.OTLP.Timeout, "otlp-timeout", cfg.OTLP.Timeout, "OTLP export timeout")
+	fs.Func("otlp-headers", "OTLP request headers as key=value pairs separated by commas", func(v string) error {
+		headers, err := parseHeaders(v)
+		if err != nil {
+			return err
+		}
+		cfg.OTLP.Headers = headers
+		return nil
+	})
+	fs.Func("readiness-components", "Comma-separated components that gate readiness (informers, metrics_collector, network_collector, ebpf, forwarder)", func(v string) error {
+		cfg.Server.ReadinessComponents = splitList(v)
+		return nil
+	})
 
-	if err := fs.Parse(os.Args[1:]); err != nil { // flag set already prints errors
+	if err := fs.Parse(args); err != nil { // flag set already prints errors
 		return Config{}, err
 	}
 
@@ -259,6 +728,183 @@ func Load() (Config, error) {
 		}
 	}
 
+	if cfg.Accumulation.Timezone == "" {
+		cfg.Accumulation.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(cfg.Accumulation.Timezone); err != nil {
+		return Config{}, fmt.Errorf("invalid accumulation timezone %q: %w", cfg.Accumulation.Timezone, err)
+	}
+	if cfg.Accumulation.Retention < 0 {
+		return Config{}, errors.New("accumulation retention must be non-negative")
+	}
+
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = ModeAgent
+	case ModeAgent:
+	case ModeCluster:
+		le := cfg.LeaderElection
+		if le.LeaseName == "" || le.LeaseNamespace == "" {
+			return Config{}, errors.New("cluster mode requires a leader election lease name and namespace")
+		}
+		if le.RetryPeriod <= 0 || le.RenewDeadline <= le.RetryPeriod || le.LeaseDuration <= le.RenewDeadline {
+			return Config{}, errors.New("leader election needs leaseDuration > renewDeadline > retryPeriod > 0")
+		}
+	case ModeNode:
+		if cfg.ClusterID == "" {
+			return Config{}, errors.New("node mode requires a cluster id")
+		}
+	case ModeCentral:
+		if cfg.ClusterID == "" {
+			return Config{}, errors.New("central mode requires a cluster id")
+		}
+		if !strings.HasPrefix(cfg.Central.IngestPath, "/") {
+			return Config{}, fmt.Errorf("central ingest path %q must start with /", cfg.Central.IngestPath)
+		}
+		if cfg.Central.NodeTTL <= 0 {
+			return Config{}, errors.New("central node ttl must be positive")
+		}
+		if cfg.Central.MaxBodyBytes <= 0 {
+			return Config{}, errors.New("central max body bytes must be positive")
+		}
+		if cfg.Central.DedupeWindow <= 0 {
+			return Config{}, errors.New("central dedupe window must be positive")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown mode %q", cfg.Mode)
+	}
+
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		return Config{}, errors.New("tls cert and key files must be set together")
+	}
+	switch cfg.Server.AuthMode {
+	case "", AuthModeNone:
+		cfg.Server.AuthMode = AuthModeNone
+	case AuthModeToken:
+		if cfg.Server.AuthTokenFile == "" {
+			return Config{}, errors.New("auth mode token requires an auth token file")
+		}
+	case AuthModeKubernetes:
+	default:
+		return Config{}, fmt.Errorf("unknown auth mode %q", cfg.Server.AuthMode)
+	}
+	if cfg.Mode == ModeCentral && cfg.Central.AuthTokenFile == "" && cfg.Server.AuthMode == AuthModeNone {
+		// Anyone who reaches an open ingest path could book costs for any node.
+		return Config{}, errors.New("central mode requires ingest auth: set a central auth token file or a server auth mode")
+	}
+
+	// A central agent collects and forwards nothing itself, and cluster
+	// mode turns the eBPF collectors off, so their components never report.
+	collects := cfg.Mode != ModeCentral
+	metricsOn := collects && cfg.Mode != ModeCluster && cfg.Metrics.Enabled
+	networkOn := collects && cfg.Mode != ModeCluster && cfg.Network.Enabled
+	for _, component := range cfg.Server.ReadinessComponents {
+		var enabled bool
+		switch component {
+		case "informers":
+			enabled = collects
+		case "metrics_collector":
+			enabled = metricsOn
+		case "network_collector":
+			enabled = networkOn
+		case "ebpf":
+			enabled = metricsOn || networkOn
+		case "forwarder":
+			// The agent only forwards, and reports forwarder health, with
+			// an endpoint to send to.
+			enabled = collects && cfg.Remote.Enabled && cfg.Remote.EndpointURL != ""
+		default:
+			return Config{}, fmt.Errorf("unknown readiness component %q", component)
+		}
+		if !enabled {
+			return Config{}, fmt.Errorf("readiness component %q is disabled in mode %q", component, cfg.Mode)
+		}
+	}
+
+	if cfg.Remote.DeltaEnabled && cfg.Remote.KeyframeEvery <= 0 {
+		return Config{}, errors.New("remote delta encoding requires keyframeEvery > 0")
+	}
+	switch cfg.Remote.WireFormat {
+	case WireFormatJSON, WireFormatProtobuf:
+	default:
+		return Config{}, fmt.Errorf("unknown remote wire format %q", cfg.Remote.WireFormat)
+	}
+	if cfg.Remote.MaxQueueBytes < 0 || cfg.Remote.MaxQueueAge < 0 {
+		return Config{}, errors.New("remote queue limits must not be negative")
+	}
+	switch cfg.Remote.OverflowPolicy {
+	case OverflowDropOldest, OverflowDropNewest:
+	case OverflowDownsample:
+		if cfg.Remote.DownsampleEvery <= 0 {
+			return Config{}, errors.New("remote downsample policy requires downsampleEvery > 0")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown remote overflow policy %q", cfg.Remote.OverflowPolicy)
+	}
+
+	if cfg.RemoteWrite.Enabled && cfg.RemoteWrite.URL == "" {
+		return Config{}, errors.New("remote_write requires a url")
+	}
+	if cfg.OTLP.Enabled {
+		if cfg.OTLP.Endpoint == "" {
+			return Config{}, errors.New("otlp export requires an endpoint")
+		}
+		switch cfg.OTLP.Protocol {
+		case OTLPProtocolHTTP, OTLPProtocolGRPC:
+		default:
+			return Config{}, fmt.Errorf("unknown otlp protocol %q", cfg.OTLP.Protocol)
+		}
+		if cfg.OTLP.Interval < time.Second {
+			return Config{}, errors.New("otlp interval must be at least 1s")
+		}
+	}
+
+	if err := validateBudgets(&cfg); err != nil {
+		return Config{}, err
+	}
+	if cfg.Anomaly.Enabled {
+		if cfg.Anomaly.Sensitivity <= 0 {
+			return Config{}, errors.New("anomaly sensitivity must be positive")
+		}
+		if cfg.Anomaly.MinSamples < 3 {
+			return Config{}, errors.New("anomaly min samples must be at least 3")
+		}
+		for _, hook := range cfg.Anomaly.Webhooks {
+			if hook.URL == "" {
+				return Config{}, errors.New("anomaly webhook url is required")
+			}
+		}
+	}
+
+	if cfg.Allocation.Enabled {
+		if cfg.Allocation.Retention < time.Hour {
+			return Config{}, errors.New("allocation retention must be at least 1h")
+		}
+		if cfg.Allocation.GPUHourPriceUSD < 0 || cfg.Allocation.StorageGiBMonthPriceUSD < 0 {
+			return Config{}, errors.New("allocation prices must be non-negative")
+		}
+	}
+	if err := validateEnvironmentRules(cfg.Environment.Rules); err != nil {
+		return Config{}, err
+	}
+	if err := validateDimensions(cfg.Enricher.Dimensions); err != nil {
+		return Config{}, err
+	}
+	if cfg.Attribution.DefaultSharedWeight < 0 {
+		return Config{}, errors.New("attribution default shared weight must be non-negative")
+	}
+
+	if cfg.Prometheus.MaxPodSeries < 0 || cfg.Prometheus.MaxConnectionSeries < 0 {
+		return Config{}, errors.New("prometheus series limits must be non-negative")
+	}
+	for _, level := range cfg.Prometheus.ConnectionLevels {
+		switch level {
+		case "pod", "workload", "namespace", "service":
+		default:
+			return Config{}, fmt.Errorf("unknown prometheus connection level %q", level)
+		}
+	}
+
 	if cfg.ScrapeIntervalSeconds < 5 {
 		cfg.ScrapeIntervalSeconds = 5
 	}
@@ -279,10 +925,33 @@ func loadFromFile(path string, cfg *Config) error {
 	}
 
 	mergeConfigs(cfg, Config(fileCfg))
+
+	// Accumulation and allocation default to on, so the file must be able
+	// to turn them off, which a plain bool cannot tell apart from unset.
+	var switches struct {
+		Accumulation struct {
+			Enabled *bool `yaml:"enabled"`
+		} `yaml:"accumulation"`
+		Allocation struct {
+			Enabled *bool `yaml:"enabled"`
+		} `yaml:"allocation"`
+	}
+	if err := yaml.Unmarshal(data, &switches); err != nil {
+		return fmt.Errorf("parse config file: %w", err)
+	}
+	if switches.Accumulation.Enabled != nil {
+		cfg.Accumulation.Enabled = *switches.Accumulation.Enabled
+	}
+	if switches.Allocation.Enabled != nil {
+		cfg.Allocation.Enabled = *switches.Allocation.Enabled
+	}
 	return nil
 }
 
 func mergeConfigs(base *Config, override Config) {
+	if override.Mode != "" {
+		base.Mode = override.Mode
+	}
 	if override.ClusterID != "" {
 		base.ClusterID = override.ClusterID
 	}
@@ -344,10 +1013,28 @@ func mergeConfigs(base *Config, override Config) {
 	mergeNetworkConfig(&base.Network, override.Network)
 	mergeMetricsConfig(&base.Metrics, override.Metrics)
 	mergeRemoteConfig(&base.Remote, override.Remote)
+	mergeCentralConfig(&base.Central, override.Central)
+	mergeLeaderElectionConfig(&base.LeaderElection, override.LeaderElection)
 	mergeEnvironmentConfig(&base.Environment, override.Environment)
+	mergeAccumulationConfig(&base.Accumulation, override.Accumulation)
+	mergeServerConfig(&base.Server, override.Server)
+	mergePrometheusConfig(&base.Prometheus, override.Prometheus)
+	mergeOTLPConfig(&base.OTLP, override.OTLP)
+	mergeRemoteWriteConfig(&base.RemoteWrite, override.RemoteWrite)
+	mergeBudgetsConfig(&base.Budgets, override.Budgets)
+	mergeAnomalyConfig(&base.Anomaly, override.Anomaly)
+	mergeCRDsConfig(&base.CRDs, override.CRDs)
+	mergeAttributionConfig(&base.Attribution, override.Attribution)
+	mergeAllocationConfig(&base.Allocation, override.Allocation)
+	if override.Enricher.Dimensions != nil {
+		base.Enricher.Dimensions = append([]DimensionConfig{}, override.Enricher.Dimensions...)
+	}
 }
 
 func applyEnvOverrides(cfg *Config) {
+	if v := os.Getenv("CLUSTERCOST_MODE"); v != "" {
+		cfg.Mode = v
+	}
 	if v := os.Getenv("CLUSTERCOST_CLUSTER_ID"); v != "" {
 		cfg.ClusterID = v
 	}
@@ -492,6 +1179,313 @@ func applyEnvOverrides(cfg *Config) {
 			cfg.Remote.GzipEnabled = bv
 		}
 	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_DELTA"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.Remote.DeltaEnabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_KEYFRAME_EVERY"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.Remote.KeyframeEvery = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WIRE_FORMAT"); v != "" {
+		cfg.Remote.WireFormat = v
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_ZSTD"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.Remote.ZstdEnabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_MAX_QUEUE_BYTES"); v != "" {
+		if iv, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.Remote.MaxQueueBytes = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_MAX_QUEUE_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Remote.MaxQueueAge = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_OVERFLOW_POLICY"); v != "" {
+		cfg.Remote.OverflowPolicy = v
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_DOWNSAMPLE_EVERY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Remote.DownsampleEvery = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_CENTRAL_INGEST_PATH"); v != "" {
+		cfg.Central.IngestPath = v
+	}
+	if v := os.Getenv("CLUSTERCOST_CENTRAL_AUTH_TOKEN_FILE"); v != "" {
+		cfg.Central.AuthTokenFile = v
+	}
+	if v := os.Getenv("CLUSTERCOST_CENTRAL_NODE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Central.NodeTTL = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_CENTRAL_MAX_BODY_BYTES"); v != "" {
+		if iv, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.Central.MaxBodyBytes = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_CENTRAL_DEDUPE_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Central.DedupeWindow = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_LEADER_ELECTION_LEASE"); v != "" {
+		cfg.LeaderElection.LeaseName = v
+	}
+	if v := os.Getenv("CLUSTERCOST_LEADER_ELECTION_NAMESPACE"); v != "" {
+		cfg.LeaderElection.LeaseNamespace = v
+	}
+	if v := os.Getenv("CLUSTERCOST_LEADER_ELECTION_LEASE_DURATION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.LeaderElection.LeaseDuration = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_LEADER_ELECTION_RENEW_DEADLINE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.LeaderElection.RenewDeadline = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_LEADER_ELECTION_RETRY_PERIOD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.LeaderElection.RetryPeriod = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ACCUMULATION_ENABLED"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.Accumulation.Enabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ACCUMULATION_CHECKPOINT"); v != "" {
+		cfg.Accumulation.CheckpointPath = v
+	}
+	if v := os.Getenv("CLUSTERCOST_ACCUMULATION_MAX_GAP"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Accumulation.MaxGap = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ACCUMULATION_TIMEZONE"); v != "" {
+		cfg.Accumulation.Timezone = v
+	}
+	if v := os.Getenv("CLUSTERCOST_ACCUMULATION_RETENTION"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.Accumulation.Retention = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_TLS_CERT_FILE"); v != "" {
+		cfg.Server.TLSCertFile = v
+	}
+	if v := os.Getenv("CLUSTERCOST_TLS_KEY_FILE"); v != "" {
+		cfg.Server.TLSKeyFile = v
+	}
+	if v := os.Getenv("CLUSTERCOST_TLS_RELOAD_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Server.TLSReloadInterval = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_AUTH_MODE"); v != "" {
+		cfg.Server.AuthMode = v
+	}
+	if v := os.Getenv("CLUSTERCOST_AUTH_TOKEN_FILE"); v != "" {
+		cfg.Server.AuthTokenFile = v
+	}
+	if v := os.Getenv("CLUSTERCOST_AUTH_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Server.AuthCacheTTL = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_ENABLED"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.RemoteWrite.Enabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_URL"); v != "" {
+		cfg.RemoteWrite.URL = v
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_AUTH_TOKEN"); v != "" {
+		cfg.RemoteWrite.AuthToken = v
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_HEADERS"); v != "" {
+		if parsed, err := parseHeaders(v); err == nil {
+			cfg.RemoteWrite.Headers = parsed
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_EXTERNAL_LABELS"); v != "" {
+		if parsed, err := parseHeaders(v); err == nil {
+			cfg.RemoteWrite.ExternalLabels = parsed
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.RemoteWrite.Timeout = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_QUEUE_DIR"); v != "" {
+		cfg.RemoteWrite.QueueDir = v
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_FLUSH_EVERY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.RemoteWrite.FlushEvery = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_MAX_BATCH"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.RemoteWrite.MaxBatch = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_MAX_RETRIES"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.RemoteWrite.MaxRetries = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_REMOTE_WRITE_BACKOFF"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.RemoteWrite.Backoff = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_BUDGET_WEBHOOK_URLS"); v != "" {
+		cfg.Budgets.Webhooks = nil
+		for _, url := range splitList(v) {
+			cfg.Budgets.Webhooks = append(cfg.Budgets.Webhooks, WebhookConfig{URL: url})
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_BUDGET_RENOTIFY_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Budgets.RenotifyInterval = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_BUDGET_STATE_PATH"); v != "" {
+		cfg.Budgets.StatePath = v
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_ENABLED"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.Anomaly.Enabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Anomaly.Window = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_MIN_SAMPLES"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.Anomaly.MinSamples = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_SENSITIVITY"); v != "" {
+		if fv, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Anomaly.Sensitivity = fv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_MIN_INCREASE"); v != "" {
+		if fv, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Anomaly.MinIncrease = fv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_EVENTS"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.Anomaly.Events = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ANOMALY_WEBHOOK_URLS"); v != "" {
+		cfg.Anomaly.Webhooks = nil
+		for _, url := range splitList(v) {
+			cfg.Anomaly.Webhooks = append(cfg.Anomaly.Webhooks, WebhookConfig{URL: url})
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_CRDS_ENABLED"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.CRDs.Enabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_CRDS_RESYNC_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.CRDs.ResyncInterval = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ALLOCATION_ENABLED"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.Allocation.Enabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ALLOCATION_RETENTION"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.Allocation.Retention = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_ATTRIBUTION_DEFAULT_TEAM"); v != "" {
+		cfg.Attribution.DefaultTeam = v
+	}
+	if v := os.Getenv("CLUSTERCOST_ATTRIBUTION_DEFAULT_COST_CENTER"); v != "" {
+		cfg.Attribution.DefaultCostCenter = v
+	}
+	if v := os.Getenv("CLUSTERCOST_OTLP_ENABLED"); v != "" {
+		if bv, err := strconv.ParseBool(v); err == nil {
+			cfg.OTLP.Enabled = bv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_OTLP_ENDPOINT"); v != "" {
+		cfg.OTLP.Endpoint = v
+	}
+	if v := os.Getenv("CLUSTERCOST_OTLP_PROTOCOL"); v != "" {
+		cfg.OTLP.Protocol = v
+	}
+	if v := os.Getenv("CLUSTERCOST_OTLP_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.OTLP.Interval = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_OTLP_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.OTLP.Timeout = d
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_OTLP_HEADERS"); v != "" {
+		if parsed, err := parseHeaders(v); err == nil {
+			cfg.OTLP.Headers = parsed
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_READINESS_COMPONENTS"); v != "" {
+		cfg.Server.ReadinessComponents = splitList(v)
+	}
+	if v := os.Getenv("CLUSTERCOST_PROMETHEUS_LABEL_KEYS"); v != "" {
+		cfg.Prometheus.LabelKeys = splitList(v)
+	}
+	if v := os.Getenv("CLUSTERCOST_PROMETHEUS_MAX_POD_SERIES"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.Prometheus.MaxPodSeries = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_PROMETHEUS_MAX_CONNECTION_SERIES"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.Prometheus.MaxConnectionSeries = iv
+		}
+	}
+	if v := os.Getenv("CLUSTERCOST_PROMETHEUS_CONNECTION_LEVELS"); v != "" {
+		cfg.Prometheus.ConnectionLevels = splitList(v)
+	}
+	if v := os.Getenv("CLUSTERCOST_MAX_STREAM_CLIENTS"); v != "" {
+		if iv, err := strconv.Atoi(v); err == nil {
+			cfg.Server.MaxStreamClients = iv
+		}
+	}
+}
+
+// splitList parses a comma-separated env value, dropping empty items.
+func splitList(raw string) []string {
+	var out []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
 
 func envOrDefault(key, def string) string {
@@ -527,6 +1521,21 @@ func parseInstancePrices(raw string) (map[string]float64, error) {
 	return parsed, nil
 }
 
+// parseHeaders reads the key=value,key2=value2 format of
+// OTEL_EXPORTER_OTLP_HEADERS.
+func parseHeaders(raw string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range splitList(raw) {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header %q, want key=value", pair)
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
 func parseNetworkPrices(raw string) (map[string]float64, error) {
 	if raw == "" {
 		return nil, nil
@@ -539,6 +1548,9 @@ func parseNetworkPrices(raw string) (map[string]float64, error) {
 }
 
 func mergeEnvironmentConfig(base *EnvironmentConfig, override EnvironmentConfig) {
+	if len(override.Rules) > 0 {
+		base.Rules = append([]EnvironmentRuleConfig{}, override.Rules...)
+	}
 	if len(override.LabelKeys) > 0 {
 		base.LabelKeys = append([]string{}, override.LabelKeys...)
 	}
@@ -643,4 +1655,381 @@ func mergeRemoteConfig(base *RemoteConfig, override RemoteConfig) {
 	if override.GzipEnabled {
 		base.GzipEnabled = override.GzipEnabled
 	}
+	if override.DeltaEnabled {
+		base.DeltaEnabled = override.DeltaEnabled
+	}
+	if override.KeyframeEvery != 0 {
+		base.KeyframeEvery = override.KeyframeEvery
+	}
+	if override.WireFormat != "" {
+		base.WireFormat = override.WireFormat
+	}
+	if override.ZstdEnabled {
+		base.ZstdEnabled = override.ZstdEnabled
+	}
+	if override.MaxQueueBytes != 0 {
+		base.MaxQueueBytes = override.MaxQueueBytes
+	}
+	if override.MaxQueueAge != 0 {
+		base.MaxQueueAge = override.MaxQueueAge
+	}
+	if override.OverflowPolicy != "" {
+		base.OverflowPolicy = override.OverflowPolicy
+	}
+	if override.DownsampleEvery != 0 {
+		base.DownsampleEvery = override.DownsampleEvery
+	}
+}
+
+func mergeCentralConfig(base *CentralConfig, override CentralConfig) {
+	if override.IngestPath != "" {
+		base.IngestPath = override.IngestPath
+	}
+	if override.AuthTokenFile != "" {
+		base.AuthTokenFile = override.AuthTokenFile
+	}
+	if override.NodeTTL != 0 {
+		base.NodeTTL = override.NodeTTL
+	}
+	if override.MaxBodyBytes != 0 {
+		base.MaxBodyBytes = override.MaxBodyBytes
+	}
+	if override.DedupeWindow != 0 {
+		base.DedupeWindow = override.DedupeWindow
+	}
+}
+
+func mergeLeaderElectionConfig(base *LeaderElectionConfig, override LeaderElectionConfig) {
+	if override.LeaseName != "" {
+		base.LeaseName = override.LeaseName
+	}
+	if override.LeaseNamespace != "" {
+		base.LeaseNamespace = override.LeaseNamespace
+	}
+	if override.LeaseDuration != 0 {
+		base.LeaseDuration = override.LeaseDuration
+	}
+	if override.RenewDeadline != 0 {
+		base.RenewDeadline = override.RenewDeadline
+	}
+	if override.RetryPeriod != 0 {
+		base.RetryPeriod = override.RetryPeriod
+	}
+}
+
+func mergeAccumulationConfig(base *AccumulationConfig, override AccumulationConfig) {
+	if override.CheckpointPath != "" {
+		base.CheckpointPath = override.CheckpointPath
+	}
+	if override.MaxGap != 0 {
+		base.MaxGap = override.MaxGap
+	}
+	if override.Timezone != "" {
+		base.Timezone = override.Timezone
+	}
+	if override.Retention != 0 {
+		base.Retention = override.Retention
+	}
+}
+
+func mergeServerConfig(base *ServerConfig, override ServerConfig) {
+	if override.TLSCertFile != "" {
+		base.TLSCertFile = override.TLSCertFile
+	}
+	if override.TLSKeyFile != "" {
+		base.TLSKeyFile = override.TLSKeyFile
+	}
+	if override.TLSReloadInterval != 0 {
+		base.TLSReloadInterval = override.TLSReloadInterval
+	}
+	if override.AuthMode != "" {
+		base.AuthMode = override.AuthMode
+	}
+	if override.AuthTokenFile != "" {
+		base.AuthTokenFile = override.AuthTokenFile
+	}
+	if override.AuthCacheTTL != 0 {
+		base.AuthCacheTTL = override.AuthCacheTTL
+	}
+	if override.MaxStreamClients != 0 {
+		base.MaxStreamClients = override.MaxStreamClients
+	}
+	if override.ReadinessComponents != nil {
+		base.ReadinessComponents = append([]string{}, override.ReadinessComponents...)
+	}
+}
+
+func mergePrometheusConfig(base *PrometheusConfig, override PrometheusConfig) {
+	if override.LabelKeys != nil {
+		base.LabelKeys = append([]string{}, override.LabelKeys...)
+	}
+	if override.MaxPodSeries != 0 {
+		base.MaxPodSeries = override.MaxPodSeries
+	}
+	if override.MaxConnectionSeries != 0 {
+		base.MaxConnectionSeries = override.MaxConnectionSeries
+	}
+	if override.ConnectionLevels != nil {
+		base.ConnectionLevels = append([]string{}, override.ConnectionLevels...)
+	}
+}
+
+func mergeOTLPConfig(base *OTLPConfig, override OTLPConfig) {
+	if override.Enabled {
+		base.Enabled = override.Enabled
+	}
+	if override.Endpoint != "" {
+		base.Endpoint = override.Endpoint
+	}
+	if override.Protocol != "" {
+		base.Protocol = override.Protocol
+	}
+	if override.Interval != 0 {
+		base.Interval = override.Interval
+	}
+	if override.Timeout != 0 {
+		base.Timeout = override.Timeout
+	}
+	if override.Headers != nil {
+		if base.Headers == nil {
+			base.Headers = map[string]string{}
+		}
+		for k, v := range override.Headers {
+			base.Headers[k] = v
+		}
+	}
+}
+
+func mergeRemoteWriteConfig(base *RemoteWriteConfig, override RemoteWriteConfig) {
+	if override.Enabled {
+		base.Enabled = override.Enabled
+	}
+	if override.URL != "" {
+		base.URL = override.URL
+	}
+	if override.AuthToken != "" {
+		base.AuthToken = override.AuthToken
+	}
+	if override.Headers != nil {
+		if base.Headers == nil {
+			base.Headers = map[string]string{}
+		}
+		for k, v := range override.Headers {
+			base.Headers[k] = v
+		}
+	}
+	if override.ExternalLabels != nil {
+		if base.ExternalLabels == nil {
+			base.ExternalLabels = map[string]string{}
+		}
+		for k, v := range override.ExternalLabels {
+			base.ExternalLabels[k] = v
+		}
+	}
+	if override.Timeout != 0 {
+		base.Timeout = override.Timeout
+	}
+	if override.QueueDir != "" {
+		base.QueueDir = override.QueueDir
+	}
+	if override.FlushEvery != 0 {
+		base.FlushEvery = override.FlushEvery
+	}
+	if override.MaxBatch != 0 {
+		base.MaxBatch = override.MaxBatch
+	}
+	if override.MaxRetries != 0 {
+		base.MaxRetries = override.MaxRetries
+	}
+	if override.Backoff != 0 {
+		base.Backoff = override.Backoff
+	}
+}
+
+func mergeBudgetsConfig(base *BudgetsConfig, override BudgetsConfig) {
+	if override.Definitions != nil {
+		base.Definitions = append([]BudgetConfig{}, override.Definitions...)
+	}
+	if override.Webhooks != nil {
+		base.Webhooks = append([]WebhookConfig{}, override.Webhooks...)
+	}
+	if override.RenotifyInterval != 0 {
+		base.RenotifyInterval = override.RenotifyInterval
+	}
+	if override.Timeout != 0 {
+		base.Timeout = override.Timeout
+	}
+	if override.StatePath != "" {
+		base.StatePath = override.StatePath
+	}
+}
+
+// validateBudgets checks budget definitions and fills in default periods
+// and thresholds. Label selectors are parsed when the budgets are built.
+func validateBudgets(cfg *Config) error {
+	if len(cfg.Budgets.Definitions) == 0 {
+		return nil
+	}
+	if !cfg.Accumulation.Enabled {
+		return errors.New("budgets require accumulation to be enabled")
+	}
+	seen := map[string]bool{}
+	for i := range cfg.Budgets.Definitions {
+		b := &cfg.Budgets.Definitions[i]
+		if err := ValidateBudget(b); err != nil {
+			return err
+		}
+		if seen[b.Name] {
+			return fmt.Errorf("duplicate budget %q", b.Name)
+		}
+		seen[b.Name] = true
+	}
+	for _, hook := range cfg.Budgets.Webhooks {
+		if hook.URL == "" {
+			return errors.New("budget webhook url is required")
+		}
+	}
+	return nil
+}
+
+func validateEnvironmentRules(rules []EnvironmentRuleConfig) error {
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
+		}
+		if strings.TrimSpace(rule.Environment) == "" {
+			return fmt.Errorf("environment rule %q has no environment", name)
+		}
+		if rule.NamespaceRegex == "" && rule.Selector == "" && len(rule.Annotations) == 0 {
+			return fmt.Errorf("environment rule %q has no conditions", name)
+		}
+		if _, err := regexp.Compile(rule.NamespaceRegex); err != nil {
+			return fmt.Errorf("environment rule %q namespace regex: %w", name, err)
+		}
+		for key, pattern := range rule.Annotations {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("environment rule %q annotation %q: %w", name, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateDimensions(dims []DimensionConfig) error {
+	seen := map[string]bool{}
+	for _, d := range dims {
+		if d.Name == "" {
+			return errors.New("enricher dimension name is required")
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("duplicate enricher dimension %q", d.Name)
+		}
+		seen[d.Name] = true
+		if len(d.Sources) == 0 && d.Default == "" {
+			return fmt.Errorf("enricher dimension %q needs sources or a default", d.Name)
+		}
+		for _, src := range d.Sources {
+			key := strings.TrimPrefix(strings.TrimPrefix(src, "annotation:"), "label:")
+			if key == "" {
+				return fmt.Errorf("enricher dimension %q has an empty source", d.Name)
+			}
+		}
+		for _, rw := range d.Rewrites {
+			if _, err := regexp.Compile(rw.Match); err != nil {
+				return fmt.Errorf("enricher dimension %q rewrite: %w", d.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateBudget checks a single budget definition and fills in the default
+// period and thresholds.
+func ValidateBudget(b *BudgetConfig) error {
+	if b.Name == "" {
+		return errors.New("budget name is required")
+	}
+	if b.Amount <= 0 {
+		return fmt.Errorf("budget %q amount must be positive", b.Name)
+	}
+	switch b.Period {
+	case "":
+		b.Period = "month"
+	case "day", "week", "month":
+	default:
+		return fmt.Errorf("budget %q has unknown period %q", b.Name, b.Period)
+	}
+	if b.Thresholds == nil {
+		b.Thresholds = []float64{50, 80, 100}
+	}
+	for _, threshold := range b.Thresholds {
+		if threshold <= 0 {
+			return fmt.Errorf("budget %q thresholds must be positive", b.Name)
+		}
+	}
+	return nil
+}
+
+func mergeAnomalyConfig(base *AnomalyConfig, override AnomalyConfig) {
+	if override.Enabled {
+		base.Enabled = override.Enabled
+	}
+	if override.Window != 0 {
+		base.Window = override.Window
+	}
+	if override.MinSamples != 0 {
+		base.MinSamples = override.MinSamples
+	}
+	if override.Sensitivity != 0 {
+		base.Sensitivity = override.Sensitivity
+	}
+	if override.MinIncrease != 0 {
+		base.MinIncrease = override.MinIncrease
+	}
+	if override.TopContributors != 0 {
+		base.TopContributors = override.TopContributors
+	}
+	if override.Events {
+		base.Events = override.Events
+	}
+	if override.Webhooks != nil {
+		base.Webhooks = append([]WebhookConfig{}, override.Webhooks...)
+	}
+	if override.Timeout != 0 {
+		base.Timeout = override.Timeout
+	}
+}
+
+func mergeCRDsConfig(base *CRDsConfig, override CRDsConfig) {
+	if override.Enabled {
+		base.Enabled = override.Enabled
+	}
+	if override.ResyncInterval != 0 {
+		base.ResyncInterval = override.ResyncInterval
+	}
+}
+
+func mergeAttributionConfig(base *AttributionConfig, override AttributionConfig) {
+	if override.DefaultTeam != "" {
+		base.DefaultTeam = override.DefaultTeam
+	}
+	if override.DefaultCostCenter != "" {
+		base.DefaultCostCenter = override.DefaultCostCenter
+	}
+	if override.DefaultSharedWeight != 0 {
+		base.DefaultSharedWeight = override.DefaultSharedWeight
+	}
+}
+
+func mergeAllocationConfig(base *AllocationConfig, override AllocationConfig) {
+	if override.Retention != 0 {
+		base.Retention = override.Retention
+	}
+	if override.GPUHourPriceUSD != 0 {
+		base.GPUHourPriceUSD = override.GPUHourPriceUSD
+	}
+	if override.StorageGiBMonthPriceUSD != 0 {
+		base.StorageGiBMonthPriceUSD = override.StorageGiBMonthPriceUSD
+	}
 }
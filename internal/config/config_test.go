@@ -51,3 +51,69 @@ pricing:
 		t.Fatalf("expected memory price 0.02, got %f", cfg.Pricing.MemoryGiBHourPriceUSD)
 	}
 }
+
+func TestLoadFileTurnsOffDefaultFeatures(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(cfgFile, []byte(`
+accumulation:
+  enabled: false
+allocation:
+  enabled: false
+`), 0o644)
+	if err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	t.Setenv("CLUSTERCOST_CONFIG_FILE", cfgFile)
+
+	cfg, err := LoadArgs(nil)
+	if err != nil {
+		t.Fatalf("LoadArgs() error = %v", err)
+	}
+	if cfg.Accumulation.Enabled || cfg.Allocation.Enabled {
+		t.Fatalf("expected both features off, got accumulation %v allocation %v", cfg.Accumulation.Enabled, cfg.Allocation.Enabled)
+	}
+}
+
+func TestLoadArgsForwarderReadinessNeedsEndpoint(t *testing.T) {
+	args := []string{"--remote-enabled", "--readiness-components", "forwarder"}
+	if _, err := LoadArgs(args); err == nil {
+		t.Fatal("expected forwarder readiness without an endpoint to be rejected")
+	}
+	if _, err := LoadArgs(append(args, "--remote-endpoint", "http://central:8080/ingest")); err != nil {
+		t.Fatalf("LoadArgs() error = %v", err)
+	}
+}
+
+func TestLoadArgsCentralNeedsIngestAuth(t *testing.T) {
+	args := []string{"--mode", "central", "--cluster-id", "prod"}
+	if _, err := LoadArgs(args); err == nil {
+		t.Fatal("expected central mode without ingest auth to be rejected")
+	}
+	if _, err := LoadArgs(append(args, "--central-auth-token-file", "/etc/clustercost/ingest-tokens")); err != nil {
+		t.Fatalf("central mode with an ingest token file: %v", err)
+	}
+	if _, err := LoadArgs(append(args, "--auth-mode", "kubernetes")); err != nil {
+		t.Fatalf("central mode with API auth: %v", err)
+	}
+}
+
+func TestLoadArgsReadinessFollowsMode(t *testing.T) {
+	ebpf := []string{"--enable-ebpf-metrics", "--readiness-components", "ebpf,metrics_collector"}
+	if _, err := LoadArgs(ebpf); err != nil {
+		t.Fatalf("eBPF readiness in agent mode: %v", err)
+	}
+	cluster := []string{"--enable-ebpf-metrics", "--mode", "cluster", "--cluster-id", "prod"}
+	if _, err := LoadArgs(append(cluster, "--readiness-components", "informers")); err != nil {
+		t.Fatalf("informers readiness in cluster mode: %v", err)
+	}
+	if _, err := LoadArgs(append(cluster, "--readiness-components", "ebpf")); err == nil {
+		t.Fatal("expected eBPF readiness to be rejected in cluster mode")
+	}
+	central := []string{"--mode", "central", "--cluster-id", "prod", "--auth-mode", "kubernetes"}
+	if _, err := LoadArgs(central); err != nil {
+		t.Fatalf("central mode: %v", err)
+	}
+	if _, err := LoadArgs(append(central, "--readiness-components", "informers")); err == nil {
+		t.Fatal("expected informers readiness to be rejected in central mode")
+	}
+}
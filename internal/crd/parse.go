@@ -0,0 +1,175 @@
+package crd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type pricingOverride struct {
+	instancePrices map[string]float64
+	rules          []snapshot.NodePriceRule
+	egressPrices   map[string]float64
+}
+
+func parsePricingOverride(obj *unstructured.Unstructured) (pricingOverride, error) {
+	var spec PricingOverrideSpec
+	if err := decodeSpec(obj, &spec); err != nil {
+		return pricingOverride{}, err
+	}
+	if len(spec.InstancePrices) == 0 && len(spec.NodePrices) == 0 && len(spec.EgressGiBPrices) == 0 {
+		return pricingOverride{}, errors.New("spec sets no prices")
+	}
+	for instanceType, price := range spec.InstancePrices {
+		if price < 0 {
+			return pricingOverride{}, fmt.Errorf("instance type %q has a negative price", instanceType)
+		}
+	}
+	for class, price := range spec.EgressGiBPrices {
+		if price < 0 {
+			return pricingOverride{}, fmt.Errorf("traffic class %q has a negative price", class)
+		}
+	}
+	out := pricingOverride{instancePrices: spec.InstancePrices, egressPrices: spec.EgressGiBPrices}
+	for i, np := range spec.NodePrices {
+		if len(np.NodeSelector.MatchLabels) == 0 && len(np.NodeSelector.MatchExpressions) == 0 {
+			return pricingOverride{}, fmt.Errorf("nodePrices[%d] has an empty node selector", i)
+		}
+		if np.HourlyPrice < 0 {
+			return pricingOverride{}, fmt.Errorf("nodePrices[%d] has a negative price", i)
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&np.NodeSelector)
+		if err != nil {
+			return pricingOverride{}, fmt.Errorf("nodePrices[%d] selector: %w", i, err)
+		}
+		out.rules = append(out.rules, snapshot.NodePriceRule{Selector: selector, HourlyPrice: np.HourlyPrice})
+	}
+	return out, nil
+}
+
+// matches counts the nodes and traffic classes in snap the override prices.
+func (o pricingOverride) matches(snap snapshot.Snapshot) (nodes, classes int) {
+	for _, node := range snap.Nodes {
+		if _, ok := lookup(o.instancePrices, node.InstanceType); ok {
+			nodes++
+			continue
+		}
+		for _, rule := range o.rules {
+			if rule.Selector.Matches(labels.Set(node.Labels)) {
+				nodes++
+				break
+			}
+		}
+	}
+	for _, class := range snap.Network.ByClass {
+		if _, ok := lookup(o.egressPrices, class.Class); ok {
+			classes++
+		}
+	}
+	return nodes, classes
+}
+
+func parseBudget(obj *unstructured.Unstructured) (config.BudgetConfig, error) {
+	var spec BudgetSpec
+	if err := decodeSpec(obj, &spec); err != nil {
+		return config.BudgetConfig{}, err
+	}
+	b := config.BudgetConfig{
+		Name:        obj.GetName(),
+		Namespace:   spec.Namespace,
+		Environment: spec.Environment,
+		Period:      spec.Period,
+		Amount:      spec.Amount,
+		Thresholds:  spec.Thresholds,
+	}
+	if spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+		if err != nil {
+			return config.BudgetConfig{}, fmt.Errorf("namespace selector: %w", err)
+		}
+		if !selector.Empty() {
+			b.Selector = selector.String()
+		}
+	}
+	if err := config.ValidateBudget(&b); err != nil {
+		return config.BudgetConfig{}, err
+	}
+	return b, nil
+}
+
+// budgetMatches reports whether a namespace falls in the budget scope.
+func budgetMatches(b config.BudgetConfig, ns snapshot.NamespaceCostRecord) bool {
+	if b.Namespace != "" && b.Namespace != ns.Namespace {
+		return false
+	}
+	if b.Environment != "" && b.Environment != ns.Environment {
+		return false
+	}
+	if b.Selector == "" {
+		return true
+	}
+	selector, err := labels.Parse(b.Selector)
+	return err == nil && selector.Matches(labels.Set(ns.Labels))
+}
+
+func parseAllocationPolicy(obj *unstructured.Unstructured) (snapshot.SharedCostPolicy, error) {
+	var spec AllocationPolicySpec
+	if err := decodeSpec(obj, &spec); err != nil {
+		return snapshot.SharedCostPolicy{}, err
+	}
+	policy := snapshot.SharedCostPolicy{Name: obj.GetName(), Namespaces: spec.SharedNamespaces}
+	switch snapshot.SplitMode(spec.Split) {
+	case "", snapshot.SplitProportional:
+		policy.Split = snapshot.SplitProportional
+	case snapshot.SplitEven, snapshot.SplitWeighted:
+		policy.Split = snapshot.SplitMode(spec.Split)
+	default:
+		return snapshot.SharedCostPolicy{}, fmt.Errorf("unknown split %q", spec.Split)
+	}
+	var err error
+	if spec.SharedNamespaceSelector != nil {
+		if policy.Selector, err = metav1.LabelSelectorAsSelector(spec.SharedNamespaceSelector); err != nil {
+			return snapshot.SharedCostPolicy{}, fmt.Errorf("shared namespace selector: %w", err)
+		}
+		if policy.Selector.Empty() {
+			return snapshot.SharedCostPolicy{}, errors.New("shared namespace selector is empty")
+		}
+	}
+	if len(policy.Namespaces) == 0 && policy.Selector == nil {
+		return snapshot.SharedCostPolicy{}, errors.New("spec selects no shared namespaces")
+	}
+	if spec.TargetNamespaceSelector != nil {
+		if policy.Targets, err = metav1.LabelSelectorAsSelector(spec.TargetNamespaceSelector); err != nil {
+			return snapshot.SharedCostPolicy{}, fmt.Errorf("target namespace selector: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+func decodeSpec(obj *unstructured.Unstructured, out any) error {
+	spec, _, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return fmt.Errorf("read spec: %w", err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, out); err != nil {
+		return fmt.Errorf("decode spec: %w", err)
+	}
+	return nil
+}
+
+func lookup(prices map[string]float64, key string) (float64, bool) {
+	for k, v := range prices {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return 0, false
+}
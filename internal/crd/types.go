@@ -0,0 +1,72 @@
+// Package crd reads clustercost.io custom resources through dynamic
+// informers and turns them into pricing overrides, budgets and shared cost
+// policies for the next snapshot.
+package crd
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Group and Version of the clustercost.io custom resources.
+const (
+	Group   = "clustercost.io"
+	Version = "v1alpha1"
+)
+
+// Resources served by the clustercost.io CRDs. All of them are cluster scoped.
+var (
+	PricingOverrides = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "costpricingoverrides"}
+	Budgets          = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "costbudgets"}
+	AllocationPolicy = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "costallocationpolicies"}
+)
+
+// PricingOverrideSpec is the spec of a CostPricingOverride. Prices are in
+// USD and layer over the agent configuration.
+type PricingOverrideSpec struct {
+	// InstancePrices are hourly node prices by instance type.
+	InstancePrices map[string]float64 `json:"instancePrices,omitempty"`
+	// NodePrices price nodes by label, ahead of their instance type.
+	NodePrices []NodePriceSpec `json:"nodePrices,omitempty"`
+	// EgressGiBPrices are per-GiB prices by traffic class.
+	EgressGiBPrices map[string]float64 `json:"egressGiBPrices,omitempty"`
+}
+
+// NodePriceSpec prices every node matching NodeSelector.
+type NodePriceSpec struct {
+	NodeSelector metav1.LabelSelector `json:"nodeSelector"`
+	HourlyPrice  float64              `json:"hourlyPrice"`
+}
+
+// BudgetSpec is the spec of a CostBudget. The object name is the budget name.
+type BudgetSpec struct {
+	Namespace         string                `json:"namespace,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	Environment       string                `json:"environment,omitempty"`
+	Period            string                `json:"period,omitempty"`
+	Amount            float64               `json:"amount"`
+	Thresholds        []float64             `json:"thresholds,omitempty"`
+}
+
+// AllocationPolicySpec is the spec of a CostAllocationPolicy. It moves the
+// cost of shared namespaces onto the namespaces selected as targets.
+type AllocationPolicySpec struct {
+	SharedNamespaces        []string              `json:"sharedNamespaces,omitempty"`
+	SharedNamespaceSelector *metav1.LabelSelector `json:"sharedNamespaceSelector,omitempty"`
+	// TargetNamespaceSelector defaults to every namespace that is not shared.
+	TargetNamespaceSelector *metav1.LabelSelector `json:"targetNamespaceSelector,omitempty"`
+	// Split is "proportional" (default), "even", or "weighted" by the
+	// targets' clustercost.io/shared-weight annotation.
+	Split string `json:"split,omitempty"`
+}
+
+// Status is written to the status subresource of every object.
+type Status struct {
+	ObservedGeneration int64 `json:"observedGeneration"`
+	Valid              bool  `json:"valid"`
+	// Matched counts the resources the object applied to in the latest
+	// snapshot: nodes and traffic classes priced, namespaces in a budget's
+	// scope, or namespaces shared by a policy.
+	Matched int    `json:"matched"`
+	Message string `json:"message,omitempty"`
+}
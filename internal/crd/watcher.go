@@ -0,0 +1,284 @@
+package crd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var resources = []schema.GroupVersionResource{PricingOverrides, Budgets, AllocationPolicy}
+
+// Watcher caches the clustercost.io objects. The snapshot builder and the
+// budget evaluator read them on every snapshot, so edits take effect without
+// a restart, and Run reports on each object through its status.
+type Watcher struct {
+	client         dynamic.Interface
+	factory        dynamicinformer.DynamicSharedInformerFactory
+	listers        map[schema.GroupVersionResource]cache.GenericLister
+	synced         []cache.InformerSynced
+	prices         *snapshot.NodePriceLookup
+	netPrices      *snapshot.NetworkPriceLookup
+	configBudgets  map[string]bool
+	budgetsEnabled bool
+	store          *snapshot.Store
+	nodeName       string
+	logger         *slog.Logger
+}
+
+// NewWatcher builds informers for the clustercost.io resources. prices and
+// netPrices are the configured lookups that pricing overrides layer over.
+func NewWatcher(client dynamic.Interface, cfg config.Config, prices *snapshot.NodePriceLookup, netPrices *snapshot.NetworkPriceLookup, store *snapshot.Store, logger *slog.Logger) *Watcher {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, cfg.CRDs.ResyncInterval)
+	w := &Watcher{
+		client:         client,
+		factory:        factory,
+		listers:        map[schema.GroupVersionResource]cache.GenericLister{},
+		prices:         prices,
+		netPrices:      netPrices,
+		configBudgets:  map[string]bool{},
+		budgetsEnabled: cfg.Accumulation.Enabled,
+		store:          store,
+		logger:         logger,
+	}
+	for _, gvr := range resources {
+		informer := factory.ForResource(gvr)
+		w.listers[gvr] = informer.Lister()
+		w.synced = append(w.synced, informer.Informer().HasSynced)
+	}
+	for _, b := range cfg.Budgets.Definitions {
+		w.configBudgets[b.Name] = true
+	}
+	return w
+}
+
+// Start launches the informers without waiting for them to sync. Until they
+// do, snapshots are built from the configuration alone.
+func (w *Watcher) Start(ctx context.Context) {
+	w.factory.Start(ctx.Done())
+}
+
+// Overrides merges every valid pricing override and allocation policy.
+// Objects apply in name order: for the same instance type or traffic class
+// the later object wins, and node selectors are tried in order.
+func (w *Watcher) Overrides() snapshot.Overrides {
+	var out snapshot.Overrides
+	instancePrices := map[string]float64{}
+	egressPrices := map[string]float64{}
+	var rules []snapshot.NodePriceRule
+	pricing := false
+	for _, obj := range w.list(PricingOverrides) {
+		o, err := parsePricingOverride(obj)
+		if err != nil {
+			continue
+		}
+		pricing = true
+		for k, v := range o.instancePrices {
+			instancePrices[k] = v
+		}
+		for k, v := range o.egressPrices {
+			egressPrices[k] = v
+		}
+		rules = append(rules, o.rules...)
+	}
+	if pricing {
+		out.Prices = w.prices.Merge(instancePrices, rules)
+		out.NetPrices = w.netPrices.Merge(egressPrices)
+	}
+	for _, obj := range w.list(AllocationPolicy) {
+		if policy, err := parseAllocationPolicy(obj); err == nil {
+			out.SharedCost = append(out.SharedCost, policy)
+		}
+	}
+	return out
+}
+
+// Budgets returns every valid CostBudget.
+func (w *Watcher) Budgets() []config.BudgetConfig {
+	var out []config.BudgetConfig
+	for _, obj := range w.list(Budgets) {
+		if b, err := w.parseBudget(obj); err == nil {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func (w *Watcher) parseBudget(obj *unstructured.Unstructured) (config.BudgetConfig, error) {
+	if !w.budgetsEnabled {
+		return config.BudgetConfig{}, fmt.Errorf("budgets require accumulation to be enabled")
+	}
+	if w.configBudgets[obj.GetName()] {
+		return config.BudgetConfig{}, fmt.Errorf("budget %q is already defined in the agent configuration", obj.GetName())
+	}
+	return parseBudget(obj)
+}
+
+// list returns the cached objects of one resource sorted by name.
+func (w *Watcher) list(gvr schema.GroupVersionResource) []*unstructured.Unstructured {
+	objs, err := w.listers[gvr].List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	out := make([]*unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			out = append(out, u)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out
+}
+
+// SetNodeName marks the snapshots in the store as covering only node. A
+// watcher in node scope never writes status, since every agent of a
+// DaemonSet would report its own counts and costs.
+func (w *Watcher) SetNodeName(node string) {
+	w.nodeName = node
+}
+
+// Run writes object status after every published snapshot until ctx is
+// done. Status is only written when it changed. It returns at once in node
+// scope; a single cluster-wide agent reports on each object.
+func (w *Watcher) Run(ctx context.Context) {
+	if w.nodeName != "" {
+		return
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), w.synced...) {
+		return
+	}
+	sub := w.store.Subscribe()
+	defer sub.Close()
+	if snap, ok := w.store.Latest(); ok {
+		w.report(ctx, snap)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			w.report(ctx, snap)
+		}
+	}
+}
+
+func (w *Watcher) report(ctx context.Context, snap snapshot.Snapshot) {
+	for _, gvr := range resources {
+		for _, obj := range w.list(gvr) {
+			status := w.evaluate(gvr, obj, snap)
+			if current, ok := currentStatus(obj); ok && current == status {
+				continue
+			}
+			if !status.Valid {
+				w.logger.Warn("invalid clustercost.io object",
+					slog.String("resource", gvr.Resource),
+					slog.String("name", obj.GetName()),
+					slog.String("error", status.Message),
+				)
+			}
+			if err := w.writeStatus(ctx, gvr, obj, status); err != nil {
+				w.logger.Warn("failed to update status",
+					slog.String("resource", gvr.Resource),
+					slog.String("name", obj.GetName()),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+	}
+}
+
+func (w *Watcher) evaluate(gvr schema.GroupVersionResource, obj *unstructured.Unstructured, snap snapshot.Snapshot) Status {
+	status := Status{ObservedGeneration: obj.GetGeneration()}
+	switch gvr {
+	case PricingOverrides:
+		o, err := parsePricingOverride(obj)
+		if err != nil {
+			status.Message = err.Error()
+			return status
+		}
+		nodes, classes := o.matches(snap)
+		status.Matched = nodes + classes
+		status.Message = fmt.Sprintf("prices %d nodes and %d traffic classes", nodes, classes)
+	case Budgets:
+		b, err := w.parseBudget(obj)
+		if err != nil {
+			status.Message = err.Error()
+			return status
+		}
+		for _, ns := range snap.Namespaces {
+			if budgetMatches(b, ns) {
+				status.Matched++
+			}
+		}
+		status.Message = fmt.Sprintf("covers %d namespaces", status.Matched)
+	case AllocationPolicy:
+		policy, err := parseAllocationPolicy(obj)
+		if err != nil {
+			status.Message = err.Error()
+			return status
+		}
+		var targets int
+		for _, ns := range snap.Namespaces {
+			switch {
+			case policy.Shares(ns.Namespace, ns.Labels):
+				status.Matched++
+			case policy.Receives(ns.Namespace, ns.Labels):
+				targets++
+			}
+		}
+		status.Message = fmt.Sprintf("shares %d namespaces across %d targets", status.Matched, targets)
+	}
+	status.Valid = true
+	return status
+}
+
+func currentStatus(obj *unstructured.Unstructured) (Status, bool) {
+	content, found, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil || !found {
+		return Status{}, false
+	}
+	var status Status
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &status); err != nil {
+		return Status{}, false
+	}
+	return status, true
+}
+
+func (w *Watcher) writeStatus(ctx context.Context, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, status Status) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("encode status: %w", err)
+	}
+	updated := obj.DeepCopy()
+	if err := unstructured.SetNestedField(updated.Object, content, "status"); err != nil {
+		return fmt.Errorf("set status: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := w.client.Resource(gvr).UpdateStatus(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			// Another agent or an edit got there first; the next snapshot
+			// sees the new version.
+			return nil
+		}
+		return fmt.Errorf("update status: %w", err)
+	}
+	return nil
+}
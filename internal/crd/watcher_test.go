@@ -0,0 +1,188 @@
+package crd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestWatcherAppliesObjectsAndReportsStatus(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		PricingOverrides: "CostPricingOverrideList",
+		Budgets:          "CostBudgetList",
+		AllocationPolicy: "CostAllocationPolicyList",
+	},
+		object("CostPricingOverride", "spot", map[string]any{
+			"instancePrices": map[string]any{"m6a.large": 0.08},
+			"nodePrices": []any{map[string]any{
+				"nodeSelector": map[string]any{"matchLabels": map[string]any{"karpenter.sh/capacity-type": "spot"}},
+				"hourlyPrice":  0.03,
+			}},
+			"egressGiBPrices": map[string]any{"internet_egress": 0.05},
+		}),
+		object("CostBudget", "team-a", map[string]any{
+			"namespaceSelector": map[string]any{"matchLabels": map[string]any{"team": "a"}},
+			"amount":            int64(500),
+		}),
+		object("CostBudget", "cluster", map[string]any{"amount": int64(100)}),
+		object("CostAllocationPolicy", "platform", map[string]any{
+			"sharedNamespaces": []any{"ingress"},
+			"split":            "evenly",
+		}),
+	)
+
+	store := snapshot.NewStore()
+	cfg := config.DefaultConfig()
+	cfg.Accumulation.Enabled = true
+	cfg.Budgets.Definitions = []config.BudgetConfig{{Name: "cluster"}}
+	watcher := NewWatcher(client, cfg, snapshot.NewNodePriceLookup(map[string]float64{"m6a.large": 0.1}, 0.2), snapshot.NewNetworkPriceLookup(0.09, nil), store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher.Start(ctx)
+	if !cache.WaitForCacheSync(ctx.Done(), watcher.synced...) {
+		t.Fatal("informers did not sync")
+	}
+
+	overrides := watcher.Overrides()
+	if got := overrides.Prices.NodePrice("m6a.large", map[string]string{"karpenter.sh/capacity-type": "spot"}); got != 0.03 {
+		t.Errorf("spot node price = %v", got)
+	}
+	if got := overrides.Prices.NodePrice("M6A.LARGE", nil); got != 0.08 {
+		t.Errorf("instance price = %v", got)
+	}
+	if got := overrides.Prices.NodePrice("c5.xlarge", nil); got != 0.2 {
+		t.Errorf("default price = %v", got)
+	}
+	if got := overrides.NetPrices.EgressCost("internet_egress", 1<<30); math.Abs(got-0.05) > 1e-9 {
+		t.Errorf("egress price = %v", got)
+	}
+	if len(overrides.SharedCost) != 0 {
+		t.Errorf("invalid policy applied: %+v", overrides.SharedCost)
+	}
+	if budgets := watcher.Budgets(); len(budgets) != 1 || budgets[0].Name != "team-a" || budgets[0].Selector != "team=a" || budgets[0].Period != "month" {
+		t.Errorf("budgets = %+v", budgets)
+	}
+
+	snap := snapshot.Snapshot{
+		Timestamp: time.Now(),
+		Nodes: []snapshot.NodeCostRecord{
+			{NodeName: "a", InstanceType: "m6a.large"},
+			{NodeName: "b", InstanceType: "c5.xlarge", Labels: map[string]string{"karpenter.sh/capacity-type": "spot"}},
+			{NodeName: "c", InstanceType: "c5.xlarge"},
+		},
+		Namespaces: []snapshot.NamespaceCostRecord{
+			{Namespace: "payments", Labels: map[string]string{"team": "a"}},
+			{Namespace: "checkout", Labels: map[string]string{"team": "a"}},
+			{Namespace: "ingress"},
+		},
+		Network: snapshot.NetworkSnapshot{ByClass: []snapshot.NetworkClassTotals{{Class: "internet_egress"}}},
+	}
+	watcher.report(ctx, snap)
+
+	checks := []struct {
+		gvr     schema.GroupVersionResource
+		name    string
+		valid   bool
+		matched int64
+	}{
+		{PricingOverrides, "spot", true, 3},
+		{Budgets, "team-a", true, 2},
+		{Budgets, "cluster", false, 0},
+		{AllocationPolicy, "platform", false, 0},
+	}
+	for _, check := range checks {
+		obj, err := client.Resource(check.gvr).Get(ctx, check.name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get %s: %v", check.name, err)
+		}
+		valid, _, _ := unstructured.NestedBool(obj.Object, "status", "valid")
+		matched, _, _ := unstructured.NestedInt64(obj.Object, "status", "matched")
+		message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+		if valid != check.valid || matched != check.matched {
+			t.Errorf("%s status valid %v matched %d (%s), want %v %d", check.name, valid, matched, message, check.valid, check.matched)
+		}
+		if generation, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); generation != 1 {
+			t.Errorf("%s observed generation = %d", check.name, generation)
+		}
+	}
+}
+
+func TestNodeScopedWatcherLeavesStatusAlone(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		PricingOverrides: "CostPricingOverrideList",
+		Budgets:          "CostBudgetList",
+		AllocationPolicy: "CostAllocationPolicyList",
+	},
+		object("CostBudget", "team-a", map[string]any{
+			"namespaceSelector": map[string]any{"matchLabels": map[string]any{"team": "a"}},
+			"amount":            int64(500),
+		}),
+	)
+	cfg := config.DefaultConfig()
+	cfg.Accumulation.Enabled = true
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The node agent only sees one of the team's namespaces.
+	nodeStore, clusterStore := snapshot.NewStore(), snapshot.NewStore()
+	nodeStore.Update(snapshot.Snapshot{Timestamp: time.Now(), Namespaces: []snapshot.NamespaceCostRecord{
+		{Namespace: "payments", Labels: map[string]string{"team": "a"}},
+	}})
+	clusterStore.Update(snapshot.Snapshot{Timestamp: time.Now(), Namespaces: []snapshot.NamespaceCostRecord{
+		{Namespace: "payments", Labels: map[string]string{"team": "a"}},
+		{Namespace: "checkout", Labels: map[string]string{"team": "a"}},
+	}})
+	node := NewWatcher(client, cfg, snapshot.NewNodePriceLookup(nil, 0), snapshot.NewNetworkPriceLookup(0, nil), nodeStore, logger)
+	node.SetNodeName("node-a")
+	cluster := NewWatcher(client, cfg, snapshot.NewNodePriceLookup(nil, 0), snapshot.NewNetworkPriceLookup(0, nil), clusterStore, logger)
+	node.Start(ctx)
+	cluster.Start(ctx)
+	if !cache.WaitForCacheSync(ctx.Done(), append(node.synced, cluster.synced...)...) {
+		t.Fatal("informers did not sync")
+	}
+
+	matched := func() int64 {
+		t.Helper()
+		obj, err := client.Resource(Budgets).Get(ctx, "team-a", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		n, _, _ := unstructured.NestedInt64(obj.Object, "status", "matched")
+		return n
+	}
+
+	node.Run(ctx)
+	if got := matched(); got != 0 {
+		t.Fatalf("expected the node agent to write no status, got matched %d", got)
+	}
+	snap, _ := clusterStore.Latest()
+	cluster.report(ctx, snap)
+	node.Run(ctx)
+	if got := matched(); got != 2 {
+		t.Fatalf("expected the cluster-wide status to stay, got matched %d", got)
+	}
+}
+
+func object(kind, name string, spec map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": Group + "/" + Version,
+		"kind":       kind,
+		"metadata":   map[string]any{"name": name, "generation": int64(1)},
+		"spec":       spec,
+	}}
+	return obj
+}
@@ -0,0 +1,115 @@
+package enricher
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Annotations that declare cost ownership on namespaces, workloads and pods.
+const (
+	AnnotationTeam         = "clustercost.io/team"
+	AnnotationCostCenter   = "clustercost.io/cost-center"
+	AnnotationEnvironment  = "clustercost.io/environment"
+	AnnotationSharedWeight = "clustercost.io/shared-weight"
+)
+
+// Attribution sources, from highest to lowest precedence.
+const (
+	SourcePod       = "pod"
+	SourceWorkload  = "workload"
+	SourceNamespace = "namespace"
+	SourceDefault   = "default"
+)
+
+// Value is a resolved attribution value and the level it came from.
+type Value struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Attribution is the ownership of a namespace or pod after precedence.
+type Attribution struct {
+	Team         Value `json:"team"`
+	CostCenter   Value `json:"costCenter"`
+	Environment  Value `json:"environment"`
+	SharedWeight Value `json:"sharedWeight"`
+}
+
+// Defaults apply when no level sets an annotation.
+type Defaults struct {
+	Team         string
+	CostCenter   string
+	Environment  string
+	SharedWeight float64
+}
+
+// Source is the metadata of one level, such as a namespace or a pod.
+type Source struct {
+	Kind        string
+	Annotations map[string]string
+}
+
+// Resolve applies the annotations of sources over defaults. Sources are
+// ordered from lowest to highest precedence, so pass the namespace, then the
+// workload, then the pod. A shared weight that is not a non-negative number
+// is ignored.
+func Resolve(defaults Defaults, sources ...Source) Attribution {
+	weight := defaults.SharedWeight
+	if weight <= 0 {
+		weight = 1
+	}
+	a := Attribution{
+		Team:         Value{Value: defaults.Team, Source: SourceDefault},
+		CostCenter:   Value{Value: defaults.CostCenter, Source: SourceDefault},
+		Environment:  Value{Value: defaults.Environment, Source: SourceDefault},
+		SharedWeight: Value{Value: strconv.FormatFloat(weight, 'f', -1, 64), Source: SourceDefault},
+	}
+	for _, src := range sources {
+		set := func(dst *Value, key string) {
+			if v := strings.TrimSpace(src.Annotations[key]); v != "" {
+				*dst = Value{Value: v, Source: src.Kind}
+			}
+		}
+		set(&a.Team, AnnotationTeam)
+		set(&a.CostCenter, AnnotationCostCenter)
+		set(&a.Environment, AnnotationEnvironment)
+		if v := strings.TrimSpace(src.Annotations[AnnotationSharedWeight]); v != "" {
+			if w, err := strconv.ParseFloat(v, 64); err == nil && w >= 0 {
+				a.SharedWeight = Value{Value: strconv.FormatFloat(w, 'f', -1, 64), Source: src.Kind}
+			}
+		}
+	}
+	return a
+}
+
+// Weight returns the shared weight as a number.
+func (a Attribution) Weight() float64 {
+	w, err := strconv.ParseFloat(a.SharedWeight.Value, 64)
+	if err != nil {
+		return 1
+	}
+	return w
+}
+
+// Labels returns the attribution as label allocation keys. Values that came
+// from annotations are returned for every key; defaults only fill keys the
+// labels do not already set.
+func (a Attribution) Labels(existing map[string]string) map[string]string {
+	out := make(map[string]string, len(existing)+3)
+	for k, v := range existing {
+		out[k] = v
+	}
+	set := func(key string, v Value) {
+		if v.Value == "" {
+			return
+		}
+		if v.Source == SourceDefault && out[key] != "" {
+			return
+		}
+		out[key] = v.Value
+	}
+	set("team", a.Team)
+	set("cost_center", a.CostCenter)
+	set("env", a.Environment)
+	return out
+}
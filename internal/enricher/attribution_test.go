@@ -0,0 +1,29 @@
+package enricher
+
+import "testing"
+
+func TestResolvePrecedenceAndLabels(t *testing.T) {
+	a := Resolve(Defaults{Team: "unowned", CostCenter: "cc-0"},
+		Source{Kind: SourceNamespace, Annotations: map[string]string{AnnotationTeam: "platform", AnnotationSharedWeight: "2"}},
+		Source{Kind: SourceWorkload, Annotations: map[string]string{AnnotationSharedWeight: "heavy"}},
+		Source{Kind: SourcePod, Annotations: map[string]string{AnnotationTeam: " payments "}},
+	)
+	if a.Team != (Value{Value: "payments", Source: SourcePod}) {
+		t.Fatalf("team = %+v", a.Team)
+	}
+	// An unparsable weight does not hide the namespace value.
+	if a.SharedWeight.Source != SourceNamespace || a.Weight() != 2 {
+		t.Fatalf("shared weight = %+v", a.SharedWeight)
+	}
+
+	labels := a.Labels(map[string]string{"team": "legacy", "cost_center": "cc-9"})
+	if labels["team"] != "payments" {
+		t.Fatalf("annotation should win over the team label, got %q", labels["team"])
+	}
+	if labels["cost_center"] != "cc-9" {
+		t.Fatalf("default should not replace the cost_center label, got %q", labels["cost_center"])
+	}
+	if _, ok := labels["env"]; ok {
+		t.Fatalf("empty environment should not be set: %v", labels)
+	}
+}
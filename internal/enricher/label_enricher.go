@@ -1,35 +1,148 @@
 package enricher
 
-// LabelEnricher merges label information with a deterministic precedence order.
-type LabelEnricher struct{}
+import (
+	"fmt"
+	"regexp"
+	"strings"
 
-var trackedKeys = []string{"team", "service", "env", "client", "cost_center"}
+	"clustercost-agent-k8s/internal/config"
+)
 
-// NewLabelEnricher returns a no-op enricher placeholder for future expansion.
-func NewLabelEnricher() *LabelEnricher {
-	return &LabelEnricher{}
+// Source key prefixes. Keys without a prefix are label keys.
+const (
+	labelPrefix      = "label:"
+	annotationPrefix = "annotation:"
+)
+
+// Dimension is one allocation dimension, such as the owning team.
+type Dimension struct {
+	Name string
+	// Keys are label keys, or annotation keys prefixed with "annotation:",
+	// tried in order.
+	Keys      []string
+	Lowercase bool
+	Rewrites  []Rewrite
+	// Default is used when no key yields a value.
+	Default string
+}
+
+// Rewrite replaces matches of Pattern in a value.
+type Rewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Object is the metadata of one level, such as a namespace or a pod.
+type Object struct {
+	Labels      map[string]string
+	Annotations map[string]string
 }
 
-// Merge returns a filtered map using the provided sources. Later sources win.
+// DefaultDimensions are the dimensions tracked when none are configured.
+// Ownership annotations take precedence over the label of the same object.
+func DefaultDimensions() []Dimension {
+	return []Dimension{
+		{Name: "team", Keys: []string{annotationPrefix + AnnotationTeam, "team"}},
+		{Name: "service", Keys: []string{"service"}},
+		{Name: "env", Keys: []string{annotationPrefix + AnnotationEnvironment, "env"}},
+		{Name: "client", Keys: []string{"client"}},
+		{Name: "cost_center", Keys: []string{annotationPrefix + AnnotationCostCenter, "cost_center"}},
+	}
+}
+
+// DimensionsFromConfig compiles configured dimensions.
+func DimensionsFromConfig(cfg []config.DimensionConfig) ([]Dimension, error) {
+	dims := make([]Dimension, 0, len(cfg))
+	for _, d := range cfg {
+		dim := Dimension{Name: d.Name, Keys: d.Sources, Lowercase: d.Lowercase, Default: d.Default}
+		for _, rw := range d.Rewrites {
+			pattern, err := regexp.Compile(rw.Match)
+			if err != nil {
+				return nil, fmt.Errorf("dimension %q rewrite: %w", d.Name, err)
+			}
+			dim.Rewrites = append(dim.Rewrites, Rewrite{Pattern: pattern, Replacement: rw.Replace})
+		}
+		dims = append(dims, dim)
+	}
+	return dims, nil
+}
+
+// LabelEnricher resolves allocation dimensions with a deterministic
+// precedence order.
+type LabelEnricher struct {
+	dimensions []Dimension
+}
+
+// NewLabelEnricher returns an enricher for dimensions, or for the default
+// dimensions when none are given.
+func NewLabelEnricher(dimensions ...Dimension) *LabelEnricher {
+	if len(dimensions) == 0 {
+		dimensions = DefaultDimensions()
+	}
+	return &LabelEnricher{dimensions: dimensions}
+}
+
+// Merge returns a filtered map using the provided label sources. Later sources win.
 func (e *LabelEnricher) Merge(sources ...map[string]string) map[string]string {
-	result := make(map[string]string)
-	for _, key := range trackedKeys {
-		for i := len(sources) - 1; i >= 0; i-- {
-			if sources[i] == nil {
-				continue
+	objects := make([]Object, len(sources))
+	for i, labels := range sources {
+		objects[i] = Object{Labels: labels}
+	}
+	return e.Resolve(objects...)
+}
+
+// Resolve returns the normalized value of every dimension. Later objects
+// win, so pass the namespace before the workload and the pod; within one
+// object the dimension keys are tried in order. Dimensions without a value
+// or default are left out.
+func (e *LabelEnricher) Resolve(objects ...Object) map[string]string {
+	result := make(map[string]string, len(e.dimensions))
+	for _, dim := range e.dimensions {
+		if v := dim.resolve(objects); v != "" {
+			result[dim.Name] = v
+		}
+	}
+	return result
+}
+
+func (d Dimension) resolve(objects []Object) string {
+	for i := len(objects) - 1; i >= 0; i-- {
+		for _, key := range d.Keys {
+			source := objects[i].Labels
+			switch {
+			case strings.HasPrefix(key, annotationPrefix):
+				source = objects[i].Annotations
+				key = strings.TrimPrefix(key, annotationPrefix)
+			case strings.HasPrefix(key, labelPrefix):
+				key = strings.TrimPrefix(key, labelPrefix)
 			}
-			if val, ok := sources[i][key]; ok && val != "" {
-				result[key] = val
-				break
+			if v := d.normalize(source[key]); v != "" {
+				return v
 			}
 		}
 	}
-	return result
+	return d.Default
+}
+
+func (d Dimension) normalize(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	if d.Lowercase {
+		value = strings.ToLower(value)
+	}
+	for _, rw := range d.Rewrites {
+		value = rw.Pattern.ReplaceAllString(value, rw.Replacement)
+	}
+	return value
 }
 
-// Keys returns the label keys handled by the enricher.
+// Keys returns the names of the dimensions handled by the enricher.
 func (e *LabelEnricher) Keys() []string {
-	cp := make([]string, len(trackedKeys))
-	copy(cp, trackedKeys)
-	return cp
+	keys := make([]string, 0, len(e.dimensions))
+	for _, dim := range e.dimensions {
+		keys = append(keys, dim.Name)
+	}
+	return keys
 }
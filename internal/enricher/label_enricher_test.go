@@ -1,6 +1,10 @@
 package enricher
 
-import "testing"
+import (
+	"testing"
+
+	"clustercost-agent-k8s/internal/config"
+)
 
 func TestLabelEnricherMergePrecedence(t *testing.T) {
 	en := NewLabelEnricher()
@@ -30,3 +34,38 @@ func TestLabelEnricherMergePrecedence(t *testing.T) {
 		t.Fatalf("cost_center label missing, got %s", got)
 	}
 }
+
+func TestLabelEnricherResolveDimensions(t *testing.T) {
+	dims, err := DimensionsFromConfig([]config.DimensionConfig{
+		{Name: "team", Sources: []string{"annotation:example.com/owner", "team", "app.kubernetes.io/part-of"}, Lowercase: true},
+		{Name: "env", Sources: []string{"env"}, Rewrites: []config.RewriteConfig{{Match: "^prd$", Replace: "production"}}, Default: "unassigned"},
+	})
+	if err != nil {
+		t.Fatalf("dimensions: %v", err)
+	}
+	en := NewLabelEnricher(dims...)
+
+	ns := Object{Labels: map[string]string{"app.kubernetes.io/part-of": "Platform", "env": "prd"}}
+	pod := Object{Annotations: map[string]string{"example.com/owner": " Payments "}}
+
+	result := en.Resolve(ns)
+	if got := result["team"]; got != "platform" {
+		t.Fatalf("expected fallback key lowercased, got %q", got)
+	}
+	if got := result["env"]; got != "production" {
+		t.Fatalf("expected rewritten env, got %q", got)
+	}
+
+	result = en.Resolve(ns, pod)
+	if got := result["team"]; got != "payments" {
+		t.Fatalf("expected pod annotation to win, got %q", got)
+	}
+
+	result = en.Resolve(Object{})
+	if got := result["env"]; got != "unassigned" {
+		t.Fatalf("expected default env, got %q", got)
+	}
+	if _, ok := result["team"]; ok {
+		t.Fatalf("expected team without value or default to be omitted, got %v", result)
+	}
+}
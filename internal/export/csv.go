@@ -0,0 +1,54 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{
+	"cluster_id",
+	"level",
+	"name",
+	"environment",
+	"basis",
+	"window",
+	"start",
+	"end",
+	"compute_cost_usd",
+	"network_cost_usd",
+	"total_cost_usd",
+}
+
+// WriteCSV renders lines as a flat CSV table.
+func WriteCSV(w io.Writer, meta Meta, lines []Line) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		record := []string{
+			meta.ClusterID,
+			string(line.Level),
+			line.Name,
+			line.Environment,
+			string(line.Basis),
+			line.Window,
+			line.Start.Format(time.RFC3339),
+			line.End.Format(time.RFC3339),
+			formatCost(line.ComputeCost),
+			formatCost(line.NetworkCost),
+			formatCost(line.ComputeCost + line.NetworkCost),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatCost(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
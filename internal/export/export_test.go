@@ -0,0 +1,102 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func TestFocusRowsMapKubernetesDimensionsToTags(t *testing.T) {
+	snap := snapshot.Snapshot{
+		Timestamp: time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC),
+		Namespaces: []snapshot.NamespaceCostRecord{
+			{
+				Namespace:         "payments",
+				HourlyCost:        0.25,
+				NetworkEgressCost: 0.0005,
+				Environment:       "production",
+				Labels:            map[string]string{"team": "checkout"},
+			},
+		},
+	}
+	meta := Meta{ClusterID: "c1", ClusterName: "prod", Provider: "aws", Region: "us-east-1"}
+
+	// The egress cost covers one 30s collection interval.
+	rows := FocusRows(meta, SnapshotLines(snap, LevelNamespace, 30*time.Second))
+	if len(rows) != 2 {
+		t.Fatalf("expected compute and network rows, got %d", len(rows))
+	}
+	compute := rows[0]
+	if compute.ServiceCategory != "Compute" || compute.BilledCost != 0.25 || compute.EffectiveCost != 0.25 {
+		t.Fatalf("unexpected compute row: %+v", compute)
+	}
+	if compute.ResourceID != "k8s://c1/namespace/payments" {
+		t.Fatalf("unexpected resource id %s", compute.ResourceID)
+	}
+	if !compute.ChargePeriodEnd.Equal(compute.ChargePeriodStart.Add(time.Hour)) {
+		t.Fatalf("expected one-hour charge period")
+	}
+	if compute.Tags["k8s.namespace.name"] != "payments" || compute.Tags["team"] != "checkout" || compute.Tags["clustercost.io/environment"] != "production" {
+		t.Fatalf("unexpected tags: %+v", compute.Tags)
+	}
+	if rows[1].ServiceCategory != "Networking" || math.Abs(rows[1].BilledCost-0.06) > 1e-9 {
+		t.Fatalf("unexpected network row: %+v", rows[1])
+	}
+}
+
+func TestWriteFOCUSFromPeriods(t *testing.T) {
+	period := accumulator.Period{
+		Window: accumulator.WindowDay,
+		Start:  time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2025, 3, 13, 0, 0, 0, 0, time.UTC),
+		Nodes: map[string]accumulator.Totals{
+			"node-a": {ComputeCost: 2.4, TotalCost: 2.4},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteFOCUS(&buf, Meta{ClusterID: "c1"}, PeriodLines([]accumulator.Period{period}, LevelNode, nil)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected header and one row, got %d", len(records))
+	}
+	row := map[string]string{}
+	for i, col := range records[0] {
+		row[col] = records[1][i]
+	}
+	if row["BilledCost"] != "2.4" || row["ChargePeriodStart"] != "2025-03-12T00:00:00Z" || row["ChargePeriodEnd"] != "2025-03-13T00:00:00Z" {
+		t.Fatalf("unexpected row: %+v", row)
+	}
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(row["Tags"]), &tags); err != nil {
+		t.Fatalf("decode tags: %v", err)
+	}
+	if tags["k8s.node.name"] != "node-a" {
+		t.Fatalf("unexpected tags: %+v", tags)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	lines := []Line{{Level: LevelNamespace, Name: "payments", Basis: BasisHourlyRate, ComputeCost: 1, NetworkCost: 0.5}}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, Meta{ClusterID: "c1"}, lines); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	if got := records[1][len(records[1])-1]; got != "1.5" {
+		t.Fatalf("total cost = %s, want 1.5", got)
+	}
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FOCUS column values that are fixed for agent-produced rows.
+const (
+	focusCurrency       = "USD"
+	focusChargeCategory = "Usage"
+	focusServiceName    = "Kubernetes"
+	focusPublisher      = "ClusterCost"
+)
+
+// focusHeader lists the FinOps Open Cost and Usage Specification columns
+// emitted by WriteFOCUS, in output order.
+var focusHeader = []string{
+	"BilledCost",
+	"EffectiveCost",
+	"ListCost",
+	"BillingCurrency",
+	"BillingPeriodStart",
+	"BillingPeriodEnd",
+	"ChargeCategory",
+	"ChargeDescription",
+	"ChargePeriodStart",
+	"ChargePeriodEnd",
+	"ProviderName",
+	"PublisherName",
+	"RegionId",
+	"ResourceId",
+	"ResourceName",
+	"ResourceType",
+	"ServiceCategory",
+	"ServiceName",
+	"SubAccountId",
+	"SubAccountName",
+	"Tags",
+}
+
+// FocusRow is a single FOCUS-compliant cost row.
+type FocusRow struct {
+	BilledCost         float64
+	EffectiveCost      float64
+	ListCost           float64
+	BillingCurrency    string
+	BillingPeriodStart time.Time
+	BillingPeriodEnd   time.Time
+	ChargeCategory     string
+	ChargeDescription  string
+	ChargePeriodStart  time.Time
+	ChargePeriodEnd    time.Time
+	ProviderName       string
+	PublisherName      string
+	RegionID           string
+	ResourceID         string
+	ResourceName       string
+	ResourceType       string
+	ServiceCategory    string
+	ServiceName        string
+	SubAccountID       string
+	SubAccountName     string
+	Tags               map[string]string
+}
+
+// FocusRows maps lines to FOCUS rows, one per resource and cost component.
+// Kubernetes dimensions are carried in Tags so rows can be joined with cloud
+// bills on the same keys.
+func FocusRows(meta Meta, lines []Line) []FocusRow {
+	rows := make([]FocusRow, 0, len(lines)*2)
+	for _, line := range lines {
+		billingStart, billingEnd := billingPeriod(line.Start)
+		base := FocusRow{
+			BillingCurrency:    focusCurrency,
+			BillingPeriodStart: billingStart,
+			BillingPeriodEnd:   billingEnd,
+			ChargeCategory:     focusChargeCategory,
+			ChargePeriodStart:  line.Start,
+			ChargePeriodEnd:    line.End,
+			ProviderName:       meta.Provider,
+			PublisherName:      focusPublisher,
+			RegionID:           meta.Region,
+			ResourceID:         resourceID(meta.ClusterID, line),
+			ResourceName:       line.Name,
+			ResourceType:       resourceType(line.Level),
+			ServiceName:        focusServiceName,
+			SubAccountID:       meta.ClusterID,
+			SubAccountName:     meta.ClusterName,
+			Tags:               tags(meta, line),
+		}
+
+		compute := base
+		compute.ServiceCategory = "Compute"
+		compute.ChargeDescription = describe(line, "compute")
+		setCost(&compute, line.ComputeCost)
+		rows = append(rows, compute)
+
+		if line.NetworkCost != 0 {
+			netRow := base
+			netRow.ServiceCategory = "Networking"
+			netRow.ChargeDescription = describe(line, "network egress")
+			setCost(&netRow, line.NetworkCost)
+			rows = append(rows, netRow)
+		}
+	}
+	return rows
+}
+
+// WriteFOCUS renders lines as FOCUS CSV.
+func WriteFOCUS(w io.Writer, meta Meta, lines []Line) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(focusHeader); err != nil {
+		return err
+	}
+	for _, row := range FocusRows(meta, lines) {
+		tagsJSON, err := json.Marshal(row.Tags)
+		if err != nil {
+			return fmt.Errorf("encode tags: %w", err)
+		}
+		record := []string{
+			formatCost(row.BilledCost),
+			formatCost(row.EffectiveCost),
+			formatCost(row.ListCost),
+			row.BillingCurrency,
+			row.BillingPeriodStart.Format(time.RFC3339),
+			row.BillingPeriodEnd.Format(time.RFC3339),
+			row.ChargeCategory,
+			row.ChargeDescription,
+			row.ChargePeriodStart.Format(time.RFC3339),
+			row.ChargePeriodEnd.Format(time.RFC3339),
+			row.ProviderName,
+			row.PublisherName,
+			row.RegionID,
+			row.ResourceID,
+			row.ResourceName,
+			row.ResourceType,
+			row.ServiceCategory,
+			row.ServiceName,
+			row.SubAccountID,
+			row.SubAccountName,
+			string(tagsJSON),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// setCost fills the cost columns. The agent prices at on-demand list rates
+// with no discounts, so billed, effective, and list cost are equal.
+func setCost(row *FocusRow, cost float64) {
+	row.BilledCost = cost
+	row.EffectiveCost = cost
+	row.ListCost = cost
+}
+
+func billingPeriod(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+func resourceID(clusterID string, line Line) string {
+	return fmt.Sprintf("k8s://%s/%s/%s", clusterID, line.Level, line.Name)
+}
+
+func resourceType(level Level) string {
+	if level == LevelNode {
+		return "Kubernetes Node"
+	}
+	return "Kubernetes Namespace"
+}
+
+func describe(line Line, component string) string {
+	if line.Basis == BasisHourlyRate {
+		return fmt.Sprintf("Projected one-hour %s cost for %s %s", component, line.Level, line.Name)
+	}
+	return fmt.Sprintf("Accumulated %s %s cost for %s %s", line.Window, component, line.Level, line.Name)
+}
+
+// tags merges resource labels with Kubernetes dimensions; the dimensions win
+// on key collisions so joins stay reliable.
+func tags(meta Meta, line Line) map[string]string {
+	out := make(map[string]string, len(line.Labels)+4)
+	for k, v := range line.Labels {
+		out[k] = v
+	}
+	out["k8s.cluster.name"] = meta.ClusterName
+	switch line.Level {
+	case LevelNode:
+		out["k8s.node.name"] = line.Name
+	default:
+		out["k8s.namespace.name"] = line.Name
+	}
+	if line.Environment != "" {
+		out["clustercost.io/environment"] = line.Environment
+	}
+	return out
+}
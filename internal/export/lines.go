@@ -0,0 +1,210 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// Level selects the resource granularity of exported rows. Rows at one level
+// sum to the cluster total; mixing levels would double count node spend.
+type Level string
+
+const (
+	// LevelNamespace emits one row set per namespace.
+	LevelNamespace Level = "namespace"
+	// LevelNode emits one row set per node.
+	LevelNode Level = "node"
+)
+
+// Basis describes how a line's cost was derived.
+type Basis string
+
+const (
+	// BasisHourlyRate marks a one-hour projection of snapshot rates.
+	BasisHourlyRate Basis = "hourly_rate"
+	// BasisAccumulated marks spend integrated over a calendar window.
+	BasisAccumulated Basis = "accumulated"
+)
+
+// ParseLevel validates a level name.
+func ParseLevel(raw string) (Level, error) {
+	switch Level(raw) {
+	case "", LevelNamespace:
+		return LevelNamespace, nil
+	case LevelNode:
+		return LevelNode, nil
+	default:
+		return "", fmt.Errorf("unknown level %q", raw)
+	}
+}
+
+// Meta describes the cluster the rows belong to.
+type Meta struct {
+	ClusterID   string
+	ClusterName string
+	Provider    string
+	Region      string
+}
+
+// Line is a format-neutral cost row for one resource and charge period.
+type Line struct {
+	Level       Level
+	Name        string
+	Environment string
+	Labels      map[string]string
+	Basis       Basis
+	Window      string
+	Start       time.Time
+	End         time.Time
+	ComputeCost float64
+	NetworkCost float64
+}
+
+// SnapshotLines projects the snapshot's hourly rates onto a one-hour charge
+// period starting at the snapshot timestamp. Egress cost is priced per
+// collection interval, so it is scaled from interval to an hour.
+func SnapshotLines(snap snapshot.Snapshot, level Level, interval time.Duration) []Line {
+	start := snap.Timestamp.UTC()
+	end := start.Add(time.Hour)
+	perHour := 1.0
+	if interval > 0 {
+		perHour = float64(time.Hour) / float64(interval)
+	}
+	var lines []Line
+	switch level {
+	case LevelNode:
+		egress := nodeEgress(snap)
+		for _, node := range snap.Nodes {
+			lines = append(lines, Line{
+				Level:       LevelNode,
+				Name:        node.NodeName,
+				Labels:      nodeTags(node),
+				Basis:       BasisHourlyRate,
+				Start:       start,
+				End:         end,
+				ComputeCost: node.HourlyCost,
+				NetworkCost: egress[node.NodeName] * perHour,
+			})
+		}
+	default:
+		for _, ns := range snap.Namespaces {
+			lines = append(lines, Line{
+				Level:       LevelNamespace,
+				Name:        ns.Namespace,
+				Environment: ns.Environment,
+				Labels:      ns.Labels,
+				Basis:       BasisHourlyRate,
+				Start:       start,
+				End:         end,
+				ComputeCost: ns.HourlyCost,
+				NetworkCost: ns.NetworkEgressCost * perHour,
+			})
+		}
+	}
+	return lines
+}
+
+// PeriodLines converts accumulated periods into lines. The optional snapshot
+// supplies environment and labels, which the accumulator does not retain.
+func PeriodLines(periods []accumulator.Period, level Level, enrich *snapshot.Snapshot) []Line {
+	namespaces := map[string]snapshot.NamespaceCostRecord{}
+	nodes := map[string]snapshot.NodeCostRecord{}
+	if enrich != nil {
+		for _, ns := range enrich.Namespaces {
+			namespaces[ns.Namespace] = ns
+		}
+		for _, node := range enrich.Nodes {
+			nodes[node.NodeName] = node
+		}
+	}
+
+	var lines []Line
+	for _, period := range periods {
+		totals := period.Namespaces
+		if level == LevelNode {
+			totals = period.Nodes
+		}
+		names := make([]string, 0, len(totals))
+		for name := range totals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			t := totals[name]
+			line := Line{
+				Level:       level,
+				Name:        name,
+				Basis:       BasisAccumulated,
+				Window:      string(period.Window),
+				Start:       period.Start.UTC(),
+				End:         period.End.UTC(),
+				ComputeCost: t.ComputeCost,
+				NetworkCost: t.NetworkCost,
+			}
+			if level == LevelNode {
+				if node, ok := nodes[name]; ok {
+					line.Labels = nodeTags(node)
+				}
+			} else if ns, ok := namespaces[name]; ok {
+				line.Environment = ns.Environment
+				line.Labels = ns.Labels
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func nodeEgress(snap snapshot.Snapshot) map[string]float64 {
+	out := map[string]float64{}
+	for _, pod := range snap.Network.Pods {
+		out[pod.Node] += pod.EgressCostHourly
+	}
+	return out
+}
+
+func nodeTags(node snapshot.NodeCostRecord) map[string]string {
+	tags := map[string]string{}
+	if node.InstanceType != "" {
+		tags["node.kubernetes.io/instance-type"] = node.InstanceType
+	}
+	if zone := node.Labels["topology.kubernetes.io/zone"]; zone != "" {
+		tags["topology.kubernetes.io/zone"] = zone
+	}
+	return tags
+}
+
+// Format selects the output encoding.
+type Format string
+
+const (
+	// FormatCSV renders a flat table with compute, network, and total columns.
+	FormatCSV Format = "csv"
+	// FormatFOCUS renders FinOps FOCUS rows as CSV.
+	FormatFOCUS Format = "focus"
+)
+
+// ParseFormat validates a format name.
+func ParseFormat(raw string) (Format, error) {
+	switch Format(raw) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatFOCUS:
+		return FormatFOCUS, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", raw)
+	}
+}
+
+// Write renders lines in the requested format.
+func Write(w io.Writer, format Format, meta Meta, lines []Line) error {
+	if format == FormatFOCUS {
+		return WriteFOCUS(w, meta, lines)
+	}
+	return WriteCSV(w, meta, lines)
+}
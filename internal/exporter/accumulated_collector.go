@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"clustercost-agent-k8s/internal/accumulator"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AccumulatedCostCollector exposes calendar cost totals as Prometheus counters.
+// Each series resets when its window rolls over, which rate() and increase()
+// treat like any other counter reset.
+type AccumulatedCostCollector struct {
+	costs       *accumulator.Accumulator
+	clusterName string
+
+	clusterDesc   *prometheus.Desc
+	namespaceDesc *prometheus.Desc
+	nodeDesc      *prometheus.Desc
+	sharedDesc    *prometheus.Desc
+}
+
+// NewAccumulatedCostCollector builds a collector that reads totals at scrape time.
+func NewAccumulatedCostCollector(clusterName string, costs *accumulator.Accumulator) *AccumulatedCostCollector {
+	return &AccumulatedCostCollector{
+		costs:       costs,
+		clusterName: clusterName,
+		clusterDesc: prometheus.NewDesc(
+			"clustercost_cluster_cost_usd_total",
+			"Accumulated cluster spend in USD for the current calendar window",
+			[]string{"cluster_name", "window", "component"}, nil,
+		),
+		namespaceDesc: prometheus.NewDesc(
+			"clustercost_namespace_cost_usd_total",
+			"Accumulated namespace spend in USD for the current calendar window",
+			[]string{"cluster_name", "namespace", "window", "component"}, nil,
+		),
+		nodeDesc: prometheus.NewDesc(
+			"clustercost_node_cost_usd_total",
+			"Accumulated node spend in USD for the current calendar window",
+			[]string{"cluster_name", "node", "window", "component"}, nil,
+		),
+		sharedDesc: prometheus.NewDesc(
+			"clustercost_namespace_shared_cost_usd_total",
+			"Part of the accumulated namespace compute spend in USD received from shared namespaces",
+			[]string{"cluster_name", "namespace", "window"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *AccumulatedCostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clusterDesc
+	ch <- c.namespaceDesc
+	ch <- c.nodeDesc
+	ch <- c.sharedDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *AccumulatedCostCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, period := range c.costs.Current() {
+		window := string(period.Window)
+		emitTotals(ch, c.clusterDesc, period.Cluster, c.clusterName, window)
+		for name, totals := range period.Namespaces {
+			emitTotals(ch, c.namespaceDesc, totals, c.clusterName, name, window)
+			ch <- prometheus.MustNewConstMetric(c.sharedDesc, prometheus.CounterValue, totals.SharedCost, c.clusterName, name, window)
+		}
+		for name, totals := range period.Nodes {
+			emitTotals(ch, c.nodeDesc, totals, c.clusterName, name, window)
+		}
+	}
+}
+
+func emitTotals(ch chan<- prometheus.Metric, desc *prometheus.Desc, totals accumulator.Totals, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, totals.ComputeCost, append(labels, "compute")...)
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, totals.NetworkCost, append(labels, "network")...)
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, totals.StorageCost, append(labels, "storage")...)
+}
@@ -0,0 +1,255 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	// ErrUnauthenticated means the bearer token was missing or not recognized.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrForbidden means the token is valid but may not read the path.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authenticator decides whether a bearer token may GET a path.
+type Authenticator interface {
+	Authorize(ctx context.Context, token, path string) error
+}
+
+// RequireAuth wraps next so that every path except the public ones needs a
+// valid bearer token. Probes stay reachable without credentials.
+func RequireAuth(next http.Handler, auth Authenticator, public []string, logger *slog.Logger) http.Handler {
+	open := make(map[string]struct{}, len(public))
+	for _, path := range public {
+		open[path] = struct{}{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := open[r.URL.Path]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			denyRequest(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		err := auth.Authorize(r.Context(), token, r.URL.Path)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, ErrUnauthenticated):
+			denyRequest(w, http.StatusUnauthorized, "invalid bearer token")
+		case errors.Is(err, ErrForbidden):
+			denyRequest(w, http.StatusForbidden, "forbidden")
+		default:
+			logger.Warn("auth check failed", slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+			denyRequest(w, http.StatusInternalServerError, "auth check failed")
+		}
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func denyRequest(w http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="clustercost-agent"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// TokenFileAuthenticator accepts any token listed in a file, one per line.
+// Blank lines and lines starting with # are ignored. The file is re-read
+// when its modification time changes so tokens can be rotated in place.
+type TokenFileAuthenticator struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	tokens  [][]byte
+}
+
+// NewTokenFileAuthenticator reads the token file and fails if it holds no tokens.
+func NewTokenFileAuthenticator(path string) (*TokenFileAuthenticator, error) {
+	a := &TokenFileAuthenticator{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	a.mu.RLock()
+	empty := len(a.tokens) == 0
+	a.mu.RUnlock()
+	if empty {
+		return nil, fmt.Errorf("auth token file %s holds no tokens", path)
+	}
+	return a, nil
+}
+
+// Authorize implements Authenticator. Every listed token may read every path.
+func (a *TokenFileAuthenticator) Authorize(_ context.Context, token, _ string) error {
+	if err := a.reload(); err != nil {
+		return err
+	}
+	candidate := []byte(token)
+	matched := 0
+	a.mu.RLock()
+	for _, known := range a.tokens {
+		matched |= subtle.ConstantTimeCompare(candidate, known)
+	}
+	a.mu.RUnlock()
+	if matched == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+func (a *TokenFileAuthenticator) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("stat auth token file: %w", err)
+	}
+	a.mu.RLock()
+	unchanged := info.ModTime().Equal(a.modTime)
+	a.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path) // #nosec G304 -- path provided by cluster operator
+	if err != nil {
+		return fmt.Errorf("read auth token file: %w", err)
+	}
+	var tokens [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, []byte(line))
+	}
+	a.mu.Lock()
+	a.tokens = tokens
+	a.modTime = info.ModTime()
+	a.mu.Unlock()
+	return nil
+}
+
+// KubeAuthenticator validates tokens with a TokenReview and checks access
+// with a SubjectAccessReview on the request path as a non-resource URL, the
+// same model kube-rbac-proxy uses. Callers need a ClusterRole granting get on
+// nonResourceURLs such as /agent/v1/* and /metrics.
+type KubeAuthenticator struct {
+	client kubernetes.Interface
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]authDecision
+}
+
+type authDecision struct {
+	err     error
+	expires time.Time
+}
+
+// NewKubeAuthenticator builds a KubeAuthenticator. Decisions, allowed or
+// denied, are cached for ttl; API errors are never cached.
+func NewKubeAuthenticator(client kubernetes.Interface, ttl time.Duration) *KubeAuthenticator {
+	return &KubeAuthenticator{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+		cache:  map[[sha256.Size]byte]authDecision{},
+	}
+}
+
+// Authorize implements Authenticator.
+func (a *KubeAuthenticator) Authorize(ctx context.Context, token, path string) error {
+	key := sha256.Sum256([]byte(token + "\x00" + path))
+	now := a.now()
+	a.mu.Lock()
+	if decision, ok := a.cache[key]; ok && now.Before(decision.expires) {
+		a.mu.Unlock()
+		return decision.err
+	}
+	a.mu.Unlock()
+
+	err := a.review(ctx, token, path)
+	if err != nil && !errors.Is(err, ErrUnauthenticated) && !errors.Is(err, ErrForbidden) {
+		return err
+	}
+	if a.ttl > 0 {
+		a.mu.Lock()
+		for k, decision := range a.cache {
+			if !now.Before(decision.expires) {
+				delete(a.cache, k)
+			}
+		}
+		a.cache[key] = authDecision{err: err, expires: now.Add(a.ttl)}
+		a.mu.Unlock()
+	}
+	return err
+}
+
+func (a *KubeAuthenticator) review(ctx context.Context, token, path string) error {
+	tr, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("token review: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		return ErrUnauthenticated
+	}
+
+	user := tr.Status.User
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	sar, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authzv1.NonResourceAttributes{
+				Path: path,
+				Verb: "get",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("subject access review: %w", err)
+	}
+	if !sar.Status.Allowed {
+		return ErrForbidden
+	}
+	return nil
+}
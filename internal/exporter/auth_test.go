@@ -0,0 +1,123 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRequireAuthWithTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# dashboards\nsecret-a\n\nsecret-b\n"), 0o600); err != nil {
+		t.Fatalf("write tokens: %v", err)
+	}
+	auth, err := NewTokenFileAuthenticator(path)
+	if err != nil {
+		t.Fatalf("new authenticator: %v", err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := RequireAuth(ok, auth, []string{"/agent/v1/readyz"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cases := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"probe is public", "/agent/v1/readyz", "", http.StatusOK},
+		{"missing token", "/agent/v1/nodes", "", http.StatusUnauthorized},
+		{"wrong scheme", "/agent/v1/nodes", "Basic secret-a", http.StatusUnauthorized},
+		{"unknown token", "/agent/v1/nodes", "Bearer nope", http.StatusUnauthorized},
+		{"known token", "/agent/v1/nodes", "Bearer secret-b", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+
+	// Rotate the file; the old token must stop working.
+	if err := os.WriteFile(path, []byte("secret-c\n"), 0o600); err != nil {
+		t.Fatalf("rewrite tokens: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := auth.Authorize(context.Background(), "secret-a", "/"); err != ErrUnauthenticated {
+		t.Fatalf("rotated token still accepted: %v", err)
+	}
+	if err := auth.Authorize(context.Background(), "secret-c", "/"); err != nil {
+		t.Fatalf("new token rejected: %v", err)
+	}
+}
+
+func TestKubeAuthenticatorReviewsAndCaches(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authnv1.TokenReview)
+		if review.Spec.Token != "sa-token" {
+			return true, &authnv1.TokenReview{}, nil
+		}
+		review.Status = authnv1.TokenReviewStatus{
+			Authenticated: true,
+			User:          authnv1.UserInfo{Username: "system:serviceaccount:monitoring:dashboard", Groups: []string{"system:serviceaccounts"}},
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := sar.Spec.NonResourceAttributes
+		sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:monitoring:dashboard" &&
+			attrs != nil && attrs.Verb == "get" && attrs.Path == "/agent/v1/nodes"
+		return true, sar, nil
+	})
+
+	auth := NewKubeAuthenticator(client, time.Minute)
+	ctx := context.Background()
+	if err := auth.Authorize(ctx, "sa-token", "/agent/v1/nodes"); err != nil {
+		t.Fatalf("expected allowed, got %v", err)
+	}
+	if err := auth.Authorize(ctx, "sa-token", "/agent/v1/nodes"); err != nil {
+		t.Fatalf("expected cached allow, got %v", err)
+	}
+	if reviews != 1 {
+		t.Fatalf("expected one token review, got %d", reviews)
+	}
+	if err := auth.Authorize(ctx, "sa-token", "/metrics"); err != ErrForbidden {
+		t.Fatalf("expected forbidden, got %v", err)
+	}
+	if err := auth.Authorize(ctx, "other", "/agent/v1/nodes"); err != ErrUnauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+
+	auth.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if err := auth.Authorize(ctx, "sa-token", "/agent/v1/nodes"); err != nil {
+		t.Fatalf("expected allowed after expiry, got %v", err)
+	}
+	if reviews != 4 {
+		t.Fatalf("expected cache expiry to trigger a new review, got %d reviews", reviews)
+	}
+}
@@ -1,114 +1,291 @@
 package exporter
 
 import (
-	"net/http"
+	"sort"
+	"strings"
 
-	"clustercost-agent-k8s/internal/aggregator"
+	"clustercost-agent-k8s/internal/snapshot"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// PrometheusExporter exposes cluster cost metrics to Prometheus.
-type PrometheusExporter struct {
-	registry      *prometheus.Registry
-	podCost       *prometheus.GaugeVec
-	namespaceCost *prometheus.GaugeVec
-	nodeAllocated *prometheus.GaugeVec
-	nodeRaw       *prometheus.GaugeVec
-	clusterCost   *prometheus.GaugeVec
+// otherSeries is the label value used for the series that absorbs everything
+// past a cardinality limit.
+const otherSeries = "__other__"
+
+// SnapshotCollectorConfig bounds the number of series the collector emits.
+type SnapshotCollectorConfig struct {
+	// LabelKeys are pod and namespace labels copied onto cost series.
+	LabelKeys []string
+	// MaxPodSeries caps clustercost_pod_cost_hourly; the most expensive pods
+	// are kept and the rest are summed into pod="__other__". Zero disables the
+	// pod family.
+	MaxPodSeries int
+	// MaxConnectionSeries caps connection series per level, ranked by egress
+	// cost then bytes.
+	MaxConnectionSeries int
+	// ConnectionLevels selects which connection graphs are exported: pod,
+	// workload, namespace, service.
+	ConnectionLevels []string
 }
 
-// NewPrometheusExporter initializes metrics collectors.
-func NewPrometheusExporter(clusterName string) *PrometheusExporter {
-	reg := prometheus.NewRegistry()
+// SnapshotCollector is a prometheus.Collector that reads the latest snapshot
+// from the store at scrape time, so /metrics always matches /agent/v1.
+type SnapshotCollector struct {
+	clusterName string
+	store       *snapshot.Store
+	cfg         SnapshotCollectorConfig
+
+	snapshotTime    *prometheus.Desc
+	clusterCost     *prometheus.Desc
+	clusterEgress   *prometheus.Desc
+	namespaceCost   *prometheus.Desc
+	namespacePods   *prometheus.Desc
+	namespaceCPUReq *prometheus.Desc
+	namespaceMemReq *prometheus.Desc
+	namespaceCPU    *prometheus.Desc
+	namespaceMem    *prometheus.Desc
+	namespaceEgress *prometheus.Desc
+	podCost         *prometheus.Desc
+	nodeAllocated   *prometheus.Desc
+	nodeRaw         *prometheus.Desc
+	nodeCPU         *prometheus.Desc
+	nodeMem         *prometheus.Desc
+	nodePods        *prometheus.Desc
+	nodePressure    *prometheus.Desc
+	classBytes      *prometheus.Desc
+	classEgress     *prometheus.Desc
+	connBytes       *prometheus.Desc
+	connEgress      *prometheus.Desc
+	truncated       *prometheus.Desc
+}
+
+// NewSnapshotCollector builds a SnapshotCollector bound to the store.
+func NewSnapshotCollector(clusterName string, store *snapshot.Store, cfg SnapshotCollectorConfig) *SnapshotCollector {
+	// Keys that map to the same Prometheus name would make every scrape fail.
+	var keys, labelNames []string
+	seen := map[string]struct{}{}
+	for _, key := range cfg.LabelKeys {
+		name := labelName(key)
+		if _, dup := seen[name]; dup {
+			continue
+		}
+		seen[name] = struct{}{}
+		keys = append(keys, key)
+		labelNames = append(labelNames, name)
+	}
+	cfg.LabelKeys = keys
+	concat := func(parts ...[]string) []string {
+		var out []string
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return out
+	}
+
+	c := &SnapshotCollector{
+		clusterName: clusterName,
+		store:       store,
+		cfg:         cfg,
+	}
+	c.snapshotTime = prometheus.NewDesc("clustercost_snapshot_timestamp_seconds", "Unix time of the snapshot backing these metrics", []string{"cluster_name"}, nil)
+	c.clusterCost = prometheus.NewDesc("clustercost_cluster_cost_hourly", "Estimated hourly cluster cost (sum of node prices)", []string{"cluster_name"}, nil)
+	c.clusterEgress = prometheus.NewDesc("clustercost_cluster_network_egress_cost", "Network egress cost for traffic in the last snapshot interval", []string{"cluster_name"}, nil)
+	c.namespaceCost = prometheus.NewDesc("clustercost_namespace_cost_hourly", "Estimated hourly namespace cost", concat([]string{"namespace"}, labelNames, []string{"environment", "cluster_name"}), nil)
+	c.namespacePods = prometheus.NewDesc("clustercost_namespace_pod_count", "Scheduled pods in the namespace", []string{"namespace", "cluster_name"}, nil)
+	c.namespaceCPUReq = prometheus.NewDesc("clustercost_namespace_cpu_request_millicores", "CPU requested by pods in the namespace", []string{"namespace", "cluster_name"}, nil)
+	c.namespaceMemReq = prometheus.NewDesc("clustercost_namespace_memory_request_bytes", "Memory requested by pods in the namespace", []string{"namespace", "cluster_name"}, nil)
+	c.namespaceCPU = prometheus.NewDesc("clustercost_namespace_cpu_usage_millicores", "CPU used by pods in the namespace", []string{"namespace", "cluster_name"}, nil)
+	c.namespaceMem = prometheus.NewDesc("clustercost_namespace_memory_usage_bytes", "Memory used by pods in the namespace", []string{"namespace", "cluster_name"}, nil)
+	c.namespaceEgress = prometheus.NewDesc("clustercost_namespace_network_egress_cost", "Network egress cost for namespace traffic in the last snapshot interval", []string{"namespace", "cluster_name"}, nil)
+	c.podCost = prometheus.NewDesc("clustercost_pod_cost_hourly", "Estimated hourly pod cost", concat([]string{"namespace", "pod", "node"}, labelNames, []string{"cluster_name", "controller_kind", "controller_name"}), nil)
+	c.nodeAllocated = prometheus.NewDesc("clustercost_node_cost_hourly", "Allocated hourly node cost (sum of pod costs)", []string{"node", "cluster_name"}, nil)
+	c.nodeRaw = prometheus.NewDesc("clustercost_node_raw_price_hourly", "Raw on-demand node price per hour", []string{"node", "instance_type", "cluster_name"}, nil)
+	c.nodeCPU = prometheus.NewDesc("clustercost_node_cpu_usage_percent", "Pod CPU usage as a percentage of node allocatable", []string{"node", "cluster_name"}, nil)
+	c.nodeMem = prometheus.NewDesc("clustercost_node_memory_usage_percent", "Pod memory usage as a percentage of node allocatable", []string{"node", "cluster_name"}, nil)
+	c.nodePods = prometheus.NewDesc("clustercost_node_pod_count", "Scheduled pods on the node", []string{"node", "cluster_name"}, nil)
+	c.nodePressure = prometheus.NewDesc("clustercost_node_under_pressure", "1 when the node reports memory, disk, or PID pressure", []string{"node", "cluster_name"}, nil)
+	c.classBytes = prometheus.NewDesc("clustercost_network_class_bytes", "Bytes per traffic class in the last snapshot interval", []string{"class", "direction", "cluster_name"}, nil)
+	c.classEgress = prometheus.NewDesc("clustercost_network_class_egress_cost", "Egress cost per traffic class in the last snapshot interval", []string{"class", "cluster_name"}, nil)
+	connLabels := []string{"level", "src_kind", "src_namespace", "src_name", "dst_kind", "dst_namespace", "dst_name", "class", "cluster_name"}
+	c.connBytes = prometheus.NewDesc("clustercost_network_connection_bytes", "Bytes between endpoints in the last snapshot interval", concat(connLabels, []string{"direction"}), nil)
+	c.connEgress = prometheus.NewDesc("clustercost_network_connection_egress_cost", "Egress cost between endpoints in the last snapshot interval", connLabels, nil)
+	c.truncated = prometheus.NewDesc("clustercost_exporter_series_truncated", "Items folded into the __other__ series by a cardinality limit", []string{"family", "cluster_name"}, nil)
+	return c
+}
 
-	podGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "clustercost_pod_cost_hourly",
-		Help: "Estimated hourly pod cost",
-	}, []string{"namespace", "pod", "node", "team", "service", "env", "client", "cluster_name", "controller_kind", "controller_name"})
+// Describe implements prometheus.Collector.
+func (c *SnapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.snapshotTime, c.clusterCost, c.clusterEgress,
+		c.namespaceCost, c.namespacePods, c.namespaceCPUReq, c.namespaceMemReq, c.namespaceCPU, c.namespaceMem, c.namespaceEgress,
+		c.podCost, c.nodeAllocated, c.nodeRaw, c.nodeCPU, c.nodeMem, c.nodePods, c.nodePressure,
+		c.classBytes, c.classEgress, c.connBytes, c.connEgress, c.truncated,
+	} {
+		ch <- d
+	}
+}
 
-	nsGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "clustercost_namespace_cost_hourly",
-		Help: "Estimated hourly namespace cost",
-	}, []string{"namespace", "team", "service", "env", "client", "cluster_name"})
+// Collect implements prometheus.Collector.
+func (c *SnapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	snap, ok := c.store.Latest()
+	if !ok {
+		return
+	}
+	c.CollectSnapshot(snap, ch)
+}
 
-	nodeAllocGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "clustercost_node_cost_hourly",
-		Help: "Allocated hourly node cost (sum of pod costs)",
-	}, []string{"node", "cluster_name"})
+// CollectSnapshot emits the series for snap rather than the latest snapshot
+// in the store, so pushers can label samples with the snapshot's own time.
+func (c *SnapshotCollector) CollectSnapshot(snap snapshot.Snapshot, ch chan<- prometheus.Metric) {
+	cluster := c.clusterName
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
 
-	nodeRawGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "clustercost_node_raw_price_hourly",
-		Help: "Raw on-demand node price per hour",
-	}, []string{"node", "instance_type", "cluster_name"})
+	gauge(c.snapshotTime, float64(snap.Timestamp.UnixNano())/1e9, cluster)
+	gauge(c.clusterCost, snap.Resources.TotalNodeHourlyCost, cluster)
+	gauge(c.clusterEgress, snap.Resources.NetworkEgressCostTotal, cluster)
 
-	clusterGauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "clustercost_cluster_cost_hourly",
-		Help: "Estimated hourly cluster cost",
-	}, []string{"cluster_name"})
+	for _, ns := range snap.Namespaces {
+		labels := append([]string{ns.Namespace}, c.labelValues(ns.Labels)...)
+		gauge(c.namespaceCost, ns.HourlyCost, append(labels, ns.Environment, cluster)...)
+		gauge(c.namespacePods, float64(ns.PodCount), ns.Namespace, cluster)
+		gauge(c.namespaceCPUReq, float64(ns.CPURequestMilli), ns.Namespace, cluster)
+		gauge(c.namespaceMemReq, float64(ns.MemoryRequestBytes), ns.Namespace, cluster)
+		gauge(c.namespaceCPU, float64(ns.CPUUsageMilli), ns.Namespace, cluster)
+		gauge(c.namespaceMem, float64(ns.MemoryUsageBytes), ns.Namespace, cluster)
+		gauge(c.namespaceEgress, ns.NetworkEgressCost, ns.Namespace, cluster)
+	}
 
-	reg.MustRegister(podGauge, nsGauge, nodeAllocGauge, nodeRawGauge, clusterGauge)
+	allocated := make(map[string]float64, len(snap.Nodes))
+	for _, pod := range snap.Pods {
+		allocated[pod.Node] += pod.HourlyCost
+	}
+	c.collectPods(snap.Pods, gauge)
 
-	exporter := &PrometheusExporter{
-		registry:      reg,
-		podCost:       podGauge,
-		namespaceCost: nsGauge,
-		nodeAllocated: nodeAllocGauge,
-		nodeRaw:       nodeRawGauge,
-		clusterCost:   clusterGauge,
+	for _, node := range snap.Nodes {
+		gauge(c.nodeAllocated, allocated[node.NodeName], node.NodeName, cluster)
+		gauge(c.nodeRaw, node.HourlyCost, node.NodeName, node.InstanceType, cluster)
+		gauge(c.nodeCPU, node.CPUUsagePercent, node.NodeName, cluster)
+		gauge(c.nodeMem, node.MemoryUsagePercent, node.NodeName, cluster)
+		gauge(c.nodePods, float64(node.PodCount), node.NodeName, cluster)
+		pressure := 0.0
+		if node.IsUnderPressure {
+			pressure = 1
+		}
+		gauge(c.nodePressure, pressure, node.NodeName, cluster)
 	}
 
-	clusterGauge.WithLabelValues(clusterName).Set(0)
-	return exporter
+	for _, class := range snap.Network.ByClass {
+		gauge(c.classBytes, float64(class.TxBytes), class.Class, "tx", cluster)
+		gauge(c.classBytes, float64(class.RxBytes), class.Class, "rx", cluster)
+		gauge(c.classEgress, class.EgressCostHourly, class.Class, cluster)
+	}
+
+	graphs := map[string][]snapshot.NetworkConnection{
+		"pod":       snap.Network.PodConnections,
+		"workload":  snap.Network.WorkloadConnections,
+		"namespace": snap.Network.NamespaceConnections,
+		"service":   snap.Network.ServiceConnections,
+	}
+	for _, level := range c.cfg.ConnectionLevels {
+		c.collectConnections(level, graphs[level], gauge)
+	}
 }
 
-// Handler returns the HTTP handler for /metrics.
-func (p *PrometheusExporter) Handler() http.Handler {
-	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+func (c *SnapshotCollector) collectPods(pods []snapshot.PodCostRecord, gauge func(*prometheus.Desc, float64, ...string)) {
+	if c.cfg.MaxPodSeries <= 0 {
+		return
+	}
+	kept, rest := pods, []snapshot.PodCostRecord(nil)
+	if len(pods) > c.cfg.MaxPodSeries {
+		ranked := append([]snapshot.PodCostRecord(nil), pods...)
+		sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].HourlyCost > ranked[j].HourlyCost })
+		kept, rest = ranked[:c.cfg.MaxPodSeries], ranked[c.cfg.MaxPodSeries:]
+	}
+	for _, pod := range kept {
+		labels := append([]string{pod.Namespace, pod.Pod, pod.Node}, c.labelValues(pod.Labels)...)
+		gauge(c.podCost, pod.HourlyCost, append(labels, c.clusterName, pod.ControllerKind, pod.ControllerName)...)
+	}
+	if len(rest) == 0 {
+		gauge(c.truncated, 0, "pod", c.clusterName)
+		return
+	}
+	var other float64
+	for _, pod := range rest {
+		other += pod.HourlyCost
+	}
+	labels := append([]string{otherSeries, otherSeries, ""}, c.labelValues(nil)...)
+	gauge(c.podCost, other, append(labels, c.clusterName, "", "")...)
+	gauge(c.truncated, float64(len(rest)), "pod", c.clusterName)
 }
 
-// Update copies aggregated data into Prometheus metrics.
-func (p *PrometheusExporter) Update(data aggregator.AggregatedData) {
-	p.podCost.Reset()
-	p.namespaceCost.Reset()
-	p.nodeAllocated.Reset()
-	p.nodeRaw.Reset()
-	p.clusterCost.Reset()
-
-	clusterName := data.Cluster.ClusterName
-	p.clusterCost.WithLabelValues(clusterName).Set(data.Cluster.HourlyCost)
-
-	for _, pod := range data.Pods {
-		labels := []string{
-			pod.Namespace,
-			pod.Pod,
-			pod.Node,
-			pod.Labels["team"],
-			pod.Labels["service"],
-			pod.Labels["env"],
-			pod.Labels["client"],
-			clusterName,
-			pod.ControllerKind,
-			pod.ControllerName,
-		}
-		p.podCost.WithLabelValues(labels...).Set(pod.HourlyCost)
+func (c *SnapshotCollector) collectConnections(level string, conns []snapshot.NetworkConnection, gauge func(*prometheus.Desc, float64, ...string)) {
+	family := "connection_" + level
+	kept, rest := conns, []snapshot.NetworkConnection(nil)
+	if c.cfg.MaxConnectionSeries > 0 && len(conns) > c.cfg.MaxConnectionSeries {
+		ranked := append([]snapshot.NetworkConnection(nil), conns...)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			if ranked[i].EgressCostHourly != ranked[j].EgressCostHourly {
+				return ranked[i].EgressCostHourly > ranked[j].EgressCostHourly
+			}
+			return ranked[i].TxBytes+ranked[i].RxBytes > ranked[j].TxBytes+ranked[j].RxBytes
+		})
+		kept, rest = ranked[:c.cfg.MaxConnectionSeries], ranked[c.cfg.MaxConnectionSeries:]
+	}
+	emit := func(labels []string, tx, rx uint64, cost float64) {
+		gauge(c.connBytes, float64(tx), append(labels, "tx")...)
+		gauge(c.connBytes, float64(rx), append(labels, "rx")...)
+		gauge(c.connEgress, cost, labels...)
 	}
+	for _, conn := range kept {
+		emit([]string{
+			level,
+			conn.Source.Kind, conn.Source.Namespace, conn.Source.Name,
+			conn.Destination.Kind, conn.Destination.Namespace, conn.Destination.Name,
+			conn.Class, c.clusterName,
+		}, conn.TxBytes, conn.RxBytes, conn.EgressCostHourly)
+	}
+	if len(rest) == 0 {
+		gauge(c.truncated, 0, family, c.clusterName)
+		return
+	}
+	var tx, rx uint64
+	var cost float64
+	for _, conn := range rest {
+		tx += conn.TxBytes
+		rx += conn.RxBytes
+		cost += conn.EgressCostHourly
+	}
+	emit([]string{level, otherSeries, "", otherSeries, otherSeries, "", otherSeries, otherSeries, c.clusterName}, tx, rx, cost)
+	gauge(c.truncated, float64(len(rest)), family, c.clusterName)
+}
 
-	for _, ns := range data.Namespaces {
-		labels := []string{
-			ns.Namespace,
-			ns.Labels["team"],
-			ns.Labels["service"],
-			ns.Labels["env"],
-			ns.Labels["client"],
-			clusterName,
-		}
-		p.namespaceCost.WithLabelValues(labels...).Set(ns.HourlyCost)
+func (c *SnapshotCollector) labelValues(labels map[string]string) []string {
+	values := make([]string, len(c.cfg.LabelKeys))
+	for i, key := range c.cfg.LabelKeys {
+		values[i] = labels[key]
 	}
+	return values
+}
+
+var reservedLabels = map[string]struct{}{
+	"namespace": {}, "pod": {}, "node": {}, "environment": {}, "cluster_name": {},
+	"controller_kind": {}, "controller_name": {},
+}
 
-	for _, node := range data.Nodes {
-		p.nodeAllocated.WithLabelValues(node.Name, clusterName).Set(node.AllocatedCostHourly)
-		p.nodeRaw.WithLabelValues(node.Name, node.InstanceType, clusterName).Set(node.RawNodePriceHourly)
+// labelName maps a Kubernetes label key to a Prometheus label name, prefixing
+// it with label_ when it would collide with a fixed label.
+func labelName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+	if _, reserved := reservedLabels[name]; reserved || name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "label_" + name
 	}
+	return name
 }
@@ -0,0 +1,173 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/accumulator"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSnapshotCollectorReadsStoreAtScrape(t *testing.T) {
+	store := snapshot.NewStore()
+	collector := NewSnapshotCollector("prod", store, SnapshotCollectorConfig{
+		LabelKeys:           []string{"team", "app.kubernetes.io/name", "app_kubernetes_io_name"},
+		MaxPodSeries:        2,
+		MaxConnectionSeries: 1,
+		ConnectionLevels:    []string{"namespace"},
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	if got := gather(t, registry); len(got) != 0 {
+		t.Fatalf("expected no series before the first snapshot, got %v", got)
+	}
+
+	store.Update(snapshot.Snapshot{
+		Timestamp: time.Unix(1700000000, 0),
+		Namespaces: []snapshot.NamespaceCostRecord{
+			{Namespace: "payments", HourlyCost: 0.3, Environment: "production", Labels: map[string]string{"team": "checkout"}},
+		},
+		Pods: []snapshot.PodCostRecord{
+			{Namespace: "payments", Pod: "api-0", Node: "node-a", HourlyCost: 0.2, ControllerKind: "Deployment", ControllerName: "api", Labels: map[string]string{"app.kubernetes.io/name": "api"}},
+			{Namespace: "payments", Pod: "api-1", Node: "node-a", HourlyCost: 0.1},
+			{Namespace: "payments", Pod: "cron-1", Node: "node-b", HourlyCost: 0.01},
+			{Namespace: "payments", Pod: "cron-2", Node: "node-b", HourlyCost: 0.02},
+		},
+		Nodes: []snapshot.NodeCostRecord{
+			{NodeName: "node-a", HourlyCost: 0.5, InstanceType: "m5.large"},
+		},
+		Resources: snapshot.ResourceSnapshot{TotalNodeHourlyCost: 0.5},
+		Network: snapshot.NetworkSnapshot{
+			ByClass: []snapshot.NetworkClassTotals{{Class: "internet_egress", TxBytes: 100, RxBytes: 10, EgressCostHourly: 0.01}},
+			NamespaceConnections: []snapshot.NetworkConnection{
+				{Source: snapshot.NetworkEndpoint{Kind: "namespace", Name: "payments"}, Destination: snapshot.NetworkEndpoint{Kind: "external", Name: "internet_egress"}, Class: "internet_egress", TxBytes: 100, EgressCostHourly: 0.01},
+				{Source: snapshot.NetworkEndpoint{Kind: "namespace", Name: "payments"}, Destination: snapshot.NetworkEndpoint{Kind: "namespace", Name: "db"}, Class: "intra_az", TxBytes: 50},
+				{Source: snapshot.NetworkEndpoint{Kind: "namespace", Name: "web"}, Destination: snapshot.NetworkEndpoint{Kind: "namespace", Name: "db"}, Class: "intra_az", TxBytes: 20},
+			},
+			PodConnections: []snapshot.NetworkConnection{
+				{Source: snapshot.NetworkEndpoint{Kind: "pod", Namespace: "payments", Name: "api-0"}, Class: "intra_az", TxBytes: 1},
+			},
+		},
+	})
+
+	got := gather(t, registry)
+	want := []string{
+		`clustercost_cluster_cost_hourly{cluster_name="prod"} 0.5`,
+		`clustercost_namespace_cost_hourly{app_kubernetes_io_name="",cluster_name="prod",environment="production",namespace="payments",team="checkout"} 0.3`,
+		`clustercost_node_cost_hourly{cluster_name="prod",node="node-a"} 0.30000000000000004`,
+		`clustercost_node_raw_price_hourly{cluster_name="prod",instance_type="m5.large",node="node-a"} 0.5`,
+		`clustercost_pod_cost_hourly{app_kubernetes_io_name="api",cluster_name="prod",controller_kind="Deployment",controller_name="api",namespace="payments",node="node-a",pod="api-0",team=""} 0.2`,
+		`clustercost_pod_cost_hourly{app_kubernetes_io_name="",cluster_name="prod",controller_kind="",controller_name="",namespace="__other__",node="",pod="__other__",team=""} 0.03`,
+		`clustercost_exporter_series_truncated{cluster_name="prod",family="pod"} 2`,
+		`clustercost_network_class_bytes{class="internet_egress",cluster_name="prod",direction="tx"} 100`,
+		`clustercost_network_connection_egress_cost{class="internet_egress",cluster_name="prod",dst_kind="external",dst_name="internet_egress",dst_namespace="",level="namespace",src_kind="namespace",src_name="payments",src_namespace=""} 0.01`,
+		`clustercost_network_connection_bytes{class="__other__",cluster_name="prod",direction="tx",dst_kind="__other__",dst_name="__other__",dst_namespace="",level="namespace",src_kind="__other__",src_name="__other__",src_namespace=""} 70`,
+		`clustercost_exporter_series_truncated{cluster_name="prod",family="connection_namespace"} 2`,
+	}
+	for _, line := range want {
+		if !contains(got, line) {
+			t.Errorf("missing series %s", line)
+		}
+	}
+	for _, line := range got {
+		if strings.Contains(line, `level="pod"`) {
+			t.Errorf("pod connections exported although not configured: %s", line)
+		}
+	}
+}
+
+func TestLabelName(t *testing.T) {
+	cases := map[string]string{
+		"team":                   "team",
+		"app.kubernetes.io/name": "app_kubernetes_io_name",
+		"namespace":              "label_namespace",
+		"1st":                    "label_1st",
+	}
+	for in, want := range cases {
+		if got := labelName(in); got != want {
+			t.Errorf("labelName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// gather renders every gauge as name{labels} value with labels sorted by name.
+func TestAccumulatedCostCollectorEmitsStorageAndShared(t *testing.T) {
+	costs := accumulator.New(accumulator.Config{StorageGiBMonthPrice: 7.3}, nil)
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	snap := snapshot.Snapshot{
+		Timestamp:  start,
+		Namespaces: []snapshot.NamespaceCostRecord{{Namespace: "payments", HourlyCost: 0.5, SharedCostHourly: 0.1}},
+		// 10 GiB at 7.3 per GiB-month is 0.1 per hour.
+		Pods:      []snapshot.PodCostRecord{{Namespace: "payments", Pod: "api-0", HourlyCost: 0.5, StorageRequestBytes: 10 << 30}},
+		Resources: snapshot.ResourceSnapshot{TotalNodeHourlyCost: 1},
+	}
+	costs.Observe(snap)
+	snap.Timestamp = start.Add(time.Hour)
+	costs.Observe(snap)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewAccumulatedCostCollector("prod", costs))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	got := map[string]float64{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["window"] == "day" {
+				got[mf.GetName()+"/"+labels["component"]] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	want := map[string]float64{
+		"clustercost_cluster_cost_usd_total/storage":   0.1,
+		"clustercost_namespace_cost_usd_total/compute": 0.5,
+		"clustercost_namespace_cost_usd_total/storage": 0.1,
+		"clustercost_namespace_shared_cost_usd_total/": 0.1,
+	}
+	for key, value := range want {
+		if math.Abs(got[key]-value) > 1e-9 {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func gather(t *testing.T, g prometheus.Gatherer) []string {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	var out []string
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			pairs := make([]string, 0, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", lp.GetName(), lp.GetValue()))
+			}
+			sort.Strings(pairs)
+			out = append(out, fmt.Sprintf("%s{%s} %v", mf.GetName(), strings.Join(pairs, ","), m.GetGauge().GetValue()))
+		}
+	}
+	return out
+}
+
+func contains(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
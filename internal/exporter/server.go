@@ -2,6 +2,7 @@ package exporter
 
 import (
 	"context"
+	"crypto/tls"
 	"log/slog"
 	"net/http"
 	"time"
@@ -10,6 +11,7 @@ import (
 // Server wraps the HTTP server with graceful shutdown support.
 type Server struct {
 	httpServer *http.Server
+	certs      *CertReloader
 	logger     *slog.Logger
 }
 
@@ -23,12 +25,36 @@ func NewServer(addr string, handler http.Handler, logger *slog.Logger) *Server {
 	return &Server{httpServer: srv, logger: logger}
 }
 
+// EnableTLS serves HTTPS with certificates from the reloader.
+func (s *Server) EnableTLS(certs *CertReloader) {
+	s.certs = certs
+	s.httpServer.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+}
+
+// RegisterOnShutdown registers a function to call when the server begins
+// shutting down, so long-lived handlers such as event streams can return.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.httpServer.RegisterOnShutdown(f)
+}
+
 // Run starts the HTTP server and blocks until the context is cancelled.
 func (s *Server) Run(ctx context.Context) error {
 	errCh := make(chan error, 1)
+	if s.certs != nil {
+		go s.certs.Run(ctx)
+	}
 	go func() {
-		s.logger.Info("starting HTTP server", slog.String("addr", s.httpServer.Addr))
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.Info("starting HTTP server", slog.String("addr", s.httpServer.Addr), slog.Bool("tls", s.certs != nil))
+		var err error
+		if s.certs != nil {
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errCh <- err
 			return
 		}
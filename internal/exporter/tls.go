@@ -0,0 +1,97 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a TLS key pair that is re-read from disk whenever the
+// files change, so rotated Secrets or cert-manager renewals take effect
+// without restarting the agent.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// NewCertReloader loads the key pair once and fails if it is unusable.
+func NewCertReloader(certFile, keyFile string, interval time.Duration, logger *slog.Logger) (*CertReloader, error) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	r := &CertReloader{certFile: certFile, keyFile: keyFile, interval: interval, logger: logger}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Run polls the files until ctx is cancelled. A pair that fails to parse is
+// ignored and the previous certificate stays in use.
+func (r *CertReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.reload()
+			if err != nil {
+				r.logger.Warn("tls reload failed; keeping previous certificate", slog.String("error", err.Error()))
+				continue
+			}
+			if changed {
+				r.logger.Info("tls certificate reloaded", slog.String("certFile", r.certFile))
+			}
+		}
+	}
+}
+
+func (r *CertReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, fmt.Errorf("read tls cert: %w", err)
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("read tls key: %w", err)
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("parse tls key pair: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.mu.Unlock()
+	return true, nil
+}
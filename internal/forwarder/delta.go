@@ -0,0 +1,232 @@
+package forwarder
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+// ErrGap is returned by Apply when a delta was not encoded against the
+// report the receiver holds, so the sender must resync with a keyframe.
+var ErrGap = errors.New("delta base does not match the held report")
+
+// Delta marks a report whose Snapshot lists only the records that changed
+// since the report numbered BaseSequence. The snapshot header, resource
+// totals and network totals are always complete.
+type Delta struct {
+	BaseSequence uint64      `json:"baseSequence"`
+	Removed      RemovedKeys `json:"removed"`
+}
+
+// RemovedKeys lists the records that disappeared since the base report, by
+// the key each list is matched on: the namespace, namespace/pod, node name,
+// traffic class, or ConnectionKey.
+type RemovedKeys struct {
+	Namespaces           []string `json:"namespaces,omitempty"`
+	Pods                 []string `json:"pods,omitempty"`
+	Nodes                []string `json:"nodes,omitempty"`
+	NetworkClasses       []string `json:"networkClasses,omitempty"`
+	NetworkPods          []string `json:"networkPods,omitempty"`
+	NetworkNamespaces    []string `json:"networkNamespaces,omitempty"`
+	PodConnections       []string `json:"podConnections,omitempty"`
+	WorkloadConnections  []string `json:"workloadConnections,omitempty"`
+	NamespaceConnections []string `json:"namespaceConnections,omitempty"`
+	ServiceConnections   []string `json:"serviceConnections,omitempty"`
+}
+
+// ConnectionKey identifies a connection in RemovedKeys as
+// "kind/namespace/name>kind/namespace/name|class".
+func ConnectionKey(conn snapshot.NetworkConnection) string {
+	return endpointKey(conn.Source) + ">" + endpointKey(conn.Destination) + "|" + conn.Class
+}
+
+func endpointKey(e snapshot.NetworkEndpoint) string {
+	return e.Kind + "/" + e.Namespace + "/" + e.Name
+}
+
+func namespaceKey(ns snapshot.NamespaceCostRecord) string { return ns.Namespace }
+func podKey(pod snapshot.PodCostRecord) string            { return pod.Namespace + "/" + pod.Pod }
+func nodeKey(node snapshot.NodeCostRecord) string         { return node.NodeName }
+func classKey(class snapshot.NetworkClassTotals) string   { return class.Class }
+func podNetworkKey(pod snapshot.PodNetworkRecord) string  { return pod.Namespace + "/" + pod.Pod }
+func namespaceNetworkKey(ns snapshot.NamespaceNetworkRecord) string {
+	return ns.Namespace
+}
+
+// Diff encodes next as a delta against base, which must be the last report
+// the receiver applied.
+func Diff(base, next AgentReport) AgentReport {
+	out := next
+	out.Delta = &Delta{BaseSequence: base.Sequence}
+	b, n, s, r := base.Snapshot, next.Snapshot, &out.Snapshot, &out.Delta.Removed
+	s.Namespaces, r.Namespaces = diffRecords(b.Namespaces, n.Namespaces, namespaceKey)
+	s.Pods, r.Pods = diffRecords(b.Pods, n.Pods, podKey)
+	s.Nodes, r.Nodes = diffRecords(b.Nodes, n.Nodes, nodeKey)
+	s.Network.ByClass, r.NetworkClasses = diffRecords(b.Network.ByClass, n.Network.ByClass, classKey)
+	s.Network.Pods, r.NetworkPods = diffRecords(b.Network.Pods, n.Network.Pods, podNetworkKey)
+	s.Network.Namespaces, r.NetworkNamespaces = diffRecords(b.Network.Namespaces, n.Network.Namespaces, namespaceNetworkKey)
+	s.Network.PodConnections, r.PodConnections = diffRecords(b.Network.PodConnections, n.Network.PodConnections, ConnectionKey)
+	s.Network.WorkloadConnections, r.WorkloadConnections = diffRecords(b.Network.WorkloadConnections, n.Network.WorkloadConnections, ConnectionKey)
+	s.Network.NamespaceConnections, r.NamespaceConnections = diffRecords(b.Network.NamespaceConnections, n.Network.NamespaceConnections, ConnectionKey)
+	s.Network.ServiceConnections, r.ServiceConnections = diffRecords(b.Network.ServiceConnections, n.Network.ServiceConnections, ConnectionKey)
+	return out
+}
+
+// Apply rebuilds the full report that delta encodes on top of base. A report
+// without Delta is returned as is. It returns ErrGap unless base is the
+// report the delta was encoded against.
+func Apply(base, delta AgentReport) (AgentReport, error) {
+	if delta.Delta == nil {
+		return delta, nil
+	}
+	if base.Sequence == 0 || base.Sequence != delta.Delta.BaseSequence {
+		return AgentReport{}, ErrGap
+	}
+	out := delta
+	out.Delta = nil
+	b, d, s, r := base.Snapshot, delta.Snapshot, &out.Snapshot, delta.Delta.Removed
+	s.Namespaces = applyRecords(b.Namespaces, d.Namespaces, r.Namespaces, namespaceKey)
+	s.Pods = applyRecords(b.Pods, d.Pods, r.Pods, podKey)
+	s.Nodes = applyRecords(b.Nodes, d.Nodes, r.Nodes, nodeKey)
+	s.Network.ByClass = applyRecords(b.Network.ByClass, d.Network.ByClass, r.NetworkClasses, classKey)
+	s.Network.Pods = applyRecords(b.Network.Pods, d.Network.Pods, r.NetworkPods, podNetworkKey)
+	s.Network.Namespaces = applyRecords(b.Network.Namespaces, d.Network.Namespaces, r.NetworkNamespaces, namespaceNetworkKey)
+	s.Network.PodConnections = applyRecords(b.Network.PodConnections, d.Network.PodConnections, r.PodConnections, ConnectionKey)
+	s.Network.WorkloadConnections = applyRecords(b.Network.WorkloadConnections, d.Network.WorkloadConnections, r.WorkloadConnections, ConnectionKey)
+	s.Network.NamespaceConnections = applyRecords(b.Network.NamespaceConnections, d.Network.NamespaceConnections, r.NamespaceConnections, ConnectionKey)
+	s.Network.ServiceConnections = applyRecords(b.Network.ServiceConnections, d.Network.ServiceConnections, r.ServiceConnections, ConnectionKey)
+	return out, nil
+}
+
+// diffRecords returns the records of next that are new or differ from base,
+// and the sorted keys of base records missing from next.
+func diffRecords[T any](base, next []T, key func(T) string) ([]T, []string) {
+	held := make(map[string]T, len(base))
+	for _, rec := range base {
+		held[key(rec)] = rec
+	}
+	changed := make([]T, 0)
+	for _, rec := range next {
+		k := key(rec)
+		if old, ok := held[k]; ok {
+			delete(held, k)
+			if reflect.DeepEqual(old, rec) {
+				continue
+			}
+		}
+		changed = append(changed, rec)
+	}
+	var removed []string
+	for k := range held {
+		removed = append(removed, k)
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// applyRecords replaces changed records in place, drops removed ones, and
+// appends new ones in the order they were sent.
+func applyRecords[T any](base, changed []T, removed []string, key func(T) string) []T {
+	drop := make(map[string]bool, len(removed))
+	for _, k := range removed {
+		drop[k] = true
+	}
+	updates := make(map[string]T, len(changed))
+	for _, rec := range changed {
+		updates[key(rec)] = rec
+	}
+	out := make([]T, 0, len(base)+len(changed))
+	for _, rec := range base {
+		k := key(rec)
+		if drop[k] {
+			continue
+		}
+		if update, ok := updates[k]; ok {
+			out = append(out, update)
+			delete(updates, k)
+			continue
+		}
+		out = append(out, rec)
+	}
+	for _, rec := range changed {
+		if _, ok := updates[key(rec)]; ok {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// DeltaEncoder encodes reports, numbered by an Identity as they are queued,
+// at send time against the last report the central agent acknowledged.
+// Retried batches are therefore re-encoded against whatever was delivered
+// since.
+type DeltaEncoder struct {
+	mu            sync.Mutex
+	keyframeEvery int
+	acked         *AgentReport
+	sinceKeyframe int
+}
+
+// NewDeltaEncoder sends a full keyframe after every keyframeEvery deltas.
+func NewDeltaEncoder(keyframeEvery int) *DeltaEncoder {
+	if keyframeEvery <= 0 {
+		keyframeEvery = 30
+	}
+	return &DeltaEncoder{keyframeEvery: keyframeEvery}
+}
+
+// Encode orders full reports by sequence. Reports not newer than the
+// acknowledged one, such as retries that waited out a backoff, are sent as
+// keyframes and do not move the base. Each other report becomes a delta
+// against the newer report before it, the first against the acknowledged
+// report, or a keyframe when nothing is acknowledged or one is due.
+// from[i] is the position in reports of wire[i]. commit records the batch
+// as acknowledged; call it only once the receiver took it.
+func (e *DeltaEncoder) Encode(reports []AgentReport) (wire []AgentReport, from []int, commit func()) {
+	e.mu.Lock()
+	base, since := e.acked, e.sinceKeyframe
+	e.mu.Unlock()
+
+	ordered := append([]AgentReport{}, reports...)
+	order := make([]int, len(reports))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ordered[order[i]].Sequence < ordered[order[j]].Sequence })
+	wire = make([]AgentReport, 0, len(ordered))
+	for _, i := range order {
+		report := ordered[i]
+		if base != nil && report.Sequence <= base.Sequence {
+			wire = append(wire, report)
+			from = append(from, i)
+			continue
+		}
+		if base == nil || since >= e.keyframeEvery {
+			wire = append(wire, report)
+			since = 0
+		} else {
+			wire = append(wire, Diff(*base, report))
+			since++
+		}
+		from = append(from, i)
+		base = &ordered[i]
+	}
+	return wire, from, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if base != nil && (e.acked == nil || base.Sequence > e.acked.Sequence) {
+			e.acked, e.sinceKeyframe = base, since
+		}
+	}
+}
+
+// Reset forgets the acknowledged report so the next batch starts with a
+// keyframe.
+func (e *DeltaEncoder) Reset() {
+	e.mu.Lock()
+	e.acked, e.sinceKeyframe = nil, 0
+	e.mu.Unlock()
+}
@@ -0,0 +1,215 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func deltaReport(seq uint64, webCost float64, pods ...string) AgentReport {
+	snap := snapshot.Snapshot{
+		Namespaces: []snapshot.NamespaceCostRecord{{Namespace: "shop", HourlyCost: webCost}},
+		Nodes:      []snapshot.NodeCostRecord{{NodeName: "a", HourlyCost: 1}},
+		Pods:       []snapshot.PodCostRecord{},
+		Network: snapshot.NetworkSnapshot{
+			ByClass:             []snapshot.NetworkClassTotals{},
+			Pods:                []snapshot.PodNetworkRecord{},
+			Namespaces:          []snapshot.NamespaceNetworkRecord{},
+			PodConnections:      []snapshot.NetworkConnection{},
+			WorkloadConnections: []snapshot.NetworkConnection{},
+			ServiceConnections:  []snapshot.NetworkConnection{},
+			NamespaceConnections: []snapshot.NetworkConnection{{
+				Source:      snapshot.NetworkEndpoint{Kind: "namespace", Name: "shop"},
+				Destination: snapshot.NetworkEndpoint{Kind: "external", Name: "internet"},
+				TxBytes:     uint64(seq),
+			}},
+		},
+	}
+	for _, pod := range pods {
+		snap.Pods = append(snap.Pods, snapshot.PodCostRecord{Namespace: "shop", Pod: pod, HourlyCost: webCost})
+	}
+	return AgentReport{NodeName: "a", Sequence: seq, Timestamp: time.Unix(int64(seq), 0), Snapshot: snap}
+}
+
+func TestDiffAndApplyRoundTrip(t *testing.T) {
+	base := deltaReport(1, 0.5, "web-1", "web-2")
+	next := deltaReport(2, 0.5, "web-1", "web-3")
+	next.Snapshot.Pods[0].CPUUsageMilli = 40
+
+	delta := Diff(base, next)
+	if delta.Delta == nil || delta.Delta.BaseSequence != 1 {
+		t.Fatalf("expected a delta against 1, got %+v", delta.Delta)
+	}
+	if len(delta.Snapshot.Namespaces) != 0 || len(delta.Snapshot.Nodes) != 0 {
+		t.Fatalf("expected unchanged records to be left out, got %+v", delta.Snapshot)
+	}
+	if len(delta.Snapshot.Pods) != 2 || !reflect.DeepEqual(delta.Delta.Removed.Pods, []string{"shop/web-2"}) {
+		t.Fatalf("unexpected pod delta %+v removed %v", delta.Snapshot.Pods, delta.Delta.Removed.Pods)
+	}
+	if len(delta.Snapshot.Network.NamespaceConnections) != 1 {
+		t.Fatalf("expected the changed connection, got %+v", delta.Snapshot.Network.NamespaceConnections)
+	}
+
+	// Round-trip through JSON as the receiver sees it.
+	data, err := json.Marshal(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire AgentReport
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatal(err)
+	}
+	full, err := Apply(base, wire)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	got, _ := json.Marshal(full.Snapshot)
+	want, _ := json.Marshal(next.Snapshot)
+	if full.Delta != nil || string(got) != string(want) {
+		t.Fatalf("rebuilt snapshot differs:\n got %s\nwant %s", got, want)
+	}
+
+	if _, err := Apply(deltaReport(7, 0.5), wire); !errors.Is(err, ErrGap) {
+		t.Fatalf("expected a gap against the wrong base, got %v", err)
+	}
+}
+
+func TestDeltaEncoderKeyframesAndAcks(t *testing.T) {
+	enc := NewDeltaEncoder(2)
+	first := uint64(100)
+	batch := []AgentReport{deltaReport(first+1, 0.5), deltaReport(first, 0.5)}
+
+	wire, from, commit := enc.Encode(batch)
+	if len(wire) != 2 || wire[0].Delta != nil || wire[0].Sequence != first || wire[1].Delta == nil {
+		t.Fatalf("expected a keyframe then a delta in sequence order, got %+v", wire)
+	}
+	if !reflect.DeepEqual(from, []int{1, 0}) {
+		t.Fatalf("expected wire reports traced to their batch positions, got %v", from)
+	}
+
+	// Not acknowledged: the retry is encoded from scratch again.
+	if wire, _, _ = enc.Encode(batch); wire[0].Delta != nil {
+		t.Fatal("expected a keyframe while nothing is acknowledged")
+	}
+	commit()
+
+	wire, from, commit = enc.Encode(append(batch, deltaReport(first+2, 0.5), deltaReport(first+3, 0.5)))
+	if len(wire) != 4 || !reflect.DeepEqual(from, []int{1, 0, 2, 3}) {
+		t.Fatalf("expected every report on the wire, got %d from %v", len(wire), from)
+	}
+	if wire[0].Delta != nil || wire[1].Delta != nil {
+		t.Fatalf("expected acknowledged reports to be sent as keyframes, got %+v", wire[:2])
+	}
+	if wire[2].Delta == nil || wire[2].Delta.BaseSequence != first+1 || wire[3].Delta != nil {
+		t.Fatalf("expected a delta then a due keyframe, got %+v", wire[2:])
+	}
+	commit()
+
+	enc.Reset()
+	if wire, _, _ = enc.Encode([]AgentReport{deltaReport(first+4, 0.5)}); wire[0].Delta != nil {
+		t.Fatal("expected a keyframe after a reset")
+	}
+}
+
+func TestQueueResendsKeyframeOnResync(t *testing.T) {
+	var batches [][]AgentReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string][]AgentReport
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		reports := payload["reports"]
+		batches = append(batches, reports)
+		if reports[0].Delta != nil && len(batches) == 2 {
+			_ = json.NewEncoder(w).Encode(Ack{Resync: []string{"a"}})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL, "", 2*time.Second, false)
+	queue := NewQueue(t.TempDir(), 10, 3, time.Second, time.Second, 1<<20, 10, sender, noopLogger())
+	queue.EnableDelta(30)
+
+	for i := 0; i < 2; i++ {
+		if err := queue.Enqueue(deltaReport(0, 0.5, "web-1")); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+		queue.flushMemory(context.Background())
+	}
+
+	if len(batches) != 3 {
+		t.Fatalf("expected keyframe, delta and resent keyframe, got %d batches", len(batches))
+	}
+	if batches[0][0].Delta != nil || batches[1][0].Delta == nil || batches[2][0].Delta != nil {
+		t.Fatalf("unexpected encodings %+v", batches)
+	}
+	if batches[2][0].Sequence != batches[1][0].Sequence {
+		t.Fatal("expected the same report to be resent as a keyframe")
+	}
+}
+
+func TestQueueDeliversRetriedReportsOlderThanTheAcknowledged(t *testing.T) {
+	var batches [][]AgentReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string][]AgentReport
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		batches = append(batches, payload["reports"])
+		if len(batches) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	sender := NewSender(server.URL, "", 2*time.Second, false)
+	queue := NewQueue(dir, 10, 3, time.Millisecond, time.Second, 1<<20, 10, sender, noopLogger())
+	queue.EnableDelta(30)
+
+	// The first report fails and is spooled to disk for a retry.
+	if err := queue.Enqueue(deltaReport(0, 0.5, "web-1")); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	queue.flushMemory(context.Background())
+	if files, _ := countQueueFiles(dir); files != 1 {
+		t.Fatalf("expected the failed report on disk, got %d files", files)
+	}
+
+	// A newer report is delivered while the retry waits out its backoff.
+	if err := queue.Enqueue(deltaReport(0, 0.7, "web-1")); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	queue.flushMemory(context.Background())
+
+	entries, _ := os.ReadDir(dir)
+	old := time.Now().Add(-time.Hour)
+	for _, entry := range entries {
+		_ = os.Chtimes(filepath.Join(dir, entry.Name()), old, old)
+	}
+	queue.flushOnce(context.Background())
+
+	if len(batches) != 3 || len(batches[2]) != 1 {
+		t.Fatalf("expected the failed report to be posted again, got %d batches", len(batches))
+	}
+	retried := batches[2][0]
+	if retried.Sequence != batches[0][0].Sequence || retried.Delta != nil {
+		t.Fatalf("expected the older report resent as a keyframe, got %+v", retried)
+	}
+	if files, _ := countQueueFiles(dir); files != 0 {
+		t.Fatalf("expected the queue to drain, %d files left", files)
+	}
+}
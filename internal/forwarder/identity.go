@@ -0,0 +1,52 @@
+package forwarder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity names the reports one agent process queues. A report ID is the
+// process's random instance UUID followed by the report's sequence, so it
+// is unique across agents and restarts and survives in queue files.
+type Identity struct {
+	instance string
+	sequence atomic.Uint64
+}
+
+// NewIdentity draws a new instance UUID. Sequences start from the wall
+// clock so they keep increasing across restarts, ahead of any report still
+// spooled by a previous run.
+func NewIdentity() *Identity {
+	id := &Identity{instance: uuid.NewString()}
+	id.sequence.Store(uint64(time.Now().UnixNano()))
+	return id
+}
+
+// Next returns the ID and sequence of the next report.
+func (i *Identity) Next() (string, uint64) {
+	seq := i.sequence.Add(1)
+	return i.instance + "-" + strconv.FormatUint(seq, 10), seq
+}
+
+// BatchKey is the Idempotency-Key of a batch: a hash of its report IDs in
+// sorted order, so every retry of the same reports carries the same key.
+// It is empty when a report has no ID, as in files spooled by older agents.
+func BatchKey(reports []AgentReport) string {
+	ids := make([]string, 0, len(reports))
+	for _, report := range reports {
+		if report.ID == "" {
+			return ""
+		}
+		ids = append(ids, report.ID)
+	}
+	sort.Strings(ids)
+	sum := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
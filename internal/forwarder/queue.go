@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/big"
@@ -14,6 +15,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"clustercost-agent-k8s/internal/health"
+	"clustercost-agent-k8s/internal/telemetry"
 )
 
 // Queue handles local spooling and batched retries.
@@ -27,7 +31,12 @@ type Queue struct {
 	maxBatchBytes int64
 	memoryBuffer  int
 	sender        *Sender
+	ids           *Identity
+	delta         *DeltaEncoder
+	retention     Retention
 	logger        *slog.Logger
+	metrics       *telemetry.Metrics
+	health        *health.Tracker
 	mu            sync.Mutex
 	mem           []queuedReport
 	memBytes      int64
@@ -64,28 +73,127 @@ func NewQueue(dir string, maxBatch, maxRetries int, backoff, flushEvery time.Dur
 		maxBatchBytes: maxBatchBytes,
 		memoryBuffer:  memoryBuffer,
 		sender:        sender,
+		ids:           NewIdentity(),
 		logger:        logger,
 		flushCh:       make(chan struct{}, 1),
 	}
 }
 
+// SetTelemetry records send latency, retries, and failed files on m and
+// reports the queue depth at scrape time.
+func (q *Queue) SetTelemetry(m *telemetry.Metrics) {
+	if q == nil {
+		return
+	}
+	q.metrics = m
+	m.WatchQueue(q.Depth)
+}
+
+// SetHealth reports every batch send outcome as the forwarder component.
+func (q *Queue) SetHealth(t *health.Tracker) {
+	if q == nil {
+		return
+	}
+	q.health = t
+	t.Track(health.Forwarder)
+}
+
+// EnableDelta numbers queued reports and sends them as deltas against the
+// last acknowledged report, with a keyframe after every keyframeEvery.
+func (q *Queue) EnableDelta(keyframeEvery int) {
+	if q == nil {
+		return
+	}
+	q.delta = NewDeltaEncoder(keyframeEvery)
+}
+
+// Depth counts reports buffered in memory and spooled on disk.
+func (q *Queue) Depth() telemetry.QueueDepth {
+	var depth telemetry.QueueDepth
+	if q == nil {
+		return depth
+	}
+	q.mu.Lock()
+	depth.MemoryReports = len(q.mem)
+	depth.MemoryBytes = q.memBytes
+	q.mu.Unlock()
+
+	depth.DiskFiles, depth.DiskBytes = countQueueFiles(q.dir)
+	depth.FailedFiles, depth.FailedBytes = countQueueFiles(q.failDir)
+	return depth
+}
+
+func countQueueFiles(dir string) (int, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0
+	}
+	var files int
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || !isQueueFile(entry.Name()) {
+			continue
+		}
+		files++
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return files, size
+}
+
+// Queue files hold one report each: JSON, or a protobuf AgentReport when
+// the sender posts protobuf. Files keep their format across a change of
+// wire format and are read by extension.
+const (
+	extJSON     = ".json"
+	extProtobuf = ".pb"
+)
+
+func isQueueFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == extJSON || ext == extProtobuf
+}
+
+func (q *Queue) marshal(report AgentReport) ([]byte, string, error) {
+	if q.sender.Protobuf() {
+		return MarshalReport(report), extProtobuf, nil
+	}
+	data, err := json.Marshal(report)
+	return data, extJSON, err
+}
+
+func readQueueFile(path string) (AgentReport, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is from queue dir entries
+	if err != nil {
+		return AgentReport{}, err
+	}
+	if filepath.Ext(path) == extProtobuf {
+		return UnmarshalReport(data)
+	}
+	var report AgentReport
+	err = json.Unmarshal(data, &report)
+	return report, err
+}
+
 // Enqueue writes a report to the queue directory.
 func (q *Queue) Enqueue(report AgentReport) error {
 	if q == nil || q.sender == nil || q.dir == "" {
 		return nil
 	}
-	data, err := json.Marshal(report)
+	report.ID, report.Sequence = q.ids.Next()
+	data, ext, err := q.marshal(report)
 	if err != nil {
 		return fmt.Errorf("marshal report: %w", err)
 	}
 
 	if q.memoryBuffer == 0 {
-		return q.writeToDisk(data)
+		return q.writeToDisk(data, ext)
 	}
 
 	shouldFlush := false
 	q.mu.Lock()
-	q.mem = append(q.mem, queuedReport{report: report, raw: data, size: int64(len(data))})
+	q.mem = append(q.mem, queuedReport{report: report, raw: data, ext: ext, size: int64(len(data))})
 	q.memBytes += int64(len(data))
 	if q.memBytes >= q.maxBatchBytes && q.maxBatchBytes > 0 {
 		shouldFlush = true
@@ -94,7 +202,7 @@ func (q *Queue) Enqueue(report AgentReport) error {
 		item := q.mem[0]
 		q.mem = q.mem[1:]
 		q.memBytes -= item.size
-		if err := q.writeToDisk(item.raw); err != nil {
+		if err := q.writeToDisk(item.raw, item.ext); err != nil {
 			q.logger.Warn("spill to disk failed", slog.String("error", err.Error()))
 		}
 	}
@@ -131,6 +239,7 @@ func (q *Queue) flushOnce(ctx context.Context) {
 		q.logger.Warn("create queue dir failed", slog.String("error", err.Error()))
 		return
 	}
+	q.enforceRetention(time.Now())
 
 	if q.flushMemory(ctx) {
 		return
@@ -147,7 +256,7 @@ func (q *Queue) flushOnce(ctx context.Context) {
 			continue
 		}
 		name := entry.Name()
-		if strings.HasSuffix(name, ".json") {
+		if isQueueFile(name) {
 			files = append(files, name)
 		}
 	}
@@ -185,17 +294,14 @@ func (q *Queue) flushOnce(ctx context.Context) {
 	}
 
 	reports := make([]AgentReport, 0, len(batchFiles))
+	sent := make(map[string]int, len(batchFiles))
 	for _, path := range batchFiles {
-		data, err := os.ReadFile(path) // #nosec G304 -- path is from queue dir entries
+		report, err := readQueueFile(path)
 		if err != nil {
 			q.logger.Warn("read queue file failed", slog.String("error", err.Error()))
 			continue
 		}
-		var report AgentReport
-		if err := json.Unmarshal(data, &report); err != nil {
-			q.logger.Warn("decode queue file failed", slog.String("error", err.Error()))
-			continue
-		}
+		sent[path] = len(reports)
 		reports = append(reports, report)
 	}
 
@@ -203,20 +309,86 @@ func (q *Queue) flushOnce(ctx context.Context) {
 		return
 	}
 
-	if err := q.sender.SendBatch(ctx, reports); err != nil {
+	start := time.Now()
+	retry, err := q.send(ctx, reports)
+	q.metrics.ObserveSend("disk", time.Since(start), err)
+	q.health.Observe(health.Forwarder, err)
+	if err != nil {
 		q.logger.Warn("remote batch send failed", slog.String("error", err.Error()))
 		q.bumpRetries(batchFiles)
 		return
 	}
 
+	var rejected []string
 	for _, path := range batchFiles {
+		if i, ok := sent[path]; ok && retry[i] {
+			rejected = append(rejected, path)
+			continue
+		}
 		if err := os.Remove(path); err != nil {
 			q.logger.Warn("remove queue file failed", slog.String("error", err.Error()))
 		}
 	}
+	if len(rejected) > 0 {
+		q.logger.Warn("remote endpoint did not take every report", slog.Int("retrying", len(rejected)))
+		q.bumpRetries(rejected)
+	}
+}
+
+// send posts reports, delta encoded when enabled, and returns the positions
+// in reports of those the receiver did not take or that were not on the
+// wire; every other report is delivered. When the receiver asks for a
+// resync the batch is sent once more, starting with a keyframe.
+func (q *Queue) send(ctx context.Context, reports []AgentReport) (map[int]bool, error) {
+	if q.delta == nil {
+		ack, err := q.sender.SendBatch(ctx, reports)
+		if err != nil {
+			return nil, err
+		}
+		return ack.Retry(reports), nil
+	}
+	for attempt := 0; ; attempt++ {
+		wire, from, commit := q.delta.Encode(reports)
+		ack, err := q.sender.SendBatch(ctx, wire)
+		if err != nil {
+			return nil, err
+		}
+		retry := unsent(len(reports), from)
+		if len(ack.Rejected) > 0 {
+			// Later deltas were encoded against a report the receiver does
+			// not hold, so the retries start from a keyframe.
+			q.delta.Reset()
+			for i := range ack.Retry(wire) {
+				retry[from[i]] = true
+			}
+			return retry, nil
+		}
+		if !ack.NeedsResync(wire) {
+			commit()
+			return retry, nil
+		}
+		q.delta.Reset()
+		if attempt > 0 {
+			return nil, errors.New("remote endpoint rejected a keyframe")
+		}
+		q.logger.Info("remote endpoint requested a resync; sending a keyframe")
+	}
+}
+
+// unsent returns the positions among n reports that are missing from from.
+func unsent(n int, from []int) map[int]bool {
+	retry := make(map[int]bool, n-len(from))
+	for i := 0; i < n; i++ {
+		retry[i] = true
+	}
+	for _, i := range from {
+		delete(retry, i)
+	}
+	return retry
 }
 
 func (q *Queue) bumpRetries(paths []string) {
+	q.metrics.AddRetries(len(paths))
 	for _, path := range paths {
 		base := filepath.Base(path)
 		retries := parseRetries(base) + 1
@@ -234,7 +406,11 @@ func (q *Queue) moveToFailed(path string) {
 		return
 	}
 	dst := filepath.Join(q.failDir, filepath.Base(path))
-	_ = os.Rename(path, dst)
+	if err := os.Rename(path, dst); err != nil {
+		q.logger.Warn("move queue file failed", slog.String("error", err.Error()))
+		return
+	}
+	q.metrics.IncFailedFiles()
 }
 
 func parseRetries(name string) int {
@@ -242,7 +418,7 @@ func parseRetries(name string) int {
 	if idx == -1 {
 		return 0
 	}
-	part := strings.TrimSuffix(name[idx+2:], ".json")
+	part := strings.TrimSuffix(name[idx+2:], filepath.Ext(name))
 	retries, err := strconv.Atoi(part)
 	if err != nil {
 		return 0
@@ -256,12 +432,13 @@ func setRetries(name string, retries int) string {
 		return name
 	}
 	base := name[:idx+2]
-	return base + strconv.Itoa(retries) + ".json"
+	return base + strconv.Itoa(retries) + filepath.Ext(name)
 }
 
 type queuedReport struct {
 	report AgentReport
 	raw    []byte
+	ext    string
 	size   int64
 }
 
@@ -291,22 +468,31 @@ func (q *Queue) flushMemory(ctx context.Context) bool {
 	for _, item := range batch {
 		reports = append(reports, item.report)
 	}
-	if err := q.sender.SendBatch(ctx, reports); err != nil {
+	start := time.Now()
+	retry, err := q.send(ctx, reports)
+	q.metrics.ObserveSend("memory", time.Since(start), err)
+	q.health.Observe(health.Forwarder, err)
+	if err != nil {
 		q.logger.Warn("remote in-memory send failed", slog.String("error", err.Error()))
-		for _, item := range batch {
-			if err := q.writeToDisk(item.raw); err != nil {
-				q.logger.Warn("spill to disk failed", slog.String("error", err.Error()))
-			}
+	} else if len(retry) > 0 {
+		q.logger.Warn("remote endpoint did not take every report", slog.Int("retrying", len(retry)))
+	}
+	for i, item := range batch {
+		if err == nil && !retry[i] {
+			continue
+		}
+		if err := q.writeToDisk(item.raw, item.ext); err != nil {
+			q.logger.Warn("spill to disk failed", slog.String("error", err.Error()))
 		}
 	}
 	return true
 }
 
-func (q *Queue) writeToDisk(data []byte) error {
+func (q *Queue) writeToDisk(data []byte, ext string) error {
 	if err := os.MkdirAll(q.dir, 0o750); err != nil {
 		return fmt.Errorf("create queue dir: %w", err)
 	}
-	name := fmt.Sprintf("%d_%06d_r0.json", time.Now().UTC().UnixNano(), q.randIntn(1_000_000))
+	name := fmt.Sprintf("%d_%06d_r0%s", time.Now().UTC().UnixNano(), q.randIntn(1_000_000), ext)
 	tmpPath := filepath.Join(q.dir, name+".tmp")
 	finalPath := filepath.Join(q.dir, name)
 	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
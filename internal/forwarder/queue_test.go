@@ -12,6 +12,10 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"clustercost-agent-k8s/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestRetriesFromName(t *testing.T) {
@@ -21,6 +25,105 @@ func TestRetriesFromName(t *testing.T) {
 	}
 }
 
+func TestQueueStoresProtobufFiles(t *testing.T) {
+	var got []AgentReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != ContentTypeProtobuf {
+			t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		reports, err := UnmarshalBatch(readBody(t, r))
+		if err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got = append(got, reports...)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	sender := NewSender(server.URL, "", 2*time.Second, false)
+	sender.SetWireFormat(true, true)
+	queue := NewQueue(dir, 50, 3, 0, time.Second, 1<<20, 0, sender, noopLogger())
+	if err := queue.Enqueue(AgentReport{ClusterID: "c1", NodeName: "node-a"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// A file spooled as JSON before the format changed is still sent.
+	if err := os.WriteFile(filepath.Join(dir, "1_000000_r0.json"), []byte(`{"clusterId":"c0"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 || filepath.Ext(entries[0].Name()) != ".pb" {
+		t.Fatalf("expected a .pb queue file, got %v", entries)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	for _, entry := range entries {
+		_ = os.Chtimes(filepath.Join(dir, entry.Name()), old, old)
+	}
+	queue.flushOnce(context.Background())
+	if len(got) != 2 || got[0].ClusterID != "c1" || got[1].ClusterID != "c0" {
+		t.Fatalf("unexpected reports %+v", got)
+	}
+	if files, _ := countQueueFiles(dir); files != 0 {
+		t.Fatalf("expected the queue to drain, %d files left", files)
+	}
+}
+
+func TestQueueRetriesOnlyRejectedReports(t *testing.T) {
+	var keys []string
+	var sent [][]AgentReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string][]AgentReport
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		sent = append(sent, payload["reports"])
+		switch len(sent) {
+		case 1:
+			w.WriteHeader(http.StatusGatewayTimeout)
+		case 2:
+			_ = json.NewEncoder(w).Encode(Ack{Rejected: []Rejection{{Index: 1, ID: payload["reports"][1].ID, Error: "bad"}}})
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	sender := NewSender(server.URL, "", 2*time.Second, false)
+	queue := NewQueue(dir, 50, 3, time.Millisecond, time.Second, 1<<20, 0, sender, noopLogger())
+	for _, id := range []string{"c1", "c2"} {
+		if err := queue.Enqueue(AgentReport{ClusterID: id}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	flush := func() {
+		entries, _ := os.ReadDir(dir)
+		old := time.Now().Add(-time.Hour)
+		for _, entry := range entries {
+			_ = os.Chtimes(filepath.Join(dir, entry.Name()), old, old)
+		}
+		queue.flushOnce(context.Background())
+	}
+	for i := 0; i < 3; i++ {
+		flush()
+	}
+
+	if len(sent) != 3 || len(sent[0]) != 2 || len(sent[2]) != 1 {
+		t.Fatalf("expected two full attempts and one retry of the rejected report, got %+v", sent)
+	}
+	if sent[0][0].ID == "" || sent[0][0].ID == sent[0][1].ID {
+		t.Fatalf("expected distinct report IDs, got %q and %q", sent[0][0].ID, sent[0][1].ID)
+	}
+	if keys[0] == "" || keys[0] != keys[1] || keys[2] == keys[1] {
+		t.Fatalf("expected the retried batch to keep its idempotency key, got %v", keys)
+	}
+	if sent[2][0].ID != sent[1][1].ID {
+		t.Fatalf("expected only the rejected report to be retried, got %+v", sent[2])
+	}
+	if files, _ := countQueueFiles(dir); files != 0 {
+		t.Fatalf("expected the queue to drain, %d files left", files)
+	}
+}
+
 func TestQueueMemorySpill(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -146,6 +249,66 @@ func TestQueueDiskBatchRespectsBatchBytes(t *testing.T) {
 	}
 }
 
+func TestQueueTelemetryCountsRetriesAndFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	sender := NewSender(server.URL, "", 2*time.Second, false)
+	queue := NewQueue(dir, 10, 1, time.Millisecond, time.Second, 10*1024, 0, sender, noopLogger())
+	metrics := telemetry.New()
+	queue.SetTelemetry(metrics)
+
+	data, err := json.Marshal(AgentReport{ClusterID: "c1"})
+	if err != nil {
+		t.Fatalf("marshal report: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"1_r0.json", "2_r2.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		_ = os.Chtimes(path, old, old)
+	}
+
+	queue.flushOnce(context.Background())
+
+	depth := queue.Depth()
+	if depth.DiskFiles != 1 || depth.FailedFiles != 1 || depth.DiskBytes != int64(len(data)) {
+		t.Fatalf("unexpected depth %+v", depth)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	counters := map[string]float64{}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if m.GetCounter() != nil {
+				counters[mf.GetName()] += m.GetCounter().GetValue()
+			}
+			if m.GetHistogram() != nil {
+				counters[mf.GetName()] += float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	if counters["clustercost_agent_forwarder_retries_total"] != 1 {
+		t.Errorf("expected 1 retry, got %v", counters["clustercost_agent_forwarder_retries_total"])
+	}
+	if counters["clustercost_agent_forwarder_failed_files_total"] != 1 {
+		t.Errorf("expected 1 failed file, got %v", counters["clustercost_agent_forwarder_failed_files_total"])
+	}
+	if counters["clustercost_agent_forwarder_send_duration_seconds"] != 1 {
+		t.Errorf("expected 1 send observation, got %v", counters["clustercost_agent_forwarder_send_duration_seconds"])
+	}
+}
+
 func noopLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(io.Discard, nil))
 }
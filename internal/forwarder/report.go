@@ -6,12 +6,69 @@ import (
 	"clustercost-agent-k8s/internal/snapshot"
 )
 
+// SchemaVersion identifies the AgentReport wire format as major.minor, with the
+// same compatibility rules as snapshot.SchemaVersion.
+const SchemaVersion = "1.8"
+
+// Report scopes. A report without a scope is a complete view of its node, or
+// of the cluster when NodeName is empty.
+const (
+	// ScopeCluster reports come from the cluster mode leader and carry the
+	// cost of every node, pod and namespace, without eBPF usage.
+	ScopeCluster = "cluster"
+	// ScopeNode reports come from node mode agents and carry only the usage
+	// and network traffic measured on their node.
+	ScopeNode = "node"
+)
+
 // AgentReport is the payload forwarded to the central agent.
 type AgentReport struct {
-	ClusterID   string            `json:"clusterId"`
-	ClusterName string            `json:"clusterName"`
-	NodeName    string            `json:"nodeName"`
-	Version     string            `json:"version"`
-	Timestamp   time.Time         `json:"timestamp"`
-	Snapshot    snapshot.Snapshot `json:"snapshot"`
+	SchemaVersion string    `json:"schemaVersion"`
+	ClusterID     string    `json:"clusterId"`
+	ClusterName   string    `json:"clusterName"`
+	NodeName      string    `json:"nodeName"`
+	Scope         string    `json:"scope,omitempty"`
+	Version       string    `json:"version"`
+	Timestamp     time.Time `json:"timestamp"`
+	// ID is the agent instance UUID and Sequence, assigned when the report
+	// is queued. Receivers drop a report whose ID they already recorded.
+	ID string `json:"id,omitempty"`
+	// Sequence increases with every report an agent queues.
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Delta is set when Snapshot holds only the records changed since
+	// Delta.BaseSequence; see Apply.
+	Delta    *Delta            `json:"delta,omitempty"`
+	Snapshot snapshot.Snapshot `json:"snapshot"`
+}
+
+// NodeUsage strips snap down to what a node mode agent reports: pod usage
+// and network traffic. Node prices, pod cost shares and namespace records
+// are left to the cluster mode leader so they are not counted twice.
+func NodeUsage(snap snapshot.Snapshot) snapshot.Snapshot {
+	pods := make([]snapshot.PodCostRecord, 0, len(snap.Pods))
+	for _, pod := range snap.Pods {
+		pods = append(pods, snapshot.PodCostRecord{
+			Namespace:        pod.Namespace,
+			Pod:              pod.Pod,
+			Node:             pod.Node,
+			CPUUsageMilli:    pod.CPUUsageMilli,
+			MemoryUsageBytes: pod.MemoryUsageBytes,
+		})
+	}
+	return snapshot.Snapshot{
+		SchemaVersion: snap.SchemaVersion,
+		Timestamp:     snap.Timestamp,
+		Namespaces:    []snapshot.NamespaceCostRecord{},
+		Pods:          pods,
+		Nodes:         []snapshot.NodeCostRecord{},
+		Resources: snapshot.ResourceSnapshot{
+			ClusterID:              snap.Resources.ClusterID,
+			CPUUsageMilliTotal:     snap.Resources.CPUUsageMilliTotal,
+			MemoryUsageBytesTotal:  snap.Resources.MemoryUsageBytesTotal,
+			NetworkTxBytesTotal:    snap.Resources.NetworkTxBytesTotal,
+			NetworkRxBytesTotal:    snap.Resources.NetworkRxBytesTotal,
+			NetworkEgressCostTotal: snap.Resources.NetworkEgressCostTotal,
+		},
+		Network: snap.Network,
+	}
 }
@@ -0,0 +1,180 @@
+package forwarder
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+)
+
+// Retention bounds the queue directory during long outages. Zero limits
+// disable it.
+type Retention struct {
+	// MaxBytes caps pending and failed/ files together. Failed files are
+	// never sent again, so they are dropped first, oldest first; pending
+	// files are then dropped by Policy.
+	MaxBytes int64
+	// MaxAge drops pending and failed files queued longer ago than this.
+	MaxAge time.Duration
+	// Policy is config.OverflowDropOldest, OverflowDropNewest, or
+	// OverflowDownsample, which keeps only the oldest report of each
+	// DownsampleEvery interval and then drops the oldest if still over.
+	Policy          string
+	DownsampleEvery time.Duration
+}
+
+// SetRetention bounds the queue directory; it is enforced before every
+// flush.
+func (q *Queue) SetRetention(r Retention) {
+	if q == nil {
+		return
+	}
+	if r.Policy == "" {
+		r.Policy = config.OverflowDropOldest
+	}
+	q.retention = r
+}
+
+type queueFile struct {
+	path string
+	at   time.Time
+	size int64
+}
+
+// listQueueFiles returns the queue files in dir, oldest first by the time
+// they were queued.
+func listQueueFiles(dir string) []queueFile {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	files := make([]queueFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !isQueueFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, queueFile{
+			path: filepath.Join(dir, entry.Name()),
+			at:   queuedAt(entry.Name(), info.ModTime()),
+			size: info.Size(),
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].at.Before(files[j].at) })
+	return files
+}
+
+// queuedAt reads the enqueue time from the name writeToDisk gives a file.
+// The modification time is no substitute, as every retry touches it.
+func queuedAt(name string, modTime time.Time) time.Time {
+	prefix, _, _ := strings.Cut(name, "_")
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return modTime
+	}
+	return time.Unix(0, nanos)
+}
+
+// enforceRetention drops expired files, then files over MaxBytes.
+func (q *Queue) enforceRetention(now time.Time) {
+	r := q.retention
+	if r.MaxBytes <= 0 && r.MaxAge <= 0 {
+		return
+	}
+	pending := listQueueFiles(q.dir)
+	failed := listQueueFiles(q.failDir)
+	if r.MaxAge > 0 {
+		pending = q.dropExpired(pending, "pending", now.Add(-r.MaxAge))
+		failed = q.dropExpired(failed, "failed", now.Add(-r.MaxAge))
+	}
+	if r.MaxBytes <= 0 {
+		return
+	}
+
+	var total int64
+	for _, file := range pending {
+		total += file.size
+	}
+	for _, file := range failed {
+		total += file.size
+	}
+	dropped := 0
+	for len(failed) > 0 && total > r.MaxBytes {
+		total -= failed[0].size
+		dropped += q.drop(failed[0])
+		failed = failed[1:]
+	}
+	q.metrics.AddDropped("failed", config.OverflowDropOldest, dropped)
+	if total <= r.MaxBytes {
+		return
+	}
+
+	// Downsampled reports count under downsample, and the oldest reports
+	// dropped when that is not enough under drop-oldest.
+	sampled, dropped := 0, 0
+	if r.Policy == config.OverflowDownsample && r.DownsampleEvery > 0 {
+		kept := pending[:0]
+		var bucket time.Time
+		for _, file := range pending {
+			slot := file.at.Truncate(r.DownsampleEvery)
+			if total > r.MaxBytes && len(kept) > 0 && slot.Equal(bucket) {
+				total -= file.size
+				sampled += q.drop(file)
+				continue
+			}
+			bucket = slot
+			kept = append(kept, file)
+		}
+		pending = kept
+	}
+	reason := config.OverflowDropOldest
+	if r.Policy == config.OverflowDropNewest {
+		reason = config.OverflowDropNewest
+	}
+	for len(pending) > 0 && total > r.MaxBytes {
+		victim := 0
+		if reason == config.OverflowDropNewest {
+			victim = len(pending) - 1
+		}
+		total -= pending[victim].size
+		dropped += q.drop(pending[victim])
+		pending = append(pending[:victim], pending[victim+1:]...)
+	}
+	q.metrics.AddDropped("pending", config.OverflowDownsample, sampled)
+	q.metrics.AddDropped("pending", reason, dropped)
+	if sampled+dropped > 0 {
+		q.logger.Warn("remote queue over its byte cap; dropped reports",
+			slog.Int("dropped", sampled+dropped), slog.String("policy", r.Policy))
+	}
+}
+
+func (q *Queue) dropExpired(files []queueFile, queue string, cutoff time.Time) []queueFile {
+	dropped := 0
+	for len(files) > 0 && files[0].at.Before(cutoff) {
+		dropped += q.drop(files[0])
+		files = files[1:]
+	}
+	q.metrics.AddDropped(queue, "max_age", dropped)
+	return files
+}
+
+// drop deletes a queue file and returns the number of reports removed.
+func (q *Queue) drop(file queueFile) int {
+	if err := os.Remove(file.path); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			q.logger.Warn("remove queue file failed", slog.String("error", err.Error()))
+		}
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,145 @@
+package forwarder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEnforceRetentionPolicies(t *testing.T) {
+	base := time.Now().Truncate(10 * time.Minute).Add(-time.Hour)
+	pending := []time.Duration{0, time.Minute, 2 * time.Minute, 11 * time.Minute, 12 * time.Minute}
+
+	tests := []struct {
+		policy string
+		want   []time.Duration
+	}{
+		{config.OverflowDropOldest, []time.Duration{2 * time.Minute, 11 * time.Minute, 12 * time.Minute}},
+		{config.OverflowDropNewest, []time.Duration{0, time.Minute, 2 * time.Minute}},
+		{config.OverflowDownsample, []time.Duration{0, 11 * time.Minute, 12 * time.Minute}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			dir := t.TempDir()
+			queue := NewQueue(dir, 10, 3, time.Second, time.Second, 1<<20, 0, nil, noopLogger())
+			queue.SetRetention(Retention{MaxBytes: 300, Policy: tt.policy, DownsampleEvery: 10 * time.Minute})
+			for _, offset := range pending {
+				writeQueueFile(t, dir, base.Add(offset))
+			}
+			writeQueueFile(t, filepath.Join(dir, "failed"), base.Add(-time.Minute))
+
+			queue.enforceRetention(time.Now())
+
+			if got := queuedOffsets(t, dir, base); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			if files, _ := countQueueFiles(filepath.Join(dir, "failed")); files != 0 {
+				t.Fatal("expected the failed file to be dropped first")
+			}
+		})
+	}
+}
+
+func TestEnforceRetentionDropsExpiredReports(t *testing.T) {
+	dir := t.TempDir()
+	queue := NewQueue(dir, 10, 3, time.Second, time.Second, 1<<20, 0, nil, noopLogger())
+	metrics := telemetry.New()
+	queue.SetTelemetry(metrics)
+	queue.SetRetention(Retention{MaxAge: time.Hour})
+
+	now := time.Now()
+	writeQueueFile(t, dir, now.Add(-2*time.Hour))
+	writeQueueFile(t, dir, now.Add(-time.Minute))
+	writeQueueFile(t, filepath.Join(dir, "failed"), now.Add(-3*time.Hour))
+
+	queue.enforceRetention(now)
+
+	depth := queue.Depth()
+	if depth.DiskFiles != 1 || depth.FailedFiles != 0 {
+		t.Fatalf("unexpected depth %+v", depth)
+	}
+
+	if dropped := droppedCounts(t, metrics); dropped["pending/max_age"] != 1 || dropped["failed/max_age"] != 1 {
+		t.Fatalf("unexpected dropped counts %v", dropped)
+	}
+}
+
+func TestEnforceRetentionCountsDownsampleFallback(t *testing.T) {
+	base := time.Now().Truncate(10 * time.Minute).Add(-time.Hour)
+	dir := t.TempDir()
+	queue := NewQueue(dir, 10, 3, time.Second, time.Second, 1<<20, 0, nil, noopLogger())
+	metrics := telemetry.New()
+	queue.SetTelemetry(metrics)
+	queue.SetRetention(Retention{MaxBytes: 100, Policy: config.OverflowDownsample, DownsampleEvery: 10 * time.Minute})
+	for _, offset := range []time.Duration{0, time.Minute, 2 * time.Minute, 11 * time.Minute, 12 * time.Minute} {
+		writeQueueFile(t, dir, base.Add(offset))
+	}
+	writeQueueFile(t, filepath.Join(dir, "failed"), base.Add(-time.Minute))
+
+	queue.enforceRetention(time.Now())
+
+	if got := queuedOffsets(t, dir, base); !reflect.DeepEqual(got, []time.Duration{11 * time.Minute}) {
+		t.Fatalf("kept %v", got)
+	}
+	want := map[string]float64{"failed/drop-oldest": 1, "pending/downsample": 3, "pending/drop-oldest": 1}
+	if dropped := droppedCounts(t, metrics); !reflect.DeepEqual(dropped, want) {
+		t.Fatalf("dropped %v, want %v", dropped, want)
+	}
+}
+
+// droppedCounts returns the dropped reports counter by queue/reason.
+func droppedCounts(t *testing.T, metrics *telemetry.Metrics) map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	dropped := map[string]float64{}
+	for _, mf := range families {
+		if mf.GetName() != "clustercost_agent_forwarder_dropped_reports_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			dropped[labels["queue"]+"/"+labels["reason"]] += m.GetCounter().GetValue()
+		}
+	}
+	return dropped
+}
+
+// writeQueueFile writes a 100 byte report file named as writeToDisk names
+// one queued at at.
+func writeQueueFile(t *testing.T, dir string, at time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("%d_000000_r0.json", at.UnixNano())
+	if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queuedOffsets(t *testing.T, dir string, base time.Time) []time.Duration {
+	t.Helper()
+	var offsets []time.Duration
+	for _, file := range listQueueFiles(dir) {
+		offsets = append(offsets, file.at.Sub(base))
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
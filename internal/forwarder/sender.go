@@ -5,20 +5,33 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
+// errUnsupportedMedia is returned when the receiver cannot decode a protobuf
+// or zstd body.
+var errUnsupportedMedia = errors.New("remote endpoint does not accept the wire format")
+
 // Sender posts AgentReports to a remote endpoint.
 type Sender struct {
 	client    *http.Client
 	endpoint  string
 	authToken string
 	gzip      bool
+	protobuf  atomic.Bool
+	zstd      atomic.Bool
 }
 
-// NewSender returns a configured Sender.
+// NewSender returns a configured Sender. It posts JSON until SetWireFormat
+// selects another format.
 func NewSender(endpoint, authToken string, timeout time.Duration, gzipEnabled bool) *Sender {
 	if timeout <= 0 {
 		timeout = 5 * time.Second
@@ -33,96 +46,235 @@ func NewSender(endpoint, authToken string, timeout time.Duration, gzipEnabled bo
 	}
 }
 
-// Send POSTs the report to the remote endpoint.
+// SetWireFormat selects protobuf bodies and zstd instead of gzip. A receiver
+// that answers either with 415, or with a 400 because it could not decode
+// the body, predates them, so the sender falls back to JSON, gzipped when
+// enabled, from then on.
+func (s *Sender) SetWireFormat(protobuf, zstdEnabled bool) {
+	s.protobuf.Store(protobuf)
+	s.zstd.Store(zstdEnabled)
+}
+
+// Protobuf reports whether reports are currently sent as protobuf.
+func (s *Sender) Protobuf() bool {
+	return s != nil && s.protobuf.Load()
+}
+
+// Send POSTs the report to the remote endpoint. Protobuf bodies are always
+// a Batch.
 func (s *Sender) Send(ctx context.Context, report AgentReport) error {
 	if s == nil || s.endpoint == "" {
 		return nil
 	}
-	body, err := json.Marshal(report)
+	_, err := s.send(ctx, BatchKey([]AgentReport{report}), func(protobuf bool) ([]byte, error) {
+		if protobuf {
+			return MarshalBatch([]AgentReport{report}), nil
+		}
+		return json.Marshal(report)
+	})
 	if err != nil {
-		return fmt.Errorf("marshal report: %w", err)
+		return fmt.Errorf("send report: %w", err)
 	}
-	payload, err := s.wrap(body)
-	if err != nil {
-		return fmt.Errorf("encode payload: %w", err)
+	return nil
+}
+
+// Ack is what a central agent answers to an accepted batch. Receivers that
+// predate delta encoding answer with an empty Ack.
+type Ack struct {
+	// Resync lists the nodes whose delta did not apply to the report the
+	// receiver holds; their next report must be a keyframe.
+	Resync []string `json:"resync,omitempty"`
+	// Rejected lists the reports the receiver did not record. The rest of
+	// the batch is delivered.
+	Rejected []Rejection `json:"rejected,omitempty"`
+}
+
+// Rejection names a report the receiver did not record, by its position
+// in the batch and its ID, and why.
+type Rejection struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// Retry returns the positions in batch of the reports that were not
+// delivered: those rejected, and the deltas of nodes asked to resync. A
+// rejection names its report by ID, or by index when the report has none;
+// one that names no report in batch is ignored.
+func (a Ack) Retry(batch []AgentReport) map[int]bool {
+	retry := map[int]bool{}
+	for _, rejection := range a.Rejected {
+		if i := rejection.position(batch); i >= 0 {
+			retry[i] = true
+		}
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, payload)
-	if err != nil {
-		return fmt.Errorf("build request: %w", err)
+	for _, node := range a.Resync {
+		for i, report := range batch {
+			if report.NodeName == node && report.Delta != nil {
+				retry[i] = true
+			}
+		}
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if s.gzip {
-		req.Header.Set("Content-Encoding", "gzip")
+	return retry
+}
+
+func (r Rejection) position(batch []AgentReport) int {
+	inRange := r.Index >= 0 && r.Index < len(batch)
+	if r.ID == "" {
+		if inRange && batch[r.Index].ID == "" {
+			return r.Index
+		}
+		return -1
 	}
-	if s.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+s.authToken)
+	if inRange && batch[r.Index].ID == r.ID {
+		return r.Index
 	}
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("send report: %w", err)
+	for i, report := range batch {
+		if report.ID == r.ID {
+			return i
+		}
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("remote endpoint returned status %d", resp.StatusCode)
+	return -1
+}
+
+// NeedsResync reports whether the receiver asked for a keyframe from any
+// node in reports.
+func (a Ack) NeedsResync(reports []AgentReport) bool {
+	for _, node := range a.Resync {
+		for _, report := range reports {
+			if report.NodeName == node {
+				return true
+			}
+		}
 	}
-	return nil
+	return false
 }
 
 // SendBatch POSTs a list of reports to the remote endpoint.
-func (s *Sender) SendBatch(ctx context.Context, reports []AgentReport) error {
+func (s *Sender) SendBatch(ctx context.Context, reports []AgentReport) (Ack, error) {
 	if s == nil || s.endpoint == "" {
-		return nil
+		return Ack{}, nil
 	}
 	if len(reports) == 0 {
-		return nil
+		return Ack{}, nil
 	}
-	payload := map[string]any{"reports": reports}
-	body, err := json.Marshal(payload)
+	ack, err := s.send(ctx, BatchKey(reports), func(protobuf bool) ([]byte, error) {
+		if protobuf {
+			return MarshalBatch(reports), nil
+		}
+		return json.Marshal(map[string]any{"reports": reports})
+	})
 	if err != nil {
-		return fmt.Errorf("marshal batch: %w", err)
+		return Ack{}, fmt.Errorf("send batch: %w", err)
+	}
+	return ack, nil
+}
+
+// send posts the body marshal builds in the current wire format, and once
+// more as plain JSON when the receiver does not accept that format. key is
+// sent as the Idempotency-Key header unless empty.
+func (s *Sender) send(ctx context.Context, key string, marshal func(protobuf bool) ([]byte, error)) (Ack, error) {
+	protobuf, zstdEnabled := s.protobuf.Load(), s.zstd.Load()
+	ack, err := s.post(ctx, key, marshal, protobuf, zstdEnabled)
+	if !errors.Is(err, errUnsupportedMedia) {
+		return ack, err
 	}
-	payloadReader, err := s.wrap(body)
+	s.protobuf.Store(false)
+	s.zstd.Store(false)
+	return s.post(ctx, key, marshal, false, false)
+}
+
+func (s *Sender) post(ctx context.Context, key string, marshal func(protobuf bool) ([]byte, error), protobuf, zstdEnabled bool) (Ack, error) {
+	body, err := marshal(protobuf)
+	if err != nil {
+		return Ack{}, fmt.Errorf("marshal: %w", err)
+	}
+	contentType, encoding := ContentTypeJSON, ""
+	if protobuf {
+		contentType = ContentTypeProtobuf
+	}
+	switch {
+	case zstdEnabled:
+		encoding = "zstd"
+	case s.gzip:
+		encoding = "gzip"
+	}
+	payload, err := compress(body, encoding)
 	if err != nil {
-		return fmt.Errorf("encode batch: %w", err)
+		return Ack{}, fmt.Errorf("encode payload: %w", err)
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, payloadReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
 	if err != nil {
-		return fmt.Errorf("build request: %w", err)
+		return Ack{}, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if s.gzip {
-		req.Header.Set("Content-Encoding", "gzip")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
 	}
 	if s.authToken != "" {
 		req.Header.Set("Authorization", "Bearer "+s.authToken)
 	}
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("send batch: %w", err)
+		return Ack{}, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
+	if (protobuf || zstdEnabled) && undecodable(resp) {
+		return Ack{}, errUnsupportedMedia
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("remote endpoint returned status %d", resp.StatusCode)
+		return Ack{}, fmt.Errorf("remote endpoint returned status %d", resp.StatusCode)
 	}
-	return nil
+	var ack Ack
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ack)
+	return ack, nil
 }
 
-func (s *Sender) wrap(payload []byte) (*bytes.Reader, error) {
-	if !s.gzip {
-		return bytes.NewReader(payload), nil
-	}
-	var buf bytes.Buffer
-	zw := gzip.NewWriter(&buf)
-	if _, err := zw.Write(payload); err != nil {
-		_ = zw.Close()
-		return nil, err
+// undecodable reports whether the receiver could not decode the body. Central
+// agents from before protobuf and zstd answer 400 with "unsupported content
+// encoding" for zstd, and a JSON decode error for protobuf.
+func undecodable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnsupportedMediaType:
+		return true
+	case http.StatusBadRequest:
+		var answer struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&answer)
+		return strings.HasPrefix(answer.Error, "unsupported content encoding") ||
+			strings.HasPrefix(answer.Error, "decode body:")
 	}
-	if err := zw.Close(); err != nil {
-		return nil, err
+	return false
+}
+
+func compress(payload []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "gzip":
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			_ = zw.Close()
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		zw, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = zw.Close()
+		}()
+		return zw.EncodeAll(payload, nil), nil
 	}
-	return bytes.NewReader(buf.Bytes()), nil
+	return payload, nil
 }
@@ -7,10 +7,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 
 	"clustercost-agent-k8s/internal/snapshot"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestSenderSend(t *testing.T) {
@@ -67,7 +70,7 @@ func TestSenderSendBatch(t *testing.T) {
 		{ClusterID: "cluster-1", NodeName: "node-a"},
 		{ClusterID: "cluster-1", NodeName: "node-b"},
 	}
-	if err := sender.SendBatch(context.Background(), reports); err != nil {
+	if _, err := sender.SendBatch(context.Background(), reports); err != nil {
 		t.Fatalf("send batch: %v", err)
 	}
 	if len(payload["reports"]) != 2 {
@@ -75,6 +78,85 @@ func TestSenderSendBatch(t *testing.T) {
 	}
 }
 
+func TestSenderFallsBackToJSON(t *testing.T) {
+	var formats []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		formats = append(formats, r.Header.Get("Content-Type")+" "+r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Type") != ContentTypeJSON {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var payload map[string][]AgentReport
+		if err := json.Unmarshal(readBody(t, r), &payload); err != nil || len(payload["reports"]) != 1 {
+			t.Errorf("decode: %v %+v", err, payload)
+		}
+	}))
+	defer server.Close()
+
+	sender := NewSender(server.URL, "", 2*time.Second, true)
+	sender.SetWireFormat(true, true)
+	reports := []AgentReport{{ClusterID: "cluster-1", NodeName: "node-a"}}
+	for i := 0; i < 2; i++ {
+		if _, err := sender.SendBatch(context.Background(), reports); err != nil {
+			t.Fatalf("send batch: %v", err)
+		}
+	}
+	want := []string{ContentTypeProtobuf + " zstd", ContentTypeJSON + " gzip", ContentTypeJSON + " gzip"}
+	if !reflect.DeepEqual(formats, want) || sender.Protobuf() {
+		t.Fatalf("expected one protobuf attempt then JSON, got %v", formats)
+	}
+}
+
+func TestSenderFallsBackOnLegacyDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		protobuf bool
+		zstd     bool
+		answer   string
+		fallback bool
+	}{
+		{"protobuf", true, false, "decode body: invalid character '\\n' looking for beginning of value", true},
+		{"zstd", false, true, `unsupported content encoding \"zstd\"`, true},
+		{"rejected", true, true, "report rejected: reports[0]: timestamp is required", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var formats []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				formats = append(formats, r.Header.Get("Content-Type")+" "+r.Header.Get("Content-Encoding"))
+				if r.Header.Get("Content-Type") != ContentTypeJSON || r.Header.Get("Content-Encoding") == "zstd" || !tt.fallback {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error":"` + tt.answer + `"}`))
+				}
+			}))
+			defer server.Close()
+
+			sender := NewSender(server.URL, "", 2*time.Second, false)
+			sender.SetWireFormat(tt.protobuf, tt.zstd)
+			_, err := sender.SendBatch(context.Background(), []AgentReport{{ClusterID: "cluster-1", NodeName: "node-a"}})
+			if tt.fallback && (err != nil || len(formats) != 2 || formats[1] != ContentTypeJSON+" ") {
+				t.Fatalf("expected a JSON retry, got %v %v", err, formats)
+			}
+			if !tt.fallback && (err == nil || len(formats) != 1) {
+				t.Fatalf("expected the rejection without a retry, got %v %v", err, formats)
+			}
+		})
+	}
+}
+
+func TestAckRetryMatchesReportsWithoutIDByIndex(t *testing.T) {
+	// Reports spooled before IDs existed have none.
+	batch := []AgentReport{{NodeName: "a"}, {NodeName: "b"}, {NodeName: "c", ID: "agent-3"}}
+	ack := Ack{Rejected: []Rejection{
+		{Index: 1, Error: "bad"},
+		{Index: 7, Error: "out of range"},
+		{Index: 0, ID: "agent-3", Error: "moved"},
+	}}
+	if retry := ack.Retry(batch); !reflect.DeepEqual(retry, map[int]bool{1: true, 2: true}) {
+		t.Fatalf("expected reports 1 and 2 retried, got %v", retry)
+	}
+}
+
 func readBody(t *testing.T, r *http.Request) []byte {
 	t.Helper()
 	var reader io.Reader = r.Body
@@ -86,6 +168,14 @@ func readBody(t *testing.T, r *http.Request) []byte {
 		defer zr.Close()
 		reader = zr
 	}
+	if r.Header.Get("Content-Encoding") == "zstd" {
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			t.Fatalf("zstd reader: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
 	data, err := io.ReadAll(reader)
 	if err != nil {
 		t.Fatalf("read body: %v", err)
@@ -0,0 +1,928 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"clustercost-agent-k8s/internal/enricher"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Content types a central agent accepts.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// MarshalBatch encodes reports as the protobuf Batch in agentreport.proto.
+// Field numbers below follow that file.
+func MarshalBatch(reports []AgentReport) []byte {
+	var b []byte
+	for _, report := range reports {
+		b = putMessage(b, 1, MarshalReport(report))
+	}
+	return b
+}
+
+// UnmarshalBatch decodes a protobuf Batch.
+func UnmarshalBatch(data []byte) ([]AgentReport, error) {
+	var reports []AgentReport
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		report, err := UnmarshalReport(v)
+		if err != nil {
+			return fmt.Errorf("reports[%d]: %w", len(reports), err)
+		}
+		reports = append(reports, report)
+		return nil
+	})
+	return reports, err
+}
+
+// MarshalReport encodes one protobuf AgentReport, as a queue file holds it.
+func MarshalReport(r AgentReport) []byte {
+	t := &stringTable{index: map[string]uint64{}}
+	snap := putSnapshot(t, r.Snapshot)
+	var delta []byte
+	if r.Delta != nil {
+		delta = putDelta(t, *r.Delta)
+	}
+
+	b := putString(nil, 1, r.SchemaVersion)
+	b = putString(b, 2, r.ClusterID)
+	b = putString(b, 3, r.ClusterName)
+	b = putString(b, 4, r.NodeName)
+	b = putString(b, 5, r.Scope)
+	b = putString(b, 6, r.Version)
+	b = putTime(b, 7, r.Timestamp)
+	b = putUint(b, 8, r.Sequence)
+	if r.Delta != nil {
+		b = putMessage(b, 9, delta)
+	}
+	b = putMessage(b, 10, snap)
+	for _, s := range t.values {
+		b = protowire.AppendTag(b, 11, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	b = putString(b, 12, r.ID)
+	return b
+}
+
+// UnmarshalReport decodes one protobuf AgentReport.
+func UnmarshalReport(data []byte) (AgentReport, error) {
+	d := &decoder{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if num == 11 && typ == protowire.BytesType {
+			d.strings = append(d.strings, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return AgentReport{}, err
+	}
+
+	var r AgentReport
+	err = walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			r.SchemaVersion = string(v)
+		case 2:
+			r.ClusterID = string(v)
+		case 3:
+			r.ClusterName = string(v)
+		case 4:
+			r.NodeName = string(v)
+		case 5:
+			r.Scope = string(v)
+		case 6:
+			r.Version = string(v)
+		case 7:
+			r.Timestamp = timeFrom(n)
+		case 8:
+			r.Sequence = n
+		case 9:
+			delta, err := d.delta(v)
+			if err != nil {
+				return fmt.Errorf("delta: %w", err)
+			}
+			r.Delta = &delta
+		case 10:
+			snap, err := d.snapshot(v)
+			if err != nil {
+				return fmt.Errorf("snapshot: %w", err)
+			}
+			r.Snapshot = snap
+		case 12:
+			r.ID = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return AgentReport{}, err
+	}
+	return r, d.err
+}
+
+// stringTable interns the strings of one report. References are 1-based so
+// the empty string, the most common value, is encoded as an omitted field.
+type stringTable struct {
+	index  map[string]uint64
+	values []string
+}
+
+func (t *stringTable) ref(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	t.values = append(t.values, s)
+	i := uint64(len(t.values))
+	t.index[s] = i
+	return i
+}
+
+func putDelta(t *stringTable, delta Delta) []byte {
+	r := delta.Removed
+	var removed []byte
+	for i, keys := range [][]string{
+		r.Namespaces, r.Pods, r.Nodes, r.NetworkClasses, r.NetworkPods, r.NetworkNamespaces,
+		r.PodConnections, r.WorkloadConnections, r.NamespaceConnections, r.ServiceConnections,
+	} {
+		removed = putRefs(removed, protowire.Number(i+1), t, keys)
+	}
+	b := putUint(nil, 1, delta.BaseSequence)
+	return putOptional(b, 2, removed)
+}
+
+func putSnapshot(t *stringTable, s snapshot.Snapshot) []byte {
+	b := putString(nil, 1, s.SchemaVersion)
+	b = putTime(b, 2, s.Timestamp)
+	for _, ns := range s.Namespaces {
+		b = putMessage(b, 3, putNamespace(t, ns))
+	}
+	for _, pod := range s.Pods {
+		b = putMessage(b, 4, putPod(t, pod))
+	}
+	for _, node := range s.Nodes {
+		b = putMessage(b, 5, putNode(t, node))
+	}
+	b = putOptional(b, 6, putResources(t, s.Resources))
+	return putOptional(b, 7, putNetwork(t, s.Network))
+}
+
+func putNamespace(t *stringTable, ns snapshot.NamespaceCostRecord) []byte {
+	b := putRef(nil, 1, t, ns.ClusterID)
+	b = putRef(b, 2, t, ns.Namespace)
+	b = putDouble(b, 3, ns.HourlyCost)
+	b = putInt(b, 4, int64(ns.PodCount))
+	b = putInt(b, 5, ns.CPURequestMilli)
+	b = putInt(b, 6, ns.MemoryRequestBytes)
+	b = putInt(b, 7, ns.CPUUsageMilli)
+	b = putInt(b, 8, ns.MemoryUsageBytes)
+	b = putUint(b, 9, ns.NetworkTxBytes)
+	b = putUint(b, 10, ns.NetworkRxBytes)
+	b = putDouble(b, 11, ns.NetworkEgressCost)
+	b = putPairs(b, 12, t, ns.Labels)
+	b = putRef(b, 13, t, ns.Environment)
+	b = putDouble(b, 14, ns.SharedCostHourly)
+	for _, shared := range ns.SharedCosts {
+		msg := putRef(nil, 1, t, shared.Namespace)
+		msg = putRef(msg, 2, t, shared.Policy)
+		msg = putDouble(msg, 3, shared.HourlyCost)
+		b = putMessage(b, 15, msg)
+	}
+	b = putDouble(b, 16, ns.DistributedCostHourly)
+	b = putOptional(b, 17, putAttribution(t, ns.Attribution))
+	return putPairs(b, 18, t, ns.Dimensions)
+}
+
+func putAttribution(t *stringTable, a enricher.Attribution) []byte {
+	var b []byte
+	for i, v := range []enricher.Value{a.Team, a.CostCenter, a.Environment, a.SharedWeight} {
+		b = putRef(b, protowire.Number(2*i+1), t, v.Value)
+		b = putRef(b, protowire.Number(2*i+2), t, v.Source)
+	}
+	return b
+}
+
+func putPod(t *stringTable, pod snapshot.PodCostRecord) []byte {
+	b := putRef(nil, 1, t, pod.Namespace)
+	b = putRef(b, 2, t, pod.Pod)
+	b = putRef(b, 3, t, pod.Node)
+	b = putRef(b, 4, t, pod.ControllerKind)
+	b = putRef(b, 5, t, pod.ControllerName)
+	b = putDouble(b, 6, pod.HourlyCost)
+	b = putInt(b, 7, pod.CPURequestMilli)
+	b = putInt(b, 8, pod.MemoryRequestBytes)
+	b = putInt(b, 9, pod.CPUUsageMilli)
+	b = putInt(b, 10, pod.MemoryUsageBytes)
+	b = putInt(b, 11, pod.GPURequest)
+	b = putInt(b, 12, pod.StorageRequestBytes)
+	b = putPairs(b, 13, t, pod.Labels)
+	b = putPairs(b, 14, t, pod.Annotations)
+	b = putOptional(b, 15, putAttribution(t, pod.Attribution))
+	return putPairs(b, 16, t, pod.Dimensions)
+}
+
+func putNode(t *stringTable, node snapshot.NodeCostRecord) []byte {
+	b := putRef(nil, 1, t, node.ClusterID)
+	b = putRef(b, 2, t, node.NodeName)
+	b = putDouble(b, 3, node.HourlyCost)
+	b = putDouble(b, 4, node.CPUUsagePercent)
+	b = putDouble(b, 5, node.MemoryUsagePercent)
+	b = putInt(b, 6, node.CPUAllocatableMilli)
+	b = putInt(b, 7, node.MemoryAllocatableBytes)
+	b = putInt(b, 8, node.GPUAllocatable)
+	b = putInt(b, 9, int64(node.PodCount))
+	b = putRef(b, 10, t, node.Status)
+	b = putBool(b, 11, node.IsUnderPressure)
+	b = putRef(b, 12, t, node.InstanceType)
+	b = putPairs(b, 13, t, node.Labels)
+	return putRefs(b, 14, t, node.Taints)
+}
+
+func putResources(t *stringTable, r snapshot.ResourceSnapshot) []byte {
+	b := putRef(nil, 1, t, r.ClusterID)
+	b = putInt(b, 2, r.CPUUsageMilliTotal)
+	b = putInt(b, 3, r.CPURequestMilliTotal)
+	b = putInt(b, 4, r.MemoryUsageBytesTotal)
+	b = putInt(b, 5, r.MemoryRequestBytesTotal)
+	b = putDouble(b, 6, r.TotalNodeHourlyCost)
+	b = putUint(b, 7, r.NetworkTxBytesTotal)
+	b = putUint(b, 8, r.NetworkRxBytesTotal)
+	return putDouble(b, 9, r.NetworkEgressCostTotal)
+}
+
+func putClasses(b []byte, num protowire.Number, t *stringTable, classes []snapshot.NetworkClassTotals) []byte {
+	for _, class := range classes {
+		msg := putRef(nil, 1, t, class.Class)
+		msg = putUint(msg, 2, class.TxBytes)
+		msg = putUint(msg, 3, class.RxBytes)
+		msg = putDouble(msg, 4, class.EgressCostHourly)
+		b = putMessage(b, num, msg)
+	}
+	return b
+}
+
+func putNetwork(t *stringTable, n snapshot.NetworkSnapshot) []byte {
+	b := putRef(nil, 1, t, n.ClusterID)
+	b = putUint(b, 2, n.TxBytes)
+	b = putUint(b, 3, n.RxBytes)
+	b = putDouble(b, 4, n.EgressCost)
+	b = putClasses(b, 5, t, n.ByClass)
+	for _, pod := range n.Pods {
+		msg := putRef(nil, 1, t, pod.Namespace)
+		msg = putRef(msg, 2, t, pod.Pod)
+		msg = putRef(msg, 3, t, pod.Node)
+		msg = putUint(msg, 4, pod.TxBytes)
+		msg = putUint(msg, 5, pod.RxBytes)
+		msg = putDouble(msg, 6, pod.EgressCostHourly)
+		msg = putClasses(msg, 7, t, pod.ByClass)
+		b = putMessage(b, 6, msg)
+	}
+	for _, ns := range n.Namespaces {
+		msg := putRef(nil, 1, t, ns.Namespace)
+		msg = putUint(msg, 2, ns.TxBytes)
+		msg = putUint(msg, 3, ns.RxBytes)
+		msg = putDouble(msg, 4, ns.EgressCostHourly)
+		msg = putClasses(msg, 5, t, ns.ByClass)
+		b = putMessage(b, 7, msg)
+	}
+	for i, conns := range [][]snapshot.NetworkConnection{
+		n.PodConnections, n.WorkloadConnections, n.NamespaceConnections, n.ServiceConnections,
+	} {
+		for _, conn := range conns {
+			msg := putRef(nil, 1, t, conn.Source.Kind)
+			msg = putRef(msg, 2, t, conn.Source.Namespace)
+			msg = putRef(msg, 3, t, conn.Source.Name)
+			msg = putRef(msg, 4, t, conn.Destination.Kind)
+			msg = putRef(msg, 5, t, conn.Destination.Namespace)
+			msg = putRef(msg, 6, t, conn.Destination.Name)
+			msg = putRef(msg, 7, t, conn.Class)
+			msg = putUint(msg, 8, conn.TxBytes)
+			msg = putUint(msg, 9, conn.RxBytes)
+			msg = putDouble(msg, 10, conn.EgressCostHourly)
+			b = putMessage(b, protowire.Number(8+i), msg)
+		}
+	}
+	return b
+}
+
+// Scalars follow proto3 and are omitted when zero.
+
+func putUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func putInt(b []byte, num protowire.Number, v int64) []byte {
+	return putUint(b, num, uint64(v))
+}
+
+func putBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return putUint(b, num, 1)
+}
+
+func putDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func putString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func putTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return putInt(b, num, t.UnixNano())
+}
+
+func putRef(b []byte, num protowire.Number, t *stringTable, s string) []byte {
+	return putUint(b, num, t.ref(s))
+}
+
+// putRefs writes a packed list of string references.
+func putRefs(b []byte, num protowire.Number, t *stringTable, values []string) []byte {
+	if len(values) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, v := range values {
+		packed = protowire.AppendVarint(packed, t.ref(v))
+	}
+	return putMessage(b, num, packed)
+}
+
+// putPairs writes a map as packed key, value references sorted by key.
+func putPairs(b []byte, num protowire.Number, t *stringTable, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k, m[k])
+	}
+	return putRefs(b, num, t, pairs)
+}
+
+// putMessage writes a length-delimited field, even an empty one, as
+// repeated messages must.
+func putMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// putOptional writes a singular message unless every field is zero.
+func putOptional(b []byte, num protowire.Number, msg []byte) []byte {
+	if len(msg) == 0 {
+		return b
+	}
+	return putMessage(b, num, msg)
+}
+
+// walk calls fn for each top-level field in msg with the raw bytes of
+// length-delimited fields or the decoded value of varint and fixed fields.
+func walk(msg []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(msg) > 0 {
+		num, typ, tagLen := protowire.ConsumeTag(msg)
+		if tagLen < 0 {
+			return protowire.ParseError(tagLen)
+		}
+		msg = msg[tagLen:]
+		var (
+			raw     []byte
+			value   uint64
+			consume int
+		)
+		switch typ {
+		case protowire.VarintType:
+			value, consume = protowire.ConsumeVarint(msg)
+		case protowire.Fixed64Type:
+			value, consume = protowire.ConsumeFixed64(msg)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, consume = protowire.ConsumeFixed32(msg)
+			value = uint64(v32)
+		case protowire.BytesType:
+			raw, consume = protowire.ConsumeBytes(msg)
+		default:
+			consume = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if consume < 0 {
+			return protowire.ParseError(consume)
+		}
+		if err := fn(num, typ, raw, value); err != nil {
+			return err
+		}
+		msg = msg[consume:]
+	}
+	return nil
+}
+
+// decoder resolves string references against one report's table. The first
+// bad reference is kept in err and later ones resolve to "".
+type decoder struct {
+	strings []string
+	err     error
+}
+
+func (d *decoder) str(ref uint64) string {
+	if ref == 0 {
+		return ""
+	}
+	if ref > uint64(len(d.strings)) {
+		if d.err == nil {
+			d.err = fmt.Errorf("string reference %d out of range", ref)
+		}
+		return ""
+	}
+	return d.strings[ref-1]
+}
+
+// refs appends the references of a packed or unpacked repeated field.
+func (d *decoder) refs(dst []string, typ protowire.Type, v []byte, n uint64) []string {
+	if typ == protowire.VarintType {
+		return append(dst, d.str(n))
+	}
+	for len(v) > 0 {
+		ref, consume := protowire.ConsumeVarint(v)
+		if consume < 0 {
+			if d.err == nil {
+				d.err = protowire.ParseError(consume)
+			}
+			return dst
+		}
+		dst = append(dst, d.str(ref))
+		v = v[consume:]
+	}
+	return dst
+}
+
+func (d *decoder) pairs(flat []string) map[string]string {
+	if len(flat)%2 != 0 {
+		if d.err == nil {
+			d.err = errors.New("map with an odd number of references")
+		}
+		return nil
+	}
+	m := make(map[string]string, len(flat)/2)
+	for i := 0; i < len(flat); i += 2 {
+		m[flat[i]] = flat[i+1]
+	}
+	return m
+}
+
+func (d *decoder) delta(data []byte) (Delta, error) {
+	var delta Delta
+	err := walk(data, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			delta.BaseSequence = n
+		case 2:
+			r := &delta.Removed
+			lists := []*[]string{
+				&r.Namespaces, &r.Pods, &r.Nodes, &r.NetworkClasses, &r.NetworkPods, &r.NetworkNamespaces,
+				&r.PodConnections, &r.WorkloadConnections, &r.NamespaceConnections, &r.ServiceConnections,
+			}
+			return walk(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+				if num >= 1 && int(num) <= len(lists) {
+					*lists[num-1] = d.refs(*lists[num-1], typ, v, n)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return delta, err
+}
+
+// snapshot decodes a Snapshot with every list and map allocated, as the
+// builder produces them, so it serializes to JSON the same way.
+func (d *decoder) snapshot(data []byte) (snapshot.Snapshot, error) {
+	s := snapshot.Snapshot{
+		Namespaces: []snapshot.NamespaceCostRecord{},
+		Pods:       []snapshot.PodCostRecord{},
+		Nodes:      []snapshot.NodeCostRecord{},
+		Network:    emptyNetwork(),
+	}
+	err := walk(data, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			s.SchemaVersion = string(v)
+		case 2:
+			s.Timestamp = timeFrom(n)
+		case 3:
+			ns, err := d.namespace(v)
+			if err != nil {
+				return err
+			}
+			s.Namespaces = append(s.Namespaces, ns)
+		case 4:
+			pod, err := d.pod(v)
+			if err != nil {
+				return err
+			}
+			s.Pods = append(s.Pods, pod)
+		case 5:
+			node, err := d.node(v)
+			if err != nil {
+				return err
+			}
+			s.Nodes = append(s.Nodes, node)
+		case 6:
+			resources, err := d.resources(v)
+			if err != nil {
+				return err
+			}
+			s.Resources = resources
+		case 7:
+			network, err := d.network(v)
+			if err != nil {
+				return err
+			}
+			s.Network = network
+		}
+		return nil
+	})
+	return s, err
+}
+
+func emptyNetwork() snapshot.NetworkSnapshot {
+	return snapshot.NetworkSnapshot{
+		ByClass:              []snapshot.NetworkClassTotals{},
+		Pods:                 []snapshot.PodNetworkRecord{},
+		Namespaces:           []snapshot.NamespaceNetworkRecord{},
+		PodConnections:       []snapshot.NetworkConnection{},
+		WorkloadConnections:  []snapshot.NetworkConnection{},
+		NamespaceConnections: []snapshot.NetworkConnection{},
+		ServiceConnections:   []snapshot.NetworkConnection{},
+	}
+}
+
+func (d *decoder) namespace(data []byte) (snapshot.NamespaceCostRecord, error) {
+	var ns snapshot.NamespaceCostRecord
+	var labels, dimensions []string
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			ns.ClusterID = d.str(n)
+		case 2:
+			ns.Namespace = d.str(n)
+		case 3:
+			ns.HourlyCost = math.Float64frombits(n)
+		case 4:
+			ns.PodCount = int(int64(n))
+		case 5:
+			ns.CPURequestMilli = int64(n)
+		case 6:
+			ns.MemoryRequestBytes = int64(n)
+		case 7:
+			ns.CPUUsageMilli = int64(n)
+		case 8:
+			ns.MemoryUsageBytes = int64(n)
+		case 9:
+			ns.NetworkTxBytes = n
+		case 10:
+			ns.NetworkRxBytes = n
+		case 11:
+			ns.NetworkEgressCost = math.Float64frombits(n)
+		case 12:
+			labels = d.refs(labels, typ, v, n)
+		case 13:
+			ns.Environment = d.str(n)
+		case 14:
+			ns.SharedCostHourly = math.Float64frombits(n)
+		case 15:
+			var shared snapshot.SharedCost
+			err := walk(v, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+				switch num {
+				case 1:
+					shared.Namespace = d.str(n)
+				case 2:
+					shared.Policy = d.str(n)
+				case 3:
+					shared.HourlyCost = math.Float64frombits(n)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ns.SharedCosts = append(ns.SharedCosts, shared)
+		case 16:
+			ns.DistributedCostHourly = math.Float64frombits(n)
+		case 17:
+			attribution, err := d.attribution(v)
+			if err != nil {
+				return err
+			}
+			ns.Attribution = attribution
+		case 18:
+			dimensions = d.refs(dimensions, typ, v, n)
+		}
+		return nil
+	})
+	ns.Labels = d.pairs(labels)
+	ns.Dimensions = d.pairs(dimensions)
+	return ns, err
+}
+
+func (d *decoder) attribution(data []byte) (enricher.Attribution, error) {
+	var a enricher.Attribution
+	values := []*enricher.Value{&a.Team, &a.CostCenter, &a.Environment, &a.SharedWeight}
+	err := walk(data, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+		if num < 1 || int(num) > 2*len(values) {
+			return nil
+		}
+		value := values[(num-1)/2]
+		if num%2 == 1 {
+			value.Value = d.str(n)
+		} else {
+			value.Source = d.str(n)
+		}
+		return nil
+	})
+	return a, err
+}
+
+func (d *decoder) pod(data []byte) (snapshot.PodCostRecord, error) {
+	var pod snapshot.PodCostRecord
+	var labels, annotations, dimensions []string
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			pod.Namespace = d.str(n)
+		case 2:
+			pod.Pod = d.str(n)
+		case 3:
+			pod.Node = d.str(n)
+		case 4:
+			pod.ControllerKind = d.str(n)
+		case 5:
+			pod.ControllerName = d.str(n)
+		case 6:
+			pod.HourlyCost = math.Float64frombits(n)
+		case 7:
+			pod.CPURequestMilli = int64(n)
+		case 8:
+			pod.MemoryRequestBytes = int64(n)
+		case 9:
+			pod.CPUUsageMilli = int64(n)
+		case 10:
+			pod.MemoryUsageBytes = int64(n)
+		case 11:
+			pod.GPURequest = int64(n)
+		case 12:
+			pod.StorageRequestBytes = int64(n)
+		case 13:
+			labels = d.refs(labels, typ, v, n)
+		case 14:
+			annotations = d.refs(annotations, typ, v, n)
+		case 15:
+			attribution, err := d.attribution(v)
+			if err != nil {
+				return err
+			}
+			pod.Attribution = attribution
+		case 16:
+			dimensions = d.refs(dimensions, typ, v, n)
+		}
+		return nil
+	})
+	pod.Labels = d.pairs(labels)
+	if len(annotations) > 0 {
+		pod.Annotations = d.pairs(annotations)
+	}
+	pod.Dimensions = d.pairs(dimensions)
+	return pod, err
+}
+
+func (d *decoder) node(data []byte) (snapshot.NodeCostRecord, error) {
+	var node snapshot.NodeCostRecord
+	var labels []string
+	node.Taints = []string{}
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			node.ClusterID = d.str(n)
+		case 2:
+			node.NodeName = d.str(n)
+		case 3:
+			node.HourlyCost = math.Float64frombits(n)
+		case 4:
+			node.CPUUsagePercent = math.Float64frombits(n)
+		case 5:
+			node.MemoryUsagePercent = math.Float64frombits(n)
+		case 6:
+			node.CPUAllocatableMilli = int64(n)
+		case 7:
+			node.MemoryAllocatableBytes = int64(n)
+		case 8:
+			node.GPUAllocatable = int64(n)
+		case 9:
+			node.PodCount = int(int64(n))
+		case 10:
+			node.Status = d.str(n)
+		case 11:
+			node.IsUnderPressure = n != 0
+		case 12:
+			node.InstanceType = d.str(n)
+		case 13:
+			labels = d.refs(labels, typ, v, n)
+		case 14:
+			node.Taints = d.refs(node.Taints, typ, v, n)
+		}
+		return nil
+	})
+	node.Labels = d.pairs(labels)
+	return node, err
+}
+
+func (d *decoder) resources(data []byte) (snapshot.ResourceSnapshot, error) {
+	var r snapshot.ResourceSnapshot
+	err := walk(data, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+		switch num {
+		case 1:
+			r.ClusterID = d.str(n)
+		case 2:
+			r.CPUUsageMilliTotal = int64(n)
+		case 3:
+			r.CPURequestMilliTotal = int64(n)
+		case 4:
+			r.MemoryUsageBytesTotal = int64(n)
+		case 5:
+			r.MemoryRequestBytesTotal = int64(n)
+		case 6:
+			r.TotalNodeHourlyCost = math.Float64frombits(n)
+		case 7:
+			r.NetworkTxBytesTotal = n
+		case 8:
+			r.NetworkRxBytesTotal = n
+		case 9:
+			r.NetworkEgressCostTotal = math.Float64frombits(n)
+		}
+		return nil
+	})
+	return r, err
+}
+
+func (d *decoder) class(data []byte) (snapshot.NetworkClassTotals, error) {
+	var class snapshot.NetworkClassTotals
+	err := walk(data, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+		switch num {
+		case 1:
+			class.Class = d.str(n)
+		case 2:
+			class.TxBytes = n
+		case 3:
+			class.RxBytes = n
+		case 4:
+			class.EgressCostHourly = math.Float64frombits(n)
+		}
+		return nil
+	})
+	return class, err
+}
+
+func (d *decoder) network(data []byte) (snapshot.NetworkSnapshot, error) {
+	network := emptyNetwork()
+	connections := []*[]snapshot.NetworkConnection{
+		&network.PodConnections, &network.WorkloadConnections, &network.NamespaceConnections, &network.ServiceConnections,
+	}
+	err := walk(data, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			network.ClusterID = d.str(n)
+		case 2:
+			network.TxBytes = n
+		case 3:
+			network.RxBytes = n
+		case 4:
+			network.EgressCost = math.Float64frombits(n)
+		case 5:
+			class, err := d.class(v)
+			if err != nil {
+				return err
+			}
+			network.ByClass = append(network.ByClass, class)
+		case 6:
+			pod := snapshot.PodNetworkRecord{ByClass: []snapshot.NetworkClassTotals{}}
+			err := walk(v, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case 1:
+					pod.Namespace = d.str(n)
+				case 2:
+					pod.Pod = d.str(n)
+				case 3:
+					pod.Node = d.str(n)
+				case 4:
+					pod.TxBytes = n
+				case 5:
+					pod.RxBytes = n
+				case 6:
+					pod.EgressCostHourly = math.Float64frombits(n)
+				case 7:
+					class, err := d.class(v)
+					if err != nil {
+						return err
+					}
+					pod.ByClass = append(pod.ByClass, class)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			network.Pods = append(network.Pods, pod)
+		case 7:
+			ns := snapshot.NamespaceNetworkRecord{ByClass: []snapshot.NetworkClassTotals{}}
+			err := walk(v, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case 1:
+					ns.Namespace = d.str(n)
+				case 2:
+					ns.TxBytes = n
+				case 3:
+					ns.RxBytes = n
+				case 4:
+					ns.EgressCostHourly = math.Float64frombits(n)
+				case 5:
+					class, err := d.class(v)
+					if err != nil {
+						return err
+					}
+					ns.ByClass = append(ns.ByClass, class)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			network.Namespaces = append(network.Namespaces, ns)
+		case 8, 9, 10, 11:
+			var conn snapshot.NetworkConnection
+			err := walk(v, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+				switch num {
+				case 1:
+					conn.Source.Kind = d.str(n)
+				case 2:
+					conn.Source.Namespace = d.str(n)
+				case 3:
+					conn.Source.Name = d.str(n)
+				case 4:
+					conn.Destination.Kind = d.str(n)
+				case 5:
+					conn.Destination.Namespace = d.str(n)
+				case 6:
+					conn.Destination.Name = d.str(n)
+				case 7:
+					conn.Class = d.str(n)
+				case 8:
+					conn.TxBytes = n
+				case 9:
+					conn.RxBytes = n
+				case 10:
+					conn.EgressCostHourly = math.Float64frombits(n)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			list := connections[num-8]
+			*list = append(*list, conn)
+		}
+		return nil
+	})
+	return network, err
+}
+
+func timeFrom(nanos uint64) time.Time {
+	return time.Unix(0, int64(nanos)).UTC()
+}
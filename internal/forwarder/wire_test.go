@@ -0,0 +1,104 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/enricher"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+func wireReport(pods int) AgentReport {
+	attribution := enricher.Attribution{
+		Team:         enricher.Value{Value: "payments", Source: "namespace"},
+		CostCenter:   enricher.Value{Value: "cc-42", Source: "default"},
+		Environment:  enricher.Value{Value: "production", Source: "label"},
+		SharedWeight: enricher.Value{Value: "1.5", Source: "annotation"},
+	}
+	classes := []snapshot.NetworkClassTotals{{Class: "internet", TxBytes: 10, RxBytes: 4, EgressCostHourly: 0.02}}
+	snap := snapshot.Snapshot{
+		SchemaVersion: snapshot.SchemaVersion,
+		Timestamp:     time.Date(2025, 3, 1, 12, 0, 0, 5, time.UTC),
+		Namespaces: []snapshot.NamespaceCostRecord{{
+			ClusterID: "c1", Namespace: "shop", HourlyCost: 1.25, PodCount: pods,
+			CPURequestMilli: 500, MemoryRequestBytes: 1 << 30, CPUUsageMilli: 120, MemoryUsageBytes: 1 << 29,
+			NetworkTxBytes: 99, NetworkRxBytes: 11, NetworkEgressCost: 0.3,
+			Labels: map[string]string{"team": "payments", "tier": "web"}, Environment: "production",
+			SharedCostHourly: 0.1, SharedCosts: []snapshot.SharedCost{{Namespace: "kube-system", Policy: "proportional", HourlyCost: 0.1}},
+			DistributedCostHourly: 1.35, Attribution: attribution, Dimensions: map[string]string{"product": "checkout"},
+		}},
+		Nodes: []snapshot.NodeCostRecord{{
+			ClusterID: "c1", NodeName: "node-a", HourlyCost: 0.4, CPUUsagePercent: 42.5, MemoryUsagePercent: 61,
+			CPUAllocatableMilli: 4000, MemoryAllocatableBytes: 16 << 30, GPUAllocatable: 1, PodCount: pods,
+			Status: "Ready", IsUnderPressure: true, InstanceType: "m5.xlarge",
+			Labels: map[string]string{"zone": "a"}, Taints: []string{"gpu=true:NoSchedule"},
+		}},
+		Resources: snapshot.ResourceSnapshot{
+			ClusterID: "c1", CPUUsageMilliTotal: 120, CPURequestMilliTotal: 500, MemoryUsageBytesTotal: 1 << 29,
+			MemoryRequestBytesTotal: 1 << 30, TotalNodeHourlyCost: 0.4, NetworkTxBytesTotal: 99, NetworkRxBytesTotal: 11,
+			NetworkEgressCostTotal: 0.3,
+		},
+		Network: snapshot.NetworkSnapshot{
+			ClusterID: "c1", TxBytes: 99, RxBytes: 11, EgressCost: 0.3, ByClass: classes,
+			Namespaces:           []snapshot.NamespaceNetworkRecord{{Namespace: "shop", TxBytes: 99, RxBytes: 11, EgressCostHourly: 0.3, ByClass: classes}},
+			WorkloadConnections:  []snapshot.NetworkConnection{},
+			NamespaceConnections: []snapshot.NetworkConnection{},
+			ServiceConnections:   []snapshot.NetworkConnection{},
+		},
+	}
+	for i := 0; i < pods; i++ {
+		name := fmt.Sprintf("web-%d", i)
+		snap.Pods = append(snap.Pods, snapshot.PodCostRecord{
+			Namespace: "shop", Pod: name, Node: "node-a", ControllerKind: "Deployment", ControllerName: "web",
+			HourlyCost: 0.05, CPURequestMilli: 100, MemoryRequestBytes: 1 << 27, CPUUsageMilli: -1, MemoryUsageBytes: 1 << 26,
+			GPURequest: 1, StorageRequestBytes: 1 << 32, Labels: map[string]string{"app": "web"},
+			Annotations: map[string]string{"clustercost.io/team": "payments"}, Attribution: attribution,
+			Dimensions: map[string]string{"product": "checkout"},
+		})
+		snap.Network.Pods = append(snap.Network.Pods, snapshot.PodNetworkRecord{
+			Namespace: "shop", Pod: name, Node: "node-a", TxBytes: 9, RxBytes: 1, EgressCostHourly: 0.01, ByClass: classes,
+		})
+		snap.Network.PodConnections = append(snap.Network.PodConnections, snapshot.NetworkConnection{
+			Source:      snapshot.NetworkEndpoint{Kind: "pod", Namespace: "shop", Name: name},
+			Destination: snapshot.NetworkEndpoint{Kind: "service", Namespace: "payments", Name: "ledger"},
+			Class:       "intra-zone", TxBytes: uint64(i), RxBytes: 3, EgressCostHourly: 0.001,
+		})
+	}
+	return AgentReport{
+		SchemaVersion: SchemaVersion, ClusterID: "c1", ClusterName: "prod", NodeName: "node-a",
+		Scope: ScopeCluster, Version: "v1.2.3", Timestamp: snap.Timestamp, ID: "agent-7", Sequence: 7, Snapshot: snap,
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	full := wireReport(3)
+	delta := Diff(full, wireReport(2))
+	reports := []AgentReport{full, delta}
+
+	decoded, err := UnmarshalBatch(MarshalBatch(reports))
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	got, _ := json.Marshal(decoded)
+	want, _ := json.Marshal(reports)
+	if string(got) != string(want) {
+		t.Fatalf("round trip differs:\n got %s\nwant %s", got, want)
+	}
+	if _, err := UnmarshalBatch([]byte{0x0a, 0x05, 0x01}); err == nil {
+		t.Fatal("expected a truncated batch to fail")
+	}
+}
+
+func TestWireInternsStrings(t *testing.T) {
+	report := wireReport(200)
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact := MarshalReport(report)
+	if len(compact)*3 > len(data) {
+		t.Fatalf("expected protobuf to be under a third of JSON, got %d vs %d bytes", len(compact), len(data))
+	}
+}
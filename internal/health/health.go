@@ -0,0 +1,204 @@
+// Package health tracks the state of each agent component so the API can
+// report a degraded agent instead of a plain "ok" once any snapshot exists.
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Component names tracked by the agent.
+const (
+	Informers        = "informers"
+	MetricsCollector = "metrics_collector"
+	NetworkCollector = "network_collector"
+	EBPF             = "ebpf"
+	Forwarder        = "forwarder"
+)
+
+// Status values. Pending components have not reported an outcome yet.
+const (
+	StatusPending  = "pending"
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailed   = "failed"
+)
+
+// failAfter is the number of consecutive errors after which a degraded
+// component is reported as failed.
+const failAfter = 3
+
+// ComponentStatus is the reported state of one component.
+type ComponentStatus struct {
+	Name                string `json:"name"`
+	Status              string `json:"status"`
+	LastError           string `json:"lastError,omitempty"`
+	LastErrorTime       string `json:"lastErrorTime,omitempty"`
+	LastSuccessTime     string `json:"lastSuccessTime,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+type component struct {
+	status      string
+	sticky      bool
+	lastError   string
+	lastErrorAt time.Time
+	lastSuccess time.Time
+	failures    int
+}
+
+// Tracker records component outcomes. A nil Tracker ignores updates.
+type Tracker struct {
+	mu         sync.RWMutex
+	components map[string]*component
+	gates      []string
+	now        func() time.Time
+}
+
+// NewTracker builds a Tracker whose Ready result depends on the gated
+// components.
+func NewTracker(gates []string) *Tracker {
+	return &Tracker{
+		components: map[string]*component{},
+		gates:      append([]string{}, gates...),
+		now:        time.Now,
+	}
+}
+
+// Track starts reporting name as pending. Outcomes for components that are
+// not tracked are ignored, so disabled components stay out of the report.
+func (t *Tracker) Track(names ...string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range names {
+		if _, ok := t.components[name]; !ok {
+			t.components[name] = &component{status: StatusPending}
+		}
+	}
+}
+
+// Observe records the outcome of one run of name. A nil err marks the
+// component ok; errors degrade it and fail it after repeated attempts.
+func (t *Tracker) Observe(name string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.components[name]
+	if !ok || c.sticky {
+		return
+	}
+	now := t.now()
+	if err == nil {
+		c.status = StatusOK
+		c.lastSuccess = now
+		c.failures = 0
+		return
+	}
+	c.failures++
+	c.lastError = err.Error()
+	c.lastErrorAt = now
+	if c.failures >= failAfter {
+		c.status = StatusFailed
+	} else {
+		c.status = StatusDegraded
+	}
+}
+
+// Fail tracks name and marks it failed for good, for errors that will not
+// clear on their own such as a collector disabled by preflight checks.
+func (t *Tracker) Fail(name string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.components[name]
+	if !ok {
+		c = &component{}
+		t.components[name] = c
+	}
+	c.status = StatusFailed
+	c.sticky = true
+	c.failures++
+	c.lastError = err.Error()
+	c.lastErrorAt = t.now()
+}
+
+// Components returns every tracked component sorted by name.
+func (t *Tracker) Components() []ComponentStatus {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]ComponentStatus, 0, len(t.components))
+	for name, c := range t.components {
+		status := ComponentStatus{
+			Name:                name,
+			Status:              c.status,
+			LastError:           c.lastError,
+			LastErrorTime:       formatTime(c.lastErrorAt),
+			LastSuccessTime:     formatTime(c.lastSuccess),
+			ConsecutiveFailures: c.failures,
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Overall returns the worst status across components, or ok when none are
+// tracked.
+func (t *Tracker) Overall() string {
+	overall := StatusOK
+	for _, c := range t.Components() {
+		if rank(c.Status) > rank(overall) {
+			overall = c.Status
+		}
+	}
+	return overall
+}
+
+// Ready reports whether every gated component is usable. Degraded components
+// still count as ready; a gated component that has not reported yet does not.
+func (t *Tracker) Ready() (bool, string) {
+	if t == nil {
+		return true, ""
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, name := range t.gates {
+		c, ok := t.components[name]
+		if !ok || c.status == StatusPending {
+			return false, name + " has not reported yet"
+		}
+		if c.status == StatusFailed {
+			return false, name + " failed: " + c.lastError
+		}
+	}
+	return true, ""
+}
+
+func rank(status string) int {
+	switch status {
+	case StatusFailed:
+		return 2
+	case StatusDegraded:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
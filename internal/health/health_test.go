@@ -0,0 +1,70 @@
+package health
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestObserveDegradesThenFails(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Track(Forwarder)
+	tracker.Observe(Forwarder, nil)
+	if got := tracker.Overall(); got != StatusOK {
+		t.Fatalf("overall = %s, want ok", got)
+	}
+
+	sendErr := errors.New("remote endpoint returned status 502")
+	tracker.Observe(Forwarder, sendErr)
+	if got := tracker.Overall(); got != StatusDegraded {
+		t.Fatalf("overall after one error = %s, want degraded", got)
+	}
+	tracker.Observe(Forwarder, sendErr)
+	tracker.Observe(Forwarder, sendErr)
+	status := tracker.Components()[0]
+	if status.Status != StatusFailed || status.ConsecutiveFailures != 3 || status.LastError != sendErr.Error() {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.LastSuccessTime == "" {
+		t.Fatalf("last success time lost after failures")
+	}
+
+	tracker.Observe(Forwarder, nil)
+	if got := tracker.Components()[0]; got.Status != StatusOK || got.ConsecutiveFailures != 0 {
+		t.Fatalf("expected recovery, got %+v", got)
+	}
+}
+
+func TestUntrackedAndStickyComponents(t *testing.T) {
+	tracker := NewTracker(nil)
+	tracker.Observe(NetworkCollector, errors.New("disabled collectors are ignored"))
+	if got := tracker.Components(); len(got) != 0 {
+		t.Fatalf("untracked component reported: %+v", got)
+	}
+
+	tracker.Fail(EBPF, errors.New("bpffs not mounted"))
+	tracker.Observe(EBPF, nil)
+	if got := tracker.Components()[0].Status; got != StatusFailed {
+		t.Fatalf("sticky failure cleared, status %s", got)
+	}
+}
+
+func TestReadyDependsOnGates(t *testing.T) {
+	tracker := NewTracker([]string{Informers})
+	tracker.Track(Informers, Forwarder)
+	if ready, _ := tracker.Ready(); ready {
+		t.Fatalf("ready before gated component reported")
+	}
+	tracker.Observe(Informers, nil)
+	for i := 0; i < failAfter; i++ {
+		tracker.Observe(Forwarder, errors.New("down"))
+	}
+	if ready, reason := tracker.Ready(); !ready {
+		t.Fatalf("ungated failure blocked readiness: %s", reason)
+	}
+	for i := 0; i < failAfter; i++ {
+		tracker.Observe(Informers, errors.New("list pods: forbidden"))
+	}
+	if ready, _ := tracker.Ready(); ready {
+		t.Fatalf("ready although gated component failed")
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	batchinformers "k8s.io/client-go/informers/batch/v1"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	discoveryinformers "k8s.io/client-go/informers/discovery/v1"
 	"k8s.io/client-go/kubernetes"
@@ -16,37 +20,62 @@ import (
 
 // ClusterCache wraps shared informers for the core resources we care about.
 type ClusterCache struct {
-	factory           informers.SharedInformerFactory
-	nodeInformer      coreinformers.NodeInformer
-	namespaceInformer coreinformers.NamespaceInformer
-	podInformer       coreinformers.PodInformer
-	serviceInformer   coreinformers.ServiceInformer
-	endpointInformer  discoveryinformers.EndpointSliceInformer
-	synced            []cache.InformerSynced
+	factory             informers.SharedInformerFactory
+	nodeInformer        coreinformers.NodeInformer
+	namespaceInformer   coreinformers.NamespaceInformer
+	podInformer         coreinformers.PodInformer
+	serviceInformer     coreinformers.ServiceInformer
+	claimInformer       coreinformers.PersistentVolumeClaimInformer
+	endpointInformer    discoveryinformers.EndpointSliceInformer
+	deploymentInformer  appsinformers.DeploymentInformer
+	statefulSetInformer appsinformers.StatefulSetInformer
+	daemonSetInformer   appsinformers.DaemonSetInformer
+	jobInformer         batchinformers.JobInformer
+	cronJobInformer     batchinformers.CronJobInformer
+	synced              []cache.InformerSynced
 }
 
-// NewClusterCache builds informers for nodes, namespaces, and pods.
+// NewClusterCache builds informers for nodes, namespaces, pods, services,
+// persistent volume claims, endpoint slices, and the workloads that own pods.
 func NewClusterCache(client kubernetes.Interface, resyncPeriod time.Duration) *ClusterCache {
 	factory := informers.NewSharedInformerFactory(client, resyncPeriod)
 	nodeInformer := factory.Core().V1().Nodes()
 	namespaceInformer := factory.Core().V1().Namespaces()
 	podInformer := factory.Core().V1().Pods()
 	serviceInformer := factory.Core().V1().Services()
+	claimInformer := factory.Core().V1().PersistentVolumeClaims()
 	endpointInformer := factory.Discovery().V1().EndpointSlices()
+	deploymentInformer := factory.Apps().V1().Deployments()
+	statefulSetInformer := factory.Apps().V1().StatefulSets()
+	daemonSetInformer := factory.Apps().V1().DaemonSets()
+	jobInformer := factory.Batch().V1().Jobs()
+	cronJobInformer := factory.Batch().V1().CronJobs()
 
 	return &ClusterCache{
-		factory:           factory,
-		nodeInformer:      nodeInformer,
-		namespaceInformer: namespaceInformer,
-		podInformer:       podInformer,
-		serviceInformer:   serviceInformer,
-		endpointInformer:  endpointInformer,
+		factory:             factory,
+		nodeInformer:        nodeInformer,
+		namespaceInformer:   namespaceInformer,
+		podInformer:         podInformer,
+		serviceInformer:     serviceInformer,
+		claimInformer:       claimInformer,
+		endpointInformer:    endpointInformer,
+		deploymentInformer:  deploymentInformer,
+		statefulSetInformer: statefulSetInformer,
+		daemonSetInformer:   daemonSetInformer,
+		jobInformer:         jobInformer,
+		cronJobInformer:     cronJobInformer,
 		synced: []cache.InformerSynced{
 			nodeInformer.Informer().HasSynced,
 			namespaceInformer.Informer().HasSynced,
 			podInformer.Informer().HasSynced,
 			serviceInformer.Informer().HasSynced,
+			claimInformer.Informer().HasSynced,
 			endpointInformer.Informer().HasSynced,
+			deploymentInformer.Informer().HasSynced,
+			statefulSetInformer.Informer().HasSynced,
+			daemonSetInformer.Informer().HasSynced,
+			jobInformer.Informer().HasSynced,
+			cronJobInformer.Informer().HasSynced,
 		},
 	}
 }
@@ -81,7 +110,79 @@ func (c *ClusterCache) ServiceLister() corev1listers.ServiceLister {
 	return c.serviceInformer.Lister()
 }
 
+// PersistentVolumeClaimLister exposes the cached persistent volume claim lister.
+func (c *ClusterCache) PersistentVolumeClaimLister() corev1listers.PersistentVolumeClaimLister {
+	return c.claimInformer.Lister()
+}
+
 // EndpointsLister exposes the cached endpoints lister.
 func (c *ClusterCache) EndpointsLister() discoverylisters.EndpointSliceLister {
 	return c.endpointInformer.Lister()
 }
+
+// Sizes counts the objects held by each informer cache, keyed by resource.
+func (c *ClusterCache) Sizes() map[string]int {
+	return map[string]int{
+		"nodes":                  len(c.nodeInformer.Informer().GetStore().ListKeys()),
+		"namespaces":             len(c.namespaceInformer.Informer().GetStore().ListKeys()),
+		"pods":                   len(c.podInformer.Informer().GetStore().ListKeys()),
+		"services":               len(c.serviceInformer.Informer().GetStore().ListKeys()),
+		"persistentvolumeclaims": len(c.claimInformer.Informer().GetStore().ListKeys()),
+		"endpointslices":         len(c.endpointInformer.Informer().GetStore().ListKeys()),
+		"deployments":            len(c.deploymentInformer.Informer().GetStore().ListKeys()),
+		"statefulsets":           len(c.statefulSetInformer.Informer().GetStore().ListKeys()),
+		"daemonsets":             len(c.daemonSetInformer.Informer().GetStore().ListKeys()),
+		"jobs":                   len(c.jobInformer.Informer().GetStore().ListKeys()),
+		"cronjobs":               len(c.cronJobInformer.Informer().GetStore().ListKeys()),
+	}
+}
+
+// Workloads lists the cached pod controllers with their metadata.
+func (c *ClusterCache) Workloads() ([]Workload, error) {
+	var out []Workload
+	add := func(kind string, meta metav1.ObjectMeta) {
+		out = append(out, Workload{
+			Kind:        kind,
+			Namespace:   meta.Namespace,
+			Name:        meta.Name,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+		})
+	}
+	deployments, err := c.deploymentInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments {
+		add("Deployment", d.ObjectMeta)
+	}
+	statefulSets, err := c.statefulSetInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statefulSets {
+		add("StatefulSet", s.ObjectMeta)
+	}
+	daemonSets, err := c.daemonSetInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range daemonSets {
+		add("DaemonSet", d.ObjectMeta)
+	}
+	jobs, err := c.jobInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		add("Job", j.ObjectMeta)
+	}
+	cronJobs, err := c.cronJobInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, cj := range cronJobs {
+		add("CronJob", cj.ObjectMeta)
+	}
+	return out, nil
+}
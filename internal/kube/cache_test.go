@@ -5,6 +5,8 @@ import (
 	"testing"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -42,3 +44,23 @@ func TestClusterCacheStartSuccess(t *testing.T) {
 		t.Fatal("cache start timeout")
 	}
 }
+
+func TestClusterCacheSizes(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api-0", Namespace: "payments"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "payments"}},
+	)
+	cache := NewClusterCache(client, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := cache.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	sizes := cache.Sizes()
+	if sizes["nodes"] != 1 || sizes["pods"] != 2 || sizes["services"] != 0 {
+		t.Fatalf("unexpected cache sizes %v", sizes)
+	}
+}
@@ -16,15 +16,25 @@ type PodContainer struct {
 
 // Pod represents a simplified pod metadata payload used by the agent.
 type Pod struct {
-	Namespace  string
-	Name       string
-	UID        types.UID
-	NodeName   string
-	PodIP      string
-	Labels     map[string]string
-	OwnerKind  string
-	OwnerName  string
-	Containers []PodContainer
+	Namespace   string
+	Name        string
+	UID         types.UID
+	NodeName    string
+	PodIP       string
+	Labels      map[string]string
+	Annotations map[string]string
+	OwnerKind   string
+	OwnerName   string
+	Containers  []PodContainer
+}
+
+// Workload is the metadata of a pod controller such as a Deployment.
+type Workload struct {
+	Kind        string
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
 }
 
 // Node contains relevant metadata for pricing decisions.
@@ -45,8 +55,9 @@ type Node struct {
 
 // Namespace describes kubernetes namespaces with cost labels.
 type Namespace struct {
-	Name   string
-	Labels map[string]string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
 }
 
 // PodUsage details actual usage metrics collected from the metrics server.
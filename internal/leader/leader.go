@@ -0,0 +1,138 @@
+// Package leader elects one replica of a cluster-scope deployment through a
+// coordination.k8s.io Lease, so cluster-wide work runs exactly once.
+package leader
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"clustercost-agent-k8s/internal/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Elector campaigns for the Lease until its context ends. A nil Elector
+// always leads, so callers can gate work on IsLeader without checking
+// whether election is enabled.
+type Elector struct {
+	identity string
+	logger   *slog.Logger
+	elector  *leaderelection.LeaderElector
+	leading  atomic.Bool
+
+	mu       sync.Mutex
+	onStart  []func(context.Context)
+	onChange []func(bool)
+}
+
+// New builds an Elector for the Lease named in cfg. identity must be unique
+// per replica; the pod name is a good choice.
+func New(client kubernetes.Interface, cfg config.LeaderElectionConfig, identity string, logger *slog.Logger) (*Elector, error) {
+	if identity == "" {
+		return nil, errors.New("leader election identity is required")
+	}
+	e := &Elector{identity: identity, logger: logger}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: cfg.LeaseName, Namespace: cfg.LeaseNamespace},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.started,
+			OnStoppedLeading: e.stopped,
+			OnNewLeader: func(current string) {
+				if current != identity {
+					logger.Info("following leader", slog.String("leader", current))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.elector = elector
+	return e, nil
+}
+
+// OnStartedLeading registers fn to run in its own goroutine every time this
+// replica becomes leader. Its context is cancelled when leadership is lost.
+// Register before Run.
+func (e *Elector) OnStartedLeading(fn func(ctx context.Context)) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.onStart = append(e.onStart, fn)
+	e.mu.Unlock()
+}
+
+// OnChange registers fn to be called with the new state whenever leadership
+// is gained or lost.
+func (e *Elector) OnChange(fn func(leading bool)) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.onChange = append(e.onChange, fn)
+	e.mu.Unlock()
+}
+
+// IsLeader reports whether this replica currently holds the Lease.
+func (e *Elector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	return e.leading.Load()
+}
+
+// Run campaigns for the Lease, and campaigns again after losing it, until
+// ctx is done. The Lease is released on the way out so a standby can take
+// over without waiting for it to expire.
+func (e *Elector) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	for ctx.Err() == nil {
+		e.elector.Run(ctx)
+	}
+}
+
+func (e *Elector) started(ctx context.Context) {
+	e.logger.Info("acquired leader lease", slog.String("identity", e.identity))
+	e.leading.Store(true)
+	e.mu.Lock()
+	onStart, onChange := append([]func(context.Context){}, e.onStart...), append([]func(bool){}, e.onChange...)
+	e.mu.Unlock()
+	for _, fn := range onChange {
+		fn(true)
+	}
+	for _, fn := range onStart {
+		go fn(ctx)
+	}
+}
+
+func (e *Elector) stopped() {
+	if !e.leading.Swap(false) {
+		return
+	}
+	e.logger.Info("lost leader lease", slog.String("identity", e.identity))
+	e.mu.Lock()
+	onChange := append([]func(bool){}, e.onChange...)
+	e.mu.Unlock()
+	for _, fn := range onChange {
+		fn(false)
+	}
+}
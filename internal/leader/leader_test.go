@@ -0,0 +1,72 @@
+package leader
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNilElectorAlwaysLeads(t *testing.T) {
+	var e *Elector
+	e.OnStartedLeading(func(context.Context) {})
+	e.OnChange(func(bool) {})
+	e.Run(context.Background())
+	if !e.IsLeader() {
+		t.Fatal("expected a nil elector to lead")
+	}
+}
+
+func TestElectorAcquiresLease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	cfg := config.LeaderElectionConfig{
+		LeaseName:      "clustercost-agent",
+		LeaseNamespace: "clustercost",
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+	e, err := New(client, cfg, "replica-a", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	started := make(chan struct{})
+	e.OnStartedLeading(func(context.Context) { close(started) })
+	changes := make(chan bool, 2)
+	e.OnChange(func(leading bool) { changes <- leading })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to acquire the lease")
+	}
+	if !e.IsLeader() || !<-changes {
+		t.Fatal("expected to report leadership")
+	}
+
+	cancel()
+	<-done
+	if e.IsLeader() || <-changes {
+		t.Fatal("expected leadership to end with the context")
+	}
+	lease, err := client.CoordinationV1().Leases("clustercost").Get(context.Background(), "clustercost-agent", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder != "" {
+		t.Fatalf("expected the lease to be released, held by %q", *holder)
+	}
+}
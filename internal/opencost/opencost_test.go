@@ -0,0 +1,143 @@
+package opencost
+
+import (
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/allocation"
+)
+
+func TestParseWindow(t *testing.T) {
+	// A Wednesday afternoon.
+	now := time.Date(2025, 3, 12, 15, 30, 0, 0, time.UTC)
+	midnight := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		raw        string
+		start, end time.Time
+	}{
+		{"today", midnight, now},
+		{"yesterday", midnight.Add(-day), midnight},
+		{"week", time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC), now},
+		{"lastweek", time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), now},
+		{"lastmonth", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"90m", now.Add(-90 * time.Minute), now},
+		{"24h", now.Add(-24 * time.Hour), now},
+		{"3d", midnight.Add(-2 * day), now},
+		{"2025-03-10T00:00:00Z,2025-03-11T00:00:00Z", midnight.Add(-2 * day), midnight.Add(-day)},
+		{"1741564800,1741651200", midnight.Add(-2 * day), midnight.Add(-day)},
+	}
+	for _, tc := range cases {
+		start, end, err := ParseWindow(tc.raw, now)
+		if err != nil {
+			t.Errorf("ParseWindow(%q): %v", tc.raw, err)
+			continue
+		}
+		if !start.Equal(tc.start) || !end.Equal(tc.end) {
+			t.Errorf("ParseWindow(%q) = %v, %v; want %v, %v", tc.raw, start, end, tc.start, tc.end)
+		}
+	}
+	for _, raw := range []string{"", "7x", "0h", "tomorrow", "2025-03-11T00:00:00Z,2025-03-10T00:00:00Z"} {
+		if _, _, err := ParseWindow(raw, now); err == nil {
+			t.Errorf("ParseWindow(%q) should fail", raw)
+		}
+	}
+}
+
+func TestSteps(t *testing.T) {
+	start := time.Date(2025, 1, 30, 12, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 2, 2, 0, 0, 0, 0, time.UTC)
+
+	steps, err := Steps(start, end, "", "")
+	if err != nil || len(steps) != 1 || !steps[0].End.Equal(end) {
+		t.Fatalf("default step = %+v, %v", steps, err)
+	}
+	steps, err = Steps(start, end, "false", "1d")
+	if err != nil || len(steps) != 3 || !steps[1].Start.Equal(start.Add(day)) || !steps[2].End.Equal(end) {
+		t.Fatalf("1d steps = %+v, %v", steps, err)
+	}
+	steps, err = Steps(start, end, "day", "1h")
+	if err != nil || len(steps) != 3 || !steps[1].Start.Equal(time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("daily steps = %+v, %v", steps, err)
+	}
+	steps, err = Steps(start, end, "month", "")
+	if err != nil || len(steps) != 2 || !steps[1].Start.Equal(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("monthly steps = %+v, %v", steps, err)
+	}
+	if steps, err = Steps(start, end, "true", "1h"); err != nil || len(steps) != 1 {
+		t.Fatalf("accumulated steps = %+v, %v", steps, err)
+	}
+	for _, tc := range [][2]string{{"sometimes", ""}, {"false", "10m"}, {"false", "1x"}} {
+		if _, err := Steps(start, end, tc[0], tc[1]); err == nil {
+			t.Errorf("Steps(accumulate=%q, step=%q) should fail", tc[0], tc[1])
+		}
+	}
+}
+
+func TestAllocationSetNamesLikeOpenCost(t *testing.T) {
+	aggregate, err := ParseAggregate("namespace,controller,label:team")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	res := allocation.Result{Allocations: []allocation.Allocation{{
+		Properties: map[string]string{
+			"namespace":  "payments",
+			"controller": "Deployment/api",
+			"label:team": allocation.Unallocated,
+		},
+		Minutes:             120,
+		CPUCoreHours:        4,
+		CPUCoreRequestHours: 2,
+		TotalCost:           1,
+	}}}
+	r := Range{Start: time.Unix(0, 0), End: time.Unix(7200, 0)}
+	set := AllocationSet(res, aggregate, r)
+	alloc, ok := set["payments/deployment:api/__unallocated__"]
+	if !ok {
+		t.Fatalf("unexpected set %+v", set)
+	}
+	if alloc.Properties.Namespace != "payments" || alloc.Properties.Controller != "deployment:api" || alloc.Properties.Labels != nil {
+		t.Fatalf("unexpected properties %+v", alloc.Properties)
+	}
+	if alloc.CPUCores != 2 || alloc.CPUCoreRequestAverage != 1 || alloc.Window.End != r.End {
+		t.Fatalf("unexpected allocation %+v", alloc)
+	}
+
+	if props, err := ParseAggregate("deployment"); err != nil || props[0].Key != "Deployment" {
+		t.Fatalf("deployment aggregate = %+v, %v", props, err)
+	}
+	if props, err := ParseAggregate(""); err != nil || len(props) != 4 {
+		t.Fatalf("empty aggregate = %+v, %v", props, err)
+	}
+	if _, err := ParseAggregate("container"); err == nil {
+		t.Fatal("expected container aggregate to fail")
+	}
+}
+
+func TestAssetSet(t *testing.T) {
+	at := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	assets := []allocation.Asset{
+		{Cluster: "c1", Node: "a", InstanceType: "m5.large", Start: at, End: at.Add(2 * time.Hour), CPUCoreHours: 4, TotalCost: 1},
+		{Cluster: "c1", Node: "b", Start: at, End: at.Add(time.Hour), CPUCoreHours: 2, TotalCost: 0.5},
+	}
+	r := Range{Start: at, End: at.Add(2 * time.Hour)}
+
+	set := AssetSet(assets, nil, r)
+	node, ok := set["c1/Node/a"]
+	if !ok || node.Type != "Node" || node.NodeType != "m5.large" || node.CPUCores != 2 {
+		t.Fatalf("unexpected node asset %+v", set)
+	}
+
+	aggregate, err := ParseAssetAggregate("type")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	set = AssetSet(assets, aggregate, r)
+	total, ok := set["Node"]
+	if !ok || len(set) != 1 || total.TotalCost != 1.5 || total.CPUCores != 3 {
+		t.Fatalf("unexpected aggregated assets %+v", set)
+	}
+	if _, err := ParseAssetAggregate("namespace"); err == nil {
+		t.Fatal("expected namespace asset aggregate to fail")
+	}
+}
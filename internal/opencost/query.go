@@ -0,0 +1,133 @@
+package opencost
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"clustercost-agent-k8s/internal/allocation"
+)
+
+// MaxSteps bounds the number of sets a single query may return.
+const MaxSteps = 1000
+
+// controllerKinds maps the OpenCost aggregate names for one kind of
+// controller to the kind.
+var controllerKinds = map[string]string{
+	"deployment":  "Deployment",
+	"statefulset": "StatefulSet",
+	"daemonset":   "DaemonSet",
+	"job":         "Job",
+	"cronjob":     "CronJob",
+	"replicaset":  "ReplicaSet",
+}
+
+// ParseAggregate maps a comma-separated OpenCost aggregate to allocation
+// properties. Besides the native properties it accepts the controller
+// kinds ("deployment", "statefulset", ...), which group by controller
+// name, and "team", "owner", "department" and "product", which read the
+// label of the same name. An empty aggregate lists every pod.
+func ParseAggregate(raw string) ([]allocation.Property, error) {
+	var out []allocation.Property
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+			continue
+		case controllerKinds[part] != "":
+			out = append(out, allocation.Property{Name: allocation.PropertyController, Key: controllerKinds[part]})
+			continue
+		case part == "team" || part == "owner" || part == "department" || part == "product":
+			out = append(out, allocation.Property{Name: allocation.PropertyLabel, Key: part})
+			continue
+		case part == "container" || part == "service":
+			return nil, fmt.Errorf("aggregate %q is not supported: costs are tracked per pod", part)
+		}
+		props, err := allocation.ParseAggregate(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, props...)
+	}
+	if len(out) == 0 {
+		out = []allocation.Property{
+			{Name: allocation.PropertyCluster},
+			{Name: allocation.PropertyNode},
+			{Name: allocation.PropertyNamespace},
+			{Name: allocation.PropertyPod},
+		}
+	}
+	return out, nil
+}
+
+// Range is one step of a query.
+type Range struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Steps splits a window into the ranges of its result sets.
+//
+// accumulate is "true" or "all" for a single set, "hour", "day", "week",
+// "month" or "quarter" for one set per calendar period, and "false" or
+// empty for one set per step. step is a duration such as "1h" or "1d" and
+// defaults to the whole window.
+func Steps(start, end time.Time, accumulate, step string) ([]Range, error) {
+	var next func(time.Time) time.Time
+	switch accumulate {
+	case "true", "all":
+		return []Range{{Start: start, End: end}}, nil
+	case "hour":
+		next = func(t time.Time) time.Time { return t.Truncate(time.Hour).Add(time.Hour) }
+	case "day":
+		next = func(t time.Time) time.Time { return t.Truncate(day).Add(day) }
+	case "week":
+		next = func(t time.Time) time.Time {
+			midnight := t.Truncate(day)
+			return midnight.AddDate(0, 0, 7-int(midnight.Weekday()))
+		}
+	case "month":
+		next = func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+		}
+	case "quarter":
+		next = func(t time.Time) time.Time {
+			first := time.Month((int(t.Month())-1)/3*3 + 1)
+			return time.Date(t.Year(), first, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 3, 0)
+		}
+	case "false", "":
+		size := end.Sub(start)
+		if step != "" {
+			d, err := allocation.ParseWindow(step)
+			if err != nil {
+				return nil, fmt.Errorf("invalid step %q", step)
+			}
+			size = d
+		}
+		if size < time.Hour {
+			return nil, fmt.Errorf("step %s is shorter than the 1h resolution", size)
+		}
+		next = func(t time.Time) time.Time { return t.Add(size) }
+	default:
+		b, err := strconv.ParseBool(accumulate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid accumulate %q", accumulate)
+		}
+		return Steps(start, end, strconv.FormatBool(b), step)
+	}
+
+	var out []Range
+	for t := start.UTC(); t.Before(end); {
+		if len(out) == MaxSteps {
+			return nil, fmt.Errorf("query has more than %d steps", MaxSteps)
+		}
+		stepEnd := next(t)
+		if stepEnd.After(end) {
+			stepEnd = end
+		}
+		out = append(out, Range{Start: t, End: stepEnd})
+		t = stepEnd
+	}
+	return out, nil
+}
@@ -0,0 +1,327 @@
+// Package opencost translates allocation data into the request and
+// response shapes of the OpenCost allocation and assets APIs, so tools
+// written against OpenCost can read from the agent.
+package opencost
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"clustercost-agent-k8s/internal/allocation"
+)
+
+// Response is the envelope of every OpenCost response.
+type Response struct {
+	Code    int    `json:"code"`
+	Data    any    `json:"data,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Window is the range a set covers.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// AllocationProperties are the properties shared by the pods of an
+// allocation.
+type AllocationProperties struct {
+	Cluster        string            `json:"cluster,omitempty"`
+	Node           string            `json:"node,omitempty"`
+	Namespace      string            `json:"namespace,omitempty"`
+	Pod            string            `json:"pod,omitempty"`
+	ControllerKind string            `json:"controllerKind,omitempty"`
+	Controller     string            `json:"controller,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+}
+
+// Allocation is an OpenCost allocation. Averages are over the minutes the
+// allocation ran; adjustments, load balancers and shared costs are always
+// zero because the agent does not reconcile against a bill.
+type Allocation struct {
+	Name                       string               `json:"name"`
+	Properties                 AllocationProperties `json:"properties"`
+	Window                     Window               `json:"window"`
+	Start                      time.Time            `json:"start"`
+	End                        time.Time            `json:"end"`
+	Minutes                    float64              `json:"minutes"`
+	CPUCores                   float64              `json:"cpuCores"`
+	CPUCoreRequestAverage      float64              `json:"cpuCoreRequestAverage"`
+	CPUCoreUsageAverage        float64              `json:"cpuCoreUsageAverage"`
+	CPUCoreHours               float64              `json:"cpuCoreHours"`
+	CPUCost                    float64              `json:"cpuCost"`
+	CPUCostAdjustment          float64              `json:"cpuCostAdjustment"`
+	CPUEfficiency              float64              `json:"cpuEfficiency"`
+	GPUCount                   float64              `json:"gpuCount"`
+	GPUHours                   float64              `json:"gpuHours"`
+	GPUCost                    float64              `json:"gpuCost"`
+	GPUCostAdjustment          float64              `json:"gpuCostAdjustment"`
+	NetworkTransferBytes       float64              `json:"networkTransferBytes"`
+	NetworkReceiveBytes        float64              `json:"networkReceiveBytes"`
+	NetworkCost                float64              `json:"networkCost"`
+	NetworkCostAdjustment      float64              `json:"networkCostAdjustment"`
+	LoadBalancerCost           float64              `json:"loadBalancerCost"`
+	LoadBalancerCostAdjustment float64              `json:"loadBalancerCostAdjustment"`
+	PVBytes                    float64              `json:"pvBytes"`
+	PVByteHours                float64              `json:"pvByteHours"`
+	PVCost                     float64              `json:"pvCost"`
+	PVCostAdjustment           float64              `json:"pvCostAdjustment"`
+	RAMBytes                   float64              `json:"ramBytes"`
+	RAMByteRequestAverage      float64              `json:"ramByteRequestAverage"`
+	RAMByteUsageAverage        float64              `json:"ramByteUsageAverage"`
+	RAMByteHours               float64              `json:"ramByteHours"`
+	RAMCost                    float64              `json:"ramCost"`
+	RAMCostAdjustment          float64              `json:"ramCostAdjustment"`
+	RAMEfficiency              float64              `json:"ramEfficiency"`
+	SharedCost                 float64              `json:"sharedCost"`
+	ExternalCost               float64              `json:"externalCost"`
+	TotalCost                  float64              `json:"totalCost"`
+	TotalEfficiency            float64              `json:"totalEfficiency"`
+}
+
+// AllocationSet converts one allocation result into an OpenCost set keyed
+// by allocation name. Controllers are named "<kind>:<name>" in lower-case
+// kind, as OpenCost does.
+func AllocationSet(res allocation.Result, aggregate []allocation.Property, r Range) map[string]Allocation {
+	out := make(map[string]Allocation, len(res.Allocations))
+	for _, a := range res.Allocations {
+		values := make([]string, len(aggregate))
+		var props AllocationProperties
+		for i, p := range aggregate {
+			v := a.Properties[p.String()]
+			if p.Name == allocation.PropertyController && p.Key == "" && v != allocation.Unallocated {
+				if kind, name, ok := strings.Cut(v, "/"); ok {
+					v = strings.ToLower(kind) + ":" + name
+				}
+			}
+			values[i] = v
+			if v != allocation.Unallocated {
+				setProperty(&props, p, v)
+			}
+		}
+		name := strings.Join(values, "/")
+		out[name] = newAllocation(name, props, a, r)
+	}
+	return out
+}
+
+func setProperty(props *AllocationProperties, p allocation.Property, v string) {
+	switch p.Name {
+	case allocation.PropertyCluster:
+		props.Cluster = v
+	case allocation.PropertyNode:
+		props.Node = v
+	case allocation.PropertyNamespace:
+		props.Namespace = v
+	case allocation.PropertyPod:
+		props.Pod = v
+	case allocation.PropertyControllerKind:
+		props.ControllerKind = v
+	case allocation.PropertyController:
+		props.Controller = v
+		if p.Key != "" {
+			props.ControllerKind = strings.ToLower(p.Key)
+		}
+	case allocation.PropertyLabel:
+		if props.Labels == nil {
+			props.Labels = map[string]string{}
+		}
+		props.Labels[p.Key] = v
+	case allocation.PropertyAnnotation:
+		if props.Annotations == nil {
+			props.Annotations = map[string]string{}
+		}
+		props.Annotations[p.Key] = v
+	}
+}
+
+func newAllocation(name string, props AllocationProperties, a allocation.Allocation, r Range) Allocation {
+	out := Allocation{
+		Name:                 name,
+		Properties:           props,
+		Window:               Window(r),
+		Start:                a.Start,
+		End:                  a.End,
+		Minutes:              a.Minutes,
+		CPUCoreHours:         a.CPUCoreHours,
+		CPUCost:              a.CPUCost,
+		CPUEfficiency:        a.CPUEfficiency,
+		GPUHours:             a.GPUHours,
+		GPUCost:              a.GPUCost,
+		NetworkTransferBytes: a.NetworkTxBytes,
+		NetworkReceiveBytes:  a.NetworkRxBytes,
+		NetworkCost:          a.NetworkCost,
+		PVByteHours:          a.PVByteHours,
+		PVCost:               a.PVCost,
+		RAMByteHours:         a.RAMByteHours,
+		RAMCost:              a.RAMCost,
+		RAMEfficiency:        a.RAMEfficiency,
+		TotalCost:            a.TotalCost,
+		TotalEfficiency:      a.TotalEfficiency,
+	}
+	if hours := a.Minutes / 60; hours > 0 {
+		out.CPUCores = a.CPUCoreHours / hours
+		out.CPUCoreRequestAverage = a.CPUCoreRequestHours / hours
+		out.CPUCoreUsageAverage = a.CPUCoreUsageHours / hours
+		out.GPUCount = a.GPUHours / hours
+		out.PVBytes = a.PVByteHours / hours
+		out.RAMBytes = a.RAMByteHours / hours
+		out.RAMByteRequestAverage = a.RAMByteRequestHours / hours
+		out.RAMByteUsageAverage = a.RAMByteUsageHours / hours
+	}
+	return out
+}
+
+// AssetProperties identify an asset.
+type AssetProperties struct {
+	Category string `json:"category,omitempty"`
+	Cluster  string `json:"cluster,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// Asset is an OpenCost asset. Only nodes are tracked, so every asset has
+// type "Node" and category "Compute", or is the sum of such assets.
+type Asset struct {
+	Type         string            `json:"type"`
+	Properties   AssetProperties   `json:"properties"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Window       Window            `json:"window"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Minutes      float64           `json:"minutes"`
+	NodeType     string            `json:"nodeType,omitempty"`
+	CPUCores     float64           `json:"cpuCores"`
+	RAMBytes     float64           `json:"ramBytes"`
+	CPUCoreHours float64           `json:"cpuCoreHours"`
+	RAMByteHours float64           `json:"ramByteHours"`
+	GPUHours     float64           `json:"GPUHours"`
+	GPUCount     float64           `json:"gpuCount"`
+	CPUCost      float64           `json:"cpuCost"`
+	GPUCost      float64           `json:"gpuCost"`
+	RAMCost      float64           `json:"ramCost"`
+	Discount     float64           `json:"discount"`
+	Preemptible  float64           `json:"preemptible"`
+	Adjustment   float64           `json:"adjustment"`
+	TotalCost    float64           `json:"totalCost"`
+}
+
+// Asset properties nodes can be grouped by.
+const (
+	AssetType     = "type"
+	AssetCategory = "category"
+	AssetCluster  = "cluster"
+	AssetName     = "name"
+)
+
+// ParseAssetAggregate parses a comma-separated list of asset properties
+// ("type", "category", "cluster", "name" or "label:<key>"). "node" is
+// accepted for name.
+func ParseAssetAggregate(raw string) ([]allocation.Property, error) {
+	var out []allocation.Property
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		name, key, hasKey := strings.Cut(part, ":")
+		switch {
+		case part == "":
+			continue
+		case name == allocation.PropertyLabel && key != "":
+		case hasKey:
+			return nil, fmt.Errorf("unknown asset property %q", part)
+		case name == "node":
+			name = AssetName
+		case name != AssetType && name != AssetCategory && name != AssetCluster && name != AssetName:
+			return nil, fmt.Errorf("unknown asset property %q", part)
+		}
+		out = append(out, allocation.Property{Name: name, Key: key})
+	}
+	return out, nil
+}
+
+// AssetSet groups node assets by the aggregate properties. Without an
+// aggregate every node is its own asset, keyed "<cluster>/Node/<name>".
+func AssetSet(assets []allocation.Asset, aggregate []allocation.Property, r Range) map[string]Asset {
+	out := map[string]Asset{}
+	for _, a := range assets {
+		values := []string{a.Cluster, "Node", a.Node}
+		props := AssetProperties{Category: "Compute", Cluster: a.Cluster, Name: a.Node}
+		labels := a.Labels
+		if len(aggregate) > 0 {
+			values = values[:0]
+			props = AssetProperties{}
+			labels = nil
+			for _, p := range aggregate {
+				v := assetValue(a, p)
+				values = append(values, v)
+				switch p.Name {
+				case AssetCategory:
+					props.Category = v
+				case AssetCluster:
+					props.Cluster = v
+				case AssetName:
+					props.Name = v
+				case allocation.PropertyLabel:
+					if v != allocation.Unallocated {
+						if labels == nil {
+							labels = map[string]string{}
+						}
+						labels[p.Key] = v
+					}
+				}
+			}
+		}
+		key := strings.Join(values, "/")
+		cur, ok := out[key]
+		if !ok {
+			cur = Asset{Type: "Node", Properties: props, Labels: labels, Window: Window(r), Start: a.Start, End: a.End}
+			if len(aggregate) == 0 {
+				cur.NodeType = a.InstanceType
+			}
+		}
+		if a.Start.Before(cur.Start) {
+			cur.Start = a.Start
+		}
+		if a.End.After(cur.End) {
+			cur.End = a.End
+		}
+		cur.CPUCoreHours += a.CPUCoreHours
+		cur.RAMByteHours += a.RAMByteHours
+		cur.GPUHours += a.GPUHours
+		cur.CPUCost += a.CPUCost
+		cur.GPUCost += a.GPUCost
+		cur.RAMCost += a.RAMCost
+		cur.TotalCost += a.TotalCost
+		out[key] = cur
+	}
+	for key, a := range out {
+		a.Minutes = a.End.Sub(a.Start).Minutes()
+		if hours := a.Minutes / 60; hours > 0 {
+			a.CPUCores = a.CPUCoreHours / hours
+			a.RAMBytes = a.RAMByteHours / hours
+			a.GPUCount = a.GPUHours / hours
+		}
+		out[key] = a
+	}
+	return out
+}
+
+func assetValue(a allocation.Asset, p allocation.Property) string {
+	var v string
+	switch p.Name {
+	case AssetType:
+		v = "Node"
+	case AssetCategory:
+		v = "Compute"
+	case AssetCluster:
+		v = a.Cluster
+	case AssetName:
+		v = a.Node
+	case allocation.PropertyLabel:
+		v = a.Labels[p.Key]
+	}
+	if v == "" {
+		return allocation.Unallocated
+	}
+	return v
+}
@@ -0,0 +1,91 @@
+package opencost
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+var durationWindow = regexp.MustCompile(`^(\d+)(m|h|d|w)$`)
+
+// ParseWindow parses an OpenCost window relative to now, in UTC:
+//
+//   - "today", "yesterday", "week", "lastweek", "month" and "lastmonth" are
+//     calendar periods; weeks start on Sunday.
+//   - "30m", "24h", "7d" and "2w" end now. Day and week windows are
+//     aligned to midnight, so "7d" is today and the six days before it.
+//   - "start,end" takes RFC 3339 timestamps or Unix seconds.
+//
+// Windows that reach past now end now.
+func ParseWindow(raw string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	midnight := now.Truncate(day)
+	weekStart := midnight.AddDate(0, 0, -int(midnight.Weekday()))
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var start, end time.Time
+	switch raw {
+	case "":
+		return time.Time{}, time.Time{}, fmt.Errorf("window is required")
+	case "today":
+		start, end = midnight, midnight.Add(day)
+	case "yesterday":
+		start, end = midnight.Add(-day), midnight
+	case "week":
+		start, end = weekStart, weekStart.AddDate(0, 0, 7)
+	case "lastweek":
+		start, end = weekStart.AddDate(0, 0, -7), weekStart
+	case "month":
+		start, end = monthStart, monthStart.AddDate(0, 1, 0)
+	case "lastmonth":
+		start, end = monthStart.AddDate(0, -1, 0), monthStart
+	default:
+		if m := durationWindow.FindStringSubmatch(raw); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n <= 0 {
+				return time.Time{}, time.Time{}, fmt.Errorf("invalid window %q", raw)
+			}
+			unit := map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": day, "w": 7 * day}[m[2]]
+			end = now
+			start = end.Add(-time.Duration(n) * unit)
+			if m[2] == "d" || m[2] == "w" {
+				start, end = start.Truncate(day).Add(day), end.Truncate(day).Add(day)
+			}
+			break
+		}
+		first, second, ok := strings.Cut(raw, ",")
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid window %q", raw)
+		}
+		var err error
+		if start, err = parseTime(first); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid window start: %w", err)
+		}
+		if end, err = parseTime(second); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid window end: %w", err)
+		}
+	}
+	if end.After(now) {
+		end = now
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("window %q is empty", raw)
+	}
+	return start, end, nil
+}
+
+func parseTime(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor Unix seconds", raw)
+	}
+	return t.UTC(), nil
+}
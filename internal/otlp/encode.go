@@ -0,0 +1,224 @@
+package otlp
+
+import (
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The types below mirror the subset of opentelemetry-proto metrics/v1 the
+// exporter needs. They are encoded by hand so the agent does not pull in the
+// OpenTelemetry SDK and gRPC for a single unary call.
+
+type attribute struct {
+	key   string
+	value string
+}
+
+type point struct {
+	attrs []attribute
+	value float64
+}
+
+type metric struct {
+	name        string
+	description string
+	unit        string
+	// cumulative marks a monotonic cumulative Sum; otherwise a Gauge.
+	cumulative bool
+	points     []point
+}
+
+type resourceMetrics struct {
+	attrs   []attribute
+	metrics []metric
+}
+
+// Field numbers from opentelemetry/proto/{collector/metrics,metrics,common,resource}/v1.
+const (
+	fieldRequestResourceMetrics = 1
+
+	fieldResourceMetricsResource = 1
+	fieldResourceMetricsScope    = 2
+	fieldResourceAttributes      = 1
+
+	fieldScopeMetricsScope   = 1
+	fieldScopeMetricsMetrics = 2
+	fieldScopeName           = 1
+	fieldScopeVersion        = 2
+
+	fieldMetricName        = 1
+	fieldMetricDescription = 2
+	fieldMetricUnit        = 3
+	fieldMetricGauge       = 5
+	fieldMetricSum         = 7
+
+	fieldGaugeDataPoints     = 1
+	fieldSumDataPoints       = 1
+	fieldSumTemporality      = 2
+	fieldSumMonotonic        = 3
+	aggregationCumulative    = 2
+	fieldPointStartTime      = 2
+	fieldPointTime           = 3
+	fieldPointAsDouble       = 4
+	fieldPointAttributes     = 7
+	fieldKeyValueKey         = 1
+	fieldKeyValueValue       = 2
+	fieldAnyValueStringValue = 1
+
+	fieldResponsePartialSuccess = 1
+	fieldPartialRejected        = 1
+	fieldPartialErrorMessage    = 2
+)
+
+// encodeRequest builds an ExportMetricsServiceRequest. Cumulative sums use
+// start as their start time; every point is stamped with now.
+func encodeRequest(resources []resourceMetrics, scopeVersion string, start, now time.Time) []byte {
+	var out []byte
+	for _, rm := range resources {
+		out = appendMessage(out, fieldRequestResourceMetrics, encodeResourceMetrics(rm, scopeVersion, start, now))
+	}
+	return out
+}
+
+func encodeResourceMetrics(rm resourceMetrics, scopeVersion string, start, now time.Time) []byte {
+	var resource []byte
+	for _, attr := range rm.attrs {
+		resource = appendMessage(resource, fieldResourceAttributes, encodeAttribute(attr))
+	}
+
+	var scope []byte
+	scope = appendString(scope, fieldScopeName, scopeName)
+	scope = appendString(scope, fieldScopeVersion, scopeVersion)
+	var scopeMetrics []byte
+	scopeMetrics = appendMessage(scopeMetrics, fieldScopeMetricsScope, scope)
+	for _, m := range rm.metrics {
+		if len(m.points) == 0 {
+			continue
+		}
+		scopeMetrics = appendMessage(scopeMetrics, fieldScopeMetricsMetrics, encodeMetric(m, start, now))
+	}
+
+	var out []byte
+	out = appendMessage(out, fieldResourceMetricsResource, resource)
+	return appendMessage(out, fieldResourceMetricsScope, scopeMetrics)
+}
+
+func encodeMetric(m metric, start, now time.Time) []byte {
+	var out []byte
+	out = appendString(out, fieldMetricName, m.name)
+	out = appendString(out, fieldMetricDescription, m.description)
+	out = appendString(out, fieldMetricUnit, m.unit)
+
+	var data []byte
+	if !m.cumulative {
+		for _, p := range m.points {
+			data = appendMessage(data, fieldGaugeDataPoints, encodePoint(p, time.Time{}, now))
+		}
+		return appendMessage(out, fieldMetricGauge, data)
+	}
+	for _, p := range m.points {
+		data = appendMessage(data, fieldSumDataPoints, encodePoint(p, start, now))
+	}
+	data = protowire.AppendTag(data, fieldSumTemporality, protowire.VarintType)
+	data = protowire.AppendVarint(data, aggregationCumulative)
+	data = protowire.AppendTag(data, fieldSumMonotonic, protowire.VarintType)
+	data = protowire.AppendVarint(data, 1)
+	return appendMessage(out, fieldMetricSum, data)
+}
+
+func encodePoint(p point, start, now time.Time) []byte {
+	var out []byte
+	if !start.IsZero() {
+		out = protowire.AppendTag(out, fieldPointStartTime, protowire.Fixed64Type)
+		out = protowire.AppendFixed64(out, uint64(start.UnixNano()))
+	}
+	out = protowire.AppendTag(out, fieldPointTime, protowire.Fixed64Type)
+	out = protowire.AppendFixed64(out, uint64(now.UnixNano()))
+	out = protowire.AppendTag(out, fieldPointAsDouble, protowire.Fixed64Type)
+	out = protowire.AppendFixed64(out, math.Float64bits(p.value))
+	for _, attr := range p.attrs {
+		out = appendMessage(out, fieldPointAttributes, encodeAttribute(attr))
+	}
+	return out
+}
+
+func encodeAttribute(attr attribute) []byte {
+	var value []byte
+	value = appendString(value, fieldAnyValueStringValue, attr.value)
+	var out []byte
+	out = appendString(out, fieldKeyValueKey, attr.key)
+	return appendMessage(out, fieldKeyValueValue, value)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// decodePartialSuccess reads ExportMetricsServiceResponse.partial_success.
+// A malformed response is treated as full success, matching the spec's
+// advice to ignore unknown content.
+func decodePartialSuccess(body []byte) (int64, string) {
+	var rejected int64
+	var message string
+	walk(body, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) {
+		if num != fieldResponsePartialSuccess || typ != protowire.BytesType {
+			return
+		}
+		walk(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) {
+			switch {
+			case num == fieldPartialRejected && typ == protowire.VarintType:
+				rejected = int64(n)
+			case num == fieldPartialErrorMessage && typ == protowire.BytesType:
+				message = string(v)
+			}
+		})
+	})
+	return rejected, message
+}
+
+// walk calls fn for each top-level field in msg with the raw bytes of
+// length-delimited fields or the decoded value of varint and fixed fields.
+func walk(msg []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) {
+	for len(msg) > 0 {
+		num, typ, tagLen := protowire.ConsumeTag(msg)
+		if tagLen < 0 {
+			return
+		}
+		msg = msg[tagLen:]
+		var (
+			raw     []byte
+			value   uint64
+			consume int
+		)
+		switch typ {
+		case protowire.VarintType:
+			value, consume = protowire.ConsumeVarint(msg)
+		case protowire.Fixed64Type:
+			value, consume = protowire.ConsumeFixed64(msg)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, consume = protowire.ConsumeFixed32(msg)
+			value = uint64(v32)
+		case protowire.BytesType:
+			raw, consume = protowire.ConsumeBytes(msg)
+		default:
+			consume = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if consume < 0 {
+			return
+		}
+		fn(num, typ, raw, value)
+		msg = msg[consume:]
+	}
+}
@@ -0,0 +1,270 @@
+// Package otlp pushes snapshot cost, usage, and network metrics to an
+// OpenTelemetry collector over OTLP/HTTP or OTLP/gRPC.
+package otlp
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+)
+
+const scopeName = "clustercost-agent-k8s"
+
+// Exporter sends the latest snapshot on every interval. Network bytes and
+// egress cost are per-snapshot amounts, so the exporter adds up every
+// snapshot it sees and reports the running totals as cumulative sums.
+type Exporter struct {
+	clusterName string
+	instance    string
+	version     string
+	store       *snapshot.Store
+	transport   transport
+	interval    time.Duration
+	timeout     time.Duration
+	logger      *slog.Logger
+
+	mu     sync.Mutex
+	start  time.Time
+	latest snapshot.Snapshot
+	seen   time.Time
+	totals map[sumKey]float64
+}
+
+// sumKey identifies one cumulative series: the resource it belongs to (a
+// namespace, or empty for the cluster) plus its metric and point attributes.
+type sumKey struct {
+	namespace string
+	metric    string
+	class     string
+	direction string
+}
+
+// NewExporter builds an Exporter for cfg.
+func NewExporter(cfg config.OTLPConfig, clusterName, version string, store *snapshot.Store, logger *slog.Logger) (*Exporter, error) {
+	var (
+		t   transport
+		err error
+	)
+	switch cfg.Protocol {
+	case config.OTLPProtocolGRPC:
+		t, err = newGRPCTransport(cfg.Endpoint, cfg.Headers, cfg.Timeout)
+	default:
+		t, err = newHTTPTransport(cfg.Endpoint, cfg.Headers, cfg.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Exporter{
+		clusterName: clusterName,
+		version:     version,
+		store:       store,
+		transport:   t,
+		interval:    interval,
+		timeout:     timeout,
+		logger:      logger,
+		totals:      map[sumKey]float64{},
+	}, nil
+}
+
+// SetInstance adds service.instance.id to every exported resource. A
+// node-scoped agent sets it to its node name, because every agent of a
+// DaemonSet exports the same resources with its own node's values.
+func (e *Exporter) SetInstance(id string) {
+	e.instance = id
+}
+
+// Run consumes snapshots and exports on every interval until ctx is done.
+func (e *Exporter) Run(ctx context.Context) {
+	sub := e.store.Subscribe()
+	defer sub.Close()
+	if snap, ok := e.store.Latest(); ok {
+		e.Observe(snap)
+	}
+	go func() {
+		for snap := range sub.C() {
+			e.Observe(snap)
+			if skipped := sub.Coalesced(); skipped > 0 {
+				e.logger.Warn("otlp exporter skipped snapshots; cumulative network totals undercount", slog.Uint64("skipped", skipped))
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			exportCtx, cancel := context.WithTimeout(ctx, e.timeout)
+			if err := e.Export(exportCtx); err != nil {
+				e.logger.Warn("otlp export failed", slog.String("error", err.Error()))
+			}
+			cancel()
+		}
+	}
+}
+
+// Observe records snap as the latest snapshot and adds its network amounts
+// to the cumulative totals. A snapshot seen twice is only counted once.
+func (e *Exporter) Observe(snap snapshot.Snapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !snap.Timestamp.After(e.seen) {
+		return
+	}
+	if e.start.IsZero() {
+		e.start = snap.Timestamp
+	}
+	e.seen = snap.Timestamp
+	e.latest = snap
+
+	for _, ns := range snap.Namespaces {
+		e.totals[sumKey{namespace: ns.Namespace, metric: "io", direction: "transmit"}] += float64(ns.NetworkTxBytes)
+		e.totals[sumKey{namespace: ns.Namespace, metric: "io", direction: "receive"}] += float64(ns.NetworkRxBytes)
+		e.totals[sumKey{namespace: ns.Namespace, metric: "egress"}] += ns.NetworkEgressCost
+	}
+	for _, class := range snap.Network.ByClass {
+		e.totals[sumKey{metric: "io", class: class.Class, direction: "transmit"}] += float64(class.TxBytes)
+		e.totals[sumKey{metric: "io", class: class.Class, direction: "receive"}] += float64(class.RxBytes)
+		e.totals[sumKey{metric: "egress", class: class.Class}] += class.EgressCostHourly
+	}
+}
+
+// Export sends one ExportMetricsServiceRequest built from the latest
+// snapshot. It is a no-op until a snapshot has been observed.
+func (e *Exporter) Export(ctx context.Context) error {
+	e.mu.Lock()
+	if e.seen.IsZero() {
+		e.mu.Unlock()
+		return nil
+	}
+	resources := e.resources()
+	start := e.start
+	e.mu.Unlock()
+
+	body := encodeRequest(resources, e.version, start, time.Now())
+	resp, err := e.transport.export(ctx, body)
+	if err != nil {
+		return err
+	}
+	if rejected, message := decodePartialSuccess(resp); rejected > 0 || message != "" {
+		e.logger.Warn("otlp endpoint rejected data points", slog.Int64("rejected", rejected), slog.String("message", message))
+	}
+	return nil
+}
+
+// resources maps the latest snapshot onto k8s resources: one for the
+// cluster, one per namespace, and one per node. Callers hold e.mu.
+func (e *Exporter) resources() []resourceMetrics {
+	snap := e.latest
+	cluster := attribute{key: "k8s.cluster.name", value: e.clusterName}
+
+	clusterRes := resourceMetrics{
+		attrs: []attribute{cluster},
+		metrics: []metric{
+			gaugeOf("clustercost.cluster.cost.hourly", "Hourly cost of all nodes", "USD/h", snap.Resources.TotalNodeHourlyCost),
+			e.classSum("clustercost.cluster.network.io", "Bytes sent and received since the exporter started, by traffic class", "By", "io"),
+			e.classSum("clustercost.cluster.network.egress.cost", "Network egress cost since the exporter started, by traffic class", "USD", "egress"),
+		},
+	}
+	out := []resourceMetrics{clusterRes}
+
+	for _, ns := range snap.Namespaces {
+		out = append(out, resourceMetrics{
+			attrs: []attribute{cluster, {key: "k8s.namespace.name", value: ns.Namespace}},
+			metrics: []metric{
+				{
+					name: "clustercost.namespace.cost.hourly", description: "Hourly cost allocated to the namespace", unit: "USD/h",
+					points: []point{{attrs: []attribute{{key: "clustercost.environment", value: ns.Environment}}, value: ns.HourlyCost}},
+				},
+				gaugeOf("clustercost.namespace.pod.count", "Pods in the namespace", "{pod}", float64(ns.PodCount)),
+				gaugeOf("clustercost.namespace.cpu.request", "CPU requested by the namespace", "{cpu}", float64(ns.CPURequestMilli)/1000),
+				gaugeOf("clustercost.namespace.cpu.usage", "CPU used by the namespace", "{cpu}", float64(ns.CPUUsageMilli)/1000),
+				gaugeOf("clustercost.namespace.memory.request", "Memory requested by the namespace", "By", float64(ns.MemoryRequestBytes)),
+				gaugeOf("clustercost.namespace.memory.usage", "Memory used by the namespace", "By", float64(ns.MemoryUsageBytes)),
+				{
+					name: "clustercost.namespace.network.io", description: "Bytes sent and received since the exporter started", unit: "By", cumulative: true,
+					points: []point{
+						{attrs: []attribute{{key: "network.io.direction", value: "transmit"}}, value: e.totals[sumKey{namespace: ns.Namespace, metric: "io", direction: "transmit"}]},
+						{attrs: []attribute{{key: "network.io.direction", value: "receive"}}, value: e.totals[sumKey{namespace: ns.Namespace, metric: "io", direction: "receive"}]},
+					},
+				},
+				{
+					name: "clustercost.namespace.network.egress.cost", description: "Network egress cost since the exporter started", unit: "USD", cumulative: true,
+					points: []point{{value: e.totals[sumKey{namespace: ns.Namespace, metric: "egress"}]}},
+				},
+			},
+		})
+	}
+
+	allocated := make(map[string]float64, len(snap.Nodes))
+	for _, pod := range snap.Pods {
+		allocated[pod.Node] += pod.HourlyCost
+	}
+	for _, node := range snap.Nodes {
+		attrs := []attribute{cluster, {key: "k8s.node.name", value: node.NodeName}}
+		if node.InstanceType != "" {
+			attrs = append(attrs, attribute{key: "host.type", value: node.InstanceType})
+		}
+		out = append(out, resourceMetrics{
+			attrs: attrs,
+			metrics: []metric{
+				gaugeOf("clustercost.node.cost.hourly", "Hourly cost allocated to pods on the node", "USD/h", allocated[node.NodeName]),
+				gaugeOf("clustercost.node.price.hourly", "Hourly list price of the node", "USD/h", node.HourlyCost),
+				gaugeOf("clustercost.node.pod.count", "Pods on the node", "{pod}", float64(node.PodCount)),
+				gaugeOf("clustercost.node.cpu.utilization", "CPU usage as a fraction of allocatable", "1", node.CPUUsagePercent/100),
+				gaugeOf("clustercost.node.memory.utilization", "Memory usage as a fraction of allocatable", "1", node.MemoryUsagePercent/100),
+			},
+		})
+	}
+	if e.instance != "" {
+		for i := range out {
+			out[i].attrs = append(out[i].attrs, attribute{key: "service.instance.id", value: e.instance})
+		}
+	}
+	return out
+}
+
+// classSum collects the cluster-wide cumulative series of kind across
+// traffic classes, sorted for stable output.
+func (e *Exporter) classSum(name, description, unit, kind string) metric {
+	m := metric{name: name, description: description, unit: unit, cumulative: true}
+	keys := make([]sumKey, 0)
+	for key := range e.totals {
+		if key.namespace == "" && key.metric == kind {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].class != keys[j].class {
+			return keys[i].class < keys[j].class
+		}
+		return keys[i].direction < keys[j].direction
+	})
+	for _, key := range keys {
+		attrs := []attribute{{key: "clustercost.network.class", value: key.class}}
+		if key.direction != "" {
+			attrs = append(attrs, attribute{key: "network.io.direction", value: key.direction})
+		}
+		m.points = append(m.points, point{attrs: attrs, value: e.totals[key]})
+	}
+	return m
+}
+
+func gaugeOf(name, description, unit string, value float64) metric {
+	return metric{name: name, description: description, unit: unit, points: []point{{value: value}}}
+}
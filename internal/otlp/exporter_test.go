@@ -0,0 +1,270 @@
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestExporterHTTP(t *testing.T) {
+	receiver := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		receiver.record(t, r.Header.Get("X-Tenant"), readAll(t, r.Body))
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer server.Close()
+
+	exporter := newTestExporter(t, config.OTLPConfig{Endpoint: server.URL, Protocol: config.OTLPProtocolHTTP})
+	exportTwice(t, exporter)
+	receiver.check(t)
+}
+
+func TestExporterGRPC(t *testing.T) {
+	receiver := &receiver{}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != grpcExportPath || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("unexpected request %s %s %s", r.Proto, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		frame := readAll(t, r.Body)
+		size := binary.BigEndian.Uint32(frame[1:5])
+		receiver.record(t, r.Header.Get("X-Tenant"), frame[5:5+size])
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	exporter := newTestExporter(t, config.OTLPConfig{Endpoint: server.URL, Protocol: config.OTLPProtocolGRPC})
+	exportTwice(t, exporter)
+	receiver.check(t)
+}
+
+func TestExporterReportsGRPCStatus(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "16")
+		w.Header().Set("Grpc-Message", "unauthenticated")
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	exporter := newTestExporter(t, config.OTLPConfig{Endpoint: server.URL, Protocol: config.OTLPProtocolGRPC})
+	exporter.Observe(testSnapshot(time.Unix(1700000000, 0)))
+	if err := exporter.Export(context.Background()); err == nil {
+		t.Fatalf("expected grpc status error")
+	}
+}
+
+func TestExporterIdentifiesInstance(t *testing.T) {
+	exporter := newTestExporter(t, config.OTLPConfig{Endpoint: "http://localhost:4318"})
+	exporter.SetInstance("node-a")
+	exporter.Observe(testSnapshot(time.Unix(1700000000, 0)))
+	for _, res := range exporter.resources() {
+		found := false
+		for _, attr := range res.attrs {
+			found = found || (attr.key == "service.instance.id" && attr.value == "node-a")
+		}
+		if !found {
+			t.Errorf("resource %v lacks service.instance.id", res.attrs)
+		}
+	}
+}
+
+func newTestExporter(t *testing.T, cfg config.OTLPConfig) *Exporter {
+	t.Helper()
+	cfg.Headers = map[string]string{"X-Tenant": "team-a"}
+	cfg.Timeout = 5 * time.Second
+	exporter, err := NewExporter(cfg, "prod", "v1.2.3", snapshot.NewStore(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	return exporter
+}
+
+// exportTwice observes two snapshots so cumulative sums must add up, and
+// replays the second to check it is not counted again.
+func exportTwice(t *testing.T, exporter *Exporter) {
+	t.Helper()
+	if err := exporter.Export(context.Background()); err != nil {
+		t.Fatalf("export before first snapshot: %v", err)
+	}
+	first := testSnapshot(time.Unix(1700000000, 0))
+	exporter.Observe(first)
+	second := testSnapshot(time.Unix(1700000060, 0))
+	exporter.Observe(second)
+	exporter.Observe(second)
+	if err := exporter.Export(context.Background()); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+}
+
+func testSnapshot(ts time.Time) snapshot.Snapshot {
+	return snapshot.Snapshot{
+		Timestamp: ts,
+		Namespaces: []snapshot.NamespaceCostRecord{{
+			Namespace: "payments", HourlyCost: 0.25, PodCount: 2, CPUUsageMilli: 500,
+			NetworkTxBytes: 100, NetworkRxBytes: 40, NetworkEgressCost: 0.01, Environment: "production",
+		}},
+		Nodes:     []snapshot.NodeCostRecord{{NodeName: "node-a", HourlyCost: 0.5, InstanceType: "m5.large", CPUUsagePercent: 50}},
+		Resources: snapshot.ResourceSnapshot{TotalNodeHourlyCost: 0.5},
+		Network: snapshot.NetworkSnapshot{
+			ByClass: []snapshot.NetworkClassTotals{{Class: "internet_egress", TxBytes: 100, RxBytes: 40, EgressCostHourly: 0.01}},
+		},
+	}
+}
+
+// receiver is a minimal OTLP metrics stand-in. It flattens each request
+// into values keyed by resource name, metric name, and point attribute
+// values.
+type receiver struct {
+	mu       sync.Mutex
+	requests int
+	header   string
+	series   map[string]float64
+	sums     map[string]bool
+}
+
+func (r *receiver) record(t *testing.T, header string, body []byte) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	r.header = header
+	r.series = map[string]float64{}
+	r.sums = map[string]bool{}
+	walk(body, func(_ protowire.Number, _ protowire.Type, rm []byte, _ uint64) {
+		var resource string
+		walk(rm, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+			switch num {
+			case fieldResourceMetricsResource:
+				walk(v, func(_ protowire.Number, _ protowire.Type, kv []byte, _ uint64) {
+					key, value := decodeAttribute(kv)
+					if key != "k8s.cluster.name" && key != "host.type" {
+						resource = value
+					} else if resource == "" {
+						resource = value
+					}
+				})
+			case fieldResourceMetricsScope:
+				walk(v, func(num protowire.Number, _ protowire.Type, m []byte, _ uint64) {
+					if num == fieldScopeMetricsMetrics {
+						r.recordMetric(resource, m)
+					}
+				})
+			}
+		})
+	})
+}
+
+func (r *receiver) recordMetric(resource string, m []byte) {
+	var name string
+	walk(m, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+		switch num {
+		case fieldMetricName:
+			name = string(v)
+		case fieldMetricGauge, fieldMetricSum:
+			walk(v, func(field protowire.Number, _ protowire.Type, dp []byte, n uint64) {
+				switch {
+				case field == fieldSumDataPoints:
+					key, value := resource+" "+name, 0.0
+					walk(dp, func(num protowire.Number, _ protowire.Type, attr []byte, bits uint64) {
+						switch num {
+						case fieldPointAsDouble:
+							value = math.Float64frombits(bits)
+						case fieldPointAttributes:
+							_, v := decodeAttribute(attr)
+							key += " " + v
+						}
+					})
+					r.series[key] = value
+				case num == fieldMetricSum && field == fieldSumTemporality:
+					r.sums[name] = n == aggregationCumulative
+				}
+			})
+		}
+	})
+}
+
+func (r *receiver) check(t *testing.T) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.requests != 1 {
+		t.Fatalf("expected 1 export request, got %d", r.requests)
+	}
+	if r.header != "team-a" {
+		t.Errorf("custom header not sent, got %q", r.header)
+	}
+	want := map[string]float64{
+		"prod clustercost.cluster.cost.hourly":                         0.5,
+		"prod clustercost.cluster.network.io internet_egress transmit": 200,
+		"payments clustercost.namespace.cost.hourly production":        0.25,
+		"payments clustercost.namespace.cpu.usage":                     0.5,
+		"payments clustercost.namespace.network.io transmit":           200,
+		"payments clustercost.namespace.network.io receive":            80,
+		"payments clustercost.namespace.network.egress.cost":           0.02,
+		"node-a clustercost.node.price.hourly":                         0.5,
+		"node-a clustercost.node.cpu.utilization":                      0.5,
+	}
+	for key, value := range want {
+		got, ok := r.series[key]
+		if !ok {
+			t.Errorf("missing series %q in %v", key, r.series)
+			continue
+		}
+		if math.Abs(got-value) > 1e-9 {
+			t.Errorf("%s = %v, want %v", key, got, value)
+		}
+	}
+	if !r.sums["clustercost.namespace.network.io"] {
+		t.Errorf("namespace network io is not a cumulative sum")
+	}
+}
+
+func decodeAttribute(kv []byte) (string, string) {
+	var key, value string
+	walk(kv, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+		switch num {
+		case fieldKeyValueKey:
+			key = string(v)
+		case fieldKeyValueValue:
+			walk(v, func(num protowire.Number, _ protowire.Type, s []byte, _ uint64) {
+				if num == fieldAnyValueStringValue {
+					value = string(s)
+				}
+			})
+		}
+	})
+	return key, value
+}
+
+func readAll(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,161 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	httpMetricsPath = "/v1/metrics"
+	grpcExportPath  = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+	maxResponseSize = 1 << 20
+)
+
+// transport delivers an encoded ExportMetricsServiceRequest and returns the
+// encoded response.
+type transport interface {
+	export(ctx context.Context, body []byte) ([]byte, error)
+}
+
+// httpTransport implements OTLP/HTTP with binary protobuf payloads.
+type httpTransport struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+}
+
+func newHTTPTransport(endpoint string, headers map[string]string, timeout time.Duration) (*httpTransport, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp http endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = httpMetricsPath
+	}
+	return &httpTransport{
+		client:   &http.Client{Timeout: timeout},
+		endpoint: u.String(),
+		headers:  headers,
+	}, nil
+}
+
+func (t *httpTransport) export(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send metrics: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("otlp endpoint returned status %d", resp.StatusCode)
+	}
+	return payload, nil
+}
+
+// grpcTransport makes the single unary Export call over HTTP/2. An http://
+// endpoint uses plaintext HTTP/2 (h2c), which is how collectors usually
+// listen on 4317 inside a cluster.
+type grpcTransport struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+}
+
+func newGRPCTransport(endpoint string, headers map[string]string, timeout time.Duration) (*grpcTransport, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp grpc endpoint %q", endpoint)
+	}
+	u.Path = grpcExportPath
+
+	protocols := new(http.Protocols)
+	transport := &http.Transport{
+		Protocols:       protocols,
+		TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	if u.Scheme == "http" {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP2(true)
+	}
+	return &grpcTransport{
+		client:   &http.Client{Transport: transport, Timeout: timeout},
+		endpoint: u.String(),
+		headers:  headers,
+	}, nil
+}
+
+func (t *grpcTransport) export(ctx context.Context, body []byte) ([]byte, error) {
+	frame := make([]byte, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(body)))
+	copy(frame[5:], body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	for k, v := range t.headers {
+		req.Header.Set(strings.ToLower(k), v)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send metrics: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("otlp endpoint returned status %d", resp.StatusCode)
+	}
+	// Trailers-only responses carry grpc-status in the headers.
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return nil, fmt.Errorf("otlp grpc status %s: %s", status, message)
+	}
+	if len(payload) < 5 {
+		return nil, nil
+	}
+	if payload[0] != 0 {
+		return nil, fmt.Errorf("compressed grpc responses are not supported")
+	}
+	size := binary.BigEndian.Uint32(payload[1:5])
+	if int(size) > len(payload)-5 {
+		return nil, fmt.Errorf("truncated grpc response")
+	}
+	return payload[5 : 5+size], nil
+}
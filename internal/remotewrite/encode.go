@@ -0,0 +1,67 @@
+package remotewrite
+
+import (
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers from prometheus/prompb remote.proto and types.proto.
+const (
+	fieldWriteRequestTimeseries = 1
+	fieldTimeSeriesLabels       = 1
+	fieldTimeSeriesSamples      = 2
+	fieldLabelName              = 1
+	fieldLabelValue             = 2
+	fieldSampleValue            = 1
+	fieldSampleTimestamp        = 2
+)
+
+// label is one name/value pair of a series, including __name__.
+type label struct {
+	name  string
+	value string
+}
+
+// series is a single sample with its full label set.
+type series struct {
+	labels      []label
+	value       float64
+	timestampMs int64
+}
+
+// encodeWriteRequest builds a prompb.WriteRequest. Labels are sorted by
+// name as receivers require. Encoded requests can be concatenated to merge
+// their time series, which is how the queue batches snapshots.
+func encodeWriteRequest(all []series) []byte {
+	var out []byte
+	for _, s := range all {
+		sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = appendString(lb, fieldLabelName, l.name)
+			lb = appendString(lb, fieldLabelValue, l.value)
+			ts = appendMessage(ts, fieldTimeSeriesLabels, lb)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, fieldSampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, fieldSampleTimestamp, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestampMs))
+		ts = appendMessage(ts, fieldTimeSeriesSamples, sample)
+		out = appendMessage(out, fieldWriteRequestTimeseries, ts)
+	}
+	return out
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
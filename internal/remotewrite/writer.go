@@ -0,0 +1,342 @@
+// Package remotewrite pushes the agent's cost series to a Prometheus
+// remote_write endpoint for clusters where nothing scrapes /metrics.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/exporter"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const spoolSuffix = ".pb"
+
+// Writer turns every snapshot into samples stamped with Snapshot.Timestamp,
+// spools them on disk, and sends them in order. Receivers reject samples
+// older than ones they already hold, so a failed batch blocks later ones
+// until it succeeds or is moved to failed/.
+type Writer struct {
+	dir        string
+	failDir    string
+	url        string
+	authToken  string
+	headers    map[string]string
+	external   []label
+	client     *http.Client
+	maxBatch   int
+	maxRetries int
+	backoff    time.Duration
+	flushEvery time.Duration
+	collector  *exporter.SnapshotCollector
+	store      *snapshot.Store
+	logger     *slog.Logger
+
+	// Retry state, owned by the flush loop.
+	failures    int
+	nextAttempt time.Time
+}
+
+// NewWriter returns a Writer that renders snapshots with collector.
+func NewWriter(cfg config.RemoteWriteConfig, collector *exporter.SnapshotCollector, store *snapshot.Store, logger *slog.Logger) *Writer {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxBatch := cfg.MaxBatch
+	if maxBatch <= 0 {
+		maxBatch = 10
+	}
+	backoff := cfg.Backoff
+	if backoff <= 0 {
+		backoff = 5 * time.Second
+	}
+	flushEvery := cfg.FlushEvery
+	if flushEvery <= 0 {
+		flushEvery = 5 * time.Second
+	}
+	external := make([]label, 0, len(cfg.ExternalLabels))
+	for name, value := range cfg.ExternalLabels {
+		external = append(external, label{name: name, value: value})
+	}
+	return &Writer{
+		dir:        cfg.QueueDir,
+		failDir:    filepath.Join(cfg.QueueDir, "failed"),
+		url:        cfg.URL,
+		authToken:  cfg.AuthToken,
+		headers:    cfg.Headers,
+		external:   external,
+		client:     &http.Client{Timeout: timeout},
+		maxBatch:   maxBatch,
+		maxRetries: cfg.MaxRetries,
+		backoff:    backoff,
+		flushEvery: flushEvery,
+		collector:  collector,
+		store:      store,
+		logger:     logger,
+	}
+}
+
+// Run spools every published snapshot and flushes the spool on an interval
+// until ctx is done.
+func (w *Writer) Run(ctx context.Context) {
+	sub := w.store.Subscribe()
+	defer sub.Close()
+	if snap, ok := w.store.Latest(); ok {
+		if err := w.Enqueue(snap); err != nil {
+			w.logger.Warn("remote_write enqueue failed", slog.String("error", err.Error()))
+		}
+	}
+	go func() {
+		for snap := range sub.C() {
+			if skipped := sub.Coalesced(); skipped > 0 {
+				w.logger.Warn("remote_write skipped snapshots", slog.Uint64("skipped", skipped))
+			}
+			if err := w.Enqueue(snap); err != nil {
+				w.logger.Warn("remote_write enqueue failed", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(w.flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.flushOnce(ctx)
+		}
+	}
+}
+
+// Enqueue renders snap and writes it to the spool directory.
+func (w *Writer) Enqueue(snap snapshot.Snapshot) error {
+	all, err := w.series(snap)
+	if err != nil {
+		return fmt.Errorf("render series: %w", err)
+	}
+	if err := os.MkdirAll(w.dir, 0o750); err != nil {
+		return fmt.Errorf("create queue dir: %w", err)
+	}
+	// Zero-padded timestamps keep lexical order equal to sample order.
+	name := fmt.Sprintf("%020d%s", snap.Timestamp.UnixNano(), spoolSuffix)
+	tmpPath := filepath.Join(w.dir, name+".tmp")
+	if err := os.WriteFile(tmpPath, encodeWriteRequest(all), 0o600); err != nil {
+		return fmt.Errorf("write queue file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(w.dir, name)); err != nil {
+		return fmt.Errorf("commit queue file: %w", err)
+	}
+	return nil
+}
+
+// series gathers the /metrics series for snap, adds the external labels a
+// series does not carry itself, and stamps them with the snapshot time.
+func (w *Writer) series(snap snapshot.Snapshot) ([]series, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(pinnedCollector{collector: w.collector, snap: snap}); err != nil {
+		return nil, err
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	ts := snap.Timestamp.UnixMilli()
+	var out []series
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make([]label, 0, len(m.GetLabel())+len(w.external)+1)
+			labels = append(labels, label{name: "__name__", value: mf.GetName()})
+			own := make(map[string]bool, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				labels = append(labels, label{name: lp.GetName(), value: lp.GetValue()})
+				own[lp.GetName()] = true
+			}
+			for _, l := range w.external {
+				if !own[l.name] {
+					labels = append(labels, l)
+				}
+			}
+			out = append(out, series{labels: labels, value: m.GetGauge().GetValue(), timestampMs: ts})
+		}
+	}
+	return out, nil
+}
+
+func (w *Writer) flushOnce(ctx context.Context) {
+	if time.Now().Before(w.nextAttempt) {
+		return
+	}
+	for {
+		files, err := w.pending()
+		if err != nil {
+			w.logger.Warn("read remote_write queue failed", slog.String("error", err.Error()))
+			return
+		}
+		if len(files) == 0 {
+			return
+		}
+		if len(files) > w.maxBatch {
+			files = files[:w.maxBatch]
+		}
+		if !w.sendFiles(ctx, files) {
+			return
+		}
+	}
+}
+
+// sendFiles sends one batch and reports whether the next batch may follow.
+func (w *Writer) sendFiles(ctx context.Context, files []string) bool {
+	var body []byte
+	for _, path := range files {
+		data, err := os.ReadFile(path) // #nosec G304 -- path is from queue dir entries
+		if err != nil {
+			w.logger.Warn("read remote_write file failed", slog.String("error", err.Error()))
+			w.moveToFailed([]string{path})
+			return true
+		}
+		body = append(body, data...)
+	}
+
+	err := w.send(ctx, body)
+	var status *statusError
+	switch {
+	case err == nil:
+		w.failures = 0
+		w.nextAttempt = time.Time{}
+		for _, path := range files {
+			if err := os.Remove(path); err != nil {
+				w.logger.Warn("remove remote_write file failed", slog.String("error", err.Error()))
+			}
+		}
+		return true
+	case errors.As(err, &status) && !status.retryable():
+		w.logger.Warn("remote_write rejected batch; moving to failed", slog.String("error", err.Error()))
+		w.moveToFailed(files)
+		return true
+	default:
+		w.failures++
+		if w.failures > w.maxRetries {
+			w.logger.Warn("remote_write batch exhausted retries; moving to failed", slog.String("error", err.Error()))
+			w.failures = 0
+			w.moveToFailed(files)
+			return true
+		}
+		w.nextAttempt = time.Now().Add(w.backoffDuration())
+		w.logger.Warn("remote_write send failed", slog.String("error", err.Error()), slog.Int("attempt", w.failures))
+		return false
+	}
+}
+
+func (w *Writer) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.authToken)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send remote_write: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{code: resp.StatusCode, message: strings.TrimSpace(string(msg))}
+}
+
+func (w *Writer) pending() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(w.dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (w *Writer) moveToFailed(paths []string) {
+	if err := os.MkdirAll(w.failDir, 0o750); err != nil {
+		return
+	}
+	for _, path := range paths {
+		if err := os.Rename(path, filepath.Join(w.failDir, filepath.Base(path))); err != nil {
+			w.logger.Warn("move remote_write file failed", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (w *Writer) backoffDuration() time.Duration {
+	shift := w.failures - 1
+	if shift > 6 {
+		shift = 6
+	}
+	return w.backoff * time.Duration(1<<shift)
+}
+
+// statusError is a non-2xx remote_write response. Per the remote_write
+// spec, 5xx and 429 may be retried and other 4xx responses must not be.
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("remote_write endpoint returned status %d", e.code)
+	}
+	return fmt.Sprintf("remote_write endpoint returned status %d: %s", e.code, e.message)
+}
+
+func (e *statusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
+
+// pinnedCollector renders one specific snapshot instead of the store's latest.
+type pinnedCollector struct {
+	collector *exporter.SnapshotCollector
+	snap      snapshot.Snapshot
+}
+
+func (p pinnedCollector) Describe(ch chan<- *prometheus.Desc) {
+	p.collector.Describe(ch)
+}
+
+func (p pinnedCollector) Collect(ch chan<- prometheus.Metric) {
+	p.collector.CollectSnapshot(p.snap, ch)
+}
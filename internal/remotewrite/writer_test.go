@@ -0,0 +1,237 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"clustercost-agent-k8s/internal/config"
+	"clustercost-agent-k8s/internal/exporter"
+	"clustercost-agent-k8s/internal/snapshot"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestWriterSendsSamplesAtSnapshotTime(t *testing.T) {
+	receiver := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		receiver.record(t, r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := newTestWriter(t, server.URL, nil)
+	first := testSnapshot(time.UnixMilli(1700000000123))
+	second := testSnapshot(time.UnixMilli(1700000060456))
+	second.Namespaces[0].HourlyCost = 0.75
+	for _, snap := range []snapshot.Snapshot{second, first} {
+		if err := writer.Enqueue(snap); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	writer.flushOnce(context.Background())
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if receiver.requests != 1 {
+		t.Fatalf("expected one batched request, got %d", receiver.requests)
+	}
+	got := receiver.samples[`clustercost_namespace_cost_hourly{namespace="payments"}`]
+	want := []sample{{value: 0.25, timestampMs: 1700000000123}, {value: 0.75, timestampMs: 1700000060456}}
+	if len(got) != len(want) {
+		t.Fatalf("namespace samples = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].timestampMs != want[i].timestampMs || math.Abs(got[i].value-want[i].value) > 1e-9 {
+			t.Errorf("sample %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if files, _ := writer.pending(); len(files) != 0 {
+		t.Errorf("queue not drained: %v", files)
+	}
+}
+
+func TestWriterAddsExternalLabels(t *testing.T) {
+	receiver := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receiver.record(t, r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer := newTestWriter(t, server.URL, map[string]string{"instance": "node-a", "namespace": "ignored"})
+	if err := writer.Enqueue(testSnapshot(time.UnixMilli(1700000000123))); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	writer.flushOnce(context.Background())
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if len(receiver.labels) == 0 {
+		t.Fatal("no series received")
+	}
+	for _, labels := range receiver.labels {
+		if labels["instance"] != "node-a" {
+			t.Errorf("series %v lacks the instance label", labels)
+		}
+		if labels["__name__"] == "clustercost_namespace_cost_hourly" && labels["namespace"] != "payments" {
+			t.Errorf("external label replaced the series' own: %v", labels)
+		}
+	}
+}
+
+func TestWriterRetriesThenFails(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		status = http.StatusServiceUnavailable
+		calls  int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	writer := newTestWriter(t, server.URL, nil)
+	if err := writer.Enqueue(testSnapshot(time.Unix(1700000000, 0))); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	writer.flushOnce(context.Background())
+	if files, _ := writer.pending(); len(files) != 1 {
+		t.Fatalf("retryable failure should keep the batch spooled, got %v", files)
+	}
+	writer.flushOnce(context.Background())
+	if calls != 1 {
+		t.Fatalf("flush during backoff should not send, got %d calls", calls)
+	}
+
+	mu.Lock()
+	status = http.StatusBadRequest
+	mu.Unlock()
+	writer.nextAttempt = time.Time{}
+	writer.flushOnce(context.Background())
+	if files, _ := writer.pending(); len(files) != 0 {
+		t.Fatalf("rejected batch should leave the queue, got %v", files)
+	}
+	failed, err := os.ReadDir(filepath.Join(writer.dir, "failed"))
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("expected one failed file, got %v (%v)", failed, err)
+	}
+}
+
+func newTestWriter(t *testing.T, url string, external map[string]string) *Writer {
+	t.Helper()
+	store := snapshot.NewStore()
+	cfg := config.RemoteWriteConfig{
+		URL:            url,
+		AuthToken:      "secret",
+		QueueDir:       t.TempDir(),
+		MaxRetries:     3,
+		Backoff:        time.Hour,
+		ExternalLabels: external,
+	}
+	collector := exporter.NewSnapshotCollector("prod", store, exporter.SnapshotCollectorConfig{})
+	return NewWriter(cfg, collector, store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func testSnapshot(ts time.Time) snapshot.Snapshot {
+	return snapshot.Snapshot{
+		Timestamp: ts,
+		Namespaces: []snapshot.NamespaceCostRecord{{
+			Namespace: "payments", HourlyCost: 0.25, PodCount: 2, Environment: "production",
+		}},
+		Nodes:     []snapshot.NodeCostRecord{{NodeName: "node-a", HourlyCost: 0.5}},
+		Resources: snapshot.ResourceSnapshot{TotalNodeHourlyCost: 0.5},
+	}
+}
+
+type sample struct {
+	value       float64
+	timestampMs int64
+}
+
+// receiver is a minimal remote_write stand-in that keys samples by metric
+// name plus the namespace label, and keeps every series' full label set.
+type receiver struct {
+	mu       sync.Mutex
+	requests int
+	samples  map[string][]sample
+	labels   []map[string]string
+}
+
+func (r *receiver) record(t *testing.T, req *http.Request) {
+	t.Helper()
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatalf("snappy decode: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if r.samples == nil {
+		r.samples = map[string][]sample{}
+	}
+	for _, ts := range fields(t, body, fieldWriteRequestTimeseries) {
+		labels := map[string]string{}
+		for _, l := range fields(t, ts, fieldTimeSeriesLabels) {
+			labels[string(fields(t, l, fieldLabelName)[0])] = string(fields(t, l, fieldLabelValue)[0])
+		}
+		r.labels = append(r.labels, labels)
+		key := labels["__name__"]
+		if ns, ok := labels["namespace"]; ok {
+			key += `{namespace="` + ns + `"}`
+		}
+		for _, s := range fields(t, ts, fieldTimeSeriesSamples) {
+			value, _ := protowire.ConsumeFixed64(fields(t, s, fieldSampleValue)[0])
+			stamp, _ := protowire.ConsumeVarint(fields(t, s, fieldSampleTimestamp)[0])
+			r.samples[key] = append(r.samples[key], sample{value: math.Float64frombits(value), timestampMs: int64(stamp)})
+		}
+	}
+}
+
+// fields returns the raw values of every occurrence of num in msg. Bytes
+// fields are returned without their length prefix; scalar fields are
+// returned still encoded.
+func fields(t *testing.T, msg []byte, num protowire.Number) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for len(msg) > 0 {
+		n, typ, tagLen := protowire.ConsumeTag(msg)
+		if tagLen < 0 {
+			t.Fatalf("bad tag")
+		}
+		msg = msg[tagLen:]
+		valueLen := protowire.ConsumeFieldValue(n, typ, msg)
+		if valueLen < 0 {
+			t.Fatalf("bad field %d", n)
+		}
+		if n == num {
+			value := msg[:valueLen]
+			if typ == protowire.BytesType {
+				value, _ = protowire.ConsumeBytes(value)
+			}
+			out = append(out, value)
+		}
+		msg = msg[valueLen:]
+	}
+	return out
+}
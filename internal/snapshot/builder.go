@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"net/netip"
 	"sort"
+	"strings"
 	"time"
 
 	"clustercost-agent-k8s/internal/collector"
+	"clustercost-agent-k8s/internal/enricher"
 	"clustercost-agent-k8s/internal/kube"
 	"clustercost-agent-k8s/internal/network"
 
@@ -21,6 +23,17 @@ type Builder struct {
 	classifier *EnvironmentClassifier
 	prices     *NodePriceLookup
 	netPrices  *NetworkPriceLookup
+	overrides  func() Overrides
+	defaults   enricher.Defaults
+	enricher   *enricher.LabelEnricher
+}
+
+// Overrides replace parts of the builder configuration from one build to
+// the next. Nil fields keep the configured value.
+type Overrides struct {
+	Prices     *NodePriceLookup
+	NetPrices  *NetworkPriceLookup
+	SharedCost []SharedCostPolicy
 }
 
 // NewBuilder returns a configured Builder.
@@ -33,20 +46,78 @@ func NewBuilder(clusterID string, classifier *EnvironmentClassifier, prices *Nod
 		classifier: classifier,
 		prices:     prices,
 		netPrices:  netPrices,
+		enricher:   enricher.NewLabelEnricher(),
 	}
 }
 
+// Prices returns the configured node and network price lookups.
+func (b *Builder) Prices() (*NodePriceLookup, *NetworkPriceLookup) {
+	return b.prices, b.netPrices
+}
+
+// SetOverrides installs a source of overrides that is consulted at the start
+// of every Build.
+func (b *Builder) SetOverrides(source func() Overrides) {
+	b.overrides = source
+}
+
+// SetAttributionDefaults sets the team, cost center and shared weight used
+// when no annotation declares them.
+func (b *Builder) SetAttributionDefaults(defaults enricher.Defaults) {
+	b.defaults = defaults
+}
+
+// SetEnricher replaces the enricher that resolves allocation dimensions.
+func (b *Builder) SetEnricher(e *enricher.LabelEnricher) {
+	b.enricher = e
+}
+
+// attribute resolves ownership annotations over the defaults, with env as
+// the default environment. Annotated environments go through the
+// classifier so that values such as "prod" map like the label would.
+func (b *Builder) attribute(env string, sources ...enricher.Source) enricher.Attribution {
+	defaults := b.defaults
+	defaults.Environment = env
+	a := enricher.Resolve(defaults, sources...)
+	if a.Environment.Source != enricher.SourceDefault {
+		a.Environment.Value = b.classifier.ClassifyValue(a.Environment.Value)
+	}
+	return a
+}
+
 // Build assembles a snapshot using the cached kubernetes objects and usage metrics.
-func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, pods []*corev1.Pod, services []*corev1.Service, endpoints []*discoveryv1.EndpointSlice, usage map[string]kube.PodUsage, networkCollection collector.NetworkCollection, generatedAt time.Time) Snapshot {
+func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, pods []*corev1.Pod, services []*corev1.Service, endpoints []*discoveryv1.EndpointSlice, workloads []kube.Workload, claims []*corev1.PersistentVolumeClaim, usage map[string]kube.PodUsage, networkCollection collector.NetworkCollection, generatedAt time.Time) Snapshot {
+	prices, netPrices := b.prices, b.netPrices
+	var sharedCost []SharedCostPolicy
+	if b.overrides != nil {
+		o := b.overrides()
+		if o.Prices != nil {
+			prices = o.Prices
+		}
+		if o.NetPrices != nil {
+			netPrices = o.NetPrices
+		}
+		sharedCost = o.SharedCost
+	}
+
 	nsRecords := make(map[string]*NamespaceCostRecord, len(namespaces))
+	nsObjects := make(map[string]enricher.Object, len(namespaces))
 	for _, ns := range namespaces {
+		attribution := b.attribute(b.classifier.Match(ns.Name, ns.Labels, ns.Annotations).Environment, enricher.Source{Kind: enricher.SourceNamespace, Annotations: ns.Annotations})
+		nsObjects[ns.Name] = enricher.Object{Labels: ns.Labels, Annotations: ns.Annotations}
 		nsRecords[ns.Name] = &NamespaceCostRecord{
 			ClusterID:   b.clusterID,
 			Namespace:   ns.Name,
 			Labels:      cloneStringMap(ns.Labels),
-			Environment: b.classifier.Classify(ns.Name, ns.Labels),
+			Environment: attribution.Environment.Value,
+			Attribution: attribution,
+			Dimensions:  b.enricher.Resolve(nsObjects[ns.Name]),
 		}
 	}
+	workloadObjects := make(map[string]enricher.Object, len(workloads))
+	for _, w := range workloads {
+		workloadObjects[w.Namespace+"/"+w.Kind+"/"+w.Name] = enricher.Object{Labels: w.Labels, Annotations: w.Annotations}
+	}
 
 	nodeRecords := make(map[string]*nodeAggregate, len(nodes))
 	var totalNodeCost float64
@@ -56,13 +127,14 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 			NodeName:               node.Name,
 			CPUAllocatableMilli:    node.Status.Allocatable.Cpu().MilliValue(),
 			MemoryAllocatableBytes: node.Status.Allocatable.Memory().Value(),
+			GPUAllocatable:         countGPUs(node.Status.Allocatable),
 			Labels:                 cloneStringMap(node.Labels),
 			Taints:                 formatTaints(node.Spec.Taints),
 			InstanceType:           detectInstanceType(node.Labels),
 			Status:                 nodeStatus(node.Status.Conditions),
 			IsUnderPressure:        nodeUnderPressure(node.Status.Conditions),
 		}
-		rec.HourlyCost = b.prices.Price(rec.InstanceType)
+		rec.HourlyCost = prices.NodePrice(rec.InstanceType, node.Labels)
 		totalNodeCost += rec.HourlyCost
 		nodeRecords[node.Name] = &nodeAggregate{record: rec}
 	}
@@ -133,12 +205,14 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 	}
 
 	networkUsage := networkCollection.PodUsage
+	podsOut := make([]PodCostRecord, 0, len(pods))
+	claimShares := storageShares(claims, pods)
 
 	for _, pod := range pods {
 		if skipPod(pod) {
 			continue
 		}
-		ns := ensureNamespace(nsRecords, b.clusterID, pod.Namespace, b.classifier)
+		ns := b.ensureNamespace(nsRecords, pod.Namespace)
 		ns.PodCount++
 
 		cpuReq, memReq := sumPodRequests(pod)
@@ -163,6 +237,32 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 		clusterCPUUsage += cpuUsage
 		clusterMemUsage += memUsage
 
+		podRecord := PodCostRecord{
+			Namespace:          pod.Namespace,
+			Pod:                pod.Name,
+			Node:               pod.Spec.NodeName,
+			CPURequestMilli:    cpuReq,
+			MemoryRequestBytes: memReq,
+			CPUUsageMilli:      cpuUsage,
+			MemoryUsageBytes:   memUsage,
+			Labels:             cloneStringMap(pod.Labels),
+			Annotations:        podAnnotations(pod.Annotations),
+			GPURequest:         podGPURequest(pod),
+		}
+		for _, claim := range podClaims(pod) {
+			podRecord.StorageRequestBytes += claimShares[pod.Namespace+"/"+claim]
+		}
+		podRecord.ControllerKind, podRecord.ControllerName = podController(pod)
+		nsObject := nsObjects[pod.Namespace]
+		workloadObject := workloadObjects[pod.Namespace+"/"+podRecord.ControllerKind+"/"+podRecord.ControllerName]
+		podObject := enricher.Object{Labels: pod.Labels, Annotations: pod.Annotations}
+		podRecord.Attribution = b.attribute(ns.Attribution.Environment.Value,
+			enricher.Source{Kind: enricher.SourceNamespace, Annotations: nsObject.Annotations},
+			enricher.Source{Kind: enricher.SourceWorkload, Annotations: workloadObject.Annotations},
+			enricher.Source{Kind: enricher.SourcePod, Annotations: podObject.Annotations},
+		)
+		podRecord.Dimensions = b.enricher.Resolve(nsObject, workloadObject, podObject)
+
 		if nodeAgg, ok := nodeRecords[pod.Spec.NodeName]; ok {
 			nodeAgg.podCount++
 			nodeAgg.cpuUsageMilli += cpuUsage
@@ -174,10 +274,18 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 				if share > 1 {
 					share = 1
 				}
-				ns.HourlyCost += share * nodeAgg.record.HourlyCost
+				podRecord.HourlyCost = share * nodeAgg.record.HourlyCost
+				ns.HourlyCost += podRecord.HourlyCost
 			}
 		}
+		podsOut = append(podsOut, podRecord)
 	}
+	sort.Slice(podsOut, func(i, j int) bool {
+		if podsOut[i].Namespace == podsOut[j].Namespace {
+			return podsOut[i].Pod < podsOut[j].Pod
+		}
+		return podsOut[i].Namespace < podsOut[j].Namespace
+	})
 
 	nodesOut := make([]NodeCostRecord, 0, len(nodeRecords))
 	for _, agg := range nodeRecords {
@@ -208,7 +316,7 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 		srcInfo := podInfoByIP[flow.SrcIP]
 		dstPod := podByIP[flow.DstIP]
 		class := network.ClassifyEgress(srcInfo, flow.DstIP, podInfoByIP)
-		cost := b.netPrices.EgressCost(class, flow.TxBytes)
+		cost := netPrices.EgressCost(class, flow.TxBytes)
 
 		srcPodEndpoint := NetworkEndpoint{Kind: "pod", Namespace: srcPod.Namespace, Name: srcPod.Name}
 		srcNsEndpoint := NetworkEndpoint{Kind: "namespace", Name: srcPod.Namespace}
@@ -282,7 +390,7 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 		podNetTotals := map[string]*NetworkClassTotals{}
 		var podNetCost float64
 		for class, txBytes := range netUsage.TxBytesByClass {
-			cost := b.netPrices.EgressCost(class, txBytes)
+			cost := netPrices.EgressCost(class, txBytes)
 			podNetCost += cost
 			accumulateNetworkTotals(podNetTotals, class, txBytes, 0, cost)
 			accumulateNetworkTotals(networkByClass, class, txBytes, 0, cost)
@@ -320,6 +428,7 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 	sort.Slice(namespacesOut, func(i, j int) bool {
 		return namespacesOut[i].Namespace < namespacesOut[j].Namespace
 	})
+	applySharedCosts(namespacesOut, sharedCost)
 
 	namespacesNetwork := make([]NamespaceNetworkRecord, 0, len(networkByNamespace))
 	for _, record := range networkByNamespace {
@@ -338,9 +447,11 @@ func (b *Builder) Build(nodes []*corev1.Node, namespaces []*corev1.Namespace, po
 	})
 
 	return Snapshot{
-		Timestamp:  generatedAt,
-		Namespaces: namespacesOut,
-		Nodes:      nodesOut,
+		SchemaVersion: SchemaVersion,
+		Timestamp:     generatedAt,
+		Namespaces:    namespacesOut,
+		Pods:          podsOut,
+		Nodes:         nodesOut,
 		Resources: ResourceSnapshot{
 			ClusterID:               b.clusterID,
 			CPUUsageMilliTotal:      clusterCPUUsage,
@@ -375,16 +486,18 @@ type nodeAggregate struct {
 	memoryUsageBytes int64
 }
 
-func ensureNamespace(set map[string]*NamespaceCostRecord, clusterID, name string, classifier *EnvironmentClassifier) *NamespaceCostRecord {
+func (b *Builder) ensureNamespace(set map[string]*NamespaceCostRecord, name string) *NamespaceCostRecord {
 	if ns, ok := set[name]; ok {
 		return ns
 	}
-	labels := map[string]string{}
+	attribution := b.attribute(b.classifier.Classify(name, nil))
 	ns := &NamespaceCostRecord{
-		ClusterID:   clusterID,
+		ClusterID:   b.clusterID,
 		Namespace:   name,
-		Labels:      labels,
-		Environment: classifier.Classify(name, nil),
+		Labels:      map[string]string{},
+		Environment: attribution.Environment.Value,
+		Attribution: attribution,
+		Dimensions:  b.enricher.Resolve(),
 	}
 	set[name] = ns
 	return ns
@@ -592,6 +705,21 @@ func workloadEndpoint(pod *corev1.Pod) NetworkEndpoint {
 	}
 }
 
+// podController returns the pod's top-level owner. ReplicaSets created by a
+// Deployment are reported as the Deployment by trimming the template hash.
+func podController(pod *corev1.Pod) (kind, name string) {
+	ctrl := metav1.GetControllerOf(pod)
+	if ctrl == nil {
+		return "", ""
+	}
+	if ctrl.Kind == "ReplicaSet" {
+		if hash := pod.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(ctrl.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(ctrl.Name, "-"+hash)
+		}
+	}
+	return ctrl.Kind, ctrl.Name
+}
+
 func sumPodRequests(pod *corev1.Pod) (cpuMilli int64, memoryBytes int64) {
 	for _, c := range pod.Spec.Containers {
 		cpuMilli += c.Resources.Requests.Cpu().MilliValue()
@@ -608,6 +736,78 @@ func sumPodRequests(pod *corev1.Pod) (cpuMilli int64, memoryBytes int64) {
 	return
 }
 
+// countGPUs sums the extended resources named */gpu, such as nvidia.com/gpu.
+func countGPUs(resources corev1.ResourceList) int64 {
+	var total int64
+	for name, qty := range resources {
+		if strings.HasSuffix(string(name), "/gpu") {
+			total += qty.Value()
+		}
+	}
+	return total
+}
+
+// podGPURequest counts the GPUs of a pod. Extended resources may be set as
+// limits only, in which case the limit is the request.
+func podGPURequest(pod *corev1.Pod) int64 {
+	var total int64
+	for _, c := range pod.Spec.Containers {
+		if gpus := countGPUs(c.Resources.Requests); gpus > 0 {
+			total += gpus
+		} else {
+			total += countGPUs(c.Resources.Limits)
+		}
+	}
+	return total
+}
+
+func podClaims(pod *corev1.Pod) []string {
+	var out []string
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			out = append(out, v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return out
+}
+
+// storageShares returns the bytes each mounting pod is charged per claim,
+// keyed by namespace/claim. A claim is split evenly between the pods that
+// mount it.
+func storageShares(claims []*corev1.PersistentVolumeClaim, pods []*corev1.Pod) map[string]int64 {
+	mounts := map[string]int64{}
+	for _, pod := range pods {
+		if skipPod(pod) {
+			continue
+		}
+		for _, claim := range podClaims(pod) {
+			mounts[pod.Namespace+"/"+claim]++
+		}
+	}
+	out := make(map[string]int64, len(mounts))
+	for _, claim := range claims {
+		if claim == nil {
+			continue
+		}
+		key := claim.Namespace + "/" + claim.Name
+		if n := mounts[key]; n > 0 {
+			out[key] = claim.Spec.Resources.Requests.Storage().Value() / n
+		}
+	}
+	return out
+}
+
+// podAnnotations copies annotations without kubectl's
+// last-applied-configuration, which holds the whole manifest.
+func podAnnotations(src map[string]string) map[string]string {
+	out := cloneStringMap(src)
+	delete(out, corev1.LastAppliedConfigAnnotation)
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
 func skipPod(pod *corev1.Pod) bool {
 	if pod == nil {
 		return true
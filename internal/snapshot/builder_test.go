@@ -3,10 +3,12 @@ package snapshot
 import (
 	"math"
 	"net/netip"
+	"reflect"
 	"testing"
 	"time"
 
 	"clustercost-agent-k8s/internal/collector"
+	"clustercost-agent-k8s/internal/enricher"
 	"clustercost-agent-k8s/internal/kube"
 	"clustercost-agent-k8s/internal/network"
 
@@ -14,6 +16,7 @@ import (
 	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func TestBuilderAggregatesSnapshot(t *testing.T) {
@@ -174,6 +177,8 @@ func TestBuilderAggregatesSnapshot(t *testing.T) {
 		[]*corev1.Pod{podProd, podNonProd},
 		[]*corev1.Service{serviceAPI, serviceWorker},
 		[]*discoveryv1.EndpointSlice{endpointsAPI, endpointsWorker},
+		nil,
+		nil,
 		usage,
 		networkCollection,
 		time.Unix(123, 0),
@@ -216,6 +221,10 @@ func TestBuilderAggregatesSnapshot(t *testing.T) {
 		t.Fatalf("prod network totals unexpected: %+v", prodNS)
 	}
 
+	if len(snap.Pods) != 2 || snap.Pods[0].Pod != "api-0" || !almostEqual(snap.Pods[0].HourlyCost, 0.025) {
+		t.Fatalf("unexpected pod records: %+v", snap.Pods)
+	}
+
 	if len(snap.Nodes) != 1 {
 		t.Fatalf("expected single node record")
 	}
@@ -273,3 +282,267 @@ func hasServiceConnection(conns []NetworkConnection, srcNS, srcName, dstNS, dstN
 	}
 	return false
 }
+
+func TestPodControllerResolvesDeployment(t *testing.T) {
+	controller := true
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   "api-7d4b9c-x2x",
+		Labels: map[string]string{"pod-template-hash": "7d4b9c"},
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "ReplicaSet", Name: "api-7d4b9c", Controller: &controller},
+		},
+	}}
+	if kind, name := podController(pod); kind != "Deployment" || name != "api" {
+		t.Fatalf("got %s/%s, want Deployment/api", kind, name)
+	}
+
+	pod.OwnerReferences[0] = metav1.OwnerReference{Kind: "StatefulSet", Name: "db", Controller: &controller}
+	if kind, name := podController(pod); kind != "StatefulSet" || name != "db" {
+		t.Fatalf("got %s/%s, want StatefulSet/db", kind, name)
+	}
+}
+
+func TestBuilderAppliesOverrides(t *testing.T) {
+	classifier := NewEnvironmentClassifier(ClassifierConfig{})
+	builder := NewBuilder("cluster-1", classifier, NewNodePriceLookup(map[string]float64{"m6a.large": 0.1}, 0), nil)
+	spot, err := labels.Parse("karpenter.sh/capacity-type=spot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := labels.Parse("clustercost.io/shared=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.SetOverrides(func() Overrides {
+		return Overrides{
+			Prices: builder.prices.Merge(nil, []NodePriceRule{{Selector: spot, HourlyPrice: 0.04}}),
+			SharedCost: []SharedCostPolicy{{
+				Name:     "platform",
+				Selector: shared,
+				Split:    SplitProportional,
+			}},
+		}
+	})
+
+	node := func(name string, nodeLabels map[string]string) *corev1.Node {
+		nodeLabels["node.kubernetes.io/instance-type"] = "m6a.large"
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+			Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1000m"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			}},
+		}
+	}
+	pod := func(namespace, name, nodeName, cpu string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+				Containers: []corev1.Container{{
+					Name: "app",
+					Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse(cpu),
+					}},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ingress", Labels: map[string]string{"clustercost.io/shared": "true"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "checkout"}},
+	}
+	snap := builder.Build(
+		[]*corev1.Node{node("on-demand", map[string]string{}), node("spot", map[string]string{"karpenter.sh/capacity-type": "spot"})},
+		namespaces,
+		[]*corev1.Pod{
+			pod("ingress", "nginx", "on-demand", "200m"),
+			pod("payments", "api", "on-demand", "600m"),
+			pod("checkout", "web", "on-demand", "200m"),
+		},
+		nil, nil, nil, nil, nil, collector.NetworkCollection{}, time.Now(),
+	)
+
+	if got := snap.Resources.TotalNodeHourlyCost; math.Abs(got-0.14) > 1e-9 {
+		t.Fatalf("total node cost = %v, want 0.14 with the spot rule", got)
+	}
+	byName := map[string]NamespaceCostRecord{}
+	for _, ns := range snap.Namespaces {
+		byName[ns.Namespace] = ns
+	}
+	// Ingress costs $0.02/h and is split 3:1 between payments and checkout.
+	checks := map[string]struct{ hourly, shared, distributed float64 }{
+		"ingress":  {0, 0, 0.02},
+		"payments": {0.075, 0.015, 0},
+		"checkout": {0.025, 0.005, 0},
+	}
+	for name, want := range checks {
+		got := byName[name]
+		if math.Abs(got.HourlyCost-want.hourly) > 1e-9 || math.Abs(got.SharedCostHourly-want.shared) > 1e-9 || math.Abs(got.DistributedCostHourly-want.distributed) > 1e-9 {
+			t.Errorf("%s: hourly %v shared %v distributed %v, want %+v", name, got.HourlyCost, got.SharedCostHourly, got.DistributedCostHourly, want)
+		}
+	}
+	if sc := byName["payments"].SharedCosts; len(sc) != 1 || sc[0].Namespace != "ingress" || sc[0].Policy != "platform" {
+		t.Errorf("payments shared costs = %+v", sc)
+	}
+}
+
+func TestBuilderResolvesAttribution(t *testing.T) {
+	classifier := NewEnvironmentClassifier(ClassifierConfig{ProductionLabelValues: []string{"prod"}, NonProdLabelValues: []string{"staging"}})
+	builder := NewBuilder("cluster-1", classifier, NewNodePriceLookup(nil, 0.1), nil)
+	builder.SetAttributionDefaults(enricher.Defaults{Team: "unowned", CostCenter: "cc-0"})
+
+	namespaces := []*corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{
+		Name: "payments",
+		Annotations: map[string]string{
+			enricher.AnnotationTeam:         "payments",
+			enricher.AnnotationEnvironment:  "prod",
+			enricher.AnnotationSharedWeight: "3",
+		},
+	}}}
+	workloads := []kube.Workload{{
+		Kind: "Deployment", Namespace: "payments", Name: "api",
+		Annotations: map[string]string{enricher.AnnotationTeam: "checkout", enricher.AnnotationCostCenter: "cc-42"},
+	}}
+	pod := func(name string, annotations map[string]string) *corev1.Pod {
+		isController := true
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "payments", Annotations: annotations,
+				Labels:          map[string]string{"pod-template-hash": "abc"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api-abc", Controller: &isController}},
+			},
+			Spec:   corev1.PodSpec{NodeName: "node-1"},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	snap := builder.Build(nil, namespaces,
+		[]*corev1.Pod{
+			pod("api-1", nil),
+			pod("api-2", map[string]string{enricher.AnnotationTeam: "canary", enricher.AnnotationEnvironment: "staging"}),
+		},
+		nil, nil, workloads, nil, nil, collector.NetworkCollection{}, time.Now(),
+	)
+
+	ns := snap.Namespaces[0]
+	if ns.Environment != "production" || ns.Attribution.Environment.Source != enricher.SourceNamespace {
+		t.Errorf("namespace environment = %q from %q", ns.Environment, ns.Attribution.Environment.Source)
+	}
+	if ns.Attribution.Team != (enricher.Value{Value: "payments", Source: enricher.SourceNamespace}) ||
+		ns.Attribution.CostCenter != (enricher.Value{Value: "cc-0", Source: enricher.SourceDefault}) ||
+		ns.Attribution.Weight() != 3 {
+		t.Errorf("namespace attribution = %+v", ns.Attribution)
+	}
+
+	checks := map[string]enricher.Attribution{
+		"api-1": {
+			Team:         enricher.Value{Value: "checkout", Source: enricher.SourceWorkload},
+			CostCenter:   enricher.Value{Value: "cc-42", Source: enricher.SourceWorkload},
+			Environment:  enricher.Value{Value: "production", Source: enricher.SourceNamespace},
+			SharedWeight: enricher.Value{Value: "3", Source: enricher.SourceNamespace},
+		},
+		"api-2": {
+			Team:         enricher.Value{Value: "canary", Source: enricher.SourcePod},
+			CostCenter:   enricher.Value{Value: "cc-42", Source: enricher.SourceWorkload},
+			Environment:  enricher.Value{Value: "nonprod", Source: enricher.SourcePod},
+			SharedWeight: enricher.Value{Value: "3", Source: enricher.SourceNamespace},
+		},
+	}
+	if len(snap.Pods) != len(checks) {
+		t.Fatalf("expected %d pods, got %d", len(checks), len(snap.Pods))
+	}
+	for _, p := range snap.Pods {
+		if want := checks[p.Pod]; p.Attribution != want {
+			t.Errorf("%s attribution = %+v, want %+v", p.Pod, p.Attribution, want)
+		}
+	}
+}
+
+func TestBuilderResolvesDimensions(t *testing.T) {
+	builder := NewBuilder("cluster-1", NewEnvironmentClassifier(ClassifierConfig{}), NewNodePriceLookup(nil, 0.1), nil)
+	builder.SetEnricher(enricher.NewLabelEnricher(
+		enricher.Dimension{Name: "team", Keys: []string{"team", "owner"}, Lowercase: true, Default: "unowned"},
+		enricher.Dimension{Name: "service", Keys: []string{"app.kubernetes.io/name"}},
+	))
+
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"owner": "Payments"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "scratch"}},
+	}
+	pods := []*corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "payments", Labels: map[string]string{"app.kubernetes.io/name": "api"}},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}}
+	snap := builder.Build(nil, namespaces, pods, nil, nil, nil, nil, nil, collector.NetworkCollection{}, time.Now())
+
+	want := map[string]map[string]string{
+		"payments": {"team": "payments"},
+		"scratch":  {"team": "unowned"},
+	}
+	for _, ns := range snap.Namespaces {
+		if !reflect.DeepEqual(ns.Dimensions, want[ns.Namespace]) {
+			t.Errorf("%s dimensions = %v, want %v", ns.Namespace, ns.Dimensions, want[ns.Namespace])
+		}
+	}
+	if got := snap.Pods[0].Dimensions; !reflect.DeepEqual(got, map[string]string{"team": "payments", "service": "api"}) {
+		t.Errorf("pod dimensions = %v", got)
+	}
+}
+
+func TestBuilderCountsGPUsAndClaims(t *testing.T) {
+	builder := NewBuilder("cluster-1", NewEnvironmentClassifier(ClassifierConfig{}), NewNodePriceLookup(nil, 0.1), nil)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu-1"},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			corev1.ResourceCPU:                    resource.MustParse("8"),
+			corev1.ResourceName("nvidia.com/gpu"): resource.MustParse("4"),
+		}},
+	}
+	pod := func(name string, gpus string, claim string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ml", Annotations: map[string]string{
+				corev1.LastAppliedConfigAnnotation: "{}",
+			}},
+			Spec: corev1.PodSpec{
+				NodeName: "gpu-1",
+				Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceName("nvidia.com/gpu"): resource.MustParse(gpus)},
+				}}},
+				Volumes: []corev1.Volume{{VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+				}}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "ml"},
+		Spec: corev1.PersistentVolumeClaimSpec{Resources: corev1.VolumeResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+		}},
+	}
+	snap := builder.Build([]*corev1.Node{node}, nil,
+		[]*corev1.Pod{pod("train-0", "2", "data"), pod("train-1", "1", "data")},
+		nil, nil, nil, []*corev1.PersistentVolumeClaim{claim}, nil, collector.NetworkCollection{}, time.Now())
+
+	if got := snap.Nodes[0].GPUAllocatable; got != 4 {
+		t.Errorf("node gpus = %d, want 4", got)
+	}
+	if len(snap.Pods) != 2 {
+		t.Fatalf("expected 2 pods, got %d", len(snap.Pods))
+	}
+	for _, p := range snap.Pods {
+		if p.StorageRequestBytes != 50<<30 {
+			t.Errorf("%s storage = %d, want half the claim", p.Pod, p.StorageRequestBytes)
+		}
+		if p.Annotations != nil {
+			t.Errorf("%s annotations = %v, want last-applied-configuration dropped", p.Pod, p.Annotations)
+		}
+	}
+	if snap.Pods[0].GPURequest+snap.Pods[1].GPURequest != 3 {
+		t.Errorf("pod gpus = %d and %d, want 3 in total", snap.Pods[0].GPURequest, snap.Pods[1].GPURequest)
+	}
+}
@@ -1,9 +1,29 @@
 package snapshot
 
-import "strings"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"clustercost-agent-k8s/internal/enricher"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Names of the built-in classification steps reported by Match.
+const (
+	RuleAnnotation   = "annotation"
+	RuleLabel        = "label"
+	RuleNameContains = "name-contains"
+	RuleSystem       = "system-namespace"
+	RuleDefault      = "default"
+)
 
 // ClassifierConfig describes how to map namespaces into environments.
 type ClassifierConfig struct {
+	// Rules are tried in order before the label and name heuristics.
+	Rules                  []EnvironmentRule
 	LabelKeys              []string
 	ProductionLabelValues  []string
 	NonProdLabelValues     []string
@@ -12,8 +32,83 @@ type ClassifierConfig struct {
 	SystemNamespaces       []string
 }
 
-// EnvironmentClassifier applies the configured heuristics.
+// EnvironmentRule assigns Environment to namespaces that match every
+// condition it sets.
+type EnvironmentRule struct {
+	Name        string
+	Environment string
+	NameRegex   *regexp.Regexp
+	Selector    labels.Selector
+	// Annotations maps annotation keys to patterns their values must match.
+	Annotations map[string]*regexp.Regexp
+}
+
+// CompileEnvironmentRule builds a rule from its textual form. nameRegex and
+// annotation patterns must match the whole value; selector uses the
+// Kubernetes label selector syntax, such as "tier in (web,api),!legacy".
+func CompileEnvironmentRule(name, environment, nameRegex, selector string, annotations map[string]string) (EnvironmentRule, error) {
+	rule := EnvironmentRule{Name: name, Environment: strings.ToLower(strings.TrimSpace(environment))}
+	if rule.Environment == "" {
+		return EnvironmentRule{}, fmt.Errorf("environment rule %q has no environment", name)
+	}
+	var err error
+	if nameRegex != "" {
+		if rule.NameRegex, err = regexp.Compile("^(?:" + nameRegex + ")$"); err != nil {
+			return EnvironmentRule{}, fmt.Errorf("environment rule %q name regex: %w", name, err)
+		}
+	}
+	if selector != "" {
+		if rule.Selector, err = labels.Parse(selector); err != nil {
+			return EnvironmentRule{}, fmt.Errorf("environment rule %q selector: %w", name, err)
+		}
+	}
+	for key, pattern := range annotations {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return EnvironmentRule{}, fmt.Errorf("environment rule %q annotation %q: %w", name, key, err)
+		}
+		if rule.Annotations == nil {
+			rule.Annotations = map[string]*regexp.Regexp{}
+		}
+		rule.Annotations[key] = re
+	}
+	if rule.NameRegex == nil && rule.Selector == nil && len(rule.Annotations) == 0 {
+		return EnvironmentRule{}, fmt.Errorf("environment rule %q has no conditions", name)
+	}
+	return rule, nil
+}
+
+// Matches reports whether a namespace satisfies every condition of the rule.
+func (r EnvironmentRule) Matches(name string, nsLabels, annotations map[string]string) bool {
+	if r.NameRegex != nil && !r.NameRegex.MatchString(name) {
+		return false
+	}
+	if r.Selector != nil && !r.Selector.Matches(labels.Set(nsLabels)) {
+		return false
+	}
+	for key, re := range r.Annotations {
+		value, ok := annotations[key]
+		if !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match is the environment of a namespace and the rule that decided it.
+type Match struct {
+	Environment string `json:"environment"`
+	// Rule is the name of a configured rule, or one of RuleAnnotation,
+	// RuleLabel, RuleNameContains, RuleSystem and RuleDefault.
+	Rule string `json:"rule"`
+	// Detail is the annotation, label key or name fragment a built-in step
+	// matched on.
+	Detail string `json:"detail,omitempty"`
+}
+
+// EnvironmentClassifier applies the configured rules and heuristics.
 type EnvironmentClassifier struct {
+	rules                  []EnvironmentRule
 	labelKeys              []string
 	labelValueMap          map[string]string
 	productionNameContains []string
@@ -51,6 +146,7 @@ func NewEnvironmentClassifier(cfg ClassifierConfig) *EnvironmentClassifier {
 	}
 
 	return &EnvironmentClassifier{
+		rules:                  cfg.Rules,
 		labelKeys:              labelKeys,
 		labelValueMap:          labelValueMap,
 		productionNameContains: prodContains,
@@ -60,28 +156,66 @@ func NewEnvironmentClassifier(cfg ClassifierConfig) *EnvironmentClassifier {
 
 // Classify returns the best-effort environment assignment for the namespace.
 func (c *EnvironmentClassifier) Classify(name string, labels map[string]string) string {
-	if labels != nil {
-		for _, key := range c.labelKeys {
-			if key == "" {
-				continue
-			}
-			if value, ok := labels[key]; ok && value != "" {
-				if env, found := c.labelValueMap[strings.ToLower(value)]; found {
-					return env
-				}
-				return "unknown"
+	return c.Match(name, labels, nil).Environment
+}
+
+// Match classifies a namespace and reports which step decided. Configured
+// rules are tried in order, then the label keys, the production name
+// fragments and the system namespaces; anything left is nonprod. A label
+// whose value is not mapped is skipped rather than deciding the result.
+func (c *EnvironmentClassifier) Match(name string, labels, annotations map[string]string) Match {
+	for i, rule := range c.rules {
+		if rule.Matches(name, labels, annotations) {
+			ruleName := rule.Name
+			if ruleName == "" {
+				ruleName = fmt.Sprintf("rules[%d]", i)
 			}
+			return Match{Environment: rule.Environment, Rule: ruleName}
+		}
+	}
+	for _, key := range c.labelKeys {
+		value := labels[key]
+		if value == "" {
+			continue
+		}
+		if env, found := c.labelValueMap[strings.ToLower(value)]; found {
+			return Match{Environment: env, Rule: RuleLabel, Detail: key}
 		}
 	}
 
 	lowerName := strings.ToLower(name)
 	for _, needle := range c.productionNameContains {
 		if needle != "" && strings.Contains(lowerName, needle) {
-			return "production"
+			return Match{Environment: "production", Rule: RuleNameContains, Detail: needle}
 		}
 	}
 	if _, ok := c.systemNamespaces[lowerName]; ok {
-		return "system"
+		return Match{Environment: "system", Rule: RuleSystem}
+	}
+	return Match{Environment: "nonprod", Rule: RuleDefault}
+}
+
+// MatchNamespace classifies a namespace the way snapshots do: the
+// clustercost.io/environment annotation, when set, takes precedence over
+// every rule.
+func (c *EnvironmentClassifier) MatchNamespace(ns *corev1.Namespace) Match {
+	if value := strings.TrimSpace(ns.Annotations[enricher.AnnotationEnvironment]); value != "" {
+		return Match{Environment: c.ClassifyValue(value), Rule: RuleAnnotation, Detail: enricher.AnnotationEnvironment}
+	}
+	return c.Match(ns.Name, ns.Labels, ns.Annotations)
+}
+
+// ClassifyValue maps an explicit environment value, such as the
+// clustercost.io/environment annotation, the same way as a label value.
+// Values that are not mapped are kept, lowercased, so that any
+// environment name can be declared.
+func (c *EnvironmentClassifier) ClassifyValue(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if env, found := c.labelValueMap[value]; found {
+		return env
+	}
+	if value == "" {
+		return "unknown"
 	}
-	return "nonprod"
+	return value
 }
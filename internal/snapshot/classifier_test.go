@@ -1,6 +1,11 @@
 package snapshot
 
-import "testing"
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 func TestEnvironmentClassifier(t *testing.T) {
 	classifier := NewEnvironmentClassifier(ClassifierConfig{
@@ -25,10 +30,10 @@ func TestEnvironmentClassifier(t *testing.T) {
 			wantEnv: "production",
 		},
 		{
-			name:    "label unknown value falls back to unknown",
-			ns:      "foo",
+			name:    "unmapped label value falls through to name heuristics",
+			ns:      "foo-prod",
 			labels:  map[string]string{"clustercost.io/environment": "weird"},
-			wantEnv: "unknown",
+			wantEnv: "production",
 		},
 		{
 			name:    "system namespace recognized",
@@ -55,3 +60,64 @@ func TestEnvironmentClassifier(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironmentClassifierRules(t *testing.T) {
+	mustRule := func(name, env, nameRegex, selector string, annotations map[string]string) EnvironmentRule {
+		t.Helper()
+		rule, err := CompileEnvironmentRule(name, env, nameRegex, selector, annotations)
+		if err != nil {
+			t.Fatalf("compile %s: %v", name, err)
+		}
+		return rule
+	}
+	classifier := NewEnvironmentClassifier(ClassifierConfig{
+		Rules: []EnvironmentRule{
+			mustRule("perf", "Perf", `perf-.*`, "", nil),
+			mustRule("sandbox", "sandbox", "", "tier in (sandbox,scratch),!pinned", nil),
+			mustRule("staging", "staging", `.*-stg`, "", map[string]string{"example.com/stage": "pre(prod)?"}),
+		},
+		ProductionLabelValues:  []string{"prod"},
+		ProductionNameContains: []string{"prod"},
+		SystemNamespaces:       []string{"kube-system"},
+	})
+
+	tests := []struct {
+		name        string
+		ns          string
+		labels      map[string]string
+		annotations map[string]string
+		want        Match
+	}{
+		{name: "name regex", ns: "perf-prod", want: Match{Environment: "perf", Rule: "perf"}},
+		{name: "regex is anchored", ns: "app-perf-1", want: Match{Environment: "nonprod", Rule: RuleDefault}},
+		{name: "selector", ns: "alice", labels: map[string]string{"tier": "scratch"}, want: Match{Environment: "sandbox", Rule: "sandbox"}},
+		{name: "selector negation", ns: "alice", labels: map[string]string{"tier": "scratch", "pinned": "true"}, want: Match{Environment: "nonprod", Rule: RuleDefault}},
+		{name: "all conditions", ns: "web-stg", annotations: map[string]string{"example.com/stage": "preprod"}, want: Match{Environment: "staging", Rule: "staging"}},
+		{name: "annotation mismatch", ns: "web-stg", annotations: map[string]string{"example.com/stage": "qa"}, want: Match{Environment: "nonprod", Rule: RuleDefault}},
+		{name: "label after rules", ns: "web", labels: map[string]string{"clustercost.io/environment": "prod"}, want: Match{Environment: "production", Rule: RuleLabel, Detail: "clustercost.io/environment"}},
+		{name: "name contains", ns: "payments-prod", want: Match{Environment: "production", Rule: RuleNameContains, Detail: "prod"}},
+		{name: "system", ns: "kube-system", want: Match{Environment: "system", Rule: RuleSystem}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifier.Match(tt.ns, tt.labels, tt.annotations); got != tt.want {
+				t.Fatalf("Match(%q)=%+v want %+v", tt.ns, got, tt.want)
+			}
+		})
+	}
+
+	annotated := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "perf-1",
+		Annotations: map[string]string{"clustercost.io/environment": "Sandbox"},
+	}}
+	if got := classifier.MatchNamespace(annotated); got.Environment != "sandbox" || got.Rule != RuleAnnotation {
+		t.Fatalf("MatchNamespace = %+v, want annotation sandbox", got)
+	}
+
+	if _, err := CompileEnvironmentRule("empty", "qa", "", "", nil); err == nil {
+		t.Fatal("expected a rule without conditions to be rejected")
+	}
+	if _, err := CompileEnvironmentRule("bad", "qa", "", "tier in (", nil); err == nil {
+		t.Fatal("expected an invalid selector to be rejected")
+	}
+}
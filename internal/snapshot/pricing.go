@@ -1,13 +1,25 @@
 package snapshot
 
-import "strings"
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
 
 // NodePriceLookup resolves node hourly prices by instance type.
 type NodePriceLookup struct {
 	prices      map[string]float64
+	rules       []NodePriceRule
 	defaultCost float64
 }
 
+// NodePriceRule prices every node matching Selector at HourlyPrice,
+// regardless of its instance type.
+type NodePriceRule struct {
+	Selector    labels.Selector
+	HourlyPrice float64
+}
+
 // NewNodePriceLookup builds a lookup map with normalized keys.
 func NewNodePriceLookup(prices map[string]float64, defaultCost float64) *NodePriceLookup {
 	n := &NodePriceLookup{
@@ -34,6 +46,41 @@ func (n *NodePriceLookup) Price(instanceType string) float64 {
 	return n.defaultCost
 }
 
+// NodePrice returns the hourly cost of a node. The first rule matching the
+// node labels wins over the instance type price.
+func (n *NodePriceLookup) NodePrice(instanceType string, nodeLabels map[string]string) float64 {
+	if n == nil {
+		return 0
+	}
+	for _, rule := range n.rules {
+		if rule.Selector != nil && rule.Selector.Matches(labels.Set(nodeLabels)) {
+			return rule.HourlyPrice
+		}
+	}
+	return n.Price(instanceType)
+}
+
+// Merge returns a copy of the lookup with prices layered over the existing
+// instance type prices and rules appended to the existing ones.
+func (n *NodePriceLookup) Merge(prices map[string]float64, rules []NodePriceRule) *NodePriceLookup {
+	out := NewNodePriceLookup(nil, 0)
+	if n != nil {
+		for k, v := range n.prices {
+			out.prices[k] = v
+		}
+		out.rules = append(out.rules, n.rules...)
+		out.defaultCost = n.defaultCost
+	}
+	for k, v := range prices {
+		if k == "" || v < 0 {
+			continue
+		}
+		out.prices[strings.ToLower(k)] = v
+	}
+	out.rules = append(out.rules, rules...)
+	return out
+}
+
 const bytesInGiB = 1024 * 1024 * 1024
 
 // NetworkPriceLookup resolves egress cost by traffic class.
@@ -69,3 +116,22 @@ func (n *NetworkPriceLookup) EgressCost(class string, txBytes uint64) float64 {
 	gib := float64(txBytes) / bytesInGiB
 	return gib * price
 }
+
+// Merge returns a copy of the lookup with prices layered over the existing
+// per-class prices.
+func (n *NetworkPriceLookup) Merge(prices map[string]float64) *NetworkPriceLookup {
+	out := NewNetworkPriceLookup(0, nil)
+	if n != nil {
+		out.defaultEgressPrice = n.defaultEgressPrice
+		for k, v := range n.egressPrices {
+			out.egressPrices[k] = v
+		}
+	}
+	for k, v := range prices {
+		if k == "" || v < 0 {
+			continue
+		}
+		out.egressPrices[strings.ToLower(k)] = v
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// SplitMode decides how a shared namespace's cost is divided between the
+// namespaces that receive it.
+type SplitMode string
+
+const (
+	// SplitProportional divides the cost by each target's own hourly cost.
+	SplitProportional SplitMode = "proportional"
+	// SplitEven divides the cost equally between targets.
+	SplitEven SplitMode = "even"
+	// SplitWeighted divides the cost by each target's
+	// clustercost.io/shared-weight annotation, which defaults to 1.
+	SplitWeighted SplitMode = "weighted"
+)
+
+// SharedCostPolicy moves the compute cost of shared namespaces, such as
+// ingress or monitoring, onto the namespaces that consume them.
+type SharedCostPolicy struct {
+	Name string
+	// Namespaces and Selector pick the shared namespaces.
+	Namespaces []string
+	Selector   labels.Selector
+	// Targets picks the namespaces that receive the cost. Nil means every
+	// namespace that is not shared by any policy.
+	Targets labels.Selector
+	Split   SplitMode
+}
+
+// SharedCost is the part of a namespace's hourly cost that came from one
+// shared namespace.
+type SharedCost struct {
+	Namespace  string  `json:"namespace"`
+	Policy     string  `json:"policy"`
+	HourlyCost float64 `json:"hourlyCost"`
+}
+
+// Shares reports whether the policy shares the namespace.
+func (p SharedCostPolicy) Shares(name string, nsLabels map[string]string) bool {
+	for _, ns := range p.Namespaces {
+		if ns == name {
+			return true
+		}
+	}
+	return p.Selector != nil && p.Selector.Matches(labels.Set(nsLabels))
+}
+
+// Receives reports whether the namespace is a target of the policy. Shared
+// namespaces never receive cost.
+func (p SharedCostPolicy) Receives(name string, nsLabels map[string]string) bool {
+	return p.Targets == nil || p.Targets.Matches(labels.Set(nsLabels))
+}
+
+// applySharedCosts redistributes the hourly cost of shared namespaces in
+// place. A namespace is shared by the first policy that selects it, and
+// shares are computed from the costs before any redistribution, so policy
+// order does not change the result. Cost with no target stays where it is.
+func applySharedCosts(records []NamespaceCostRecord, policies []SharedCostPolicy) {
+	if len(policies) == 0 {
+		return
+	}
+	sharedBy := map[int]int{}
+	for i, rec := range records {
+		for p, policy := range policies {
+			if policy.Shares(rec.Namespace, rec.Labels) {
+				sharedBy[i] = p
+				break
+			}
+		}
+	}
+	own := make([]float64, len(records))
+	for i, rec := range records {
+		own[i] = rec.HourlyCost
+	}
+
+	for p, policy := range policies {
+		var targets []int
+		var weights []float64
+		var totalWeight float64
+		for i, rec := range records {
+			if _, shared := sharedBy[i]; shared || !policy.Receives(rec.Namespace, rec.Labels) {
+				continue
+			}
+			weight := 1.0
+			switch policy.Split {
+			case SplitEven:
+			case SplitWeighted:
+				weight = rec.Attribution.Weight()
+			default:
+				weight = own[i]
+			}
+			targets = append(targets, i)
+			weights = append(weights, weight)
+			totalWeight += weight
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		// Without any weight the cost is split evenly rather than lost.
+		even := totalWeight <= 0
+		for i := range records {
+			if by, ok := sharedBy[i]; !ok || by != p || own[i] <= 0 {
+				continue
+			}
+			cost := own[i]
+			records[i].HourlyCost -= cost
+			records[i].DistributedCostHourly += cost
+			for n, t := range targets {
+				share := cost / float64(len(targets))
+				if !even {
+					share = cost * weights[n] / totalWeight
+				}
+				if share == 0 {
+					continue
+				}
+				records[t].HourlyCost += share
+				records[t].SharedCostHourly += share
+				records[t].SharedCosts = append(records[t].SharedCosts, SharedCost{
+					Namespace:  records[i].Namespace,
+					Policy:     policy.Name,
+					HourlyCost: share,
+				})
+			}
+		}
+	}
+}
@@ -7,18 +7,22 @@ type Store struct {
 	mu       sync.RWMutex
 	snapshot Snapshot
 	ready    bool
+	subs     map[*Subscription]struct{}
 }
 
 // NewStore constructs an empty store.
 func NewStore() *Store {
-	return &Store{}
+	return &Store{subs: map[*Subscription]struct{}{}}
 }
 
-// Update swaps the snapshot atomically.
+// Update swaps the snapshot atomically and notifies subscribers.
 func (s *Store) Update(snap Snapshot) {
 	s.mu.Lock()
 	s.snapshot = snap
 	s.ready = true
+	for sub := range s.subs {
+		sub.publish(snap)
+	}
 	s.mu.Unlock()
 }
 
@@ -38,3 +42,71 @@ func (s *Store) LatestSnapshot() Snapshot {
 	defer s.mu.RUnlock()
 	return s.snapshot
 }
+
+// Subscribe registers for snapshots published by later Update calls. Update
+// never blocks on subscribers: a reader that falls behind only sees the newest
+// snapshot, and Coalesced reports how many were skipped.
+func (s *Store) Subscribe() *Subscription {
+	sub := &Subscription{store: s, ch: make(chan Snapshot, 1)}
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	return sub
+}
+
+// Subscribers returns the number of open subscriptions.
+func (s *Store) Subscribers() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.subs)
+}
+
+// Subscription delivers snapshots from a Store.
+type Subscription struct {
+	store     *Store
+	ch        chan Snapshot
+	coalesced uint64 // guarded by store.mu
+	closed    bool   // guarded by store.mu
+}
+
+// C returns the delivery channel. It is closed by Close.
+func (sub *Subscription) C() <-chan Snapshot {
+	return sub.ch
+}
+
+// Coalesced returns and resets the number of snapshots replaced before the
+// reader received them.
+func (sub *Subscription) Coalesced() uint64 {
+	sub.store.mu.Lock()
+	defer sub.store.mu.Unlock()
+	n := sub.coalesced
+	sub.coalesced = 0
+	return n
+}
+
+// Close unregisters the subscription. It is safe to call more than once.
+func (sub *Subscription) Close() {
+	sub.store.mu.Lock()
+	defer sub.store.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(sub.store.subs, sub)
+	close(sub.ch)
+}
+
+// publish runs with store.mu held, so it is the only sender on ch.
+func (sub *Subscription) publish(snap Snapshot) {
+	select {
+	case sub.ch <- snap:
+		return
+	default:
+	}
+	select {
+	case <-sub.ch:
+		sub.coalesced++
+	default:
+	}
+	sub.ch <- snap
+}
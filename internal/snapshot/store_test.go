@@ -0,0 +1,36 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscriptionCoalescesForSlowReaders(t *testing.T) {
+	store := NewStore()
+	sub := store.Subscribe()
+	base := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		store.Update(Snapshot{Timestamp: base.Add(time.Duration(i) * time.Minute)})
+	}
+	got := <-sub.C()
+	if !got.Timestamp.Equal(base.Add(2 * time.Minute)) {
+		t.Fatalf("expected newest snapshot, got %s", got.Timestamp)
+	}
+	if n := sub.Coalesced(); n != 2 {
+		t.Fatalf("coalesced = %d, want 2", n)
+	}
+	if n := sub.Coalesced(); n != 0 {
+		t.Fatalf("coalesced not reset, got %d", n)
+	}
+
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.C(); ok {
+		t.Fatalf("expected closed channel")
+	}
+	if store.Subscribers() != 0 {
+		t.Fatalf("subscription still registered")
+	}
+	store.Update(Snapshot{Timestamp: base.Add(time.Hour)}) // must not panic on a closed subscription
+}
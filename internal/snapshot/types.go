@@ -1,6 +1,15 @@
 package snapshot
 
-import "time"
+import (
+	"time"
+
+	"clustercost-agent-k8s/internal/enricher"
+)
+
+// SchemaVersion identifies the snapshot wire format as major.minor. The minor
+// part changes for additive fields; the major part changes when a field is
+// removed, renamed, or changes type.
+const SchemaVersion = "1.5"
 
 // NamespaceCostRecord is the namespace-level payload required by the backend.
 type NamespaceCostRecord struct {
@@ -17,6 +26,47 @@ type NamespaceCostRecord struct {
 	NetworkEgressCost  float64           `json:"networkEgressCostHourly"`
 	Labels             map[string]string `json:"labels"`
 	Environment        string            `json:"environment"`
+	// SharedCostHourly is the cost received from shared namespaces. It is
+	// included in HourlyCost and broken down by source in SharedCosts.
+	SharedCostHourly float64      `json:"sharedCostHourly"`
+	SharedCosts      []SharedCost `json:"sharedCosts,omitempty"`
+	// DistributedCostHourly is the namespace's own cost that was moved to
+	// other namespaces and is no longer part of HourlyCost.
+	DistributedCostHourly float64 `json:"distributedCostHourly"`
+	// Attribution is the ownership declared by namespace annotations.
+	Attribution enricher.Attribution `json:"attribution"`
+	// Dimensions are the normalized allocation dimensions resolved from the
+	// namespace labels and annotations.
+	Dimensions map[string]string `json:"dimensions"`
+}
+
+// PodCostRecord is the share of its node's hourly price a pod is charged for,
+// with the owner resolved to the top-level controller where possible.
+type PodCostRecord struct {
+	Namespace          string  `json:"namespace"`
+	Pod                string  `json:"pod"`
+	Node               string  `json:"node"`
+	ControllerKind     string  `json:"controllerKind"`
+	ControllerName     string  `json:"controllerName"`
+	HourlyCost         float64 `json:"hourlyCost"`
+	CPURequestMilli    int64   `json:"cpuRequestMilli"`
+	MemoryRequestBytes int64   `json:"memoryRequestBytes"`
+	CPUUsageMilli      int64   `json:"cpuUsageMilli"`
+	MemoryUsageBytes   int64   `json:"memoryUsageBytes"`
+	// GPURequest counts the GPUs requested, from resources named */gpu.
+	GPURequest int64 `json:"gpuRequest"`
+	// StorageRequestBytes is the storage requested by the persistent volume
+	// claims the pod mounts. A claim mounted by several pods is split evenly.
+	StorageRequestBytes int64             `json:"storageRequestBytes"`
+	Labels              map[string]string `json:"labels"`
+	// Annotations are the pod annotations, without kubectl's
+	// last-applied-configuration.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Attribution resolves ownership annotations with pod over workload
+	// over namespace precedence.
+	Attribution enricher.Attribution `json:"attribution"`
+	// Dimensions resolve with the same precedence as Attribution.
+	Dimensions map[string]string `json:"dimensions"`
 }
 
 // NodeCostRecord captures node pricing and utilization.
@@ -28,6 +78,7 @@ type NodeCostRecord struct {
 	MemoryUsagePercent     float64           `json:"memoryUsagePercent"`
 	CPUAllocatableMilli    int64             `json:"cpuAllocatableMilli"`
 	MemoryAllocatableBytes int64             `json:"memoryAllocatableBytes"`
+	GPUAllocatable         int64             `json:"gpuAllocatable"`
 	PodCount               int               `json:"podCount"`
 	Status                 string            `json:"status"`
 	IsUnderPressure        bool              `json:"isUnderPressure"`
@@ -111,9 +162,11 @@ type NetworkConnection struct {
 
 // Snapshot is the unit exchanged between the builder and the HTTP API.
 type Snapshot struct {
-	Timestamp  time.Time             `json:"timestamp"`
-	Namespaces []NamespaceCostRecord `json:"namespaces"`
-	Nodes      []NodeCostRecord      `json:"nodes"`
-	Resources  ResourceSnapshot      `json:"resources"`
-	Network    NetworkSnapshot       `json:"network"`
+	SchemaVersion string                `json:"schemaVersion"`
+	Timestamp     time.Time             `json:"timestamp"`
+	Namespaces    []NamespaceCostRecord `json:"namespaces"`
+	Pods          []PodCostRecord       `json:"pods"`
+	Nodes         []NodeCostRecord      `json:"nodes"`
+	Resources     ResourceSnapshot      `json:"resources"`
+	Network       NetworkSnapshot       `json:"network"`
 }
@@ -0,0 +1,282 @@
+// Package telemetry exposes the agent's own health as Prometheus metrics so
+// operators can alert on a degraded agent rather than on missing cost data.
+package telemetry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Result label values shared by the success/error counters.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// QueueDepth describes reports waiting in the forwarder queue.
+type QueueDepth struct {
+	MemoryReports int
+	MemoryBytes   int64
+	DiskFiles     int
+	DiskBytes     int64
+	FailedFiles   int
+	FailedBytes   int64
+}
+
+// Metrics holds the agent self-observability series. All methods are safe on
+// a nil receiver so components can be built without instrumentation.
+type Metrics struct {
+	buildDuration     prometheus.Histogram
+	builds            *prometheus.CounterVec
+	collectorRuns     *prometheus.CounterVec
+	collectorDuration *prometheus.HistogramVec
+	mapEntries        *prometheus.GaugeVec
+	mapMaxEntries     *prometheus.GaugeVec
+	mapFillRatio      *prometheus.GaugeVec
+	cgroupLookups     *prometheus.CounterVec
+	sendDuration      *prometheus.HistogramVec
+	retries           prometheus.Counter
+	failedFiles       prometheus.Counter
+	dropped           *prometheus.CounterVec
+	leader            prometheus.Gauge
+
+	cacheSizesDesc  *prometheus.Desc
+	queueReportDesc *prometheus.Desc
+	queueBytesDesc  *prometheus.Desc
+
+	mu         sync.Mutex
+	cacheSizes func() map[string]int
+	queueDepth func() QueueDepth
+}
+
+// New builds an uninstrumented Metrics; register it on a prometheus.Registerer.
+func New() *Metrics {
+	return &Metrics{
+		buildDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "clustercost_agent_snapshot_build_duration_seconds",
+			Help:    "Time spent listing informers, collecting usage, and building a snapshot",
+			Buckets: prometheus.DefBuckets,
+		}),
+		builds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "clustercost_agent_snapshot_builds_total",
+			Help: "Snapshot builds by result",
+		}, []string{"result"}),
+		collectorRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "clustercost_agent_collector_runs_total",
+			Help: "Usage collector runs by collector and result",
+		}, []string{"collector", "result"}),
+		collectorDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "clustercost_agent_collector_duration_seconds",
+			Help:    "Usage collector latency",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"collector"}),
+		mapEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "clustercost_agent_ebpf_map_entries",
+			Help: "Entries seen in a pinned eBPF map during the last collection",
+		}, []string{"map"}),
+		mapMaxEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "clustercost_agent_ebpf_map_max_entries",
+			Help: "Capacity of a pinned eBPF map",
+		}, []string{"map"}),
+		mapFillRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "clustercost_agent_ebpf_map_fill_ratio",
+			Help: "Entries divided by max_entries; new flows or cgroups are dropped at 1",
+		}, []string{"map"}),
+		cgroupLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "clustercost_agent_cgroup_lookups_total",
+			Help: "Pod to cgroup resolutions by result; misses leave a pod without usage",
+		}, []string{"result"}),
+		sendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "clustercost_agent_forwarder_send_duration_seconds",
+			Help:    "Remote batch send latency by queue medium and result",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"medium", "result"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clustercost_agent_forwarder_retries_total",
+			Help: "Queued reports rescheduled after a failed send",
+		}),
+		failedFiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "clustercost_agent_forwarder_failed_files_total",
+			Help: "Queue files moved to failed/ after exhausting retries",
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "clustercost_agent_forwarder_dropped_reports_total",
+			Help: "Queued reports deleted unsent by queue (pending or failed) and reason (max_age or the overflow policy)",
+		}, []string{"queue", "reason"}),
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "clustercost_agent_leader",
+			Help: "1 while this replica holds the cluster-scope leader lease",
+		}),
+		cacheSizesDesc: prometheus.NewDesc(
+			"clustercost_agent_informer_cache_objects",
+			"Objects held in each informer cache",
+			[]string{"resource"}, nil,
+		),
+		queueReportDesc: prometheus.NewDesc(
+			"clustercost_agent_forwarder_queue_reports",
+			"Reports waiting in the forwarder queue by medium",
+			[]string{"medium"}, nil,
+		),
+		queueBytesDesc: prometheus.NewDesc(
+			"clustercost_agent_forwarder_queue_bytes",
+			"Encoded bytes waiting in the forwarder queue by medium",
+			[]string{"medium"}, nil,
+		),
+	}
+}
+
+// WatchCaches reports informer cache sizes from fn at scrape time.
+func (m *Metrics) WatchCaches(fn func() map[string]int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.cacheSizes = fn
+	m.mu.Unlock()
+}
+
+// WatchQueue reports forwarder queue depth from fn at scrape time.
+func (m *Metrics) WatchQueue(fn func() QueueDepth) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.queueDepth = fn
+	m.mu.Unlock()
+}
+
+// ObserveBuild records one snapshot build.
+func (m *Metrics) ObserveBuild(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.buildDuration.Observe(elapsed.Seconds())
+	m.builds.WithLabelValues(result(err)).Inc()
+}
+
+// ObserveCollector records one run of a usage collector.
+func (m *Metrics) ObserveCollector(name string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.collectorDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+	m.collectorRuns.WithLabelValues(name, result(err)).Inc()
+}
+
+// ObserveMap records how full a pinned eBPF map was on the last iteration.
+func (m *Metrics) ObserveMap(name string, entries int, maxEntries uint32) {
+	if m == nil {
+		return
+	}
+	m.mapEntries.WithLabelValues(name).Set(float64(entries))
+	m.mapMaxEntries.WithLabelValues(name).Set(float64(maxEntries))
+	if maxEntries > 0 {
+		m.mapFillRatio.WithLabelValues(name).Set(float64(entries) / float64(maxEntries))
+	}
+}
+
+// ObserveCgroupLookups records pods that did and did not resolve to a cgroup.
+func (m *Metrics) ObserveCgroupLookups(hits, misses int) {
+	if m == nil {
+		return
+	}
+	m.cgroupLookups.WithLabelValues("hit").Add(float64(hits))
+	m.cgroupLookups.WithLabelValues("miss").Add(float64(misses))
+}
+
+// ObserveSend records one remote batch send.
+func (m *Metrics) ObserveSend(medium string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.sendDuration.WithLabelValues(medium, result(err)).Observe(elapsed.Seconds())
+}
+
+// AddRetries counts reports rescheduled after a failed send.
+func (m *Metrics) AddRetries(n int) {
+	if m == nil {
+		return
+	}
+	m.retries.Add(float64(n))
+}
+
+// IncFailedFiles counts a queue file moved to failed/.
+func (m *Metrics) IncFailedFiles() {
+	if m == nil {
+		return
+	}
+	m.failedFiles.Inc()
+}
+
+// AddDropped counts queued reports deleted unsent from queue ("pending" or
+// "failed") for reason.
+func (m *Metrics) AddDropped(queue, reason string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.dropped.WithLabelValues(queue, reason).Add(float64(n))
+}
+
+// SetLeader records whether this replica holds the leader lease.
+func (m *Metrics) SetLeader(leading bool) {
+	if m == nil {
+		return
+	}
+	if leading {
+		m.leader.Set(1)
+		return
+	}
+	m.leader.Set(0)
+}
+
+func (m *Metrics) instruments() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.buildDuration, m.builds, m.collectorRuns, m.collectorDuration,
+		m.mapEntries, m.mapMaxEntries, m.mapFillRatio, m.cgroupLookups,
+		m.sendDuration, m.retries, m.failedFiles, m.dropped, m.leader,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.instruments() {
+		c.Describe(ch)
+	}
+	ch <- m.cacheSizesDesc
+	ch <- m.queueReportDesc
+	ch <- m.queueBytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.instruments() {
+		c.Collect(ch)
+	}
+	m.mu.Lock()
+	cacheSizes, queueDepth := m.cacheSizes, m.queueDepth
+	m.mu.Unlock()
+
+	if cacheSizes != nil {
+		for resource, n := range cacheSizes() {
+			ch <- prometheus.MustNewConstMetric(m.cacheSizesDesc, prometheus.GaugeValue, float64(n), resource)
+		}
+	}
+	if queueDepth != nil {
+		depth := queueDepth()
+		ch <- prometheus.MustNewConstMetric(m.queueReportDesc, prometheus.GaugeValue, float64(depth.MemoryReports), "memory")
+		ch <- prometheus.MustNewConstMetric(m.queueReportDesc, prometheus.GaugeValue, float64(depth.DiskFiles), "disk")
+		ch <- prometheus.MustNewConstMetric(m.queueReportDesc, prometheus.GaugeValue, float64(depth.FailedFiles), "failed")
+		ch <- prometheus.MustNewConstMetric(m.queueBytesDesc, prometheus.GaugeValue, float64(depth.MemoryBytes), "memory")
+		ch <- prometheus.MustNewConstMetric(m.queueBytesDesc, prometheus.GaugeValue, float64(depth.DiskBytes), "disk")
+		ch <- prometheus.MustNewConstMetric(m.queueBytesDesc, prometheus.GaugeValue, float64(depth.FailedBytes), "failed")
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNilMetricsIsNoop(t *testing.T) {
+	var m *Metrics
+	m.ObserveBuild(time.Second, nil)
+	m.ObserveCollector("metrics", time.Second, errors.New("boom"))
+	m.ObserveMap("flows", 1, 16384)
+	m.ObserveCgroupLookups(1, 1)
+	m.ObserveSend("disk", time.Second, nil)
+	m.AddRetries(1)
+	m.IncFailedFiles()
+	m.AddDropped("pending", "max_age", 1)
+	m.SetLeader(true)
+	m.WatchCaches(func() map[string]int { return nil })
+	m.WatchQueue(func() QueueDepth { return QueueDepth{} })
+}
+
+func TestMetricsCollect(t *testing.T) {
+	m := New()
+	m.ObserveCollector("network", 20*time.Millisecond, nil)
+	m.ObserveCollector("network", 20*time.Millisecond, errors.New("map missing"))
+	m.ObserveMap("flows", 4096, 16384)
+	m.ObserveCgroupLookups(9, 1)
+	m.WatchCaches(func() map[string]int { return map[string]int{"pods": 12} })
+	m.WatchQueue(func() QueueDepth { return QueueDepth{MemoryReports: 2, DiskFiles: 3} })
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	series := map[string]float64{}
+	for _, mf := range families {
+		for _, metric := range mf.GetMetric() {
+			for _, lp := range metric.GetLabel() {
+				key := mf.GetName() + "{" + lp.GetName() + "=" + lp.GetValue() + "}"
+				switch {
+				case metric.GetCounter() != nil:
+					series[key] += metric.GetCounter().GetValue()
+				case metric.GetGauge() != nil:
+					series[key] += metric.GetGauge().GetValue()
+				case metric.GetHistogram() != nil:
+					series[key] += float64(metric.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+	}
+
+	if got := series["clustercost_agent_ebpf_map_fill_ratio{map=flows}"]; got != 0.25 {
+		t.Errorf("fill ratio = %v, want 0.25", got)
+	}
+	if got := series["clustercost_agent_collector_runs_total{result=error}"]; got != 1 {
+		t.Errorf("error runs = %v, want 1", got)
+	}
+	if got := series["clustercost_agent_cgroup_lookups_total{result=miss}"]; got != 1 {
+		t.Errorf("cgroup misses = %v, want 1", got)
+	}
+	if got := series["clustercost_agent_informer_cache_objects{resource=pods}"]; got != 12 {
+		t.Errorf("pod cache size = %v, want 12", got)
+	}
+	if got := series["clustercost_agent_forwarder_queue_reports{medium=disk}"]; got != 3 {
+		t.Errorf("disk queue depth = %v, want 3", got)
+	}
+	if got := series["clustercost_agent_collector_duration_seconds{collector=network}"]; got != 2 {
+		t.Errorf("network latency observations = %v, want 2", got)
+	}
+}